@@ -0,0 +1,292 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"sort"
+	"sync"
+)
+
+// EndpointWeight returns the load balancing weight of the Endpoint, as
+// set with OptionWeight on the Dialer that created it.  Endpoints without
+// a weight, including all those accepted by a Listener, have weight 1.
+func EndpointWeight(ep Endpoint) int {
+	w := 1
+	if p, ok := ep.(*pipe); ok && p.d != nil {
+		p.d.sock.Lock()
+		if p.d.weight > 1 {
+			w = p.d.weight
+		}
+		p.d.sock.Unlock()
+	}
+	return w
+}
+
+// SendSelector chooses the peer to send a message to, for protocols that
+// spread messages across their peers; see OptionSendSelector.  It is given
+// the Endpoints enabled for sending, ordered by ID, and the message, and
+// returns one of them.  Returning nil, or any other value, leaves the
+// choice to the protocol.
+type SendSelector func(eps []Endpoint, m *Message) Endpoint
+
+// SendSelectorValue converts a value supplied for OptionSendSelector,
+// validating it.  A nil value removes the selector.  This is intended for
+// use by Protocol implementations.
+func SendSelectorValue(v interface{}) (SendSelector, error) {
+	switch v := v.(type) {
+	case SendSelector:
+		return v, nil
+	case func([]Endpoint, *Message) Endpoint:
+		return v, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, ErrBadValue
+}
+
+// Balancer is used by protocols that spread messages across their peers
+// (such as PUSH and REQ) to honor endpoint weights.  Each endpoint has a
+// sender that competes for messages; before each attempt the sender must
+// have a turn.  An endpoint gets as many turns in each round as its
+// weight, and once it has used them it waits for the other endpoints to
+// use theirs.  Note that this means a peer that is slow to accept
+// messages slows the others down as well.  When all weights are 1 the
+// Balancer has no effect at all, and senders simply race for messages.
+// Endpoints disabled with SetSendEnabled get no turns until re-enabled.
+//
+// With SetOrdered, endpoints instead take their turns strictly one after
+// another, in order of ID, each getting as many messages in a row as its
+// weight.
+//
+// With SetSelector, the sender that obtains a message asks Select which
+// endpoint it is for, and passes it to that endpoint's sender if it is
+// not its own.
+type Balancer struct {
+	eps      map[uint32]*balancerEp
+	weighted int           // number of endpoints with weight other than 1
+	ordered  bool          // strict rotation by ID, see SetOrdered
+	next     uint32        // when ordered, the lowest ID that may go next
+	changed  chan struct{} // closed (and replaced) when turns change
+	selector SendSelector  // see SetSelector
+	sync.Mutex
+}
+
+type balancerEp struct {
+	ep     Endpoint
+	weight int
+	turns  int
+}
+
+// Init must be called to initialize the Balancer.
+func (b *Balancer) Init() {
+	b.eps = make(map[uint32]*balancerEp)
+	b.changed = make(chan struct{})
+}
+
+// Add adds an endpoint, which starts with a full set of turns.
+func (b *Balancer) Add(ep Endpoint) {
+	w := EndpointWeight(ep)
+	if p, ok := ep.(*pipe); ok {
+		p.Lock()
+		p.bal = b
+		p.Unlock()
+	}
+	b.Lock()
+	b.eps[ep.GetID()] = &balancerEp{ep: ep, weight: w, turns: w}
+	if w != 1 {
+		b.weighted++
+	}
+	b.notify()
+	b.Unlock()
+}
+
+// Remove removes an endpoint.
+func (b *Balancer) Remove(ep Endpoint) {
+	b.Lock()
+	if be, ok := b.eps[ep.GetID()]; ok {
+		delete(b.eps, ep.GetID())
+		if be.weight != 1 {
+			b.weighted--
+		}
+		b.notify()
+	}
+	b.Unlock()
+}
+
+// Turn is called by the sender for an endpoint before it competes for
+// a message.  It returns nil if the sender may proceed.  Otherwise it
+// returns a channel that is closed when Turn should be called again.
+func (b *Balancer) Turn(ep Endpoint) <-chan struct{} {
+	b.Lock()
+	defer b.Unlock()
+	be := b.eps[ep.GetID()]
+	if be == nil {
+		return nil
+	}
+	if !ep.SendEnabled() {
+		return b.changed
+	}
+	if b.ordered {
+		if b.current() == be {
+			return nil
+		}
+		return b.changed
+	}
+	if b.weighted == 0 || be.turns > 0 {
+		return nil
+	}
+	for _, other := range b.eps {
+		if other.turns > 0 && other.ep.SendEnabled() {
+			return b.changed
+		}
+	}
+	// Everyone has used their turns, so start a new round.
+	for _, other := range b.eps {
+		other.turns = other.weight
+	}
+	b.notify()
+	return nil
+}
+
+// Changed returns a channel that is closed at the next change to the
+// turns or the set of enabled endpoints.  A sender should obtain it
+// before calling Turn, and include it while waiting for a message, so
+// that it stops competing promptly when its endpoint is disabled.
+func (b *Balancer) Changed() <-chan struct{} {
+	b.Lock()
+	defer b.Unlock()
+	return b.changed
+}
+
+// Took is called by the sender for an endpoint when it has obtained a
+// message to send, using up one turn.
+func (b *Balancer) Took(ep Endpoint) {
+	b.Lock()
+	if be := b.eps[ep.GetID()]; be != nil && b.ordered {
+		if be.turns--; be.turns <= 0 {
+			// Pass the turn to the next endpoint.
+			be.turns = be.weight
+			b.next = ep.GetID() + 1
+		}
+		b.notify()
+	} else if be != nil && b.weighted != 0 {
+		if be.turns > 0 {
+			be.turns--
+		}
+		if be.turns == 0 {
+			b.notify()
+		}
+	}
+	b.Unlock()
+}
+
+// SetOrdered selects strict round-robin, in which endpoints take turns in
+// order of ID (wrapping around from the highest to the lowest), rather
+// than competing for messages.  This makes the distribution of messages
+// predictable, at the cost of a slow peer holding up all the others.
+func (b *Balancer) SetOrdered(ordered bool) {
+	b.Lock()
+	if b.ordered != ordered {
+		b.ordered = ordered
+		for _, be := range b.eps {
+			be.turns = be.weight
+		}
+		b.notify()
+	}
+	b.Unlock()
+}
+
+// Ordered reports whether SetOrdered is in effect.
+func (b *Balancer) Ordered() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.ordered
+}
+
+// SetSelector installs a SendSelector to choose the endpoint for each
+// message, or removes it if nil.
+func (b *Balancer) SetSelector(fn SendSelector) {
+	b.Lock()
+	b.selector = fn
+	b.Unlock()
+}
+
+// Selector returns the SendSelector, or nil if there is none.
+func (b *Balancer) Selector() SendSelector {
+	b.Lock()
+	defer b.Unlock()
+	return b.selector
+}
+
+// Select returns the endpoint that the SendSelector chooses for the
+// message.  It returns nil if there is no selector, or if it chose none
+// of the endpoints enabled for sending.  The selector is called without
+// the lock held.
+func (b *Balancer) Select(m *Message) Endpoint {
+	b.Lock()
+	fn := b.selector
+	if fn == nil {
+		b.Unlock()
+		return nil
+	}
+	eps := make([]Endpoint, 0, len(b.eps))
+	for _, be := range b.eps {
+		if be.ep.SendEnabled() {
+			eps = append(eps, be.ep)
+		}
+	}
+	b.Unlock()
+	if len(eps) == 0 {
+		return nil
+	}
+	sort.Slice(eps, func(i, j int) bool {
+		return eps[i].GetID() < eps[j].GetID()
+	})
+	if ep := fn(eps, m); ep != nil {
+		for _, e := range eps {
+			if e == ep {
+				return ep
+			}
+		}
+	}
+	return nil
+}
+
+// current returns the endpoint whose turn it is when ordered: the enabled
+// endpoint with the lowest ID not below next, or failing that the lowest
+// of all.
+func (b *Balancer) current() *balancerEp {
+	var first, cur *balancerEp
+	for id, be := range b.eps {
+		if !be.ep.SendEnabled() {
+			continue
+		}
+		if first == nil || id < first.ep.GetID() {
+			first = be
+		}
+		if id >= b.next && (cur == nil || id < cur.ep.GetID()) {
+			cur = be
+		}
+	}
+	if cur == nil {
+		return first
+	}
+	return cur
+}
+
+func (b *Balancer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
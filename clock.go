@@ -0,0 +1,63 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"time"
+)
+
+// Clock is used by protocols to obtain the current time and to schedule
+// timers.  The default implementation simply uses the time package, but
+// an alternate implementation can be supplied via OptionClock.  This is
+// mostly useful for testing, where a fake clock can be advanced by hand
+// to trigger timeouts and retries without sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc waits for the duration to elapse and then calls f
+	// in its own goroutine.  It returns a ClockTimer that can be
+	// used to cancel or reschedule the call.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock.  Its methods have the same
+// semantics as the methods of the same name on time.Timer.
+type ClockTimer interface {
+	// Stop prevents the timer from firing.  It returns true if the
+	// call stops the timer, false if the timer has already expired
+	// or been stopped.
+	Stop() bool
+
+	// Reset changes the timer to expire after the duration.  It
+	// returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// RealClock returns a Clock that uses the system time.  This is the
+// default Clock used by sockets.
+func RealClock() Clock {
+	return realClock{}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Codec converts between values and message bodies, so that applications
+// can send typed messages without marshaling them by hand.  See SendValue
+// and RecvValue (and, with Go 1.18 or newer, SendJSON and RecvJSON).
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// JSONCodec encodes values using encoding/json.  It is registered under
+// the name "json".
+var JSONCodec Codec = jsonCodec{}
+
+var codecs = struct {
+	byName map[string]Codec
+	sync.Mutex
+}{byName: map[string]Codec{"json": JSONCodec}}
+
+// RegisterCodec makes a Codec, such as one for protobuf or msgpack,
+// available by name from LookupCodec.  Registering a nil Codec removes
+// the name.
+func RegisterCodec(name string, c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if c == nil {
+		delete(codecs.byName, name)
+	} else {
+		codecs.byName[name] = c
+	}
+}
+
+// LookupCodec returns the Codec registered under name, or nil if there is
+// none.
+func LookupCodec(name string) Codec {
+	codecs.Lock()
+	defer codecs.Unlock()
+	return codecs.byName[name]
+}
+
+// SendValue encodes v with the Codec, and sends the result as a single
+// message on the Socket.
+func SendValue(sock Socket, c Codec, v interface{}) error {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return sock.Send(b)
+}
+
+// RecvValue receives a message from the Socket, and decodes its body with
+// the Codec into the value pointed to by v.  If the message cannot be
+// decoded, the Codec's error is returned, and the message is lost.
+func RecvValue(sock Socket, c Codec, v interface{}) error {
+	m, err := sock.RecvMsg()
+	if err != nil {
+		return err
+	}
+	defer m.Free()
+	return c.Unmarshal(m.Body, v)
+}
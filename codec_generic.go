@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+// SendTyped encodes v with the Codec, and sends it on the Socket.
+func SendTyped[T any](sock Socket, c Codec, v T) error {
+	return SendValue(sock, c, v)
+}
+
+// RecvTyped receives a message from the Socket, and decodes it with the
+// Codec as a value of type T.
+func RecvTyped[T any](sock Socket, c Codec) (T, error) {
+	var v T
+	err := RecvValue(sock, c, &v)
+	return v, err
+}
+
+// SendJSON sends v, encoded as JSON, on the Socket.
+func SendJSON[T any](sock Socket, v T) error {
+	return SendTyped(sock, JSONCodec, v)
+}
+
+// RecvJSON receives a JSON encoded value of type T from the Socket.
+func RecvJSON[T any](sock Socket) (T, error) {
+	return RecvTyped[T](sock, JSONCodec)
+}
@@ -15,10 +15,12 @@
 package mangos
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"net"
 	"sync"
+	"time"
 )
 
 // conn implements the Pipe interface on top of net.Conn.  The
@@ -31,6 +33,7 @@ type conn struct {
 	open  bool
 	props map[string]interface{}
 	maxrx int64
+	bsize int // minimum body capacity, see OptionDefaultBodySize
 	sync.Mutex
 }
 
@@ -58,7 +61,7 @@ func (p *conn) Recv() (*Message, error) {
 	if sz < 0 || (p.maxrx > 0 && sz > p.maxrx) {
 		return nil, ErrTooLong
 	}
-	msg = NewMessage(int(sz))
+	msg = NewMessage(recvBodySize(int(sz), p.bsize))
 	msg.Body = msg.Body[0:sz]
 	if _, err = io.ReadFull(p.c, msg.Body); err != nil {
 		msg.Free()
@@ -67,6 +70,15 @@ func (p *conn) Recv() (*Message, error) {
 	return msg, nil
 }
 
+// recvBodySize is the capacity to allocate for a received message body of
+// sz bytes, given the socket's OptionDefaultBodySize.
+func recvBodySize(sz, min int) int {
+	if sz < min {
+		return min
+	}
+	return sz
+}
+
 // Send implements the Pipe Send method.  The message is sent as a 64-bit
 // size (network byte order) followed by the message itself.
 func (p *conn) Send(msg *Message) error {
@@ -79,20 +91,39 @@ func (p *conn) Send(msg *Message) error {
 	}
 
 	// send length header
-	if err := binary.Write(p.c, binary.BigEndian, l); err != nil {
+	var lb [8]byte
+	binary.BigEndian.PutUint64(lb[:], l)
+	if err := writeFull(p.c, lb[:]); err != nil {
 		return err
 	}
-	if _, err := p.c.Write(msg.Header); err != nil {
+	if err := writeFull(p.c, msg.Header); err != nil {
 		return err
 	}
-	// hope this works
-	if _, err := p.c.Write(msg.Body); err != nil {
+	if err := writeFull(p.c, msg.Body); err != nil {
 		return err
 	}
 	msg.Free()
 	return nil
 }
 
+// writeFull writes all of b, continuing after short writes.  The io.Writer
+// contract requires an error to accompany a short write, but not every
+// net.Conn (particularly wrappers around other connections) honors that,
+// and a partially written frame would corrupt the stream.
+func writeFull(w io.Writer, b []byte) error {
+	for len(b) > 0 {
+		n, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
 // LocalProtocol returns our local protocol number.
 func (p *conn) LocalProtocol() uint16 {
 	return p.proto.Number()
@@ -114,6 +145,17 @@ func (p *conn) Close() error {
 	return nil
 }
 
+// Flush implements PipeFlusher, for connections (such as coalescing TLS
+// ones) that buffer writes.  It does nothing for those that do not.
+func (p *conn) Flush() error {
+	if f, ok := p.c.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 // IsOpen implements the PipeIsOpen method.
 func (p *conn) IsOpen() bool {
 	return p.open
@@ -126,6 +168,14 @@ func (p *conn) GetProp(n string) (interface{}, error) {
 	return nil, ErrBadProperty
 }
 
+// HandshakeHook is called during the SP handshake, once the peer's greeting
+// has been received, but before the pipe is attached to the socket.  It is
+// given the remote address and the protocol number proposed by the peer.
+// Returning a non-nil error rejects the connection; the error is returned
+// from the handshake, and the underlying connection is closed.  This can
+// be used to implement address allow lists, or to rate limit connections.
+type HandshakeHook func(remote net.Addr, proto uint16) error
+
 // NewConnPipe allocates a new Pipe using the supplied net.Conn, and
 // initializes it.  It performs the handshake required at the SP layer,
 // only returning the Pipe once the SP layer negotiation is complete.
@@ -165,6 +215,7 @@ func (p *conn) handshake(props []interface{}) error {
 	p.props = make(map[string]interface{})
 	p.props[PropLocalAddr] = p.c.LocalAddr()
 	p.props[PropRemoteAddr] = p.c.RemoteAddr()
+	p.props[PropNetConn] = p.c
 
 	for len(props) >= 2 {
 		switch name := props[0].(type) {
@@ -180,16 +231,37 @@ func (p *conn) handshake(props []interface{}) error {
 		// socket guarantees this is an integer
 		p.maxrx = int64(v.(int))
 	}
+	if v, e := p.sock.GetOption(OptionDefaultBodySize); e == nil {
+		p.bsize = v.(int)
+	}
+	var hook HandshakeHook
+	if v, e := p.sock.GetOption(OptionHandshakeHook); e == nil {
+		hook, _ = v.(HandshakeHook)
+	}
+	lenient := false
+	if v, e := p.sock.GetOption(OptionGreetingLenient); e == nil {
+		lenient, _ = v.(bool)
+	}
+	if v, e := p.sock.GetOption(OptionHandshakeTimeout); e == nil {
+		if d := v.(time.Duration); d > 0 {
+			// Bound the greeting exchange, so that a peer that
+			// never sends one cannot tie up the connection.
+			p.c.SetDeadline(time.Now().Add(d))
+			defer p.c.SetDeadline(time.Time{})
+		}
+	}
 
 	h := connHeader{S: 'S', P: 'P', Proto: p.proto.Number()}
-	if err = binary.Write(p.c, binary.BigEndian, &h); err != nil {
+	hb := &bytes.Buffer{}
+	binary.Write(hb, binary.BigEndian, &h)
+	if err = writeFull(p.c, hb.Bytes()); err != nil {
 		return err
 	}
 	if err = binary.Read(p.c, binary.BigEndian, &h); err != nil {
 		p.c.Close()
 		return err
 	}
-	if h.Zero != 0 || h.S != 'S' || h.P != 'P' || h.Rsvd != 0 {
+	if h.Zero != 0 || h.S != 'S' || h.P != 'P' || (h.Rsvd != 0 && !lenient) {
 		p.c.Close()
 		return ErrBadHeader
 	}
@@ -199,8 +271,15 @@ func (p *conn) handshake(props []interface{}) error {
 		return ErrBadVersion
 	}
 
+	if hook != nil {
+		if err = hook(p.c.RemoteAddr(), h.Proto); err != nil {
+			p.c.Close()
+			return err
+		}
+	}
+
 	// The protocol number lives as 16-bits (big-endian) at offset 4.
-	if h.Proto != p.proto.PeerNumber() {
+	if !ValidPeer(p.proto, h.Proto) {
 		p.c.Close()
 		return ErrBadProto
 	}
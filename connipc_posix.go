@@ -44,15 +44,13 @@ func (p *connipc) Send(msg *Message) error {
 	header[0] = 1
 	binary.BigEndian.PutUint64(header[1:], l)
 
-	if _, err = p.c.Write(header[:]); err != nil {
+	if err = writeFull(p.c, header[:]); err != nil {
 		return err
 	}
-
-	if _, err = p.c.Write(msg.Header); err != nil {
+	if err = writeFull(p.c, msg.Header); err != nil {
 		return err
 	}
-	// hope this works
-	if _, err = p.c.Write(msg.Body); err != nil {
+	if err = writeFull(p.c, msg.Body); err != nil {
 		return err
 	}
 	msg.Free()
@@ -78,7 +76,7 @@ func (p *connipc) Recv() (*Message, error) {
 	if sz < 0 || (p.maxrx > 0 && sz > p.maxrx) {
 		return nil, ErrTooLong
 	}
-	msg = NewMessage(int(sz))
+	msg = NewMessage(recvBodySize(int(sz), p.bsize))
 	msg.Body = msg.Body[0:sz]
 	if _, err = io.ReadFull(p.c, msg.Body); err != nil {
 		msg.Free()
@@ -51,7 +51,7 @@ func (p *connipc) Send(msg *Message) error {
 	buf = append(buf, msg.Header...)
 	buf = append(buf, msg.Body...)
 
-	if _, err = p.c.Write(buf[:]); err != nil {
+	if err = writeFull(p.c, buf[:]); err != nil {
 		return err
 	}
 	msg.Free()
@@ -77,7 +77,7 @@ func (p *connipc) Recv() (*Message, error) {
 	if sz < 0 || (p.maxrx > 0 && sz > p.maxrx) {
 		return nil, ErrTooLong
 	}
-	msg = NewMessage(int(sz))
+	msg = NewMessage(recvBodySize(int(sz), p.bsize))
 	msg.Body = msg.Body[0:sz]
 	if _, err = io.ReadFull(p.c, msg.Body); err != nil {
 		msg.Free()
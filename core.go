@@ -15,9 +15,13 @@
 package mangos
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,35 +33,74 @@ const defaultMaxRxSize = 1024 * 1024
 
 // socket is the meaty part of the core information.
 type socket struct {
+	// The 64-bit counters are first, to ensure alignment for atomic access.
+	recvDrops uint64 // messages dropped due to recvFull policy
+	bytesSent uint64 // message bytes written to pipes
+	bytesRecv uint64 // message bytes read from pipes
+	staleDrop uint64 // messages older than OptionMaxMessageAge
+	garbled   uint64 // messages with malformed headers
+	sendHeld  int32  // messages held by the priority send pump
+	paused    int32  // non-zero while pauseq is set; see setFlag
+	stampTx   int32  // non-zero if OptionSendTimestamp is set
+	recvWire  int32  // non-zero if OptionRecvWire is set
+
 	proto Protocol
 
 	sync.Mutex
 
 	uwq      chan *Message // upper write queue
 	uwqLen   int           // upper write queue buffer length
+	pipeQLen int           // per-pipe write queue length, -1 to follow uwqLen
+	pipeID   func() uint32 // pipe ID generator, nil for the default
+	rdrain   time.Duration // how long Recv may drain urq after Close
+	rdrainBy time.Time     // deadline for that, set by Close
 	urq      chan *Message // upper read queue
 	urqLen   int           // upper read queue buffer length
+	urqin    chan *Message // protocol side of urq, when not blocking
+	uwqin    chan *Message // application side of uwq, when prioritized
 	closeq   chan struct{} // closed when user requests close
 	recverrq chan struct{} // signaled when an error is pending
-
-	closing    bool  // true if Socket was closed at API level
-	active     bool  // true if either Dial or Listen has been successfully called
-	bestEffort bool  // true if OptionBestEffort is set
-	recverr    error // error to return on attempts to Recv()
-	senderr    error // error to return on attempts to Send()
+	attachq  chan struct{} // closed (and replaced) when a pipe attaches
+	pauseq   chan struct{} // non-nil while paused, closed by ResumeRecv
+
+	closing    bool   // true if Socket was closed at API level
+	active     bool   // true if either Dial or Listen has been successfully called
+	bestEffort bool   // true if OptionBestEffort is set
+	recverr    error  // error to return on attempts to Recv()
+	recvFull   string // policy when urq is full (OptionRecvFull)
+	sendPrio   bool   // true if OptionSendPriority is set
+	lenient    bool   // true if OptionGreetingLenient is set
+	sendOwn    bool   // true if OptionSendNoCopy is set
+	fragment   bool   // true if OptionFragment is set
+	closeWait  bool   // true if OptionCloseWait is set
+	senderr    error  // error to return on attempts to Send()
 
 	rdeadline  time.Duration
 	wdeadline  time.Duration
 	reconntime time.Duration // reconnect time after error or disconnect
 	reconnmax  time.Duration // max reconnect interval
 	linger     time.Duration
-	maxRxSize  int // max recv size
+	hstimeout  time.Duration // handshake timeout
+	maxAge     time.Duration // see OptionMaxMessageAge
+	maxRxSize  int           // max recv size
+	bodySize   int           // min body capacity, see OptionDefaultBodySize
+	chunkSize  int           // see OptionStreamChunkSize
+	maxTxSize  int           // max send body size, 0 if unbounded
+	minTxSize  int           // min send body size, 0 if unbounded
+	clock      Clock
 
 	pipes map[*pipe]struct{}
 
 	listeners []*listener
+	dialers   []*dialer // those that have been started
 
 	transports map[string]Transport
+	resolvers  map[string]Resolver
+	allowed    map[string]bool // permitted schemes, nil if unrestricted
+	tdefaults  []defaultOption // defaults for dialers and listeners
+
+	pingSeq uint64                   // last sequence number used by Ping
+	pongq   map[uint64]chan struct{} // Pings awaiting a pong, by seq
 
 	// These are conditional "type aliases" for our self
 	sendhook ProtocolSendHook
@@ -65,12 +108,31 @@ type socket struct {
 
 	// Port hook -- called when a port is added or removed
 	porthook PortHook
+
+	// Handshake hook -- called during the SP greeting
+	handshakeHook HandshakeHook
+
+	garbledHook GarbledHook // see OptionGarbledHook
+	garbledMax  int         // see OptionGarbledLimit
+
+	drophook atomic.Value // DropHook, see SetDropHook
+
+	gos Waiter // goroutines started with Go, see OptionCloseWait
 }
 
 func (sock *socket) addPipe(tranpipe Pipe, d *dialer, l *listener) *pipe {
-	p := newPipe(tranpipe)
+	sock.Lock()
+	idfn := sock.pipeID
+	sock.Unlock()
+	p := newPipe(tranpipe, idfn)
 	p.d = d
 	p.l = l
+	if d != nil {
+		sock.Lock()
+		p.redials = d.conns
+		d.conns++
+		sock.Unlock()
+	}
 
 	// Either listener or dialer is non-nil -- this could be an assert
 	if l == nil && d == nil {
@@ -82,20 +144,37 @@ func (sock *socket) addPipe(tranpipe Pipe, d *dialer, l *listener) *pipe {
 	if fn := sock.porthook; fn != nil {
 		sock.Unlock()
 		if !fn(PortActionAdd, p) {
-			p.Close()
+			p.closeWith(CloseReasonRejected)
 			return nil
 		}
 		sock.Lock()
 	}
 	if sock.pipes == nil {
 		sock.Unlock()
-		p.Close()
+		p.closeWith(CloseReasonShutdown)
+		return nil
+	}
+	p.Lock()
+	if p.closing {
+		// Closed from within the hook, or by a peer.
+		p.Unlock()
+		sock.Unlock()
 		return nil
 	}
 	p.sock = sock
+	p.Unlock()
 	sock.pipes[p] = struct{}{}
+	frag := sock.fragment
 	sock.Unlock()
+	if frag {
+		sock.Go(p.sendFragHello)
+	}
 	sock.proto.AddEndpoint(p)
+
+	sock.Lock()
+	close(sock.attachq)
+	sock.attachq = make(chan struct{})
+	sock.Unlock()
 	return p
 }
 
@@ -112,17 +191,23 @@ func newSocket(proto Protocol) *socket {
 	sock := new(socket)
 	sock.uwqLen = defaultQLen
 	sock.urqLen = defaultQLen
+	sock.pipeQLen = -1
 	sock.uwq = make(chan *Message, sock.uwqLen)
 	sock.urq = make(chan *Message, sock.urqLen)
 	sock.closeq = make(chan struct{})
 	sock.recverrq = make(chan struct{})
+	sock.attachq = make(chan struct{})
 	sock.reconntime = time.Millisecond * 100
 	sock.reconnmax = time.Duration(0)
 	sock.proto = proto
 	sock.transports = make(map[string]Transport)
+	sock.resolvers = make(map[string]Resolver)
 	sock.linger = time.Second
 	sock.maxRxSize = defaultMaxRxSize
+	sock.recvFull = RecvFullBlock
+	sock.clock = RealClock()
 	sock.pipes = make(map[*pipe]struct{})
+	sock.gos.Init()
 
 	// Add some conditionals now -- saves checks later
 	if i, ok := interface{}(proto).(ProtocolRecvHook); ok {
@@ -140,7 +225,9 @@ func newSocket(proto Protocol) *socket {
 // MakeSocket is intended for use by Protocol implementations.  The intention
 // is that they can wrap this to provide a "proto.NewSocket()" implementation.
 func MakeSocket(proto Protocol) Socket {
-	return newSocket(proto)
+	sock := newSocket(proto)
+	sock.tdefaults = sock.applyDefaults()
+	return sock
 }
 
 // Implementation of ProtocolSocket bits on socket.  This is the middle
@@ -155,9 +242,127 @@ func (sock *socket) SendChannel() <-chan *Message {
 func (sock *socket) RecvChannel() chan<- *Message {
 	sock.Lock()
 	defer sock.Unlock()
+	if sock.urqin != nil {
+		return sock.urqin
+	}
 	return sock.urq
 }
 
+// activate marks the socket active, which freezes the queue configuration.
+// If a dropping policy is in effect for the read queue, it also starts the
+// goroutine that applies it.  The socket lock must be held.
+func (sock *socket) activate() {
+	if sock.active {
+		return
+	}
+	sock.active = true
+	if sock.recvFull != RecvFullBlock {
+		// With no queue there is nothing old to drop.
+		dropOld := sock.recvFull == RecvFullDropOld && sock.urqLen > 0
+		sock.urqin = make(chan *Message)
+		in, out := sock.urqin, sock.urq
+		sock.Go(func() { sock.recvPump(in, out, dropOld) })
+	}
+	if sock.sendPrio {
+		// The protocol takes messages from uwq only as it can send
+		// them, so that the pump holds the rest in priority order.
+		limit := sock.uwqLen
+		if limit < 1 {
+			limit = 1
+		}
+		// Closing the old queue makes the protocol fetch the new
+		// one, once it has taken any messages sent before now.
+		owq := sock.uwq
+		sock.uwqin = make(chan *Message)
+		sock.uwq = make(chan *Message)
+		close(owq)
+		in, out := sock.uwqin, sock.uwq
+		sock.Go(func() { sock.sendPump(in, out, limit) })
+	}
+}
+
+// sendPump holds up to limit messages sent by the application, and hands
+// them to the protocol highest priority first.
+func (sock *socket) sendPump(in <-chan *Message, out chan<- *Message, limit int) {
+	var held [MaxPriority + 1][]*Message
+	n := 0
+	for {
+		var head *Message
+		var outq chan<- *Message
+		inq := in
+		for p := MaxPriority; p >= 0; p-- {
+			if len(held[p]) > 0 {
+				head = held[p][0]
+				outq = out
+				break
+			}
+		}
+		if n >= limit {
+			inq = nil
+		}
+		select {
+		case m := <-inq:
+			held[m.prio] = append(held[m.prio], m)
+			n++
+			atomic.AddInt32(&sock.sendHeld, 1)
+		case outq <- head:
+			held[head.prio] = held[head.prio][1:]
+			n--
+			atomic.AddInt32(&sock.sendHeld, -1)
+		case <-sock.closeq:
+			for _, q := range held {
+				for _, m := range q {
+					m.Free()
+				}
+			}
+			return
+		}
+	}
+}
+
+// drainSend waits for messages held by the priority send pump, if any, to
+// be taken by the protocol, and then for the write queue to drain.
+func (sock *socket) drainSend(expire time.Time) {
+	for atomic.LoadInt32(&sock.sendHeld) > 0 && time.Now().Before(expire) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	DrainChannel(sock.uwq, expire)
+}
+
+// recvPump moves messages from the protocol into the read queue without
+// ever blocking the protocol.  When the read queue is full, either the
+// oldest queued message or the new one is discarded.
+func (sock *socket) recvPump(in <-chan *Message, out chan *Message, dropOld bool) {
+	for {
+		var m *Message
+		select {
+		case m = <-in:
+		case <-sock.closeq:
+			return
+		}
+		for m != nil {
+			select {
+			case out <- m:
+				m = nil
+				continue
+			default:
+			}
+			if !dropOld {
+				atomic.AddUint64(&sock.recvDrops, 1)
+				sock.Dropped(m, DropReasonQueueFull)
+				break
+			}
+			select {
+			case old := <-out:
+				atomic.AddUint64(&sock.recvDrops, 1)
+				sock.Dropped(old, DropReasonQueueFull)
+			default:
+				// Drained by the reader meanwhile, so retry.
+			}
+		}
+	}
+}
+
 func (sock *socket) CloseChannel() <-chan struct{} {
 	return sock.closeq
 }
@@ -168,6 +373,27 @@ func (sock *socket) SetSendError(err error) {
 	sock.Unlock()
 }
 
+func (sock *socket) Garbled(ep Endpoint) {
+	atomic.AddUint64(&sock.garbled, 1)
+	p, ok := ep.(*pipe)
+	if !ok {
+		return
+	}
+	sock.Lock()
+	hook, limit := sock.garbledHook, sock.garbledMax
+	sock.Unlock()
+	p.Lock()
+	p.garbled++
+	n := p.garbled
+	p.Unlock()
+	if hook != nil {
+		hook(p, n)
+	}
+	if limit > 0 && n >= limit {
+		p.closeWith(CloseReasonGarbled)
+	}
+}
+
 func (sock *socket) SetRecvError(err error) {
 	sock.Lock()
 	sock.recverr = err
@@ -187,7 +413,7 @@ func (sock *socket) Close() error {
 
 	fin := time.Now().Add(sock.linger)
 
-	DrainChannel(sock.uwq, fin)
+	sock.drainSend(fin)
 
 	sock.Lock()
 	if sock.closing {
@@ -195,6 +421,7 @@ func (sock *socket) Close() error {
 		return ErrClosed
 	}
 	sock.closing = true
+	sock.rdrainBy = time.Now().Add(sock.rdrain)
 	close(sock.closeq)
 
 	for _, l := range sock.listeners {
@@ -212,21 +439,43 @@ func (sock *socket) Close() error {
 	sock.proto.Shutdown(fin)
 
 	for p := range pipes {
-		p.Close()
+		p.closeWith(CloseReasonShutdown)
 	}
 
+	sock.Lock()
+	wait := sock.closeWait
+	sock.Unlock()
+	if wait {
+		sock.gos.Wait()
+	}
 	return nil
 }
 
+// Go runs fn in a new goroutine, which Close waits for if OptionCloseWait
+// is set.
+func (sock *socket) Go(fn func()) {
+	sock.gos.Add()
+	go func() {
+		defer sock.gos.Done()
+		fn()
+	}()
+}
+
 func (sock *socket) SendMsg(msg *Message) error {
 
 	sock.Lock()
 	e := sock.senderr
+	if e == nil {
+		if sock.maxTxSize > 0 && len(msg.Body) > sock.maxTxSize {
+			e = ErrTooLong
+		} else if len(msg.Body) < sock.minTxSize {
+			e = ErrTooShort
+		}
+	}
+	sock.Unlock()
 	if e != nil {
-		sock.Unlock()
 		return e
 	}
-	sock.Unlock()
 	if sock.sendhook != nil {
 		if ok := sock.sendhook.SendHook(msg); !ok {
 			// just drop it silently
@@ -235,8 +484,15 @@ func (sock *socket) SendMsg(msg *Message) error {
 		}
 	}
 	sock.Lock()
+	if getFlag(&sock.stampTx) {
+		msg.stamp = sock.clock.Now()
+	}
 	useBestEffort := sock.bestEffort
 	wdeadline := sock.wdeadline
+	wq := sock.uwq
+	if sock.uwqin != nil {
+		wq = sock.uwqin
+	}
 
 	if wdeadline != 0 {
 		msg.expire = time.Now().Add(wdeadline)
@@ -245,6 +501,9 @@ func (sock *socket) SendMsg(msg *Message) error {
 	}
 	sock.Unlock()
 
+	if msg.effort != 0 {
+		useBestEffort = msg.effort > 0
+	}
 	if !useBestEffort {
 		timeout := mkTimer(wdeadline)
 		select {
@@ -252,24 +511,111 @@ func (sock *socket) SendMsg(msg *Message) error {
 			return ErrSendTimeout
 		case <-sock.closeq:
 			return ErrClosed
-		case sock.uwq <- msg:
+		case wq <- msg:
 			return nil
 		}
 	} else {
 		select {
 		case <-sock.closeq:
 			return ErrClosed
-		case sock.uwq <- msg:
+		case wq <- msg:
 			return nil
 		default:
-			msg.Free()
+			sock.Dropped(msg, DropReasonBestEffort)
 			return nil
 		}
 	}
 }
 
+func (sock *socket) SendMsgEndpoint(msg *Message) (Endpoint, error) {
+	if u, ok := sock.proto.(ProtocolUnicast); !ok || !u.Unicast() {
+		return nil, sock.SendMsg(msg)
+	}
+	sock.Lock()
+	wdeadline := sock.wdeadline
+	sock.Unlock()
+
+	sq := newSendWait()
+	msg.sentq = sq
+	timeout := mkTimer(wdeadline)
+	if err := sock.SendMsg(msg); err != nil {
+		msg.sentq = nil
+		return nil, err
+	}
+	select {
+	case r := <-sq.q:
+		return r.ep, r.err
+	case <-timeout:
+		// Still queued, it will not be written now; otherwise it is
+		// being written, and the caller needs to know where.
+		return sq.abandon(), ErrSendTimeout
+	case <-sock.closeq:
+		return sq.abandon(), ErrClosed
+	}
+}
+
+func (sock *socket) SendToEndpoint(ep Endpoint, msg *Message) error {
+	if raw, err := sock.proto.GetOption(OptionRaw); err != nil || raw != true {
+		return ErrProtoOp
+	}
+	sock.Lock()
+	if sock.closing {
+		sock.Unlock()
+		return ErrClosed
+	}
+	p, ok := ep.(*pipe)
+	if ok {
+		_, ok = sock.pipes[p]
+	}
+	wdeadline := sock.wdeadline
+	sock.Unlock()
+	if !ok {
+		return ErrBadEndpoint
+	}
+
+	if wdeadline == 0 {
+		msg.expire = time.Time{}
+		if err := p.SendMsg(msg); err != nil {
+			msg.Free()
+			return err
+		}
+		return nil
+	}
+
+	// The write may have to wait for one already under way, so it is
+	// done aside; a message whose deadline passes first is discarded
+	// by the pipe rather than written.
+	msg.expire = time.Now().Add(wdeadline)
+	sq := newSendWait()
+	msg.sentq = sq
+	timeout := mkTimer(wdeadline)
+	sock.Go(func() {
+		if err := p.SendMsg(msg); err != nil {
+			msg.Free()
+		}
+	})
+	select {
+	case r := <-sq.q:
+		return r.err
+	case <-timeout:
+		sq.abandon()
+		return ErrSendTimeout
+	}
+}
+
 func (sock *socket) Send(b []byte) error {
-	msg := NewMessage(len(b))
+	sock.Lock()
+	sz, own := sock.bodySize, sock.sendOwn
+	sock.Unlock()
+	if own {
+		msg := NewMessage(0)
+		msg.Body = b
+		return sock.SendMsg(msg)
+	}
+	if sz < len(b) {
+		sz = len(b)
+	}
+	msg := NewMessage(sz)
 	msg.Body = append(msg.Body, b...)
 	return sock.SendMsg(msg)
 }
@@ -292,23 +638,52 @@ func (sock *socket) RecvMsg() (*Message, error) {
 			sock.Unlock()
 			return nil, e
 		}
+		closing, drainEnd := sock.closing, sock.rdrainBy
 		sock.Unlock()
-		select {
-		case <-timeout:
-			return nil, ErrRecvTimeout
-		case msg := <-sock.urq:
-			if sock.recvhook != nil {
-				if ok := sock.recvhook.RecvHook(msg); ok {
-					return msg, nil
-				} // else loop
-				msg.Free()
-			} else {
-				return msg, nil
+
+		var msg *Message
+		if closing {
+			// Only messages already queued can be drained, and
+			// only for the window given by OptionRecvDrain.
+			if !time.Now().Before(drainEnd) {
+				return nil, ErrClosed
+			}
+			select {
+			case msg = <-sock.urq:
+			default:
+				return nil, ErrClosed
+			}
+		} else {
+			select {
+			case <-timeout:
+				return nil, ErrRecvTimeout
+			case msg = <-sock.urq:
+			case <-sock.closeq:
+				continue
+			case <-sock.recverrq:
+				continue
+			}
+		}
+		// Stale messages go before the protocol sees them, so that
+		// (say) REQ does not take a stale reply as its answer.
+		if getFlag(&sock.stampTx) && !msg.stamp.IsZero() {
+			sock.Lock()
+			maxAge, clock := sock.maxAge, sock.clock
+			sock.Unlock()
+			if maxAge > 0 && clock.Now().Sub(msg.stamp) > maxAge {
+				atomic.AddUint64(&sock.staleDrop, 1)
+				sock.Dropped(msg, DropReasonStale)
+				continue
 			}
-		case <-sock.closeq:
-			return nil, ErrClosed
-		case <-sock.recverrq:
 		}
+		if sock.recvhook != nil && !sock.recvhook.RecvHook(msg) {
+			msg.Free()
+			continue
+		}
+		if msg.wire != nil {
+			msg.Body, msg.wire = msg.wire, nil
+		}
+		return msg, nil
 	}
 }
 
@@ -334,6 +709,9 @@ func (sock *socket) getTransport(addr string) Transport {
 	sock.Lock()
 	defer sock.Unlock()
 
+	if sock.allowed != nil && !sock.allowed[scheme] {
+		return nil
+	}
 	t, ok := sock.transports[scheme]
 	if t != nil && ok {
 		return t
@@ -341,12 +719,50 @@ func (sock *socket) getTransport(addr string) Transport {
 	return nil
 }
 
+// checkAllowed returns ErrTranDenied if the address uses a scheme not
+// permitted by OptionTransports.
+func (sock *socket) checkAllowed(addr string) error {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return nil // getTransport will fail it
+	}
+	sock.Lock()
+	defer sock.Unlock()
+	if sock.allowed != nil && !sock.allowed[addr[:i]] {
+		return ErrTranDenied
+	}
+	return nil
+}
+
 func (sock *socket) AddTransport(t Transport) {
 	sock.Lock()
 	sock.transports[t.Scheme()] = t
 	sock.Unlock()
 }
 
+func (sock *socket) getResolver(addr string) Resolver {
+	var i int
+
+	if i = strings.Index(addr, "://"); i < 0 {
+		return nil
+	}
+	scheme := addr[:i]
+
+	sock.Lock()
+	defer sock.Unlock()
+	return sock.resolvers[scheme]
+}
+
+func (sock *socket) AddResolver(scheme string, r Resolver) {
+	sock.Lock()
+	if r == nil {
+		delete(sock.resolvers, scheme)
+	} else {
+		sock.resolvers[scheme] = r
+	}
+	sock.Unlock()
+}
+
 func (sock *socket) DialOptions(addr string, opts map[string]interface{}) error {
 
 	d, err := sock.NewDialer(addr, opts)
@@ -360,18 +776,64 @@ func (sock *socket) Dial(addr string) error {
 	return sock.DialOptions(addr, nil)
 }
 
+func (sock *socket) DialContext(ctx context.Context, addr string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d, err := sock.NewDialer(addr, nil)
+	if err != nil {
+		return err
+	}
+	if err = d.Dial(); err != nil {
+		return err
+	}
+	dd := d.(*dialer)
+	sock.Go(func() {
+		select {
+		case <-ctx.Done():
+			dd.Close()
+		case <-dd.closeq:
+		case <-sock.closeq:
+		}
+	})
+	return nil
+}
+
 func (sock *socket) NewDialer(addr string, options map[string]interface{}) (Dialer, error) {
 	var err error
-	d := &dialer{sock: sock, addr: addr, closeq: make(chan struct{})}
-	t := sock.getTransport(addr)
-	if t == nil {
+	d := &dialer{
+		sock:   sock,
+		addr:   addr,
+		opts:   make(map[string]interface{}),
+		closeq: make(chan struct{}),
+	}
+	if err = sock.checkAllowed(addr); err != nil {
+		return nil, err
+	}
+	if r := sock.getResolver(addr); r != nil {
+		d.newpd = func() (PipeDialer, error) {
+			return &resolvedDialer{
+				sock: sock,
+				addr: addr,
+				r:    r,
+				opts: make(map[string]interface{}),
+			}, nil
+		}
+	} else if t := sock.getTransport(addr); t == nil {
 		return nil, ErrBadTran
+	} else {
+		d.newpd = func() (PipeDialer, error) {
+			return t.NewDialer(addr, sock)
+		}
 	}
-	if d.d, err = t.NewDialer(addr, sock); err != nil {
+	if d.d, err = d.newpd(); err != nil {
 		return nil, err
 	}
+	for _, o := range sock.tdefaults {
+		d.SetOption(o.name, o.value)
+	}
 	for n, v := range options {
-		if err = d.d.SetOption(n, v); err != nil {
+		if err = d.SetOption(n, v); err != nil {
 			return nil, err
 		}
 	}
@@ -399,16 +861,22 @@ func (sock *socket) NewListener(addr string, options map[string]interface{}) (Li
 	// connections.  The Listener just needs to listen continuously,
 	// as we assume that we want to continue to receive inbound
 	// connections without limit.
+	if err := sock.checkAllowed(addr); err != nil {
+		return nil, err
+	}
 	t := sock.getTransport(addr)
 	if t == nil {
 		return nil, ErrBadTran
 	}
 	var err error
-	l := &listener{sock: sock, addr: addr}
+	l := &listener{sock: sock, addr: addr, closeq: make(chan struct{})}
 	l.l, err = t.NewListener(addr, sock)
 	if err != nil {
 		return nil, err
 	}
+	for _, o := range sock.tdefaults {
+		l.l.SetOption(o.name, o.value)
+	}
 	for n, v := range options {
 		if err = l.l.SetOption(n, v); err != nil {
 			l.l.Close()
@@ -418,6 +886,45 @@ func (sock *socket) NewListener(addr string, options map[string]interface{}) (Li
 	return l, nil
 }
 
+func (sock *socket) CloseListener(addr string) error {
+	var found []*listener
+	sock.Lock()
+	for _, l := range sock.listeners {
+		if l.addr == addr || l.Address() == addr {
+			found = append(found, l)
+		}
+	}
+	sock.Unlock()
+	if len(found) == 0 {
+		return ErrBadAddr
+	}
+	for _, l := range found {
+		l.Close()
+	}
+	return nil
+}
+
+func (sock *socket) ReconnectEndpoint(addr string) error {
+	var found []*dialer
+	sock.Lock()
+	for _, d := range sock.dialers {
+		if d.addr == addr {
+			found = append(found, d)
+		}
+	}
+	sock.Unlock()
+	if len(found) == 0 {
+		return ErrBadAddr
+	}
+	for _, d := range found {
+		select {
+		case d.redialq <- struct{}{}:
+		default: // already requested
+		}
+	}
+	return nil
+}
+
 func (sock *socket) SetOption(name string, value interface{}) error {
 	matched := false
 	err := sock.proto.SetOption(name, value)
@@ -457,6 +964,15 @@ func (sock *socket) SetOption(name string, value interface{}) error {
 		sock.uwq = make(chan *Message, sock.uwqLen)
 		close(owq)
 		return nil
+	case OptionWriteQLenPerPipe:
+		length, ok := value.(int)
+		if !ok || length < 0 {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.pipeQLen = length
+		sock.Unlock()
+		return nil
 	case OptionReadQLen:
 		sock.Lock()
 		defer sock.Unlock()
@@ -483,6 +999,58 @@ func (sock *socket) SetOption(name string, value interface{}) error {
 		default:
 			return ErrBadValue
 		}
+	case OptionStreamChunkSize:
+		sock.Lock()
+		defer sock.Unlock()
+		switch value := value.(type) {
+		case int:
+			if value < 0 {
+				return ErrBadValue
+			}
+			sock.chunkSize = value
+			return nil
+		default:
+			return ErrBadValue
+		}
+	case OptionDefaultBodySize:
+		sock.Lock()
+		defer sock.Unlock()
+		switch value := value.(type) {
+		case int:
+			if value < 0 {
+				return ErrBadValue
+			}
+			sock.bodySize = value
+			return nil
+		default:
+			return ErrBadValue
+		}
+	case OptionMaxSendSize:
+		sock.Lock()
+		defer sock.Unlock()
+		switch value := value.(type) {
+		case int:
+			if value < 0 {
+				return ErrBadValue
+			}
+			sock.maxTxSize = value
+			return nil
+		default:
+			return ErrBadValue
+		}
+	case OptionMinSendSize:
+		sock.Lock()
+		defer sock.Unlock()
+		switch value := value.(type) {
+		case int:
+			if value < 0 {
+				return ErrBadValue
+			}
+			sock.minTxSize = value
+			return nil
+		default:
+			return ErrBadValue
+		}
 	case OptionReconnectTime:
 		sock.Lock()
 		sock.reconntime = value.(time.Duration)
@@ -498,10 +1066,202 @@ func (sock *socket) SetOption(name string, value interface{}) error {
 		sock.bestEffort = value.(bool)
 		sock.Unlock()
 		return nil
+	case OptionHandshakeHook:
+		hook, ok := value.(HandshakeHook)
+		if !ok && value != nil {
+			// Permit a plain function literal, as a convenience.
+			fn, isfn := value.(func(net.Addr, uint16) error)
+			if !isfn {
+				return ErrBadValue
+			}
+			hook = fn
+		}
+		sock.Lock()
+		sock.handshakeHook = hook
+		sock.Unlock()
+		return nil
+	case OptionRecvFull:
+		policy, ok := value.(string)
+		switch {
+		case !ok:
+			return ErrBadValue
+		case policy == RecvFullBlock:
+		case policy == RecvFullDropOld:
+		case policy == RecvFullDropNew:
+		default:
+			return ErrBadValue
+		}
+		sock.Lock()
+		defer sock.Unlock()
+		if sock.active {
+			return ErrBadOption
+		}
+		sock.recvFull = policy
+		return nil
+	case OptionSendPriority:
+		prio, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		sock.Lock()
+		defer sock.Unlock()
+		if sock.active {
+			return ErrBadOption
+		}
+		sock.sendPrio = prio
+		return nil
+	case OptionMaxMessageAge:
+		age, ok := value.(time.Duration)
+		if !ok || age < 0 {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.maxAge = age
+		sock.Unlock()
+		return nil
+	case OptionSendTimestamp:
+		stamp, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		setFlag(&sock.stampTx, stamp)
+		return nil
+	case OptionTransports:
+		schemes, ok := value.([]string)
+		if !ok && value != nil {
+			return ErrBadValue
+		}
+		sock.Lock()
+		defer sock.Unlock()
+		if sock.active {
+			return ErrBadOption
+		}
+		if schemes == nil {
+			sock.allowed = nil
+			return nil
+		}
+		sock.allowed = make(map[string]bool)
+		for _, scheme := range schemes {
+			sock.allowed[scheme] = true
+		}
+		return nil
+	case OptionGreetingLenient:
+		lenient, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.lenient = lenient
+		sock.Unlock()
+		return nil
+	case OptionGarbledHook:
+		var hook GarbledHook
+		switch fn := value.(type) {
+		case GarbledHook:
+			hook = fn
+		case func(Port, int):
+			hook = fn
+		case nil:
+		default:
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.garbledHook = hook
+		sock.Unlock()
+		return nil
+	case OptionGarbledLimit:
+		limit, ok := value.(int)
+		if !ok || limit < 0 {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.garbledMax = limit
+		sock.Unlock()
+		return nil
+	case OptionSendNoCopy:
+		own, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.sendOwn = own
+		sock.Unlock()
+		return nil
+	case OptionFragment:
+		frag, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		sock.Lock()
+		announce := frag && !sock.fragment
+		sock.fragment = frag
+		var peers []*pipe
+		if announce {
+			for p := range sock.pipes {
+				peers = append(peers, p)
+			}
+		}
+		sock.Unlock()
+		for _, p := range peers {
+			sock.Go(p.sendFragHello)
+		}
+		return nil
+	case OptionCloseWait:
+		wait, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.closeWait = wait
+		sock.Unlock()
+		return nil
+	case OptionRecvWire:
+		wire, ok := value.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		setFlag(&sock.recvWire, wire)
+		return nil
+	case OptionHandshakeTimeout:
+		d, ok := value.(time.Duration)
+		if !ok || d < 0 {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.hstimeout = d
+		sock.Unlock()
+		return nil
+	case OptionRecvDrain:
+		d, ok := value.(time.Duration)
+		if !ok || d < 0 {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.rdrain = d
+		sock.Unlock()
+		return nil
+	case OptionPipeID:
+		fn, ok := value.(func() uint32)
+		if !ok && value != nil {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.pipeID = fn
+		sock.Unlock()
+		return nil
+	case OptionClock:
+		clock, ok := value.(Clock)
+		if !ok || clock == nil {
+			return ErrBadValue
+		}
+		sock.Lock()
+		sock.clock = clock
+		sock.Unlock()
+		return nil
+	}
+	if matched {
+		return nil
 	}
-	if matched {
-		return nil
-	}
 	return ErrBadOption
 }
 
@@ -531,6 +1291,13 @@ func (sock *socket) GetOption(name string) (interface{}, error) {
 		sock.Lock()
 		defer sock.Unlock()
 		return sock.uwqLen, nil
+	case OptionWriteQLenPerPipe:
+		sock.Lock()
+		defer sock.Unlock()
+		if sock.pipeQLen < 0 {
+			return sock.uwqLen, nil
+		}
+		return sock.pipeQLen, nil
 	case OptionReadQLen:
 		sock.Lock()
 		defer sock.Unlock()
@@ -539,6 +1306,22 @@ func (sock *socket) GetOption(name string) (interface{}, error) {
 		sock.Lock()
 		defer sock.Unlock()
 		return sock.maxRxSize, nil
+	case OptionStreamChunkSize:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.chunkSize, nil
+	case OptionDefaultBodySize:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.bodySize, nil
+	case OptionMaxSendSize:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.maxTxSize, nil
+	case OptionMinSendSize:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.minTxSize, nil
 	case OptionReconnectTime:
 		sock.Lock()
 		defer sock.Unlock()
@@ -547,6 +1330,92 @@ func (sock *socket) GetOption(name string) (interface{}, error) {
 		sock.Lock()
 		defer sock.Unlock()
 		return sock.reconnmax, nil
+	case OptionRecvFull:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.recvFull, nil
+	case OptionRecvDrops:
+		return atomic.LoadUint64(&sock.recvDrops), nil
+	case OptionStaleDrops:
+		return atomic.LoadUint64(&sock.staleDrop), nil
+	case OptionGarbledDrops:
+		return atomic.LoadUint64(&sock.garbled), nil
+	case OptionGarbledHook:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.garbledHook, nil
+	case OptionGarbledLimit:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.garbledMax, nil
+	case OptionMaxMessageAge:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.maxAge, nil
+	case OptionBytesSent:
+		return atomic.LoadUint64(&sock.bytesSent), nil
+	case OptionBytesRecv:
+		return atomic.LoadUint64(&sock.bytesRecv), nil
+	case OptionConnected:
+		sock.Lock()
+		defer sock.Unlock()
+		return len(sock.pipes) > 0, nil
+	case OptionSendPriority:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.sendPrio, nil
+	case OptionSendTimestamp:
+		return getFlag(&sock.stampTx), nil
+	case OptionClock:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.clock, nil
+	case OptionPipeID:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.pipeID, nil
+	case OptionRecvDrain:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.rdrain, nil
+	case OptionHandshakeHook:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.handshakeHook, nil
+	case OptionHandshakeTimeout:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.hstimeout, nil
+	case OptionGreetingLenient:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.lenient, nil
+	case OptionRecvWire:
+		return getFlag(&sock.recvWire), nil
+	case OptionFragment:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.fragment, nil
+	case OptionCloseWait:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.closeWait, nil
+	case OptionSendNoCopy:
+		sock.Lock()
+		defer sock.Unlock()
+		return sock.sendOwn, nil
+	case OptionTransports:
+		sock.Lock()
+		defer sock.Unlock()
+		if sock.allowed == nil {
+			return []string(nil), nil
+		}
+		schemes := make([]string, 0, len(sock.allowed))
+		for scheme := range sock.allowed {
+			schemes = append(schemes, scheme)
+		}
+		sort.Strings(schemes)
+		return schemes, nil
 	}
 	return nil, ErrBadOption
 }
@@ -555,6 +1424,223 @@ func (sock *socket) GetProtocol() Protocol {
 	return sock.proto
 }
 
+func (sock *socket) WaitConnected(ctx context.Context) error {
+	for {
+		sock.Lock()
+		if sock.closing {
+			sock.Unlock()
+			return ErrClosed
+		}
+		if len(sock.pipes) > 0 {
+			sock.Unlock()
+			return nil
+		}
+		attachq := sock.attachq
+		sock.Unlock()
+
+		select {
+		case <-attachq:
+		case <-sock.closeq:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (sock *socket) Endpoints() []Endpoint {
+	sock.Lock()
+	eps := make([]Endpoint, 0, len(sock.pipes))
+	for p := range sock.pipes {
+		eps = append(eps, p)
+	}
+	sock.Unlock()
+	sort.Slice(eps, func(i, j int) bool {
+		return eps[i].GetID() < eps[j].GetID()
+	})
+	return eps
+}
+
+func (sock *socket) MoveEndpoint(ep Endpoint, to Socket) (Endpoint, error) {
+	p, ok := ep.(*pipe)
+	if !ok {
+		return nil, ErrBadEndpoint
+	}
+	dst, ok := to.(*socket)
+	if !ok || dst == sock {
+		return nil, ErrBadValue
+	}
+	if dst.proto.Number() != sock.proto.Number() {
+		return nil, ErrBadProto
+	}
+	dst.Lock()
+	closed := dst.pipes == nil
+	dst.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	np := &pipe{
+		pipe:    p.pipe,
+		id:      p.id,
+		l:       p.l,
+		d:       p.d,
+		since:   p.since,
+		redials: p.redials,
+		sendmx:  p.sendmx,
+		frag:    p.frag,
+	}
+	np.closeq = make(chan struct{})
+	np.readyq = make(chan struct{})
+
+	sock.Lock()
+	hook := sock.porthook
+	frag := sock.fragment
+	sock.Unlock()
+	p.Lock()
+	if p.sock != sock {
+		p.Unlock()
+		return nil, ErrBadEndpoint
+	}
+	if p.closing {
+		p.Unlock()
+		return nil, ErrClosed
+	}
+	// Marking it closing stops the old protocol's Close tearing down
+	// the connection, as its senders and receivers wind up.
+	p.closing = true
+	p.reason = CloseReasonLocal
+	p.movedTo = np
+	if !p.reading {
+		close(np.readyq)
+	}
+	for k, v := range p.meta {
+		np.SetMeta(k, v)
+	}
+	np.nosend = p.nosend
+	p.Unlock()
+
+	sock.remPipe(p)
+	pipes.Lock()
+	pipes.byid[np.id] = np
+	pipes.Unlock()
+	if hook != nil {
+		hook(PortActionRemove, p)
+	}
+
+	dst.Lock()
+	if fn := dst.porthook; fn != nil {
+		dst.Unlock()
+		if !fn(PortActionAdd, np) {
+			np.closeWith(CloseReasonRejected)
+			return nil, ErrConnRefused
+		}
+		dst.Lock()
+	}
+	if dst.pipes == nil {
+		dst.Unlock()
+		np.closeWith(CloseReasonShutdown)
+		return nil, ErrClosed
+	}
+	np.Lock()
+	np.sock = dst
+	np.Unlock()
+	dst.pipes[np] = struct{}{}
+	// The peer has had our hello already if the old socket used
+	// OptionFragment; if only the new one does, it needs one now.
+	announce := dst.fragment && !frag
+	dst.Unlock()
+	if announce {
+		dst.Go(np.sendFragHello)
+	}
+	dst.proto.AddEndpoint(np)
+
+	dst.Lock()
+	close(dst.attachq)
+	dst.attachq = make(chan struct{})
+	dst.Unlock()
+	return np, nil
+}
+
+func (sock *socket) Flush() error {
+	sock.Lock()
+	if sock.closing {
+		sock.Unlock()
+		return ErrClosed
+	}
+	wait := sock.wdeadline
+	if wait == 0 {
+		wait = sock.linger
+	}
+	sock.Unlock()
+
+	// Messages still queued have to reach the transports first, as
+	// when closing.
+	fin := time.Now().Add(wait)
+	sock.drainSend(fin)
+	if d, ok := sock.proto.(ProtocolDrainer); ok {
+		d.Drain(fin)
+	}
+
+	sock.Lock()
+	peers := make([]*pipe, 0, len(sock.pipes))
+	for p := range sock.pipes {
+		peers = append(peers, p)
+	}
+	sock.Unlock()
+	var err error
+	for _, p := range peers {
+		if e := p.flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (sock *socket) PauseRecv() {
+	sock.Lock()
+	if sock.pauseq == nil {
+		sock.pauseq = make(chan struct{})
+		setFlag(&sock.paused, true)
+	}
+	sock.Unlock()
+}
+
+func (sock *socket) ResumeRecv() {
+	sock.Lock()
+	if sock.pauseq != nil {
+		close(sock.pauseq)
+		sock.pauseq = nil
+		setFlag(&sock.paused, false)
+	}
+	sock.Unlock()
+}
+
+// waitResume blocks while receiving is paused.  It returns false if the
+// pipe or the socket was closed in the meantime.
+func (sock *socket) waitResume(p *pipe) bool {
+	for {
+		// Checked for every message, so the lock is only taken while
+		// paused.
+		if !getFlag(&sock.paused) {
+			return true
+		}
+		sock.Lock()
+		q := sock.pauseq
+		sock.Unlock()
+		if q == nil {
+			return true
+		}
+		select {
+		case <-q:
+		case <-p.closeq:
+			return false
+		case <-sock.closeq:
+			return false
+		}
+	}
+}
+
 func (sock *socket) SetPortHook(newhook PortHook) PortHook {
 	sock.Lock()
 	oldhook := sock.porthook
@@ -565,11 +1651,20 @@ func (sock *socket) SetPortHook(newhook PortHook) PortHook {
 
 type dialer struct {
 	d      PipeDialer
+	newpd  func() (PipeDialer, error)
+	opts   map[string]interface{} // options given to the PipeDialer
+	optmx  sync.Mutex             // serializes SetOption
 	sock   *socket
 	addr   string
 	closed bool
 	active bool
+	weight int
+	tries  int      // failed attempts allowed, see OptionDialAttempts
+	hook   DialHook // see OptionDialHook
+	conns  int      // connections established so far
 	closeq chan struct{}
+
+	redialq chan struct{} // signaled by ReconnectEndpoint
 }
 
 func (d *dialer) Dial() error {
@@ -579,10 +1674,12 @@ func (d *dialer) Dial() error {
 		return ErrAddrInUse
 	}
 	d.closeq = make(chan struct{})
-	d.sock.active = true
+	d.redialq = make(chan struct{}, 1)
+	d.sock.activate()
 	d.active = true
+	d.sock.dialers = append(d.sock.dialers, d)
 	d.sock.Unlock()
-	go d.dialer()
+	d.sock.Go(d.dialer)
 	return nil
 }
 
@@ -593,17 +1690,122 @@ func (d *dialer) Close() error {
 		return ErrClosed
 	}
 	d.closed = true
-	close(d.closeq)
+	d.stop()
 	d.sock.Unlock()
 	return nil
 }
 
+// stop ends the dialer's current run, as when it is closed or gives up,
+// waking anything waiting on closeq.  The lock must be held.
+func (d *dialer) stop() {
+	select {
+	case <-d.closeq: // already given up
+	default:
+		close(d.closeq)
+	}
+	d.sock.remDialer(d)
+}
+
+// remDialer forgets a dialer that has stopped.  The lock must be held.
+func (sock *socket) remDialer(d *dialer) {
+	for i, od := range sock.dialers {
+		if od == d {
+			sock.dialers = append(sock.dialers[:i], sock.dialers[i+1:]...)
+			break
+		}
+	}
+}
+
 func (d *dialer) GetOption(n string) (interface{}, error) {
-	return d.d.GetOption(n)
+	switch n {
+	case OptionWeight:
+		d.sock.Lock()
+		defer d.sock.Unlock()
+		if d.weight < 1 {
+			return 1, nil
+		}
+		return d.weight, nil
+	case OptionDialAttempts:
+		d.sock.Lock()
+		defer d.sock.Unlock()
+		return d.tries, nil
+	case OptionDialHook:
+		d.sock.Lock()
+		defer d.sock.Unlock()
+		return d.hook, nil
+	}
+	d.sock.Lock()
+	pd := d.d
+	d.sock.Unlock()
+	return pd.GetOption(n)
 }
 
 func (d *dialer) SetOption(n string, v interface{}) error {
-	return d.d.SetOption(n, v)
+	// These are handled by the core, not the transport.
+	switch n {
+	case OptionWeight:
+		w, ok := v.(int)
+		if !ok || w < 1 {
+			return ErrBadValue
+		}
+		d.sock.Lock()
+		d.weight = w
+		d.sock.Unlock()
+		return nil
+	case OptionDialAttempts:
+		tries, ok := v.(int)
+		if !ok || tries < 0 {
+			return ErrBadValue
+		}
+		d.sock.Lock()
+		d.tries = tries
+		d.sock.Unlock()
+		return nil
+	case OptionDialHook:
+		var hook DialHook
+		switch fn := v.(type) {
+		case DialHook:
+			hook = fn
+		case func(Dialer, error, bool):
+			hook = fn
+		case nil:
+		default:
+			return ErrBadValue
+		}
+		d.sock.Lock()
+		d.hook = hook
+		d.sock.Unlock()
+		return nil
+	}
+
+	d.optmx.Lock()
+	defer d.optmx.Unlock()
+	d.sock.Lock()
+	pd, active := d.d, d.active
+	d.sock.Unlock()
+
+	if active {
+		// The dialing goroutine may be using the PipeDialer, so we
+		// configure a fresh one, which it picks up on its next
+		// connection attempt.
+		var err error
+		if pd, err = d.newpd(); err != nil {
+			return err
+		}
+		for on, ov := range d.opts {
+			if err = pd.SetOption(on, ov); err != nil {
+				return err
+			}
+		}
+	}
+	if err := pd.SetOption(n, v); err != nil {
+		return err
+	}
+	d.sock.Lock()
+	d.d = pd
+	d.opts[n] = v
+	d.sock.Unlock()
+	return nil
 }
 
 func (d *dialer) Address() string {
@@ -614,11 +1816,39 @@ func (d *dialer) Address() string {
 func (d *dialer) dialer() {
 	rtime := d.sock.reconntime
 	rtmax := d.sock.reconnmax
+	fails := 0
 	for {
-		p, err := d.d.Dial()
-		if err == nil {
-			// reset retry time
+		var cp *pipe
+		select {
+		case <-d.redialq:
+			// This attempt satisfies any request made so far.
+		default:
+		}
+		d.sock.Lock()
+		pd := d.d
+		d.sock.Unlock()
+		p, err := pd.Dial()
+		if err != nil {
+			fails++
+			d.sock.Lock()
+			hook := d.hook
+			final := d.tries > 0 && fails >= d.tries && !d.closed
+			if final {
+				// Allow Dial to be called again.
+				d.active = false
+				d.stop()
+			}
+			d.sock.Unlock()
+			if hook != nil {
+				hook(d, err, final)
+			}
+			if final {
+				return
+			}
+		} else {
+			// reset retry time and failure count
 			rtime = d.sock.reconntime
+			fails = 0
 			d.sock.Lock()
 			if d.closed {
 				d.sock.Unlock()
@@ -626,11 +1856,15 @@ func (d *dialer) dialer() {
 				return
 			}
 			d.sock.Unlock()
-			if cp := d.sock.addPipe(p, d, nil); cp != nil {
+			if cp = d.sock.addPipe(p, d, nil); cp != nil {
 				select {
 				case <-d.sock.closeq: // parent socket closed
 				case <-cp.closeq: // disconnect event
 				case <-d.closeq: // dialer closed
+				case <-d.redialq: // reconnect now
+					cp.closeWith(CloseReasonReconnect)
+					rtime = d.sock.reconntime
+					continue
 				}
 			}
 		}
@@ -638,15 +1872,14 @@ func (d *dialer) dialer() {
 		// we're redialing here
 		select {
 		case <-d.closeq: // dialer closed
-			if p != nil {
-				p.Close()
-			}
+			d.closePipe(cp, p)
 			return
 		case <-d.sock.closeq: // exit if parent socket closed
-			if p != nil {
-				p.Close()
-			}
+			d.closePipe(cp, p)
 			return
+		case <-d.redialq: // skip the rest of the backoff
+			rtime = d.sock.reconntime
+			continue
 		case <-time.After(rtime):
 			if rtmax > 0 {
 				rtime *= 2
@@ -659,10 +1892,24 @@ func (d *dialer) dialer() {
 	}
 }
 
+// closePipe closes the connection made by the dialer on shutdown, through
+// the core pipe if it got that far, so that the reason is recorded.
+func (d *dialer) closePipe(cp *pipe, p Pipe) {
+	switch {
+	case cp != nil:
+		cp.closeWith(CloseReasonShutdown)
+	case p != nil:
+		p.Close()
+	}
+}
+
 type listener struct {
-	l    PipeListener
-	sock *socket
-	addr string
+	l      PipeListener
+	sock   *socket
+	addr   string
+	active bool
+	closeq chan struct{} // closed by Close
+	once   sync.Once
 }
 
 func (l *listener) GetOption(n string) (interface{}, error) {
@@ -670,6 +1917,13 @@ func (l *listener) GetOption(n string) (interface{}, error) {
 }
 
 func (l *listener) SetOption(n string, v interface{}) error {
+	l.sock.Lock()
+	active := l.active
+	l.sock.Unlock()
+	if active {
+		// The accepting goroutine uses the options.
+		return ErrBadOption
+	}
 	return l.l.SetOption(n, v)
 }
 
@@ -679,11 +1933,16 @@ func (l *listener) serve() {
 		select {
 		case <-l.sock.closeq:
 			return
+		case <-l.closeq:
+			return
 		default:
 		}
 
 		// If the underlying PipeListener is closed, or not
 		// listening, we expect to return back with an error.
+		// Transports wrapping net listeners return their own error
+		// for that, rather than ErrClosed, so closeq is checked
+		// again above.
 		if pipe, err := l.l.Accept(); err == nil {
 			l.sock.addPipe(pipe, nil, l)
 		} else if err == ErrClosed {
@@ -704,9 +1963,10 @@ func (l *listener) Listen() error {
 	}
 	l.sock.Lock()
 	l.sock.listeners = append(l.sock.listeners, l)
-	l.sock.active = true
+	l.active = true
+	l.sock.activate()
 	l.sock.Unlock()
-	go l.serve()
+	l.sock.Go(l.serve)
 	return nil
 }
 
@@ -715,5 +1975,15 @@ func (l *listener) Address() string {
 }
 
 func (l *listener) Close() error {
+	l.sock.Lock()
+	for i, ol := range l.sock.listeners {
+		if ol == l {
+			l.sock.listeners = append(l.sock.listeners[:i],
+				l.sock.listeners[i+1:]...)
+			break
+		}
+	}
+	l.sock.Unlock()
+	l.once.Do(func() { close(l.closeq) })
 	return l.l.Close()
 }
@@ -0,0 +1,150 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"reflect"
+	"sync"
+)
+
+// defaults holds the options set with SetDefaultOption, in the order in
+// which they were first set.
+var defaults struct {
+	names  []string
+	values map[string]interface{}
+	sync.Mutex
+}
+
+// SetDefaultOption sets an option to be applied to every Socket created
+// from then on, as if SetOption had been called on it straight after
+// creation, so that applications creating many sockets with the same
+// tuning can set it up in one place.  Sockets created before the call are
+// not changed, and setting the option on a Socket overrides the default
+// for that Socket.
+//
+// Defaults are applied to a new socket in the order in which they were
+// first set, and one that the socket does not accept (such as a
+// protocol option another protocol has no use for) is skipped.  Options
+// unknown to the socket are also applied to each Dialer and Listener it
+// creates, before any options given to NewDialer or NewListener, so that
+// transport options such as OptionTLSConfig can have defaults too; again,
+// those a transport does not accept are skipped, including by the
+// transport of each address a Resolver returns.
+//
+// The value of a socket option handled by the core is checked against the
+// type given for it by Socket.Options, and ErrBadValue is returned if it
+// does not match, or ErrBadOption if the option is read-only.  Other
+// values can only be checked when they are applied.  A nil value removes
+// the default.
+func SetDefaultOption(name string, value interface{}) error {
+	if value != nil {
+		for _, o := range coreOptions {
+			if o.Name != name {
+				continue
+			}
+			if o.ReadOnly {
+				return ErrBadOption
+			}
+			if !reflect.TypeOf(value).AssignableTo(o.Type) {
+				return ErrBadValue
+			}
+		}
+	}
+	defaults.Lock()
+	defer defaults.Unlock()
+	if value == nil {
+		if _, ok := defaults.values[name]; ok {
+			delete(defaults.values, name)
+			for i, n := range defaults.names {
+				if n == name {
+					defaults.names = append(defaults.names[:i],
+						defaults.names[i+1:]...)
+					break
+				}
+			}
+		}
+		return nil
+	}
+	if defaults.values == nil {
+		defaults.values = make(map[string]interface{})
+	}
+	if _, ok := defaults.values[name]; !ok {
+		defaults.names = append(defaults.names, name)
+	}
+	defaults.values[name] = value
+	return nil
+}
+
+// DefaultOptions returns the options set with SetDefaultOption.  The map
+// is a copy, which the caller may keep or modify.
+func DefaultOptions() map[string]interface{} {
+	defaults.Lock()
+	defer defaults.Unlock()
+	opts := make(map[string]interface{}, len(defaults.values))
+	for n, v := range defaults.values {
+		opts[n] = v
+	}
+	return opts
+}
+
+// ProtocolDefaults returns the value of each option that a new Socket using
+// the protocol starts with, as set up by the core and the protocol's Init,
+// so that tooling can show which settings differ from the defaults.  Those
+// set with SetDefaultOption are not applied.  Every option listed by
+// Socket.Options is included, except the write-only ones.  The protocol
+// must be a new instance, such as one from the protocol package's
+// NewProtocol; it is used to make a Socket, which is closed again.
+func ProtocolDefaults(proto Protocol) map[string]interface{} {
+	sock := newSocket(proto)
+	defer sock.Close()
+	opts := make(map[string]interface{})
+	for _, o := range sock.Options() {
+		if o.WriteOnly {
+			continue
+		}
+		if v, err := sock.GetOption(o.Name); err == nil {
+			opts[o.Name] = v
+		}
+	}
+	return opts
+}
+
+// defaultOption is an option set with SetDefaultOption.
+type defaultOption struct {
+	name  string
+	value interface{}
+}
+
+func defaultOptions() []defaultOption {
+	defaults.Lock()
+	defer defaults.Unlock()
+	opts := make([]defaultOption, 0, len(defaults.names))
+	for _, n := range defaults.names {
+		opts = append(opts, defaultOption{n, defaults.values[n]})
+	}
+	return opts
+}
+
+// applyDefaults sets the default options on a new socket, and returns
+// those it did not accept, for its dialers and listeners.
+func (sock *socket) applyDefaults() []defaultOption {
+	var rest []defaultOption
+	for _, o := range defaultOptions() {
+		if sock.SetOption(o.name, o.value) == ErrBadOption {
+			rest = append(rest, o)
+		}
+	}
+	return rest
+}
@@ -22,16 +22,28 @@ type Dialer interface {
 	Close() error
 
 	// Dial starts connecting on the address.  If a connection fails,
-	// it will restart.
+	// it will restart, unless OptionDialAttempts makes it give up, in
+	// which case Dial may be called again later.
 	Dial() error
 
 	// Address returns the string (full URL) of the Listener.
 	Address() string
 
-	// SetOption sets an option on the Dialer. Setting options
-	// can only be done before Dial() has been called.
+	// SetOption sets an option on the Dialer.  Options may be changed
+	// after Dial() has been called, and the call does not wait for any
+	// connection.  OptionWeight takes effect immediately.  Transport
+	// options (such as OptionTLSConfig) require a reconnect: they are
+	// validated at once, but an established connection keeps its old
+	// settings, and the new ones apply from the next connection
+	// attempt.  Closing the Port forces such a reconnect.
 	SetOption(name string, value interface{}) error
 
 	// GetOption gets an option value from the Listener.
 	GetOption(name string) (interface{}, error)
 }
+
+// DialHook is a function that is called by a Dialer each time an attempt
+// to connect fails, with the error from the transport.  If final is true,
+// the Dialer has given up, because of OptionDialAttempts, and makes no more
+// attempts unless Dial is called again.  See OptionDialHook.
+type DialHook func(d Dialer, err error, final bool)
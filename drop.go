@@ -0,0 +1,84 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+// DropReason describes why a message was discarded.  See DropHook.
+type DropReason int
+
+// DropReason values.
+const (
+	// DropReasonQueueFull means there was no room in a queue for the
+	// message: the socket's read queue under a dropping OptionRecvFull
+	// policy, a subscriber unable to keep up, or the queue a PUB, BUS or
+	// STAR socket keeps for a peer.
+	DropReasonQueueFull DropReason = iota
+
+	// DropReasonBestEffort means the message could not be queued for
+	// sending at once, and OptionBestEffort (or SetBestEffort on the
+	// message) said not to wait.
+	DropReasonBestEffort
+
+	// DropReasonStale means the message was older than
+	// OptionMaxMessageAge when it was received.
+	DropReasonStale
+
+	// DropReasonNoSubscriber means PUB discarded the message because
+	// no subscriber wanted it, see OptionDropUnsubscribed.
+	DropReasonNoSubscriber
+
+	// DropReasonTooLong means the transport could not carry a message
+	// that large (Pipe.Send returned ErrTooLong).
+	DropReasonTooLong
+)
+
+var dropReasonNames = [...]string{
+	DropReasonQueueFull:    "queue full",
+	DropReasonBestEffort:   "best effort send",
+	DropReasonStale:        "message too old",
+	DropReasonNoSubscriber: "no subscriber",
+	DropReasonTooLong:      "too long for transport",
+}
+
+func (r DropReason) String() string {
+	if r >= 0 && int(r) < len(dropReasonNames) {
+		return dropReasonNames[r]
+	}
+	return "unknown"
+}
+
+// DropHook is called when a Socket discards a message, with the message
+// and the reason, so that lossy behavior can be seen while debugging.  It
+// is called from whichever goroutine dropped the message, so it must be
+// safe for concurrent use, and should be quick.  The message is freed
+// once it returns, so the hook must not keep it.
+type DropHook func(m *Message, reason DropReason)
+
+func (sock *socket) SetDropHook(newhook DropHook) DropHook {
+	sock.Lock()
+	oldhook, _ := sock.drophook.Load().(DropHook)
+	sock.drophook.Store(newhook)
+	sock.Unlock()
+	return oldhook
+}
+
+// Dropped reports the message to the DropHook, if any, and frees it.  The
+// hook is loaded without the socket lock, as drops can come in bursts.
+func (sock *socket) Dropped(m *Message, reason DropReason) {
+	hook, _ := sock.drophook.Load().(DropHook)
+	if hook != nil {
+		hook(m, reason)
+	}
+	m.Free()
+}
@@ -42,4 +42,11 @@ var (
 	ErrBadProperty = errors.New("invalid property name")
 	ErrTLSNoConfig = errors.New("missing TLS configuration")
 	ErrTLSNoCert   = errors.New("missing TLS certificates")
+	ErrNoPeers     = errors.New("no connected peers")
+	ErrTranDenied  = errors.New("transport not allowed on socket")
+	ErrCanceled    = errors.New("operation canceled")
+	ErrBadEndpoint = errors.New("endpoint not connected to socket")
+	ErrProtoInUse  = errors.New("protocol already registered")
+	ErrBadTrace    = errors.New("invalid message backtrace")
+	ErrNoReply     = errors.New("no reply after all attempts")
 )
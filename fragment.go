@@ -0,0 +1,263 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// The control frames used by OptionFragment, which like those of
+// Socket.Ping start with a zero word.  A hello carries the largest frame,
+// as a 32-bit count of bytes, that its sender wants to receive.  Each
+// fragment carries a message ID, its index and the number of fragments
+// (16 bits each), and then its part of the message's header and body.
+const (
+	fragHelloMagic = "\x00\x00\x00\x00MANGOS-FRGS"
+	fragMagic      = "\x00\x00\x00\x00MANGOS-FRAG"
+	fragHelloLen   = len(fragHelloMagic) + 4
+	fragHdrLen     = len(fragMagic) + 8
+
+	// maxFragsPending is how many incomplete messages a pipe keeps;
+	// beyond that the oldest is given up on.
+	maxFragsPending = 16
+
+	// fragHelloWait is how long a message too big for our frames waits
+	// for the peer's hello, before being sent whole.
+	fragHelloWait = time.Second
+)
+
+// fragState is a connection's fragmentation state.  It belongs to the
+// connection rather than the pipe, so that a pipe given to another socket
+// with MoveEndpoint takes it along.
+type fragState struct {
+	peer   int                 // largest frame the peer wants, 0 if unknown
+	helloq chan struct{}       // closed once the peer's hello arrives
+	sentq  chan struct{}       // closed once our hello has been sent
+	id     uint32              // last message ID sent in fragments
+	bufs   map[uint32]*fragBuf // messages being reassembled, by ID
+	q      []uint32            // their IDs, oldest first
+	sync.Mutex
+}
+
+func newFragState() *fragState {
+	return &fragState{
+		helloq: make(chan struct{}),
+		sentq:  make(chan struct{}),
+	}
+}
+
+// hello records the frame size from the peer's hello.
+func (st *fragState) hello(n int) {
+	st.Lock()
+	defer st.Unlock()
+	if st.peer == 0 && n != 0 {
+		close(st.helloq)
+	}
+	if n != 0 {
+		st.peer = n
+	}
+}
+
+// fragBuf collects the fragments of one message.
+type fragBuf struct {
+	parts [][]byte
+	got   int
+	size  int
+}
+
+// frameSize returns the largest frame the transport is expected to carry
+// well, from PropFrameSizeHint.
+func (p *pipe) frameSize() int {
+	if v, err := p.pipe.GetProp(PropFrameSizeHint); err == nil {
+		if n, ok := v.(int); ok && n > 0 {
+			return n
+		}
+	}
+	return DefaultFrameSizeHint
+}
+
+// sendFragHello tells the peer that we can reassemble fragments, and the
+// frame size we want.  Failures are ignored; the pipe is being closed.
+func (p *pipe) sendFragHello() {
+	m := NewMessage(fragHelloLen)
+	m.Body = append(m.Body, fragHelloMagic...)
+	m.Body = m.Body[:fragHelloLen]
+	binary.BigEndian.PutUint32(m.Body[len(fragHelloMagic):], uint32(p.frameSize()))
+	if p.SendMsg(m) != nil {
+		m.Free()
+	}
+	p.frag.Lock()
+	select {
+	case <-p.frag.sentq:
+	default:
+		close(p.frag.sentq)
+	}
+	p.frag.Unlock()
+}
+
+// fragLimit returns the frame size above which messages sent on the pipe
+// are split, or zero if they are not.  A message of size bytes that is
+// too big for our own frames, sent before the peer's hello has arrived,
+// waits for it (as when sent just after connecting), but only for
+// fragHelloWait, as a peer not using OptionFragment sends none.
+func (p *pipe) fragLimit(size int) int {
+	p.sock.Lock()
+	on := p.sock.fragment
+	p.sock.Unlock()
+	if !on {
+		return 0
+	}
+	p.frag.Lock()
+	peer := p.frag.peer
+	p.frag.Unlock()
+	if peer == 0 && size > p.frameSize() {
+		t := time.NewTimer(fragHelloWait)
+		select {
+		case <-p.frag.helloq:
+		case <-p.closeq:
+		case <-t.C:
+		}
+		t.Stop()
+		p.frag.Lock()
+		peer = p.frag.peer
+		p.frag.Unlock()
+	}
+	if peer == 0 {
+		return 0
+	}
+	limit := peer
+	if own := p.frameSize(); own < peer {
+		limit = own
+	}
+	if size > limit {
+		// Our hello must get there first, or the peer would not
+		// know to put the fragments back together.
+		select {
+		case <-p.frag.sentq:
+		case <-p.closeq:
+		}
+	}
+	return limit
+}
+
+// sendFrags writes the message as a series of fragments no bigger than
+// limit.  The send lock must be held.  Like Pipe.Send, it frees the
+// message on success.
+func (p *pipe) sendFrags(msg *Message, limit int) error {
+	piece := limit - fragHdrLen
+	data := make([]byte, 0, len(msg.Header)+len(msg.Body))
+	data = append(append(data, msg.Header...), msg.Body...)
+	count := (len(data) + piece - 1) / piece
+	if piece <= 0 || count > 0xffff {
+		// Frames too small to be of use; send it whole.
+		return p.pipe.Send(msg)
+	}
+	p.frag.Lock()
+	p.frag.id++
+	id := p.frag.id
+	p.frag.Unlock()
+	for i := 0; i < count; i++ {
+		part := data[i*piece:]
+		if len(part) > piece {
+			part = part[:piece]
+		}
+		f := NewMessage(fragHdrLen + len(part))
+		f.Body = append(f.Body, fragMagic...)
+		f.Body = f.Body[:fragHdrLen]
+		binary.BigEndian.PutUint32(f.Body[len(fragMagic):], id)
+		binary.BigEndian.PutUint16(f.Body[len(fragMagic)+4:], uint16(i))
+		binary.BigEndian.PutUint16(f.Body[len(fragMagic)+6:], uint16(count))
+		f.Body = append(f.Body, part...)
+		f.expire = msg.expire
+		if err := p.pipe.Send(f); err != nil {
+			f.Free()
+			return err
+		}
+	}
+	msg.Free()
+	return nil
+}
+
+// defrag returns the message unchanged if it is not a fragment.  A
+// fragment is kept, and nil returned, until the last one arrives, when
+// the whole message is returned.  Nothing is taken for a fragment unless
+// OptionFragment is set and the peer has said it uses it too, so that
+// other messages starting the same way are left alone.
+func (p *pipe) defrag(msg *Message) *Message {
+	b := msg.Body
+	if len(msg.Header) != 0 || len(b) < fragHdrLen ||
+		string(b[:len(fragMagic)]) != fragMagic {
+		return msg
+	}
+	p.sock.Lock()
+	on := p.sock.fragment
+	maxrx := p.sock.maxRxSize
+	p.sock.Unlock()
+	st := p.frag
+	st.Lock()
+	defer st.Unlock()
+	if !on || st.peer == 0 {
+		return msg
+	}
+	id := binary.BigEndian.Uint32(b[len(fragMagic):])
+	idx := int(binary.BigEndian.Uint16(b[len(fragMagic)+4:]))
+	count := int(binary.BigEndian.Uint16(b[len(fragMagic)+6:]))
+	part := b[fragHdrLen:]
+
+	fb := st.bufs[id]
+	if fb == nil && idx < count {
+		if st.bufs == nil {
+			st.bufs = make(map[uint32]*fragBuf)
+		}
+		if len(st.q) >= maxFragsPending {
+			delete(st.bufs, st.q[0])
+			st.q = st.q[1:]
+		}
+		fb = &fragBuf{parts: make([][]byte, count)}
+		st.bufs[id] = fb
+		st.q = append(st.q, id)
+	}
+	if fb == nil || count != len(fb.parts) || idx >= len(fb.parts) ||
+		fb.parts[idx] != nil {
+		// Malformed, or a duplicate.
+		msg.Free()
+		return nil
+	}
+	fb.parts[idx] = append([]byte(nil), part...)
+	fb.got++
+	fb.size += len(part)
+	msg.Free()
+
+	if fb.got < count && (maxrx <= 0 || fb.size <= maxrx) {
+		return nil
+	}
+	delete(st.bufs, id)
+	for i, qid := range st.q {
+		if qid == id {
+			st.q = append(st.q[:i], st.q[i+1:]...)
+			break
+		}
+	}
+	if maxrx > 0 && fb.size > maxrx {
+		return nil
+	}
+	whole := NewMessage(fb.size)
+	for _, part := range fb.parts {
+		whole.Body = append(whole.Body, part...)
+	}
+	return whole
+}
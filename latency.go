@@ -0,0 +1,50 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"time"
+)
+
+// LatencyStats summarizes a series of latency measurements, such as those
+// reported by OptionReplyLatency.  The zero value is an empty summary.
+type LatencyStats struct {
+	Count uint64        // number of measurements
+	Total time.Duration // sum of all measurements
+	Min   time.Duration // smallest measurement
+	Max   time.Duration // largest measurement
+	Last  time.Duration // most recent measurement
+}
+
+// Add records a measurement.
+func (s *LatencyStats) Add(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	if d > s.Max {
+		s.Max = d
+	}
+	s.Count++
+	s.Total += d
+	s.Last = d
+}
+
+// Mean returns the average measurement, or zero if there are none.
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
@@ -28,7 +28,8 @@ type Listener interface {
 	Address() string
 
 	// SetOption sets an option on the Listener. Setting options
-	// can only be done before Listen() has been called.
+	// can only be done before Listen() has been called; afterwards
+	// ErrBadOption is returned.
 	SetOption(name string, value interface{}) error
 
 	// GetOption gets an option value from the Listener.
@@ -0,0 +1,58 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+// BusMesh plumbs a BUS socket into a fully connected mesh.  The socket
+// listens on the listen address (unless it is empty), and dials the
+// addresses in peers.
+//
+// BUS delivers a message once per connection, so each pair of members must
+// be connected exactly once.  To let every member of the mesh be given the
+// same list, if listen appears in peers then only the addresses following it
+// are dialed; the members listed ahead of it are expected to dial us.  If
+// listen is not in peers (e.g. a member that only dials), every address is
+// dialed.
+//
+// Dialing is asynchronous, so peers that are not yet up are not an error;
+// the socket keeps retrying (subject to OptionReconnectTime and
+// OptionMaxReconnectTime) until each peer becomes reachable, and reconnects
+// if a peer later goes away.  An error is returned only if the socket is
+// not a BUS socket, or if listening or dialing fails outright (for example
+// because of a malformed address or missing transport).
+func BusMesh(sock Socket, listen string, peers []string) error {
+	if sock == nil {
+		return ErrClosed
+	}
+	if sock.GetProtocol().Number() != ProtoBus {
+		return ErrBadProto
+	}
+	if listen != "" {
+		if err := sock.Listen(listen); err != nil {
+			return err
+		}
+		for i, addr := range peers {
+			if addr == listen {
+				peers = peers[i+1:]
+				break
+			}
+		}
+	}
+	for _, addr := range peers {
+		if err := sock.Dial(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@
 package mangos
 
 import (
+	"encoding/binary"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -43,12 +44,65 @@ type Message struct {
 
 	bbuf   []byte
 	hbuf   []byte
+	topic  []byte
+	ident  []byte
+	onSent func(error)
+	sentq  *sendWait // see Socket.SendMsgEndpoint
+	prio   int
+	effort int
+	ttl    int    // see SetTTL
+	wire   []byte // frame as received, see OptionRecvWire
 	bsize  int
 	refcnt int32
 	expire time.Time
+	stamp  time.Time // see SendTime
 	pool   *sync.Pool
 }
 
+// sendResult reports the outcome of writing a message to a pipe, for a
+// caller waiting in Socket.SendMsgEndpoint.
+type sendResult struct {
+	ep  Endpoint
+	err error
+}
+
+// sendWait is how a caller waiting for a message to be written hears
+// the outcome.  Once the caller gives up, no pipe may start the write.
+type sendWait struct {
+	q    chan sendResult
+	ep   Endpoint // the pipe writing the message, once started
+	done bool     // true once the caller has given up
+	sync.Mutex
+}
+
+func newSendWait() *sendWait {
+	return &sendWait{q: make(chan sendResult, 1)}
+}
+
+// take claims the message for the write by ep, unless the caller has
+// already given up.
+func (w *sendWait) take(ep Endpoint) bool {
+	if w == nil {
+		return true
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.done {
+		return false
+	}
+	w.ep = ep
+	return true
+}
+
+// abandon stops any further write from starting, and returns the
+// Endpoint of one already under way, if any.
+func (w *sendWait) abandon() Endpoint {
+	w.Lock()
+	defer w.Unlock()
+	w.done = true
+	return w.ep
+}
+
 type msgCacheInfo struct {
 	maxbody int
 	pool    *sync.Pool
@@ -116,6 +170,11 @@ func (m *Message) Free() {
 	if v := atomic.AddInt32(&m.refcnt, -1); v > 0 {
 		return
 	}
+	if m.sentq != nil {
+		// Discarded without being written anywhere.
+		m.sentq.q <- sendResult{}
+		m.sentq = nil
+	}
 	for i := range messageCache {
 		if m.bsize == messageCache[i].maxbody {
 			messageCache[i].pool.Put(m)
@@ -151,6 +210,271 @@ func (m *Message) Expired() bool {
 	return true
 }
 
+// Topic returns the topic associated with the message, if any.  This is
+// only populated by SUB sockets configured to split the topic from the
+// payload (see OptionTopicDelimiter and OptionTopicLength).  The returned
+// slice shares storage with the message, and is only valid until the
+// message is freed.
+func (m *Message) Topic() []byte {
+	return m.topic
+}
+
+// SetTopic sets the topic associated with the message.  This is intended
+// for use by Protocol implementations.
+func (m *Message) SetTopic(topic []byte) {
+	m.topic = topic
+}
+
+// SenderIdentity returns the identity of the sender of the message, if
+// any.  This is only populated by sockets using OptionIdentity, and only
+// for messages received from peers that also use it.  The returned slice
+// shares storage with the message, and is only valid until the message
+// is freed.
+func (m *Message) SenderIdentity() []byte {
+	return m.ident
+}
+
+// AddIdentity prepends an identity frame to the body of the message.  The
+// frame is a 16-bit big-endian length, followed by the identity itself.
+// This is intended for use by Protocol implementations.
+func (m *Message) AddIdentity(id []byte) {
+	n := len(id)
+	body := make([]byte, 0, 2+n+len(m.Body))
+	body = append(body, byte(n>>8), byte(n))
+	body = append(body, id...)
+	m.Body = append(body, m.Body...)
+}
+
+// IdentityValue converts a value supplied for OptionIdentity, validating
+// it.  This is intended for use by Protocol implementations.
+func IdentityValue(v interface{}) ([]byte, error) {
+	var id []byte
+	switch v := v.(type) {
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		id = v
+	case string:
+		id = append([]byte{}, v...)
+	case nil:
+		return nil, nil
+	default:
+		return nil, ErrBadValue
+	}
+	if len(id) > 0xffff {
+		return nil, ErrBadValue
+	}
+	return id, nil
+}
+
+// stampMagic starts the prefix put ahead of each frame by
+// OptionSendTimestamp, which is followed by the send time as 8 bytes of
+// nanoseconds since the Unix epoch.  Like the frames of Socket.Ping, it
+// starts with a zero word.
+const stampMagic = "\x00\x00\x00\x00MANGOS-TIME"
+
+const stampLen = len(stampMagic) + 8
+
+// SendTime returns the time the message was sent by the peer, or the zero
+// Time if it is not known.  It is only populated by sockets using
+// OptionSendTimestamp, for messages from peers that also use it.  The
+// time is taken from the sender's clock, so comparing it with the local
+// clock measures latency only as well as the two clocks agree.
+func (m *Message) SendTime() time.Time {
+	return m.stamp
+}
+
+// stamped returns a copy of the message for the wire, with the send time
+// in a prefix ahead of the header and body.  The message itself is left
+// alone, as it may be shared with other pipes, or sent again.
+func (m *Message) stamped(t time.Time) *Message {
+	s := NewMessage(stampLen + len(m.Header) + len(m.Body))
+	s.Body = append(s.Body, stampMagic...)
+	s.Body = binary.BigEndian.AppendUint64(s.Body, uint64(t.UnixNano()))
+	s.Body = append(s.Body, m.Header...)
+	s.Body = append(s.Body, m.Body...)
+	s.expire = m.expire
+	return s
+}
+
+// unstamp removes the send time prefix from a message received, if it
+// has one, recording the time (see SendTime).
+func (m *Message) unstamp() {
+	if len(m.Header) != 0 || len(m.Body) < stampLen ||
+		string(m.Body[:len(stampMagic)]) != stampMagic {
+		return
+	}
+	ns := int64(binary.BigEndian.Uint64(m.Body[len(stampMagic):]))
+	m.stamp = time.Unix(0, ns)
+	m.Body = m.Body[stampLen:]
+}
+
+// StripIdentity removes the identity frame from the front of the body of
+// the message, recording the identity (see SenderIdentity).  It returns
+// false if the body does not begin with a valid frame.  This is intended
+// for use by Protocol implementations.
+func (m *Message) StripIdentity() bool {
+	if len(m.Body) < 2 {
+		return false
+	}
+	n := int(m.Body[0])<<8 | int(m.Body[1])
+	if len(m.Body) < 2+n {
+		return false
+	}
+	m.ident = m.Body[2 : 2+n]
+	m.Body = m.Body[2+n:]
+	return true
+}
+
+// OnSent registers a function to be called when the message has been
+// written to the underlying transport connection, as opposed to merely
+// being queued.  The function is passed nil on success, or the error
+// that caused the write to fail.  If the message expires before it is
+// written, ErrSendTimeout is passed.  The function is called from the
+// goroutine doing the write, so it should not block.  Note that the
+// function is called once per write attempt, so protocols which deliver a
+// message to multiple peers (such as PUB or BUS), or which retry on another
+// peer after a failure (such as REQ), may call it more than once.  Messages
+// discarded before reaching any pipe (for example due to backpressure) do
+// not result in a call at all.
+func (m *Message) OnSent(f func(err error)) {
+	m.onSent = f
+}
+
+// MergeOnSent moves the OnSent function of the other message, if any, to
+// this one, to be called when this one is written, after any this one
+// already has.  This is intended for Protocol implementations which copy
+// several messages into one, as PUSH does with OptionBatchSize.
+func (m *Message) MergeOnSent(other *Message) {
+	cb := other.onSent
+	if cb == nil {
+		return
+	}
+	other.onSent = nil
+	if prev := m.onSent; prev != nil {
+		m.onSent = func(err error) {
+			prev(err)
+			cb(err)
+		}
+	} else {
+		m.onSent = cb
+	}
+}
+
+// MaxPriority is the highest message priority.  See SetPriority.
+const MaxPriority = 7
+
+// SetPriority sets the priority of the message, from 0 (the default) to
+// MaxPriority; values outside the range are clamped.  On sockets using
+// OptionSendPriority, queued messages of higher priority are handed to the
+// protocol ahead of those of lower priority, while messages of the same
+// priority keep their order.  Otherwise the priority is ignored.
+func (m *Message) SetPriority(p int) {
+	switch {
+	case p < 0:
+		p = 0
+	case p > MaxPriority:
+		p = MaxPriority
+	}
+	m.prio = p
+}
+
+// Priority returns the priority of the message.
+func (m *Message) Priority() int {
+	return m.prio
+}
+
+// MaxTTL is the largest hop limit that can be carried by a message.
+const MaxTTL = 255
+
+// SetTTL limits the number of hops the message may travel, on sockets
+// that carry a hop limit with each message; at present only BUS does,
+// when OptionTTL is set.  With a TTL of 1, the message reaches the
+// sender's peers, but is not relayed any further; with 2 it can be
+// relayed once, and so on.  Values are clamped to the range 0 to MaxTTL,
+// and 0 (the default) means the socket's OptionTTL applies.
+func (m *Message) SetTTL(ttl int) {
+	switch {
+	case ttl < 0:
+		ttl = 0
+	case ttl > MaxTTL:
+		ttl = MaxTTL
+	}
+	m.ttl = ttl
+}
+
+// TTL returns the hop limit of the message, as set by SetTTL.  For a
+// message received on a socket that carries hop limits, this is the
+// number of hops it had left, counting the one it arrived on.
+func (m *Message) TTL() int {
+	return m.ttl
+}
+
+// SetBestEffort overrides OptionBestEffort for this message alone.  When
+// true, the message is discarded rather than blocking if the socket's send
+// queue is full; when false, sending it blocks (subject to OptionSendDeadline)
+// even if the socket is otherwise best-effort.  Messages on which this has
+// not been called follow the socket setting.
+func (m *Message) SetBestEffort(b bool) {
+	if b {
+		m.effort = 1
+	} else {
+		m.effort = -1
+	}
+}
+
+// MaxBacktrace is the longest backtrace SetBacktrace accepts, in entries:
+// the local pipe ID, plus up to 255 (the largest OptionTTL) hops.
+const MaxBacktrace = 256
+
+// Backtrace returns the Header of a message received on a raw REP or
+// RESPONDENT socket (or a raw SURVEYOR with OptionSurveyBacktrace set) as a
+// slice of 32-bit entries.  Each entry is four bytes of the header, in
+// big-endian order.  The first is the ID of the pipe the request arrived
+// on (which selects the pipe the reply is sent on), followed by the pipe
+// IDs added by each device the request passed through, most recent first,
+// and finally the request ID, which is the only entry with the high bit
+// (0x80000000) set.  Nil is returned if the
+// header is not a whole number of entries.  The slice is a copy, so
+// modifying it has no effect until it is passed to SetBacktrace.
+func (m *Message) Backtrace() []uint32 {
+	if len(m.Header)%4 != 0 {
+		return nil
+	}
+	bt := make([]uint32, 0, len(m.Header)/4)
+	for b := m.Header; len(b) > 0; b = b[4:] {
+		bt = append(bt, binary.BigEndian.Uint32(b))
+	}
+	return bt
+}
+
+// SetBacktrace replaces the Header with the given backtrace, laid out as
+// described for Backtrace.  This allows routers built on raw sockets to
+// direct a reply along a chosen path, rather than pushing and popping
+// header bytes by hand.  ErrBadTrace is returned, and the message is left
+// unchanged, if the backtrace is empty, longer than MaxBacktrace, or if
+// any entry other than the last has the high bit set, or the last does
+// not.  Sockets may impose a lower limit; raw REP drops replies whose
+// backtrace is deeper than its OptionTTL.
+func (m *Message) SetBacktrace(bt []uint32) error {
+	if len(bt) == 0 || len(bt) > MaxBacktrace {
+		return ErrBadTrace
+	}
+	for i, v := range bt {
+		if (v&0x80000000 != 0) != (i == len(bt)-1) {
+			return ErrBadTrace
+		}
+	}
+	m.Header = m.Header[:0]
+	var b [4]byte
+	for _, v := range bt {
+		binary.BigEndian.PutUint32(b[:], v)
+		m.Header = append(m.Header, b[:]...)
+	}
+	return nil
+}
+
 // NewMessage is the supported way to obtain a new Message.  This makes
 // use of a "cache" which greatly reduces the load on the garbage collector.
 func NewMessage(sz int) *Message {
@@ -168,5 +492,15 @@ func NewMessage(sz int) *Message {
 	m.refcnt = 1
 	m.Body = m.bbuf
 	m.Header = m.hbuf
+	m.topic = nil
+	m.ident = nil
+	m.onSent = nil
+	m.sentq = nil
+	m.stamp = time.Time{}
+	m.expire = time.Time{}
+	m.prio = 0
+	m.effort = 0
+	m.ttl = 0
+	m.wire = nil
 	return m
 }
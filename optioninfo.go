@@ -0,0 +1,114 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// OptionInfo describes an option accepted by a Socket, so that generic
+// configuration code, such as one loading options from a file, can check
+// names and convert values to the right type before calling SetOption.
+type OptionInfo struct {
+	// Name is the option name, for example OptionRecvDeadline.
+	Name string
+
+	// Type is the Go type of the value given to SetOption and returned
+	// by GetOption.  Where SetOption accepts more than one type, as
+	// OptionSubscribe does, this is the one GetOption would return.
+	Type reflect.Type
+
+	// ReadOnly is true if the option can only be read with GetOption.
+	ReadOnly bool
+
+	// WriteOnly is true if the option can only be set with SetOption.
+	WriteOnly bool
+}
+
+// The types of option values, for use in OptionInfo.
+var (
+	boolType     = reflect.TypeOf(false)
+	intType      = reflect.TypeOf(0)
+	uint64Type   = reflect.TypeOf(uint64(0))
+	stringType   = reflect.TypeOf("")
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// coreOptions are the options handled by the socket itself, rather than
+// by its protocol.
+var coreOptions = []OptionInfo{
+	{Name: OptionRecvDeadline, Type: durationType},
+	{Name: OptionSendDeadline, Type: durationType},
+	{Name: OptionLinger, Type: durationType},
+	{Name: OptionWriteQLen, Type: intType},
+	{Name: OptionWriteQLenPerPipe, Type: intType},
+	{Name: OptionReadQLen, Type: intType},
+	{Name: OptionMaxRecvSize, Type: intType},
+	{Name: OptionStreamChunkSize, Type: intType},
+	{Name: OptionDefaultBodySize, Type: intType},
+	{Name: OptionMaxSendSize, Type: intType},
+	{Name: OptionMinSendSize, Type: intType},
+	{Name: OptionReconnectTime, Type: durationType},
+	{Name: OptionMaxReconnectTime, Type: durationType},
+	{Name: OptionBestEffort, Type: boolType, WriteOnly: true},
+	{Name: OptionHandshakeHook, Type: reflect.TypeOf(HandshakeHook(nil))},
+	{Name: OptionHandshakeTimeout, Type: durationType},
+	{Name: OptionGreetingLenient, Type: boolType},
+	{Name: OptionRecvWire, Type: boolType},
+	{Name: OptionFragment, Type: boolType},
+	{Name: OptionCloseWait, Type: boolType},
+	{Name: OptionSendNoCopy, Type: boolType},
+	{Name: OptionRecvFull, Type: stringType},
+	{Name: OptionRecvDrops, Type: uint64Type, ReadOnly: true},
+	{Name: OptionRecvDrain, Type: durationType},
+	{Name: OptionSendPriority, Type: boolType},
+	{Name: OptionSendTimestamp, Type: boolType},
+	{Name: OptionMaxMessageAge, Type: durationType},
+	{Name: OptionStaleDrops, Type: uint64Type, ReadOnly: true},
+	{Name: OptionGarbledDrops, Type: uint64Type, ReadOnly: true},
+	{Name: OptionGarbledHook, Type: reflect.TypeOf(GarbledHook(nil))},
+	{Name: OptionGarbledLimit, Type: intType},
+	{Name: OptionBytesSent, Type: uint64Type, ReadOnly: true},
+	{Name: OptionBytesRecv, Type: uint64Type, ReadOnly: true},
+	{Name: OptionConnected, Type: boolType, ReadOnly: true},
+	{Name: OptionTransports, Type: reflect.TypeOf([]string(nil))},
+	{Name: OptionPipeID, Type: reflect.TypeOf((func() uint32)(nil))},
+	{Name: OptionClock, Type: reflect.TypeOf((*Clock)(nil)).Elem()},
+}
+
+func (sock *socket) Options() []OptionInfo {
+	var opts []OptionInfo
+	seen := make(map[string]bool)
+	if po, ok := sock.proto.(ProtocolOptions); ok {
+		for _, o := range po.Options() {
+			if !seen[o.Name] {
+				seen[o.Name] = true
+				opts = append(opts, o)
+			}
+		}
+	}
+	for _, o := range coreOptions {
+		if !seen[o.Name] {
+			seen[o.Name] = true
+			opts = append(opts, o)
+		}
+	}
+	sort.Slice(opts, func(i, j int) bool {
+		return opts[i].Name < opts[j].Name
+	})
+	return opts
+}
@@ -51,6 +51,15 @@ const (
 	// is outstanding may not have the desired effect.
 	OptionRetryTime = "RETRY-TIME"
 
+	// OptionReplyLatency is a read-only option on REQ sockets that
+	// summarizes the time taken to get replies, as a LatencyStats.  Each
+	// reply is measured from when its request was first sent (so the
+	// time spent waiting for retries, see OptionRetryTime, is included)
+	// until Recv returns it, using the socket's Clock.  With
+	// OptionBroadcast, every reply is measured.  Raw mode sockets do
+	// not track requests, and so record nothing.
+	OptionReplyLatency = "REPLY-LATENCY"
+
 	// OptionSubscribe is used by SUB/XSUB.  The argument is a []byte.
 	// The application will receive messages that start with this prefix.
 	// Multiple subscriptions may be in effect on a given socket.  The
@@ -65,6 +74,65 @@ const (
 	// removed from the socket.
 	OptionUnsubscribe = "UNSUBSCRIBE"
 
+	// OptionSubscribeAll is used by SUB/XSUB.  When true, every message
+	// is received, without consulting the subscription list at all.  This
+	// is clearer than subscribing to the empty prefix, and faster when
+	// other subscriptions are present.  Subscriptions may still be added
+	// and removed while it is set; they take effect again once it is
+	// cleared.  As no subscription is matched, a message lacking the
+	// OptionTopicDelimiter has an empty topic.  The value is a bool,
+	// default false.
+	OptionSubscribeAll = "SUBSCRIBE-ALL"
+
+	// OptionSubMatch is used by SUB to filter messages with an arbitrary
+	// function, such as a regular expression or a test of the content.
+	// The value is a func([]byte) bool, which is passed the body of each
+	// message that matches a subscription (or OptionSubscribeAll), and
+	// which returns true to deliver it; so to filter with the function
+	// alone, subscribe to everything.  Cheap prefix subscriptions can
+	// usefully narrow the messages the function must look at.  It is
+	// called on the goroutine receiving from each connection, for every
+	// such message, so it must be safe for concurrent use, must not
+	// retain the slice, and should be fast: a slow function holds up
+	// the connection, and so the publisher, unless OptionSubMatchWorkers
+	// is set.  Publishers are not told of it (see OptionSubForward), so
+	// it does not reduce the traffic sent.  nil, the default, delivers
+	// every subscribed message.
+	OptionSubMatch = "SUB-MATCH"
+
+	// OptionSubMatchWorkers is used by SUB to run the OptionSubMatch
+	// function on a pool of goroutines, rather than on the goroutine
+	// receiving from each connection.  A connection can then keep
+	// reading while earlier messages are still being matched, and
+	// several of its messages can be matched at once, which helps when
+	// the function is slow, for example because it waits on a lookup.
+	// Messages from each connection are still delivered in the order
+	// they arrived; up to 64 of them may be awaiting a match at once,
+	// after which the connection waits.  The value is an int, the number
+	// of goroutines; zero, the default, runs the function inline.
+	OptionSubMatchWorkers = "SUB-MATCH-WORKERS"
+
+	// OptionSubActivityHook is used by SUB to learn when a subscription
+	// starts and stops matching traffic, which helps to detect topics
+	// that have gone dead.  The value is a func(topic string, active
+	// bool), called with active true when a subscription first matches
+	// a message, and with active false once it has matched nothing for
+	// OptionSubIdleTime; a later match makes it active again.  Only
+	// prefix subscriptions are tracked, not OptionSubscribeAll, and a
+	// message counts even if OptionSubMatch then discards it.  The
+	// function is called without locks held, from the goroutine handling
+	// the connection or from a timer, so it must be safe for concurrent
+	// use.  Unsubscribing forgets the subscription's state, without a
+	// call.  The default is nil.
+	OptionSubActivityHook = "SUB-ACTIVITY-HOOK"
+
+	// OptionSubIdleTime is how long a subscription may go without
+	// matching a message before OptionSubActivityHook reports it as
+	// quiet.  It applies to subscriptions that become active after it
+	// is set.  The value is a time.Duration; zero, the default, means
+	// that subscriptions are never reported quiet.
+	OptionSubIdleTime = "SUB-IDLE-TIME"
+
 	// OptionSurveyTime is used to indicate the deadline for survey
 	// responses, when used with a SURVEYOR socket.  Messages arriving
 	// after this will be discarded.  Additionally, this will set the
@@ -74,16 +142,151 @@ const (
 	// indicate an infinite time.  Default is 1 second.
 	OptionSurveyTime = "SURVEY-TIME"
 
+	// OptionSurveyCancel is used by SURVEYOR to end the current survey
+	// before OptionSurveyTime elapses, for example once enough responses
+	// have been gathered.  Responses to the canceled survey that arrive
+	// later, or that have not yet been received, are discarded, and
+	// receive operations fail with ErrCanceled (including any already
+	// blocked) until the next survey is sent.  Canceling a survey twice
+	// does nothing.  The value is a bool, which must be true.  It cannot
+	// be retrieved with GetOption.
+	OptionSurveyCancel = "SURVEY-CANCEL"
+
+	// OptionSurveyDedup is used by SURVEYOR to discard all but the first
+	// response received on each connection for a survey.  This pairs
+	// with OptionRetransmitCount on respondents, so that retransmitted
+	// copies are only counted once.  It must not be used when a single
+	// connection carries responses from several respondents, as through
+	// a device.  The value is a bool, default false.
+	OptionSurveyDedup = "SURVEY-DEDUP"
+
+	// OptionSurveyBacktrace is used by raw SURVEYOR sockets, to say which
+	// respondent each response came from.  When set, the Header of a
+	// received response starts with the ID of the pipe it arrived on,
+	// followed by the survey ID, laid out as a REP or RESPONDENT
+	// backtrace is (see Message.Backtrace).  The pipe ID stays the same
+	// for as long as the respondent is connected, so an aggregator can
+	// use it to correlate responses to the same respondent across
+	// surveys, or to pick out the Endpoint with Socket.Endpoints.  As
+	// for OptionSurveyDedup, several respondents behind a device share
+	// the device's pipe.  The header must be trimmed back to the survey
+	// ID before a response is forwarded on, for example with a Device.
+	// It has no effect in cooked mode.  The value is a bool, default
+	// false.
+	OptionSurveyBacktrace = "SURVEY-BACKTRACE"
+
+	// OptionRetransmitCount is used by RESPONDENT to send extra copies
+	// of each response, improving the odds of delivery over unreliable
+	// paths.  The copies follow the original at OptionRetransmitInterval
+	// apart; those arriving after the survey has ended are discarded by
+	// the surveyor, which should use OptionSurveyDedup.  The value is an
+	// int, the number of extra copies, default zero.
+	OptionRetransmitCount = "RETRANSMIT-COUNT"
+
+	// OptionRetransmitInterval is the time between copies of a response
+	// sent due to OptionRetransmitCount.  Copies not yet sent are
+	// discarded when the peer goes away, or the socket is closed.  The
+	// value is a time.Duration, default 100 milliseconds.
+	OptionRetransmitInterval = "RETRANSMIT-INTERVAL"
+
+	// OptionInprocNoCopy makes the inproc transport hand message bodies
+	// to the peer socket directly, rather than copying them.  This can be
+	// a large saving for big messages in trusted, single process
+	// pipelines.  It is set with DialOptions or ListenOptions, and affects
+	// messages sent over the resulting pipes.  The value is a boolean,
+	// and defaults to false.
+	//
+	// WARNING: with this option the sender and the receiver share the
+	// same memory.  Once a message has been sent with SendMsg, the sender
+	// must not modify or reuse the byte slice used as its body for any
+	// purpose, and the receiver must treat the body it gets as shared
+	// too.  (Send copies its argument into a fresh message, so the slice
+	// passed to it remains the caller's.)  Violating this results in silent data
+	// corruption.  Messages carrying a protocol header (such as those of
+	// REQ, REP, SURVEYOR and RESPONDENT) are still copied.
+	OptionInprocNoCopy = "INPROC-NO-COPY"
+
+	// OptionSendNoCopy makes Send take ownership of the byte slice it is
+	// given, using it as the body of the message sent, instead of copying
+	// it into a fresh message as it does by default.  This saves a copy
+	// (and an allocation, for large messages) on every Send, without the
+	// caller having to build a Message for SendMsg.  Together with
+	// OptionInprocNoCopy, a message can go from one socket to another in
+	// the same process without being copied at all.  The value is a bool,
+	// and defaults to false.
+	//
+	// WARNING: once Send has been called with this option set, the slice
+	// passed to it belongs to the socket, whether or not Send returned an
+	// error.  The caller must not modify it, reuse it for another message,
+	// or return it to a pool: it may still be waiting in a queue, being
+	// written by a transport, or (with OptionInprocNoCopy) be in the hands
+	// of the receiver, and the protocol may write to spare capacity beyond
+	// its length.  Doing any of these results in silent data corruption,
+	// which no error will report.  Pass a slice that is never touched
+	// again, such as one freshly allocated for the message.
+	OptionSendNoCopy = "SEND-NO-COPY"
+
 	// OptionTLSConfig is used to supply TLS configuration details. It
 	// can be set using the ListenOptions or DialOptions.
 	// The parameter is a tls.Config pointer.
 	OptionTLSConfig = "TLS-CONFIG"
 
+	// OptionTLSSessionCache is used by TLS dialers to resume sessions on
+	// reconnect, avoiding the cost of a full handshake.  The value is
+	// either a tls.ClientSessionCache, which may be shared by several
+	// dialers, or a bool; true uses a cache shared by all dialers of the
+	// same transport instance, and false disables caching.  It overrides
+	// any ClientSessionCache in the OptionTLSConfig, which is otherwise
+	// honored as is.  Listeners issue session tickets unless the server
+	// configuration sets SessionTicketsDisabled.
+	OptionTLSSessionCache = "TLS-SESSION-CACHE"
+
+	// OptionTLSCoalesce trades latency for throughput on TLS connections.
+	// Normally each write (several per message) is sent at once in its
+	// own TLS record, which keeps latency low but costs a record header,
+	// MAC, and usually a TCP segment each time.  When this is set to a
+	// positive time.Duration, writes are buffered and sent together
+	// once a full record (16 KB) has accumulated, or after that delay,
+	// whichever comes first.  This suits bulk transfers of many small
+	// messages.  It can be set using the ListenOptions or DialOptions,
+	// and defaults to zero, which disables coalescing.  Socket.Flush
+	// writes out buffered data without waiting for the delay, so that an
+	// application can batch messages and flush them at times of its own
+	// choosing, with the delay as a bound.  Note that with coalescing,
+	// PropNetConn refers to a wrapper around the *tls.Conn.
+	OptionTLSCoalesce = "TLS-COALESCE"
+
+	// OptionTLSHandshakeTimeout bounds the time allowed for the TLS
+	// handshake on tls+tcp connections.  A peer that stalls the
+	// handshake is otherwise able to hold a connection open forever,
+	// and, on a listener, to hold up the connections behind it.  When
+	// the time runs out the connection is closed.  The SP greeting that
+	// follows is covered separately, by OptionHandshakeTimeout.  It can
+	// be set using the ListenOptions or DialOptions.  The value is a
+	// time.Duration; zero, the default, means no limit.
+	OptionTLSHandshakeTimeout = "TLS-HANDSHAKE-TIMEOUT"
+
 	// OptionWriteQLen is used to set the size, in messages, of the write
 	// queue channel. By default, it's 128. This option cannot be set if
 	// Dial or Listen has been called on the socket.
+	//
+	// This is the socket level queue, shared by all pipes, which Send
+	// places messages on.  Protocols that fan out to several peers (PUB,
+	// BUS and STAR) also keep a queue for each pipe, fed from the socket
+	// queue; see OptionWriteQLenPerPipe.  Other protocols hand messages
+	// to one pipe at a time with little or no buffering of their own, so
+	// for them the socket queue is the only one that matters.
 	OptionWriteQLen = "WRITEQ-LEN"
 
+	// OptionWriteQLenPerPipe sets the size, in messages, of the write
+	// queue that fan out protocols (PUB, BUS and STAR) keep for each
+	// pipe.  It bounds how much a single slow peer can have buffered;
+	// once its queue is full, further messages for that peer are dropped
+	// while other peers continue to receive them.  The value is an int,
+	// and defaults to the value of OptionWriteQLen.  It may be changed at
+	// any time, but only affects pipes connected afterwards.
+	OptionWriteQLenPerPipe = "WRITEQ-LEN-PER-PIPE"
+
 	// OptionReadQLen is used to set the size, in messages, of the read
 	// queue channel. By default, it's 128. This option cannot be set if
 	// Dial or Listen has been called on the socket.
@@ -95,7 +298,8 @@ const (
 
 	// OptionKeepAliveTime is used to set TCP KeepAlive time in seconds.
 	// Value is a time.Duration. Default is OS dependent.
-	// Default is true.
+	// Default is true.  The UDP transport uses it as the interval between
+	// its own keepalives; there the default is one second.
 	OptionKeepAliveTime = "KEEPALIVETIME"
 
 	// OptionNoDelay is used to configure Nagle -- when true messages are
@@ -103,6 +307,32 @@ const (
 	// Value is a boolean.  Default is true.
 	OptionNoDelay = "NO-DELAY"
 
+	// OptionTCPLinger sets SO_LINGER on TCP connections, controlling what
+	// the operating system does with unsent data when a connection is
+	// closed.  This is unrelated to OptionLinger, which is about the
+	// socket's own queues.  Zero makes close abortive: pending data is
+	// discarded and the peer gets a reset (RST), which is useful for
+	// dropping misbehaving clients without leaving connections in
+	// TIME_WAIT.  A positive value makes close wait up to that long
+	// (rounded up to whole seconds) for the data to be sent.  A negative
+	// value restores the default, a graceful close in the background.
+	// It is set on a Dialer or Listener, and applies to connections it
+	// makes afterwards.  The value is a time.Duration; by default the
+	// operating system's behavior is left alone.
+	OptionTCPLinger = "TCP-LINGER"
+
+	// OptionTOS sets the IP type of service byte (IPV6_TCLASS for IPv6)
+	// on TCP connections, so that managed networks can prioritize some
+	// traffic, such as a control plane, over bulk data.  The upper six
+	// bits are the DSCP code point, so for example Expedited Forwarding
+	// (DSCP 46) is 46<<2, or 184.  It is set on a Dialer or Listener
+	// before Dial or Listen, and is applied to the socket before it
+	// connects or listens; accepted connections take it from the
+	// listening socket.  It is only supported on Unix-like systems, and
+	// elsewhere setting it returns ErrBadOption.  The value is an int
+	// from 0 to 255; by default the operating system's value is used.
+	OptionTOS = "TOS"
+
 	// OptionLinger is used to set the linger property.  This is the amount
 	// of time to wait for send queues to drain when Close() is called.
 	// Close() may block for up to this long if there is unsent data, but
@@ -115,8 +345,29 @@ const (
 	// those that do, if a message traverses more than this many devices,
 	// it will be dropped.  This is used to provide protection against
 	// loops in the topology.  The default is protocol specific.
+	//
+	// On BUS, which has no backtrace to measure, OptionTTL makes each
+	// message carry a hop limit, in a byte ahead of the body, which raw
+	// BUS sockets relaying the message (as in a Device) decrease, and
+	// the message is dropped when it runs out; this bounds propagation
+	// in large meshes, whatever their shape.  The value set is the limit
+	// for messages sent without Message.SetTTL.  As this changes the
+	// wire format, every BUS socket in the mesh must use it.  The value
+	// is an int from 0 to MaxTTL; 0, the default, disables hop limits.
 	OptionTTL = "TTL"
 
+	// OptionReplyAnyOrder lets a cooked REP socket reply to requests in
+	// any order.  Normally REP remembers only the request most recently
+	// received, and a reply always goes to it.  With this set, each
+	// message from RecvMsg keeps the backtrace of its request in the
+	// Header, and a message sent with SendMsg that carries such a Header
+	// is routed by it, to the right client whatever else has been
+	// received or replied to since.  The simplest way is to set the Body
+	// of the request message to the reply and send it back.  Messages
+	// sent without a Header (including those from Send) still answer the
+	// latest request, once.  The value is a bool, default false.
+	OptionReplyAnyOrder = "REPLY-ANY-ORDER"
+
 	// OptionMaxRecvSize supplies the maximum receive size for inbound
 	// messages.  This option exists because the wire protocol allows
 	// the sender to specify the size of the incoming message, and
@@ -137,6 +388,42 @@ const (
 	// and not a substitute for proper application message verification.
 	OptionMaxRecvSize = "MAX-RCV-SIZE"
 
+	// OptionDefaultBodySize is the smallest body capacity the socket
+	// allocates for messages it receives over stream transports (TCP,
+	// TLS and IPC), and for those created by Send.  Smaller messages get
+	// room to grow, so that an application which appends to them, for
+	// example to build a reply in the request's message, does not have
+	// to reallocate.  Larger values trade memory for fewer allocations;
+	// messages bigger than this are sized to fit, as always.  It is read
+	// when a connection is established.  The value is an int; the
+	// default of 0 sizes each message to its content.
+	OptionDefaultBodySize = "DEFAULT-BODY-SIZE"
+
+	// OptionStreamChunkSize enables SendStream and RecvStream, which
+	// carry a single large payload as a series of messages, so that it
+	// never has to be held in memory whole.  The value is the number of
+	// payload bytes in each chunk; a small header is added to each, and
+	// the result must fit within the receiver's OptionMaxRecvSize.  Both
+	// peers must set it, as it implies a framing of the message bodies
+	// that ordinary messages do not follow.  The value is an int; zero,
+	// the default, disables streaming.
+	OptionStreamChunkSize = "STREAM-CHUNK-SIZE"
+
+	// OptionMaxSendSize is the largest message body that Send will accept.
+	// Larger messages are rejected with ErrTooLong before they are queued,
+	// which helps catch application bugs early rather than having the
+	// peer discard them (see OptionMaxRecvSize).  The size is that of the
+	// body only; protocol headers added by the socket are not counted.
+	// Value is an int; 0 (the default) means unbounded.
+	OptionMaxSendSize = "MAX-SND-SIZE"
+
+	// OptionMinSendSize is the smallest message body that Send will accept.
+	// Shorter messages are rejected with ErrTooShort.  This is useful to
+	// catch messages that were accidentally left empty.  As with
+	// OptionMaxSendSize, only the body is considered.  Value is an int;
+	// 0 (the default) means unbounded.
+	OptionMinSendSize = "MIN-SND-SIZE"
+
 	// OptionReconnectTime is the initial interval used for connection
 	// attempts.  If a connection attempt does not succeed, then ths socket
 	// will wait this long before trying again.  An optional exponential
@@ -159,6 +446,535 @@ const (
 	// with the sender. (Multicast sockets types like Bus or Star do not
 	// behave this way.)  If this option is set, instead of blocking, the
 	// message will be silently discarded.  The value is a boolean, and
-	// defaults to False.
+	// defaults to False.  Individual messages may override this with
+	// Message.SetBestEffort.
 	OptionBestEffort = "BEST-EFFORT"
+
+	// OptionClock supplies the Clock used by protocol timers, such as
+	// the REQ retry timer, the SURVEYOR deadline, the RESPONDENT
+	// OptionRetransmitInterval, and the wait for OptionSync and
+	// OptionSubIdleTime on SUB.  The value is a Clock, and defaults to
+	// RealClock().  This is intended primarily for testing, where a fake
+	// clock allows timer driven behavior to be exercised
+	// deterministically.  It should be set before any requests or
+	// surveys are started.
+	OptionClock = "CLOCK"
+
+	// OptionTopicDelimiter is used by SUB to split received messages
+	// into a topic and a payload.  The value is a []byte (or string).
+	// When set to a non-empty value, the topic is the portion of the
+	// message preceding the first occurrence of the delimiter, and the
+	// Body is the portion following it.  The topic is available from
+	// Message.Topic() when using RecvMsg.  If a message does not contain
+	// the delimiter, then the matched subscription prefix is used as the
+	// topic instead.  The default is empty, meaning no splitting.
+	OptionTopicDelimiter = "TOPIC-DELIMITER"
+
+	// OptionTopicLength is used by SUB to split received messages into
+	// a fixed size topic and a payload.  The value is an int, giving
+	// the number of leading bytes that make up the topic.  Messages
+	// shorter than this are dropped.  The default is zero, meaning no
+	// splitting.  OptionTopicDelimiter takes precedence if both are set.
+	OptionTopicLength = "TOPIC-LENGTH"
+
+	// OptionAckMode is used by PUSH and PULL to enable at-least-once
+	// delivery.  When enabled, PUSH tags each message with a sequence
+	// number, and PULL returns a small acknowledgement once the message
+	// has been handed to the application.  Messages not acknowledged
+	// when a pipe is lost are resent, in their original order, on another
+	// (or a reconnected) pipe.  Duplicates are possible, so applications
+	// must tolerate them.  This changes the wire protocol, so both peers
+	// must enable it, and it must be set before Dial or Listen.  For best
+	// results PULL should also use a zero OptionReadQLen, as messages are
+	// acknowledged once queued.  The value is a bool, default false.
+	OptionAckMode = "ACK-MODE"
+
+	// OptionSendWindow is used by PUSH in ack mode (see OptionAckMode)
+	// to limit the number of unacknowledged messages on each pipe.  Once
+	// a pipe has that many messages outstanding, PUSH sends it no more
+	// until the peer acknowledges some, and other pipes take the traffic
+	// meanwhile.  This keeps a slow consumer from being buried in
+	// messages (and from holding a large backlog that must be resent if
+	// it fails), and reacts sooner than TCP backpressure.  The value is
+	// an int; zero, the default, means no limit.  It is ignored outside
+	// of ack mode, and only affects pipes connected after it is set.
+	OptionSendWindow = "SEND-WINDOW"
+
+	// OptionDedupWindow is used by PULL in ack mode (see OptionAckMode)
+	// to discard messages that PUSH has resent but which were already
+	// delivered, typically because the acknowledgement was lost along
+	// with the pipe.  PULL remembers the sequence numbers of the last
+	// this many messages received, and drops (after acknowledging again)
+	// any message repeating one of them, so the application sees each
+	// message once as long as the repeat arrives within the window.
+	// Each PUSH socket starts its sequence at a random value, but they
+	// are not otherwise coordinated; with several senders a large window
+	// makes it more likely that a fresh message is mistaken for a
+	// duplicate.  It must be set before Dial or Listen, although the
+	// window may later be resized.  The value is an int; zero, the
+	// default, disables it.
+	OptionDedupWindow = "DEDUP-WINDOW"
+
+	// OptionBatchSize is used by PUSH and PULL to coalesce small messages
+	// into larger wire frames, amortizing the per-frame overhead at very
+	// high rates of tiny messages.  On PUSH, the value is the size in
+	// bytes at which a frame is closed; messages already waiting in the
+	// send queue are appended, each with a 4 byte length prefix, until
+	// the frame reaches this size, or the peer's turn (see OptionWeight)
+	// is used up, each message counting as one.  No delay is added to
+	// wait for more messages, so an idle socket sends each message alone
+	// (framed).  On
+	// PULL, any positive value enables splitting frames back into the
+	// original messages.  This changes the wire protocol, so both peers
+	// must enable it, and it must be set before Dial or Listen.  Split
+	// messages carry the Port of the frame, and the OnSent function of
+	// each batched message is called when its frame is written.  It is
+	// ignored in ack mode and in raw mode.  The value is an int, default
+	// zero (disabled).
+	OptionBatchSize = "BATCH-SIZE"
+
+	// OptionTTLDrops is a read-only option that reports the number of
+	// messages dropped because their backtrace was deeper than the
+	// limit set by OptionTTL, whether requests received or replies sent
+	// with a backtrace set by hand (see Message.SetBacktrace).  This is
+	// useful to detect misconfigured device topologies, or peers sending
+	// abusive headers.  The value is a uint64.  REP supports this, as
+	// does BUS, where it counts messages not relayed because their hop
+	// limit ran out.
+	OptionTTLDrops = "TTL-DROPS"
+
+	// OptionRouteTableSize bounds the number of peers a REP socket keeps
+	// in the table it uses to route replies, one entry per connected
+	// pipe.  Entries normally go away when the pipe disconnects, but a
+	// broker with many transient clients that vanish without closing
+	// their connections can accumulate them.  When a new peer connects
+	// and the table is full, the peer least recently heard from or
+	// replied to is disconnected to make room, and counted in
+	// OptionRouteEvictions; replies still due to it are lost.  The value
+	// is an int; 0, the default, means no limit.
+	OptionRouteTableSize = "ROUTE-TABLE-SIZE"
+
+	// OptionRouteEvictions is a read-only option that reports the number
+	// of peers disconnected by REP because of OptionRouteTableSize.  The
+	// value is a uint64.
+	OptionRouteEvictions = "ROUTE-EVICTIONS"
+
+	// OptionBroadcast is used by REQ.  When true, each request is sent to
+	// every connected REP peer, rather than to just one of them, and
+	// every reply to the outstanding request is passed up to the
+	// application; the Port of each received Message identifies which
+	// peer sent it.  Replies for a request are accepted until the next
+	// request is sent, so applications will generally use
+	// OptionRecvDeadline to bound collection.  Automatic retries are not
+	// performed in this mode.  A peer slow to take its copy holds up the
+	// rest, as for any send, until OptionSendDeadline passes; its copy
+	// is then dropped, which a DropHook sees as DropReasonQueueFull.
+	// This differs from SURVEYOR in that the peers are ordinary REP
+	// sockets, and there is no survey deadline.  The value is a bool,
+	// default false.
+	OptionBroadcast = "BROADCAST"
+
+	// OptionHandshakeHook supplies a HandshakeHook that is called during
+	// the SP greeting exchange on stream transports (TCP, TLS, IPC),
+	// before the pipe is attached to the socket.  The hook can reject
+	// the connection by returning an error.  The value is a
+	// HandshakeHook, and the default is nil (no hook).
+	OptionHandshakeHook = "HANDSHAKE-HOOK"
+
+	// OptionHandshakeTimeout bounds the time allowed for the SP greeting
+	// exchange on stream transports (TCP, TLS, IPC).  If the peer has
+	// not sent a valid greeting within this time, the connection is
+	// closed.  This protects listeners from clients that connect but
+	// never speak SP, such as port scanners, or deliberately slow
+	// clients.  The value is a time.Duration; zero, the default, means
+	// no limit.
+	OptionHandshakeTimeout = "HANDSHAKE-TIMEOUT"
+
+	// OptionGreetingLenient relaxes the checks made on the SP greeting
+	// received from peers on stream transports (TCP, TLS, IPC), for
+	// interoperating with implementations that fill it in differently.
+	// The greeting is eight bytes: a zero, 'S', 'P', the version, the
+	// 16-bit protocol number, and two reserved bytes.  Normally (strict
+	// mode) the reserved bytes must be zero, as the RFC requires, and
+	// the greeting is refused with ErrBadHeader otherwise; in lenient
+	// mode they are ignored, as nanomsg and NNG do.  The leading zero,
+	// 'S' and 'P', the version (which must be 0), and the protocol
+	// number are checked in either mode.  The value is a bool, default
+	// false (strict).
+	OptionGreetingLenient = "GREETING-LENIENT"
+
+	// OptionRecvWire is a debugging aid, for looking at messages as
+	// peers actually framed them.  When set, the Body of each received
+	// message is the whole frame as it came off the transport, with the
+	// SP header (such as the request ID of REQ, or the backtrace of a
+	// relayed REP request) still at the front, rather than the payload
+	// alone.  This goes further than OptionRaw, which splits the header
+	// into Header: the protocol still parses and strips the header as
+	// usual, so replies and other protocol state work as normal, and only
+	// what is handed to the application differs.  The length prefix used
+	// by stream transports is not included.  The value is a bool,
+	// default false.
+	OptionRecvWire = "RECV-WIRE"
+
+	// OptionFragment lets messages bigger than a connection's frames be
+	// sent anyway, by splitting them into several frames which the peer
+	// puts back together before the protocol sees them.  This is mostly
+	// for transports with small frames, such as UDP, where a message
+	// larger than a datagram could otherwise not be sent at all.  The
+	// frame size is the smaller of PropFrameSizeHint at the two ends:
+	// each end with the option set tells the other its own when the
+	// connection is made (or when the option is set), and messages are
+	// only split once the peer's has arrived, so both peers must set it
+	// for it to have any effect.  A message too big for the frames that
+	// is sent before then waits up to a second for the peer's, and is
+	// sent whole if none comes.  The announcement is an ordinary frame,
+	// a zero word and "MANGOS-FRGS" followed by the frame size, so a
+	// peer that is not mangos receives it as a message.  Messages that
+	// look like fragments are passed on as they are unless both ends
+	// have set the option.  Fragments of a message that never completes
+	// (as when a datagram is lost) are discarded once 16 newer messages
+	// are being put together, and the whole message is subject to
+	// OptionMaxRecvSize.  The value is a bool, default false.
+	OptionFragment = "FRAGMENT"
+
+	// OptionCloseWait makes Close block until every goroutine the socket
+	// and its protocol started has exited, rather than leaving some of
+	// them to finish in the background.  This is meant for tests and
+	// orderly process shutdown, for instance where a goroutine leak
+	// detector runs after Close.  Goroutines started by the pipes and
+	// listeners of a transport, such as UDP, are covered too, as Close
+	// closes those and they wait for their goroutines.  The websocket
+	// listener's HTTP server is the exception, as it belongs to net/http.
+	// Close must not be called from a hook or callback run by the socket
+	// while this is set, or it will wait for itself.  The value is a
+	// bool, default false.
+	OptionCloseWait = "CLOSE-WAIT"
+
+	// OptionSync is used by SUB to wait until at least one publisher is
+	// delivering to this socket, so that no messages published from
+	// that point on are missed.  (Subscriptions are filtered locally by
+	// SUB, so the race is that of the publisher attaching its end of a
+	// new connection.)  SUB sends a small probe, which a PUB socket
+	// echoes back; when the echo arrives the publisher is known to be
+	// ready.  Setting this option blocks, and the value is a
+	// time.Duration with the same meaning as OptionRecvDeadline: zero
+	// waits indefinitely, and a negative value does not wait.
+	// ErrRecvTimeout is returned if no publisher answers in time.  Peers
+	// that do not echo the probe (such as older versions) will never
+	// answer.  This option cannot be read.
+	OptionSync = "SYNC"
+
+	// OptionSubForward makes SUB report its subscriptions to publishers,
+	// so that they know which topics have subscribers (see
+	// OptionSubscribers).  Filtering is still done by SUB; the reports
+	// are advisory.  A report is sent to each publisher when it
+	// connects and whenever the subscriptions change, and clearing the
+	// option tells publishers to forget them.  Only mangos PUB sockets
+	// understand these reports, so this should not be enabled when
+	// other SP implementations are publishing.  Reports are messages
+	// whose body starts with "\x00\x00SUBS"; while the option is set,
+	// SUB discards any published message that parses as one, taking it
+	// for its own report echoed back.  The value is a bool, default
+	// false.
+	OptionSubForward = "SUB-FORWARD"
+
+	// OptionSubscribers is used with PUB to obtain the number of
+	// subscribers to each topic, as a map[string]int from the topic (the
+	// subscription prefix, where "" means everything) to the count.  Only
+	// subscribers using OptionSubForward are counted; others, whose
+	// subscriptions are unknown, do not appear at all.  The map is a
+	// snapshot, and can be modified freely.  This option is read-only.
+	OptionSubscribers = "SUBSCRIBERS"
+
+	// OptionSubscriberHook sets a function which PUB calls when a topic
+	// gains its first subscriber (with true), or loses its last (with
+	// false), as counted by OptionSubscribers.  A publisher can use this
+	// to start or stop producing data that is expensive to generate.
+	// Calls are made in order, from a goroutine belonging to the socket,
+	// and the function should not block.  The value is a
+	// func(topic string, active bool), or nil (the default) for none.
+	OptionSubscriberHook = "SUBSCRIBER-HOOK"
+
+	// OptionDropUnsubscribed makes PUB discard messages that no connected
+	// subscriber would accept, rather than queueing them for every peer,
+	// which saves work when producers publish optimistically.  Only
+	// subscribers using OptionSubForward can be ruled out; any other peer
+	// might want any message, so while one is connected nothing is
+	// dropped.  Dropped messages are counted, see OptionUnsubscribedDrops.
+	// The value is a bool, default false.
+	OptionDropUnsubscribed = "DROP-UNSUBSCRIBED"
+
+	// OptionUnsubscribedDrops is a read-only option that reports the
+	// number of messages PUB discarded because of OptionDropUnsubscribed.
+	// The value is a uint64.
+	OptionUnsubscribedDrops = "UNSUBSCRIBED-DROPS"
+
+	// OptionRetain makes PUB keep the most recent messages published on
+	// chosen topics, and send them to each subscriber as soon as it
+	// connects, ahead of anything new, so that a late joiner (such as a
+	// dashboard) sees the current state straight away.  The value is a
+	// map[string]int from topic prefix to the number of messages to keep
+	// for it; a message is kept under the longest prefix it matches, and
+	// is not kept if it matches none.  Retained messages are sent in the
+	// order they were published, and the subscriber filters them like
+	// any others.  Changing the map keeps what is still wanted, trimmed
+	// to the new depths; nil (the default) or an empty map retains
+	// nothing.  Retention happens whether or not anyone is connected,
+	// except for messages discarded by OptionDropUnsubscribed.
+	OptionRetain = "RETAIN"
+
+	// OptionRecvFull selects what happens when a message arrives while
+	// the read queue (see OptionReadQLen) is full.  The value is one of
+	// RecvFullBlock, RecvFullDropOld, or RecvFullDropNew.  The default,
+	// RecvFullBlock, applies backpressure to the peers (for protocols
+	// that do not drop on their own).  The dropping policies instead
+	// discard the oldest queued message or the newly arrived one, which
+	// keeps latency low for consumers that only care about recent data.
+	// Dropped messages are counted, see OptionRecvDrops.  With a zero
+	// length read queue, RecvFullDropOld behaves as RecvFullDropNew.
+	// This option cannot be set if Dial or Listen has been called on the socket.
+	OptionRecvFull = "RECV-FULL"
+
+	// OptionRecvDrops is a read-only option that reports the number of
+	// messages discarded because of the OptionRecvFull policy.  Messages
+	// dropped by the protocol itself are not included.  The value is a
+	// uint64.
+	OptionRecvDrops = "RECV-DROPS"
+
+	// OptionBytesSent is a read-only option that reports the total number
+	// of message bytes the socket has written to its pipes since it was
+	// created.  Each message counts the length of its header plus its
+	// body as handed to the transport, so protocol headers (such as the
+	// 4 byte REQ request ID) are included, but transport framing (such as
+	// the 8 byte TCP length prefix) is not.  A message sent to several
+	// peers is counted once per peer; failed writes are not counted.  The
+	// value is a uint64, and only ever increases.
+	OptionBytesSent = "BYTES-SENT"
+
+	// OptionBytesRecv is the receive side counterpart of OptionBytesSent.
+	// It reports the total number of message bytes, protocol headers
+	// included, read from the socket's pipes since it was created.  All
+	// messages are counted, including those later discarded by the
+	// protocol or the OptionRecvFull policy.  The value is a uint64.
+	OptionBytesRecv = "BYTES-RECV"
+
+	// OptionConnected is a read-only option that reports whether the
+	// socket has at least one established pipe, for health checks and
+	// dashboards that just want a yes or no.  It only says that some
+	// connection is up, not that the peer is responsive (see Socket.Ping),
+	// and it can change at any moment afterwards.  Use the PortHook, or
+	// WaitConnected, to follow or wait for changes.  The value is a bool.
+	OptionConnected = "CONNECTED"
+
+	// OptionWeight is set on a Dialer (for example with DialOptions) to
+	// give connections made by it a load balancing weight.  PUSH and REQ
+	// send proportionally more messages to peers with higher weights, so
+	// a peer with weight 3 receives three messages for every one sent to
+	// a peer with weight 1.  Weights are applied strictly, so a slow peer
+	// will hold up the others once they have had their share.  The value
+	// is an int, at least 1, and the default is 1.  Connections accepted
+	// by a Listener always have weight 1.
+	OptionWeight = "WEIGHT"
+
+	// OptionRoundRobin is used by PUSH and REQ to send to their peers in
+	// strict rotation, rather than to whichever peer is ready first.
+	// Peers take their turns in order of Endpoint ID (the order given by
+	// Socket.Endpoints), wrapping around from the last to the first, and
+	// each gets as many messages in a row as its OptionWeight.  Peers that
+	// connect or disconnect join or leave the rotation at their place in
+	// that order, and ones disabled with SetSendEnabled are skipped.  This
+	// makes the spread of messages predictable, for reproducible tests
+	// for example, but a peer that is slow to accept its message holds up
+	// the rest.  Retransmitted requests take a turn like any other.  The
+	// value is a bool; the default, false, lets peers compete.
+	OptionRoundRobin = "ROUND-ROBIN"
+
+	// OptionSendSelector is used by PUSH and REQ to let the application
+	// choose the peer for each message, for content-based routing (on a
+	// header or key in the message), consistent hashing, or picking the
+	// least loaded peer.  The value is a SendSelector, and it is called
+	// with the peers enabled for sending, in order of Endpoint ID, for
+	// each message taken from the send queue, including requests being
+	// retried.  The message goes to the peer returned, waiting for it if
+	// it is busy; if the selector returns nil, or that peer disconnects
+	// first, the message goes wherever the protocol would have sent it.
+	// A peer that is slow to accept its messages can hold up others.
+	// Messages chosen this way are not batched (OptionBatchSize), and
+	// messages retransmitted in OptionAckMode are not offered to it.
+	// The selector is called from the socket's goroutines, so it must be
+	// safe for concurrent use, and should be fast.  The default is nil,
+	// leaving the choice to the protocol.
+	OptionSendSelector = "SEND-SELECTOR"
+
+	// OptionDialAttempts is set on a Dialer to limit the number of
+	// consecutive connection attempts that may fail before it gives up,
+	// rather than retrying forever.  Once it has given up, the Dialer
+	// stays idle until its Dial method is called again, so that the
+	// application can decide when (or whether) to reconnect.  A
+	// successful connection resets the count, so an established
+	// connection that drops is redialed as usual.  The value is an int;
+	// 1 means a single attempt, and 0, the default, means no limit.
+	// Use OptionDialHook to learn when the Dialer gives up.
+	OptionDialAttempts = "DIAL-ATTEMPTS"
+
+	// OptionDialHook is set on a Dialer to supply a DialHook, which is
+	// called after every failed connection attempt, so that failures
+	// that would otherwise only show up as retries can be logged or
+	// acted on.  The hook is called from the dialing goroutine, and
+	// should not block.  The value is a DialHook (or a function of the
+	// same signature), and the default is nil.
+	OptionDialHook = "DIAL-HOOK"
+
+	// OptionReqFailFast is used by REQ.  When true, Send fails with
+	// ErrNoPeers if there are no connected peers at the time, rather
+	// than queueing the request until a peer arrives.  This lets clients
+	// report that a service is unavailable promptly.  Note that a peer
+	// may still disconnect after the request is queued.  The value is a
+	// bool, default false.
+	OptionReqFailFast = "REQ-FAIL-FAST"
+
+	// OptionRetryOtherPeer is used by REQ.  When true, a request that is
+	// resent (see OptionRetryTime) goes to a connected peer it has not
+	// yet been sent to, if there is one, rather than to whichever peer
+	// is next in turn, which may be the one that failed to answer.  Once
+	// every peer has been tried, further attempts go to any peer.  With
+	// OptionMaxAttempts this gives load balanced requests that fail over
+	// between servers.  The value is a bool, default false.
+	OptionRetryOtherPeer = "RETRY-OTHER-PEER"
+
+	// OptionMaxAttempts is used by REQ to limit the number of times a
+	// request is sent, counting the first.  When the last attempt has
+	// gone unanswered for OptionRetryTime, the request is abandoned,
+	// and Recv fails with ErrNoReply until another request is sent.  The
+	// value is an int; zero, the default, means no limit.  It only has
+	// an effect when OptionRetryTime is non-zero.
+	OptionMaxAttempts = "MAX-ATTEMPTS"
+
+	// OptionRequestID is used by REQ in raw mode, for custom routing
+	// schemes that do not follow the usual request ID convention (where
+	// the high order bit marks the end of the backtrace).  The value is
+	// a func() uint32, which is called for each message sent, and its
+	// result is appended to the message header, after anything the
+	// application placed there.  Peers must understand the scheme; the
+	// standard REP does not.  Cooked mode always uses the standard
+	// convention, ignoring this.  The default, nil, leaves the header
+	// to the application, as usual in raw mode.
+	OptionRequestID = "REQUEST-ID"
+
+	// OptionRecvDrain lets Recv and RecvMsg continue to return messages
+	// that had already been received and queued (see OptionReadQLen)
+	// when the socket was closed, rather than discarding them.  It is
+	// the inbound counterpart of OptionLinger, allowing consumers such as
+	// PULL or SUB to shut down gracefully without losing messages.  Once
+	// Close is called no new messages are accepted; the queued ones may
+	// be read for the given time.Duration after Close, and then (or as
+	// soon as the queue is empty) ErrClosed is returned.  The default, 0,
+	// returns ErrClosed from the moment the socket is closed.
+	OptionRecvDrain = "RECV-DRAIN"
+
+	// OptionPipeID supplies the IDs given to new pipes (see
+	// Endpoint.GetID), so that tests can assert on values that are
+	// otherwise random.  The value is a func() uint32, called once for
+	// each pipe the socket connects, whose result is used as the ID with
+	// the high order bit cleared.  Pipe IDs are unique across the whole
+	// process, so if the value is zero or already in use by another pipe,
+	// one is allocated as usual instead.  The default, nil, leaves IDs to
+	// the normal allocator; this is not intended for production use.
+	OptionPipeID = "PIPE-ID"
+
+	// OptionIdentity is used by PUB, SUB, and BUS to identify the
+	// sender of each message.  The value is a []byte (or string) of up
+	// to 65535 bytes; nil, the default, disables the feature.  When set,
+	// each message sent carries an identity frame ahead of the body, and
+	// each message received is expected to carry one, which is removed
+	// and made available from Message.SenderIdentity().  Received
+	// messages without a valid frame are discarded.  This changes the
+	// wire format, so all peers must agree on its use; SUB only receives,
+	// so its own identity is never sent, and may be empty.  Raw mode
+	// sockets pass the frame through untouched, so devices work as-is.
+	OptionIdentity = "IDENTITY"
+
+	// OptionTransports restricts the transports the socket may use to
+	// the given list of URL schemes, for example []string{"tls+tcp"}.
+	// Attempts to Dial or Listen on any other scheme fail with
+	// ErrTranDenied, even if the transport has been added (as with
+	// transport/all).  This is intended for security sensitive services,
+	// and should be set just after the socket is created; it cannot be
+	// changed once Dial or Listen has been called.  The value is a
+	// []string; nil, the default, allows every transport added.
+	OptionTransports = "TRANSPORTS"
+
+	// OptionSendPriority orders the send queue by message priority (see
+	// Message.SetPriority), so that urgent control messages can overtake
+	// bulk data that is waiting to be sent.  Messages of equal priority
+	// are sent in order.  This applies to the socket's queue, and to the
+	// queues that PUB and BUS keep for each peer (see
+	// OptionWriteQLenPerPipe), which is where their messages wait for a
+	// slow peer.  The total number queued is still bounded by
+	// OptionWriteQLen.  It must be set before Dial or Listen.  The value
+	// is a bool, default false.
+	OptionSendPriority = "SEND-PRIORITY"
+
+	// OptionSendTimestamp stamps each message sent with the time, taken
+	// from the socket's Clock, and makes the stamp on each message
+	// received available from Message.SendTime, for measuring latency or
+	// discarding stale data.  The time is taken when the message is given
+	// to Send or SendMsg (or when it is written, for messages made by the
+	// protocol), and a resent message keeps it.  The stamp travels as a
+	// 23 byte prefix ahead of each frame, a zero word, "MANGOS-TIME" and
+	// the time in nanoseconds, which is added on the way to the transport
+	// and removed on receipt before the protocol sees the message; the
+	// message itself, and so OptionMaxSendSize, is unaffected.  Both peers
+	// should set it, as a peer that does not will see the prefix as part
+	// of the message, and messages received without one are passed up as
+	// they are.  The value is a bool, default false.
+	OptionSendTimestamp = "SEND-TIMESTAMP"
+
+	// OptionMaxMessageAge makes Recv discard messages that were sent
+	// longer ago than this, according to their OptionSendTimestamp stamp,
+	// so that an application that has fallen behind does not act on
+	// stale data.  It only has an effect when OptionSendTimestamp is set.
+	// The age is the local Clock's time less the sender's stamp, so the
+	// clocks of the two hosts must be synchronized (with NTP or the
+	// like) to well within this value; a sender whose clock runs ahead
+	// has its messages kept too long, and one that lags has them dropped
+	// too soon.  Messages without a stamp are never discarded.  The
+	// check comes before the protocol sees the message, so a stale REQ
+	// reply leaves the request outstanding, to be resent as usual.  Drops
+	// are counted by OptionStaleDrops.  The value is a time.Duration;
+	// zero, the default, disables the check.
+	OptionMaxMessageAge = "MAX-MESSAGE-AGE"
+
+	// OptionStaleDrops is a read-only option that reports the number of
+	// messages discarded by OptionMaxMessageAge.  The value is a uint64.
+	OptionStaleDrops = "STALE-DROPS"
+
+	// OptionGarbledDrops is a read-only option that reports the number
+	// of messages dropped because their SP header could not be parsed,
+	// such as a REQ request whose backtrace ends without a request ID,
+	// or a reply too short to carry one.  These come from buggy or
+	// hostile peers.  The value is a uint64.
+	OptionGarbledDrops = "GARBLED-DROPS"
+
+	// OptionGarbledHook supplies a GarbledHook, which is called for each
+	// message counted by OptionGarbledDrops, with the Port it came from
+	// and the number of such messages seen on that Port so far, so that
+	// they can be logged.  It is called from the protocol's receiver, and
+	// should not block.  The value is a GarbledHook (or a function of the
+	// same signature), and the default is nil (no hook).
+	OptionGarbledHook = "GARBLED-HOOK"
+
+	// OptionGarbledLimit closes a Port once that many messages with
+	// malformed headers have been received on it, with the reason
+	// CloseReasonGarbled.  A dialer redials as it would after any other
+	// disconnect.  The value is an int; zero, the default, means Ports
+	// are never closed for this.
+	OptionGarbledLimit = "GARBLED-LIMIT"
+)
+
+// The following are values for OptionRecvFull.
+const (
+	RecvFullBlock   = "block"
+	RecvFullDropOld = "drop-old"
+	RecvFullDropNew = "drop-new"
 )
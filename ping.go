@@ -0,0 +1,139 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// The control frames used by Socket.Ping.  Each is the magic below, then
+// a 64-bit sequence number, echoed in the pong.  The leading zero word is
+// never a valid backtrace entry or request ID (pipe IDs are never zero,
+// and request IDs have the high bit set), so the frames cannot be taken
+// for REQ or REP traffic; for PAIR a collision would need an application
+// message of exactly this length and content.  Other protocols neither
+// send nor answer them.
+const (
+	pingMagic = "\x00\x00\x00\x00MANGOS-PING"
+	pongMagic = "\x00\x00\x00\x00MANGOS-PONG"
+	pingLen   = len(pingMagic) + 8
+)
+
+// pingable returns true if the protocol supports Socket.Ping.
+func pingable(proto Protocol) bool {
+	switch proto.Number() {
+	case ProtoPair, ProtoReq, ProtoRep:
+		return true
+	}
+	return false
+}
+
+func (sock *socket) Ping(timeout time.Duration) error {
+	if !pingable(sock.proto) {
+		return ErrProtoOp
+	}
+	sock.Lock()
+	if sock.closing {
+		sock.Unlock()
+		return ErrClosed
+	}
+	if sock.pongq == nil {
+		sock.pongq = make(map[uint64]chan struct{})
+	}
+	sock.pingSeq++
+	seq := sock.pingSeq
+	q := make(chan struct{})
+	sock.pongq[seq] = q
+	peers := make([]*pipe, 0, len(sock.pipes))
+	for p := range sock.pipes {
+		peers = append(peers, p)
+	}
+	sock.Unlock()
+
+	defer func() {
+		sock.Lock()
+		delete(sock.pongq, seq)
+		sock.Unlock()
+	}()
+
+	for _, p := range peers {
+		m := NewMessage(pingLen)
+		m.Body = append(m.Body, pingMagic...)
+		m.Body = m.Body[:pingLen]
+		binary.BigEndian.PutUint64(m.Body[len(pingMagic):], seq)
+		p := p
+		sock.Go(func() {
+			if p.SendMsg(m) != nil {
+				m.Free()
+			}
+		})
+	}
+
+	select {
+	case <-q:
+		return nil
+	case <-mkTimer(timeout):
+		return ErrRecvTimeout
+	case <-sock.closeq:
+		return ErrClosed
+	}
+}
+
+// pong wakes the Ping waiting for the given sequence number, if any.
+func (sock *socket) pong(seq uint64) {
+	sock.Lock()
+	if q, ok := sock.pongq[seq]; ok {
+		close(q)
+		delete(sock.pongq, seq)
+	}
+	sock.Unlock()
+}
+
+// control handles the frames used by Socket.Ping, answering pings and
+// passing on pongs.  It returns false, leaving the message alone, if it
+// is not one of them.
+func (p *pipe) control(msg *Message) bool {
+	if len(msg.Header) != 0 {
+		return false
+	}
+	if len(msg.Body) == fragHelloLen &&
+		string(msg.Body[:len(fragHelloMagic)]) == fragHelloMagic {
+		// See OptionFragment.
+		n := int(binary.BigEndian.Uint32(msg.Body[len(fragHelloMagic):]))
+		p.frag.hello(n)
+		msg.Free()
+		return true
+	}
+	if len(msg.Body) != pingLen || !pingable(p.sock.proto) {
+		return false
+	}
+	switch {
+	case string(msg.Body[:len(pingMagic)]) == pingMagic:
+		// Turn it around; the sequence number stays as it is.
+		copy(msg.Body, pongMagic)
+		p.sock.Go(func() {
+			if p.SendMsg(msg) != nil {
+				msg.Free()
+			}
+		})
+	case string(msg.Body[:len(pongMagic)]) == pongMagic:
+		p.sock.pong(binary.BigEndian.Uint64(msg.Body[len(pongMagic):]))
+		msg.Free()
+	default:
+		return false
+	}
+	return true
+}
@@ -15,8 +15,10 @@
 package mangos
 
 import (
+	"crypto/tls"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,6 +38,23 @@ type pipe struct {
 	d       *dialer
 	sock    *socket
 	closing bool // true if we were closed
+	since   time.Time
+	redials int         // connections made by the dialer before this one
+	sendmx  *sync.Mutex // serializes sends, see socket.SendToEndpoint
+	reason  CloseReason
+	meta    map[string]interface{} // application data, see SetMeta
+	nosend  bool                   // see SetSendEnabled
+	bal     *Balancer              // notified when nosend changes
+	garbled int                    // malformed messages, see Garbled
+
+	// Moving to another socket, see Socket.MoveEndpoint.
+	reading bool          // true while in the transport's Recv
+	movedTo *pipe         // the pipe that took over, once moved
+	readyq  chan struct{} // if not nil, closed once we may read
+	handed  *Message      // read for us by the pipe moved from
+	handErr error         // error read for us by the pipe moved from
+
+	frag *fragState // see OptionFragment; kept by MoveEndpoint
 
 	sync.Mutex
 }
@@ -45,9 +64,25 @@ func init() {
 	pipes.nextid = uint32(rand.NewSource(time.Now().UnixNano()).Int63())
 }
 
-func newPipe(tranpipe Pipe) *pipe {
-	p := &pipe{pipe: tranpipe}
+// newPipe allocates a pipe and its ID.  If idfn is not nil, it is asked
+// for the ID first, and the usual sequence is only used if the value it
+// supplies is zero or already taken.
+func newPipe(tranpipe Pipe, idfn func() uint32) *pipe {
+	p := &pipe{pipe: tranpipe, since: time.Now()}
 	p.closeq = make(chan struct{})
+	p.sendmx = &sync.Mutex{}
+	p.frag = newFragState()
+	if idfn != nil {
+		id := idfn() & 0x7fffffff
+		pipes.Lock()
+		if id != 0 && pipes.byid[id] == nil {
+			p.id = id
+			pipes.byid[id] = p
+			pipes.Unlock()
+			return p
+		}
+		pipes.Unlock()
+	}
 	for {
 		pipes.Lock()
 		p.id = pipes.nextid & 0x7fffffff
@@ -67,6 +102,12 @@ func (p *pipe) GetID() uint32 {
 }
 
 func (p *pipe) Close() error {
+	return p.closeWith(CloseReasonLocal)
+}
+
+// closeWith closes the pipe, recording the reason unless it was already
+// closed for some other one.
+func (p *pipe) closeWith(reason CloseReason) error {
 	var hook PortHook
 	p.Lock()
 	sock := p.sock
@@ -78,6 +119,7 @@ func (p *pipe) Close() error {
 		return nil
 	}
 	p.closing = true
+	p.reason = reason
 	p.Unlock()
 	close(p.closeq)
 	if sock != nil {
@@ -95,24 +137,244 @@ func (p *pipe) Close() error {
 
 func (p *pipe) SendMsg(msg *Message) error {
 
-	if err := p.pipe.Send(msg); err != nil {
-		p.Close()
+	// The transport frees the message on success, so we have to
+	// grab the completion callback, if any, first.  The same goes for
+	// a waiting SendMsgEndpoint, which must hear about the write rather
+	// than the free.
+	cb := msg.onSent
+	sq := msg.sentq
+	if sq != nil {
+		msg.sentq = nil
+	}
+	if msg.Expired() {
+		msg.Free()
+		if cb != nil {
+			cb(ErrSendTimeout)
+		}
+		p.sent(sq, ErrSendTimeout)
+		return nil
+	}
+	sz := uint64(len(msg.Header) + len(msg.Body))
+	wire := msg
+	if getFlag(&p.sock.stampTx) {
+		// Stamped when sent by the application, or now if
+		// the protocol made it.
+		t := msg.stamp
+		if t.IsZero() {
+			p.sock.Lock()
+			t = p.sock.clock.Now()
+			p.sock.Unlock()
+		}
+		wire = msg.stamped(t)
+	}
+	n := len(wire.Header) + len(wire.Body)
+	limit := p.fragLimit(n)
+	p.sendmx.Lock()
+	if msg.Expired() || !sq.take(p) {
+		// The deadline passed while waiting for the write before, or
+		// the caller stopped waiting.
+		p.sendmx.Unlock()
+		if wire != msg {
+			wire.Free()
+		}
+		msg.Free()
+		if cb != nil {
+			cb(ErrSendTimeout)
+		}
+		p.sent(sq, ErrSendTimeout)
+		return nil
+	}
+	var err error
+	if limit > 0 && n > limit {
+		err = p.sendFrags(wire, limit)
+	} else {
+		err = p.pipe.Send(wire)
+	}
+	p.sendmx.Unlock()
+	if wire != msg {
+		// The transport took the copy, if it succeeded.
+		if err == nil {
+			msg.Free()
+		} else {
+			wire.Free()
+		}
+	}
+	if err == ErrTooLong {
+		// Refused by the transport, which is still usable, so this
+		// message is lost but the pipe carries on.
+		if cb != nil {
+			cb(err)
+		}
+		p.sock.Dropped(msg, DropReasonTooLong)
+		p.sent(sq, err)
+		return nil
+	}
+	if err != nil {
+		p.closeWith(closeReasonFor(err))
+		if cb != nil {
+			cb(err)
+		}
+		p.sent(sq, err)
 		return err
 	}
+	atomic.AddUint64(&p.sock.bytesSent, sz)
+	if cb != nil {
+		cb(nil)
+	}
+	p.sent(sq, nil)
 	return nil
 }
 
+// flush writes out anything the transport has buffered, once any write
+// under way has finished.
+func (p *pipe) flush() error {
+	f, ok := p.pipe.(PipeFlusher)
+	if !ok {
+		return nil
+	}
+	p.sendmx.Lock()
+	defer p.sendmx.Unlock()
+	return f.Flush()
+}
+
+// sent reports the outcome of a write to SendMsgEndpoint, if it is waiting.
+func (p *pipe) sent(sq *sendWait, err error) {
+	if sq != nil {
+		sq.q <- sendResult{ep: p, err: err}
+	}
+}
+
 func (p *pipe) RecvMsg() *Message {
 
-	msg, err := p.pipe.Recv()
-	if err != nil {
-		p.Close()
-		return nil
+	var msg *Message
+	for {
+		if !p.sock.waitResume(p) {
+			return nil
+		}
+		var err error
+		msg, err = p.recv()
+		if msg == nil && err == nil {
+			return nil // moved
+		}
+		if err != nil {
+			p.closeWith(closeReasonFor(err))
+			return nil
+		}
+		atomic.AddUint64(&p.sock.bytesRecv, uint64(len(msg.Header)+len(msg.Body)))
+		stamp := getFlag(&p.sock.stampTx)
+		if stamp {
+			msg.unstamp()
+		}
+		if p.control(msg) {
+			continue
+		}
+		m := p.defrag(msg)
+		if m == nil {
+			continue
+		}
+		if m != msg && stamp {
+			// Reassembled, the stamp being inside.
+			m.unstamp()
+		}
+		msg = m
+		break
 	}
 	msg.Port = p
+	if getFlag(&p.sock.recvWire) {
+		// Keep a copy, as protocols take the header off in place.
+		msg.wire = make([]byte, 0, len(msg.Header)+len(msg.Body))
+		msg.wire = append(append(msg.wire, msg.Header...), msg.Body...)
+	}
 	return msg
 }
 
+// recv reads the next message from the transport.  If the pipe has been
+// moved to another socket it returns neither a message nor an error, and
+// whatever it was reading at the time is passed on to the pipe that took
+// its place, so that nothing read from the peer is lost.
+func (p *pipe) recv() (*Message, error) {
+	if p.readyq != nil {
+		// Wait for the pipe we were moved from to finish its read.
+		select {
+		case <-p.readyq:
+		case <-p.closeq:
+			return nil, nil
+		}
+	}
+	p.Lock()
+	if p.movedTo != nil {
+		p.Unlock()
+		return nil, nil
+	}
+	if msg, err := p.handed, p.handErr; msg != nil || err != nil {
+		p.handed, p.handErr = nil, nil
+		p.Unlock()
+		return msg, err
+	}
+	p.reading = true
+	p.Unlock()
+
+	msg, err := p.pipe.Recv()
+
+	p.Lock()
+	p.reading = false
+	to := p.movedTo
+	p.Unlock()
+	if to != nil {
+		to.Lock()
+		to.handed, to.handErr = msg, err
+		to.Unlock()
+		close(to.readyq)
+		return nil, nil
+	}
+	return msg, err
+}
+
+func (p *pipe) CloseReason() CloseReason {
+	p.Lock()
+	defer p.Unlock()
+	return p.reason
+}
+
+func (p *pipe) SetMeta(key string, value interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	if value == nil {
+		delete(p.meta, key)
+		return
+	}
+	if p.meta == nil {
+		p.meta = make(map[string]interface{})
+	}
+	p.meta[key] = value
+}
+
+func (p *pipe) GetMeta(key string) (interface{}, bool) {
+	p.Lock()
+	defer p.Unlock()
+	v, ok := p.meta[key]
+	return v, ok
+}
+
+func (p *pipe) SetSendEnabled(enabled bool) {
+	p.Lock()
+	changed := p.nosend == enabled
+	p.nosend = !enabled
+	bal := p.bal
+	p.Unlock()
+	if changed && bal != nil {
+		bal.Lock()
+		bal.notify()
+		bal.Unlock()
+	}
+}
+
+func (p *pipe) SendEnabled() bool {
+	p.Lock()
+	defer p.Unlock()
+	return !p.nosend
+}
+
 func (p *pipe) Address() string {
 	switch {
 	case p.l != nil:
@@ -124,6 +386,43 @@ func (p *pipe) Address() string {
 }
 
 func (p *pipe) GetProp(name string) (interface{}, error) {
+	switch name {
+	case PropEstablished:
+		return p.since, nil
+	case PropReconnects:
+		if p.d != nil {
+			return p.redials, nil
+		}
+	case PropFrameSizeHint:
+		if v, err := p.pipe.GetProp(name); err == nil {
+			return v, nil
+		}
+		return DefaultFrameSizeHint, nil
+	case PropTLSVersion, PropTLSCipherSuite:
+		v, err := p.pipe.GetProp(PropTLSConnState)
+		if err != nil {
+			return nil, err
+		}
+		state, ok := v.(tls.ConnectionState)
+		if !ok {
+			return nil, ErrBadProperty
+		}
+		if name == PropTLSVersion {
+			return state.Version, nil
+		}
+		return state.CipherSuite, nil
+	case PropTransportOptions:
+		v, err := p.pipe.GetProp(name)
+		if err != nil {
+			return nil, err
+		}
+		// Copy it, so that callers cannot disturb the transport's.
+		opts := make(map[string]interface{})
+		for k, o := range v.(map[string]interface{}) {
+			opts[k] = o
+		}
+		return opts, nil
+	}
 	return p.pipe.GetProp(name)
 }
 
@@ -14,6 +14,11 @@
 
 package mangos
 
+import (
+	"io"
+	"net"
+)
+
 // Port represents the high level interface to a low level communications
 // channel.  There is one of these associated with a given TCP connection,
 // for example.  This interface is intended for application use.
@@ -53,6 +58,101 @@ type Port interface {
 
 	// Listener returns the listener for this Port, or nil if a client.
 	Listener() Listener
+
+	// CloseReason reports why the Port was closed, or CloseReasonNone
+	// if it is still open.  It is most useful from a PortHook, when
+	// called with PortActionRemove.
+	CloseReason() CloseReason
+
+	// SetMeta attaches application data to the Port under key.  A nil
+	// value removes the key.  The same data is visible through the
+	// Endpoint for the Port, and through the Port set on messages
+	// received from it, so a value set from a PortHook when the Port is
+	// added can be used to authorize each message that later arrives.
+	SetMeta(key string, value interface{})
+
+	// GetMeta returns the value stored under key, and whether it was set.
+	GetMeta(key string) (interface{}, bool)
+
+	// SetSendEnabled controls whether the socket may choose this Port
+	// when distributing messages among its peers.  See the method of
+	// the same name on Endpoint.
+	SetSendEnabled(enabled bool)
+
+	// SendEnabled reports the value last set by SetSendEnabled.
+	SendEnabled() bool
+}
+
+// CloseReason describes why a Port was closed.
+type CloseReason int
+
+// CloseReason values.  Only the first cause is recorded, so for example a
+// Port closed by the application reports CloseReasonLocal even though
+// the transport subsequently fails with an error.
+const (
+	// CloseReasonNone means the Port is still open.
+	CloseReasonNone CloseReason = iota
+
+	// CloseReasonLocal means Close was called on the Port, either by
+	// the application or by the protocol (for example PAIR refusing a
+	// second peer).
+	CloseReasonLocal
+
+	// CloseReasonRejected means the PortHook declined the Port.
+	CloseReasonRejected
+
+	// CloseReasonShutdown means the Socket or the Dialer was closed.
+	CloseReasonShutdown
+
+	// CloseReasonPeer means the peer closed the connection.
+	CloseReasonPeer
+
+	// CloseReasonTooLong means the peer sent a message larger than
+	// OptionMaxRecvSize.
+	CloseReasonTooLong
+
+	// CloseReasonIOError means the transport failed with some other
+	// error while sending or receiving.
+	CloseReasonIOError
+
+	// CloseReasonGarbled means the peer sent OptionGarbledLimit
+	// messages whose headers could not be parsed.
+	CloseReasonGarbled
+
+	// CloseReasonReconnect means Socket.ReconnectEndpoint closed the
+	// Port, so that its dialer could connect again.
+	CloseReasonReconnect
+)
+
+var closeReasonNames = [...]string{
+	CloseReasonNone:     "none",
+	CloseReasonLocal:    "closed locally",
+	CloseReasonRejected: "rejected by port hook",
+	CloseReasonShutdown: "socket or dialer shut down",
+	CloseReasonPeer:     "closed by peer",
+	CloseReasonTooLong:  "message too long",
+	CloseReasonIOError:  "transport error",
+	CloseReasonGarbled:  "too many garbled messages",
+
+	CloseReasonReconnect: "reconnect requested",
+}
+
+func (r CloseReason) String() string {
+	if r >= 0 && int(r) < len(closeReasonNames) {
+		return closeReasonNames[r]
+	}
+	return "unknown"
+}
+
+// closeReasonFor classifies an error returned by a transport Pipe.
+func closeReasonFor(err error) CloseReason {
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, ErrClosed:
+		return CloseReasonPeer
+	case ErrTooLong:
+		return CloseReasonTooLong
+	}
+	return CloseReasonIOError
 }
 
 // PortAction determines whether the action on a Port is addition or removal.
@@ -68,3 +168,20 @@ const (
 // from a Socket.  In the case of PortActionAdd, the function may return false
 // to indicate that the port should not be added.
 type PortHook func(PortAction, Port) bool
+
+// GarbledHook is called when a message with a malformed header is dropped,
+// with the Port it came from and the number of them received on it so far.
+// See OptionGarbledHook.
+type GarbledHook func(p Port, count int)
+
+// NetConn returns the net.Conn underlying the Port, or nil if the transport
+// has none (as with inproc).  See PropNetConn for the restrictions on its
+// use.
+func NetConn(p Port) net.Conn {
+	if v, err := p.GetProp(PropNetConn); err == nil {
+		if c, ok := v.(net.Conn); ok {
+			return c
+		}
+	}
+	return nil
+}
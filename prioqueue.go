@@ -0,0 +1,132 @@
+// Copyright 2015 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"sync"
+	"time"
+)
+
+// PrioQueue is a bounded queue of messages for a single peer, for
+// protocols that copy each message to a queue per peer, such as PUB and
+// BUS.  When made for a socket using OptionSendPriority, it hands out
+// messages of higher priority (see Message.SetPriority) first, and those
+// of equal priority in order; otherwise it is first in, first out.
+type PrioQueue struct {
+	held    [MaxPriority + 1][]*Message
+	n       int
+	limit   int
+	prio    bool
+	closed  bool
+	waiting int // callers blocked in Get, and not yet handed a message
+	handed  int // messages queued for those callers, beyond the limit
+	cv      sync.Cond
+	sync.Mutex
+}
+
+// NewPrioQueue returns a queue that holds up to limit messages, much as
+// a channel with that buffer would, ordering them by priority if prio is
+// true.
+func NewPrioQueue(limit int, prio bool) *PrioQueue {
+	q := &PrioQueue{limit: limit, prio: prio}
+	q.cv.L = q
+	return q
+}
+
+// Put adds the message, unless the queue is full or closed.  It never
+// blocks, and reports whether it took the message.
+func (q *PrioQueue) Put(m *Message) bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return false
+	}
+	if q.waiting > 0 {
+		// As on a channel, a waiting caller takes it at once, without
+		// using up any room.
+		q.waiting--
+		q.handed++
+	} else if q.n-q.handed >= q.limit {
+		return false
+	}
+	p := 0
+	if q.prio {
+		p = m.prio
+	}
+	q.held[p] = append(q.held[p], m)
+	q.n++
+	q.cv.Signal()
+	return true
+}
+
+// Get removes and returns the next message, waiting for one if the queue
+// is empty.  Once the queue is closed and empty, it returns nil.
+func (q *PrioQueue) Get() *Message {
+	q.Lock()
+	defer q.Unlock()
+	for q.n == 0 {
+		if q.closed {
+			return nil
+		}
+		q.waiting++
+		q.cv.Wait()
+	}
+	for p := MaxPriority; p >= 0; p-- {
+		if h := q.held[p]; len(h) > 0 {
+			m := h[0]
+			h[0] = nil
+			q.held[p] = h[1:]
+			q.n--
+			if q.handed > 0 {
+				q.handed--
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+// Len returns the number of messages queued.
+func (q *PrioQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.n
+}
+
+// Close stops the queue from taking any more messages.  Those already
+// queued can still be had from Get, which returns nil after the last.
+func (q *PrioQueue) Close() {
+	q.Lock()
+	q.closed = true
+	q.cv.Broadcast()
+	q.Unlock()
+}
+
+// Drain waits, until the given time at most, for the queue to empty, as
+// DrainChannel does for a channel.  It returns true if it did.
+func (q *PrioQueue) Drain(expire time.Time) bool {
+	for q.Len() > 0 {
+		now := time.Now()
+		if now.After(expire) {
+			return false
+		}
+		dur := expire.Sub(now)
+		if dur > time.Millisecond*10 {
+			dur = time.Millisecond * 10
+		}
+		time.Sleep(dur)
+	}
+	return true
+}
@@ -31,7 +31,60 @@ const (
 	// value is a tls.ConnectionState.  It is only valid when TLS is used.
 	PropTLSConnState = "TLS-STATE"
 
+	// PropTLSVersion is the TLS version negotiated for the connection,
+	// such as tls.VersionTLS12.  PropTLSCipherSuite is the cipher suite,
+	// such as tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.  Both are taken
+	// from PropTLSConnState, and so are only valid when TLS is used; they
+	// save digging through the state when auditing what is in use.  The
+	// values are uint16s.
+	PropTLSVersion     = "TLS-VERSION"
+	PropTLSCipherSuite = "TLS-CIPHER-SUITE"
+
+	// PropTransportOptions reports the transport options that were
+	// applied to the connection when it was made, such as OptionNoDelay
+	// and OptionKeepAlive, including any defaults the transport set, so
+	// that it can be confirmed that configuration took effect.  Options
+	// left at the operating system default are absent.  The value is a
+	// map[string]interface{} keyed by option name, which is a copy that
+	// the caller may modify.  It is supplied by the TCP and TLS
+	// transports.
+	PropTransportOptions = "TRANSPORT-OPTIONS"
+
 	// PropHTTPRequest conveys an *http.Request.  This property only exists
 	// for websocket connections.
 	PropHTTPRequest = "HTTP-REQUEST"
+
+	// PropEstablished is the time at which the connection was added to
+	// the socket.  The value is a time.Time.  It is available for all
+	// transports.
+	PropEstablished = "ESTABLISHED"
+
+	// PropReconnects is the number of connections the Port's dialer had
+	// established before this one, so zero for the first connection.  A
+	// value that climbs quickly points to a flapping connection.  The
+	// value is an int.  It only exists for Ports created by a dialer.
+	PropReconnects = "RECONNECTS"
+
+	// PropNetConn is the net.Conn underlying a connection, for advanced
+	// uses such as setting platform specific socket options, or reading
+	// addresses.  The connection belongs to mangos: applications must
+	// NEVER read from, write to, close, or set deadlines on it, as doing
+	// so corrupts the SP stream.  For TLS this is the *tls.Conn.  It is
+	// absent for transports that have no net.Conn, such as inproc; see
+	// also NetConn.
+	PropNetConn = "NET-CONN"
+
+	// PropFrameSizeHint is the largest message, in bytes, that the Port's
+	// transport is expected to carry efficiently; applications moving
+	// large payloads can use it to pick a chunk size.  It is only a
+	// hint, and larger messages still work on transports that allow
+	// them.  Transports may report their own value; for the others,
+	// including TCP, TLS, IPC and websocket, which carry messages of any
+	// size as a stream, it is DefaultFrameSizeHint.  The value is an int.
+	PropFrameSizeHint = "FRAME-SIZE-HINT"
 )
+
+// DefaultFrameSizeHint is the value of PropFrameSizeHint for transports
+// that do not report one.  It is large enough to amortize per-message
+// overheads, while small enough not to stall other traffic for long.
+const DefaultFrameSizeHint = 64 * 1024
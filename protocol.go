@@ -36,6 +36,30 @@ type Endpoint interface {
 	// RecvMsg receives a message.  It blocks until the message is
 	// received.  On error, the pipe is closed and nil is returned.
 	RecvMsg() *Message
+
+	// SetMeta associates an application supplied value with key on the
+	// Endpoint, replacing any earlier value.  A nil value removes the
+	// key.  The core never interprets the data; it exists so that, for
+	// example, an identity established by a HandshakeHook or PortHook
+	// can be consulted later for messages arriving on the same pipe.
+	// It is safe to call concurrently with GetMeta.
+	SetMeta(key string, value interface{})
+
+	// GetMeta returns the value stored with SetMeta for key, and whether
+	// one was present.
+	GetMeta(key string) (interface{}, bool)
+
+	// SetSendEnabled takes the Endpoint out of (false) or back into
+	// (true) the rotation that protocols using a Balancer, such as PUSH
+	// and REQ, choose among when sending a message.  A disabled Endpoint
+	// stays connected and continues to receive, and messages already
+	// handed to it are still sent, so traffic can be drained from a peer
+	// before it is taken down.  Messages that are sent to every peer,
+	// such as REQ broadcasts, are not affected.  Endpoints start enabled.
+	SetSendEnabled(enabled bool)
+
+	// SendEnabled reports the value last set by SetSendEnabled.
+	SendEnabled() bool
 }
 
 // Protocol implementations handle the "meat" of protocol processing.  Each
@@ -106,6 +130,34 @@ type ProtocolSendHook interface {
 	SendHook(*Message) bool
 }
 
+// ProtocolUnicast is intended to be an additional extension
+// to the Protocol interface.
+type ProtocolUnicast interface {
+	// Unicast returns true if each message the application sends is
+	// written to at most one peer, as with PUSH or REQ, rather than
+	// copied to several.  Socket.SendMsgEndpoint relies on it to know
+	// that there is a single Endpoint to report.
+	Unicast() bool
+}
+
+// ProtocolDrainer is intended to be an additional extension
+// to the Protocol interface.
+type ProtocolDrainer interface {
+	// Drain waits, until the given time at most, for messages the
+	// protocol has queued for particular peers to be taken for writing,
+	// much as Shutdown does but leaving everything open.  Socket.Flush
+	// relies on it, so that it covers messages still queued.
+	Drain(time.Time)
+}
+
+// ProtocolOptions is intended to be an additional extension
+// to the Protocol interface.
+type ProtocolOptions interface {
+	// Options describes the options handled by the protocol's SetOption
+	// and GetOption, for Socket.Options.
+	Options() []OptionInfo
+}
+
 // ProtocolSocket is the "handle" given to protocols to interface with the
 // socket.  The Protocol implementation should not access any sockets or pipes
 // except by using functions made available on the ProtocolSocket.  Note
@@ -152,6 +204,23 @@ type ProtocolSocket interface {
 	// waiting to send a message that will never be delivered (e.g. due
 	// to incorrect state.)  If set to nil, then TX works normally.
 	SetSendError(error)
+
+	// Garbled is called by the protocol when it drops a message received
+	// from the Endpoint because its header could not be parsed.  The
+	// core counts it, and may close the Endpoint.  (See
+	// OptionGarbledLimit.)  It should not be called with locks held.
+	Garbled(Endpoint)
+
+	// Dropped is called by the protocol when it discards a message for
+	// one of the reasons given by DropReason, such as a full queue.  It
+	// tells the DropHook, if any, and frees the message.  It should not
+	// be called with locks held.
+	Dropped(*Message, DropReason)
+
+	// Go runs the function in a new goroutine.  Protocols should start
+	// their goroutines this way, so that Close can wait for them to
+	// exit when OptionCloseWait is set.
+	Go(func())
 }
 
 // Useful constants for protocol numbers.  Note that the major protocol number
@@ -174,35 +243,38 @@ const (
 	ProtoStar = (100 * 16)
 )
 
+var stdProtocols = map[uint16]string{
+	ProtoPair:       "pair",
+	ProtoPub:        "pub",
+	ProtoSub:        "sub",
+	ProtoReq:        "req",
+	ProtoRep:        "rep",
+	ProtoPush:       "push",
+	ProtoPull:       "pull",
+	ProtoSurveyor:   "surveyor",
+	ProtoRespondent: "respondent",
+	ProtoBus:        "bus",
+	ProtoStar:       "star"}
+
 // ProtocolName returns the name corresponding to a given protocol number.
 // This is useful for transports like WebSocket, which use a text name
-// rather than the number in the handshake.
+// rather than the number in the handshake.  Protocols added with
+// RegisterProtocol are included.
 func ProtocolName(number uint16) string {
-	names := map[uint16]string{
-		ProtoPair:       "pair",
-		ProtoPub:        "pub",
-		ProtoSub:        "sub",
-		ProtoReq:        "req",
-		ProtoRep:        "rep",
-		ProtoPush:       "push",
-		ProtoPull:       "pull",
-		ProtoSurveyor:   "surveyor",
-		ProtoRespondent: "respondent",
-		ProtoBus:        "bus"}
-	return names[number]
+	if name, ok := stdProtocols[number]; ok {
+		return name
+	}
+	if info, ok := LookupProtocol(number); ok {
+		return info.Name
+	}
+	return ""
 }
 
 // ValidPeers returns true if the two sockets are capable of
 // peering to one another.  For example, REQ can peer with REP,
-// but not with BUS.
+// but not with BUS.  See also ValidPeer.
 func ValidPeers(p1, p2 Protocol) bool {
-	if p1.Number() != p2.PeerNumber() {
-		return false
-	}
-	if p2.Number() != p1.PeerNumber() {
-		return false
-	}
-	return true
+	return ValidPeer(p1, p2.Number()) && ValidPeer(p2, p1.Number())
 }
 
 // NullRecv simply loops, receiving and discarding messages, until the
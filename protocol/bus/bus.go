@@ -18,7 +18,9 @@ package bus
 
 import (
 	"encoding/binary"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nanomsg.org/go-mangos"
@@ -26,7 +28,7 @@ import (
 
 type busEp struct {
 	ep mangos.Endpoint
-	q  chan *mangos.Message
+	q  *mangos.PrioQueue
 	x  *bus
 }
 
@@ -34,6 +36,9 @@ type bus struct {
 	sock  mangos.ProtocolSocket
 	peers map[uint32]*busEp
 	raw   bool
+	ident []byte
+	ttl   int    // hop limit, see OptionTTL; 0 if not carried
+	drops uint64 // see OptionTTLDrops
 	w     mangos.Waiter
 	init  sync.Once
 
@@ -46,7 +51,21 @@ func (x *bus) Init(sock mangos.ProtocolSocket) {
 	x.peers = make(map[uint32]*busEp)
 	x.w.Init()
 	x.w.Add()
-	go x.sender()
+	x.sock.Go(x.sender)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (x *bus) Drain(expire time.Time) {
+	x.Lock()
+	qs := make([]*mangos.PrioQueue, 0, len(x.peers))
+	for _, peer := range x.peers {
+		qs = append(qs, peer.q)
+	}
+	x.Unlock()
+	for _, q := range qs {
+		q.Drain(expire)
+	}
 }
 
 func (x *bus) Shutdown(expire time.Time) {
@@ -59,8 +78,8 @@ func (x *bus) Shutdown(expire time.Time) {
 	x.Unlock()
 
 	for id, peer := range peers {
-		mangos.DrainChannel(peer.q, expire)
-		close(peer.q)
+		peer.q.Drain(expire)
+		peer.q.Close()
 		delete(peers, id)
 	}
 }
@@ -68,7 +87,7 @@ func (x *bus) Shutdown(expire time.Time) {
 // Bottom sender.
 func (pe *busEp) peerSender() {
 	for {
-		m := <-pe.q
+		m := pe.q.Get()
 		if m == nil {
 			return
 		}
@@ -81,6 +100,7 @@ func (pe *busEp) peerSender() {
 
 func (x *bus) broadcast(m *mangos.Message, sender uint32) {
 
+	var full []*mangos.Message
 	x.Lock()
 	for id, pe := range x.peers {
 		if sender == id {
@@ -88,19 +108,20 @@ func (x *bus) broadcast(m *mangos.Message, sender uint32) {
 		}
 		m = m.Dup()
 
-		select {
-		case pe.q <- m:
-		default:
+		if !pe.q.Put(m) {
 			// No room on outbound queue, drop it.
 			// Note that if we are passing on a linger/shutdown
 			// notification and we can't deliver due to queue
 			// full, it means we will wind up waiting the full
 			// linger time in the lower sender.  Its correct, if
 			// suboptimal, behavior.
-			m.Free()
+			full = append(full, m)
 		}
 	}
 	x.Unlock()
+	for _, m := range full {
+		x.sock.Dropped(m, mangos.DropReasonQueueFull)
+	}
 }
 
 func (x *bus) sender() {
@@ -119,16 +140,46 @@ func (x *bus) sender() {
 			}
 			// If a header was present, it means this message is
 			// being rebroadcast.  It should be a pipe ID.
-			if len(m.Header) >= 4 {
+			relay := len(m.Header) >= 4
+			if relay {
 				id = binary.BigEndian.Uint32(m.Header)
 				m.Header = m.Header[4:]
 			}
-			x.broadcast(m, id)
+			if x.addTTL(m, relay) {
+				x.broadcast(m, id)
+			}
 			m.Free()
 		}
 	}
 }
 
+// addTTL prefixes the body with the hop limit, when the socket carries
+// them.  A message being relayed uses up one of its hops.  It returns false
+// if the message has run out of hops, and should not be sent.
+func (x *bus) addTTL(m *mangos.Message, relay bool) bool {
+	x.Lock()
+	def := x.ttl
+	x.Unlock()
+	if def == 0 {
+		return true
+	}
+	ttl := m.TTL()
+	switch {
+	case relay:
+		ttl--
+	case ttl == 0:
+		ttl = def
+	}
+	if ttl < 1 {
+		atomic.AddUint64(&x.drops, 1)
+		return false
+	}
+	m.Body = append(m.Body, 0)
+	copy(m.Body[1:], m.Body)
+	m.Body[0] = byte(ttl)
+	return true
+}
+
 func (pe *busEp) receiver() {
 
 	rq := pe.x.sock.RecvChannel()
@@ -139,6 +190,19 @@ func (pe *busEp) receiver() {
 		if m == nil {
 			return
 		}
+		pe.x.Lock()
+		ttl := pe.x.ttl
+		pe.x.Unlock()
+		if ttl > 0 {
+			// Take off the hop limit added by the sender.
+			if len(m.Body) < 1 || m.Body[0] == 0 {
+				m.Free()
+				pe.x.sock.Garbled(pe.ep)
+				continue
+			}
+			m.SetTTL(int(m.Body[0]))
+			m.Body = m.Body[1:]
+		}
 		v := pe.ep.GetID()
 		m.Header = append(m.Header,
 			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
@@ -150,31 +214,35 @@ func (pe *busEp) receiver() {
 			return
 		default:
 			// No room, so we just drop it.
-			m.Free()
+			pe.x.sock.Dropped(m, mangos.DropReasonQueueFull)
 		}
 	}
 }
 
 func (x *bus) AddEndpoint(ep mangos.Endpoint) {
-	// Set our broadcast depth to match upper depth -- this should
-	// help avoid dropping when bursting, if we burst before we
-	// context switch.
+	// Unless configured otherwise, set our broadcast depth to match
+	// upper depth -- this should help avoid dropping when bursting, if
+	// we burst before we context switch.
 	depth := 16
-	if i, err := x.sock.GetOption(mangos.OptionWriteQLen); err == nil {
+	if i, err := x.sock.GetOption(mangos.OptionWriteQLenPerPipe); err == nil {
 		depth = i.(int)
 	}
-	pe := &busEp{ep: ep, x: x, q: make(chan *mangos.Message, depth)}
+	prio := false
+	if v, err := x.sock.GetOption(mangos.OptionSendPriority); err == nil {
+		prio = v.(bool)
+	}
+	pe := &busEp{ep: ep, x: x, q: mangos.NewPrioQueue(depth, prio)}
 	x.Lock()
 	x.peers[ep.GetID()] = pe
 	x.Unlock()
-	go pe.peerSender()
-	go pe.receiver()
+	x.sock.Go(pe.peerSender)
+	x.sock.Go(pe.receiver)
 }
 
 func (x *bus) RemoveEndpoint(ep mangos.Endpoint) {
 	x.Lock()
 	if peer := x.peers[ep.GetID()]; peer != nil {
-		close(peer.q)
+		peer.q.Close()
 		delete(x.peers, ep.GetID())
 	}
 	x.Unlock()
@@ -196,10 +264,31 @@ func (*bus) PeerName() string {
 	return "bus"
 }
 
+// SendHook adds our identity frame, if we have one.
+func (x *bus) SendHook(m *mangos.Message) bool {
+	x.Lock()
+	id, raw := x.ident, x.raw
+	x.Unlock()
+	if id != nil && !raw {
+		m.AddIdentity(id)
+	}
+	return true
+}
+
 func (x *bus) RecvHook(m *mangos.Message) bool {
-	if !x.raw && len(m.Header) >= 4 {
+	if x.raw {
+		return true
+	}
+	if len(m.Header) >= 4 {
 		m.Header = m.Header[4:]
 	}
+	x.Lock()
+	id := x.ident
+	x.Unlock()
+	if id != nil && !m.StripIdentity() {
+		// Peer is not sending identities, discard.
+		return false
+	}
 	return true
 }
 
@@ -211,15 +300,54 @@ func (x *bus) SetOption(name string, v interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionIdentity:
+		id, err := mangos.IdentityValue(v)
+		if err != nil {
+			return err
+		}
+		x.Lock()
+		x.ident = id
+		x.Unlock()
+		return nil
+	case mangos.OptionTTL:
+		ttl, ok := v.(int)
+		if !ok || ttl < 0 || ttl > mangos.MaxTTL {
+			return mangos.ErrBadValue
+		}
+		x.Lock()
+		x.ttl = ttl
+		x.Unlock()
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (x *bus) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionIdentity, Type: reflect.TypeOf([]byte(nil))},
+		{Name: mangos.OptionTTL, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionTTLDrops,
+			Type:     reflect.TypeOf(uint64(0)),
+			ReadOnly: true},
+	}
+}
+
 func (x *bus) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return x.raw, nil
+	case mangos.OptionIdentity:
+		x.Lock()
+		defer x.Unlock()
+		return x.ident, nil
+	case mangos.OptionTTL:
+		x.Lock()
+		defer x.Unlock()
+		return x.ttl, nil
+	case mangos.OptionTTLDrops:
+		return atomic.LoadUint64(&x.drops), nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
@@ -17,6 +17,7 @@
 package pair
 
 import (
+	"reflect"
 	"sync"
 	"time"
 
@@ -104,8 +105,8 @@ func (x *pair) AddEndpoint(ep mangos.Endpoint) {
 	x.Unlock()
 
 	x.w.Add()
-	go x.receiver(peer)
-	go x.sender(peer)
+	x.sock.Go(func() { x.receiver(peer) })
+	x.sock.Go(func() { x.sender(peer) })
 }
 
 func (x *pair) RemoveEndpoint(ep mangos.Endpoint) {
@@ -133,6 +134,10 @@ func (*pair) PeerName() string {
 	return "pair"
 }
 
+func (*pair) Unicast() bool {
+	return true
+}
+
 func (x *pair) SetOption(name string, v interface{}) error {
 	var ok bool
 	switch name {
@@ -146,6 +151,12 @@ func (x *pair) SetOption(name string, v interface{}) error {
 	}
 }
 
+func (x *pair) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+	}
+}
+
 func (x *pair) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
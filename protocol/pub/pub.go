@@ -18,24 +18,51 @@
 package pub
 
 import (
+	"bytes"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nanomsg.org/go-mangos"
 )
 
 type pubEp struct {
-	ep mangos.Endpoint
-	q  chan *mangos.Message
-	p  *pub
-	w  mangos.Waiter
+	ep   mangos.Endpoint
+	q    *mangos.PrioQueue
+	p    *pub
+	w    mangos.Waiter
+	subs map[string]struct{} // reported subscriptions, nil if unknown
+	hist []*mangos.Message   // retained messages to send first
+}
+
+// retainRing holds the most recent messages published on one topic, for
+// OptionRetain.
+type retainRing struct {
+	depth int
+	msgs  []retainedMsg // oldest first
+}
+
+type retainedMsg struct {
+	seq uint64 // orders messages across topics
+	m   *mangos.Message
 }
 
 type pub struct {
-	sock mangos.ProtocolSocket
-	eps  map[uint32]*pubEp
-	raw  bool
-	w    mangos.Waiter
+	drops   uint64 // see OptionUnsubscribedDrops, first for alignment
+	sock    mangos.ProtocolSocket
+	eps     map[uint32]*pubEp
+	raw     bool
+	ident   []byte
+	w       mangos.Waiter
+	topics  map[string]int // reporting subscribers per topic
+	subhook func(string, bool)
+	dropun  bool       // true if OptionDropUnsubscribed is set
+	hookmx  sync.Mutex // orders calls to subhook
+
+	retain map[string]*retainRing // see OptionRetain, by topic
+	rseq   uint64
 
 	sync.Mutex
 }
@@ -43,10 +70,25 @@ type pub struct {
 func (p *pub) Init(sock mangos.ProtocolSocket) {
 	p.sock = sock
 	p.eps = make(map[uint32]*pubEp)
+	p.topics = make(map[string]int)
 	p.sock.SetRecvError(mangos.ErrProtoOp)
 	p.w.Init()
 	p.w.Add()
-	go p.sender()
+	p.sock.Go(p.sender)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (p *pub) Drain(expire time.Time) {
+	p.Lock()
+	qs := make([]*mangos.PrioQueue, 0, len(p.eps))
+	for _, peer := range p.eps {
+		qs = append(qs, peer.q)
+	}
+	p.Unlock()
+	for _, q := range qs {
+		q.Drain(expire)
+	}
 }
 
 func (p *pub) Shutdown(expire time.Time) {
@@ -59,17 +101,34 @@ func (p *pub) Shutdown(expire time.Time) {
 	p.Unlock()
 
 	for id, peer := range peers {
-		mangos.DrainChannel(peer.q, expire)
-		close(peer.q)
+		peer.q.Drain(expire)
+		peer.q.Close()
 		delete(peers, id)
 	}
+
+	p.Lock()
+	for _, r := range p.retain {
+		r.trim(0)
+	}
+	p.Unlock()
 }
 
 // Bottom sender.
 func (pe *pubEp) peerSender() {
 
+	hist := pe.hist
+	pe.hist = nil
+	for i, m := range hist {
+		if pe.ep.SendMsg(m) != nil {
+			for _, m := range hist[i:] {
+				m.Free()
+			}
+			return
+		}
+	}
+
 	for {
-		m := <-pe.q
+		m := pe.q.Get()
 		if m == nil {
 			break
 		}
@@ -81,6 +140,41 @@ func (pe *pubEp) peerSender() {
 	}
 }
 
+// Bottom receiver.  SUB peers send us subscription reports (see
+// OptionSubForward), and synchronization probes (see OptionSync), which we
+// echo back in order with published data.  Anything else is discarded.
+func (pe *pubEp) peerReceiver() {
+	for {
+		m := pe.ep.RecvMsg()
+		if m == nil {
+			return
+		}
+		p := pe.p
+		if topics, fwd, ok := mangos.ParseSubscriptionReport(m); ok {
+			var subs map[string]struct{}
+			if fwd {
+				subs = make(map[string]struct{}, len(topics))
+				for _, t := range topics {
+					subs[string(t)] = struct{}{}
+				}
+			}
+			m.Free()
+			p.setSubs(pe, subs)
+			continue
+		}
+		if !mangos.IsSyncProbe(m) {
+			m.Free()
+			continue
+		}
+		p.Lock()
+		if p.eps[pe.ep.GetID()] != pe || !pe.q.Put(m) {
+			// Removed, or no room.
+			m.Free()
+		}
+		p.Unlock()
+	}
+}
+
 // Top sender.
 func (p *pub) sender() {
 	defer p.w.Done()
@@ -100,15 +194,24 @@ func (p *pub) sender() {
 			}
 
 			p.Lock()
+			if p.dropun && !p.subscribed(m) {
+				p.Unlock()
+				atomic.AddUint64(&p.drops, 1)
+				p.sock.Dropped(m, mangos.DropReasonNoSubscriber)
+				continue
+			}
+			p.keep(m)
+			var full []*mangos.Message
 			for _, peer := range p.eps {
 				m := m.Dup()
-				select {
-				case peer.q <- m:
-				default:
-					m.Free()
+				if !peer.q.Put(m) {
+					full = append(full, m)
 				}
 			}
 			p.Unlock()
+			for _, m := range full {
+				p.sock.Dropped(m, mangos.DropReasonQueueFull)
+			}
 			m.Free()
 		}
 	}
@@ -116,18 +219,23 @@ func (p *pub) sender() {
 
 func (p *pub) AddEndpoint(ep mangos.Endpoint) {
 	depth := 16
-	if i, err := p.sock.GetOption(mangos.OptionWriteQLen); err == nil {
+	if i, err := p.sock.GetOption(mangos.OptionWriteQLenPerPipe); err == nil {
 		depth = i.(int)
 	}
-	pe := &pubEp{ep: ep, p: p, q: make(chan *mangos.Message, depth)}
+	prio := false
+	if v, err := p.sock.GetOption(mangos.OptionSendPriority); err == nil {
+		prio = v.(bool)
+	}
+	pe := &pubEp{ep: ep, p: p, q: mangos.NewPrioQueue(depth, prio)}
 	pe.w.Init()
 	p.Lock()
 	p.eps[ep.GetID()] = pe
+	pe.hist = p.history()
 	p.Unlock()
 
 	pe.w.Add()
-	go pe.peerSender()
-	go mangos.NullRecv(ep)
+	p.sock.Go(pe.peerSender)
+	p.sock.Go(pe.peerReceiver)
 }
 
 func (p *pub) RemoveEndpoint(ep mangos.Endpoint) {
@@ -137,10 +245,159 @@ func (p *pub) RemoveEndpoint(ep mangos.Endpoint) {
 	delete(p.eps, id)
 	p.Unlock()
 	if pe != nil {
-		close(pe.q)
+		pe.q.Close()
+		p.setSubs(pe, nil)
 	}
 }
 
+// subscribed reports whether any peer may want the message.  Peers that do
+// not report their subscriptions might, so they always count.  It is
+// called with the lock held.
+func (p *pub) subscribed(m *mangos.Message) bool {
+	body := p.topicBody(m)
+	for _, pe := range p.eps {
+		if pe.subs == nil {
+			return true
+		}
+		for t := range pe.subs {
+			if bytes.HasPrefix(body, []byte(t)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topicBody returns the part of the message that subscribers match
+// against.  It is called with the lock held.
+func (p *pub) topicBody(m *mangos.Message) []byte {
+	body := m.Body
+	if n := 2 + len(p.ident); p.ident != nil && !p.raw && len(body) >= n {
+		// Subscribers match after removing our identity frame.
+		body = body[n:]
+	}
+	return body
+}
+
+// keep retains a copy of the message, if its topic is configured with
+// OptionRetain.  The longest matching topic wins.  It is called with the
+// lock held.
+func (p *pub) keep(m *mangos.Message) {
+	if len(p.retain) == 0 {
+		return
+	}
+	body := p.topicBody(m)
+	var ring *retainRing
+	best := -1
+	for t, r := range p.retain {
+		if len(t) > best && bytes.HasPrefix(body, []byte(t)) {
+			ring, best = r, len(t)
+		}
+	}
+	if ring == nil {
+		return
+	}
+	p.rseq++
+	ring.msgs = append(ring.msgs, retainedMsg{seq: p.rseq, m: retainCopy(m)})
+	ring.trim(ring.depth)
+}
+
+// retainCopy copies the message for keeping.  A Dup would share the
+// original's send deadline, and replaying it after that passes would only
+// have the transport discard it.
+func retainCopy(m *mangos.Message) *mangos.Message {
+	c := mangos.NewMessage(len(m.Body))
+	c.Header = append(c.Header, m.Header...)
+	c.Body = append(c.Body, m.Body...)
+	return c
+}
+
+// trim discards the oldest messages, leaving at most n.
+func (r *retainRing) trim(n int) {
+	if len(r.msgs) <= n {
+		return
+	}
+	drop := len(r.msgs) - n
+	for _, rm := range r.msgs[:drop] {
+		rm.m.Free()
+	}
+	r.msgs = append(r.msgs[:0], r.msgs[drop:]...)
+}
+
+// history returns copies of the retained messages, in the order they were
+// published.  It is called with the lock held.
+func (p *pub) history() []*mangos.Message {
+	var all []retainedMsg
+	for _, r := range p.retain {
+		all = append(all, r.msgs...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seq < all[j].seq })
+	hist := make([]*mangos.Message, 0, len(all))
+	for _, rm := range all {
+		hist = append(hist, rm.m.Dup())
+	}
+	return hist
+}
+
+// subChange records a topic gaining its first subscriber, or losing its
+// last one.
+type subChange struct {
+	topic  string
+	active bool
+}
+
+// setSubs replaces the subscriptions reported by a peer, updating the
+// per-topic counts and calling the subscriber hook for topics that gain
+// their first subscriber or lose their last.  Hook calls are made without
+// the lock held, but in order.
+func (p *pub) setSubs(pe *pubEp, subs map[string]struct{}) {
+	p.hookmx.Lock()
+	defer p.hookmx.Unlock()
+
+	var changes []subChange
+	p.Lock()
+	if p.eps[pe.ep.GetID()] != pe && subs != nil {
+		// Removed already; only the final teardown may change it.
+		p.Unlock()
+		return
+	}
+	// Add new ones first, so that topics in both sets never
+	// appear to drop to zero.
+	for t := range subs {
+		p.topics[t]++
+		if p.topics[t] == 1 {
+			changes = append(changes, subChange{t, true})
+		}
+	}
+	for t := range pe.subs {
+		p.topics[t]--
+		if p.topics[t] == 0 {
+			delete(p.topics, t)
+			changes = append(changes, subChange{t, false})
+		}
+	}
+	pe.subs = subs
+	hook := p.subhook
+	p.Unlock()
+
+	if hook != nil {
+		for _, c := range changes {
+			hook(c.topic, c.active)
+		}
+	}
+}
+
+// SendHook adds our identity frame, if we have one.
+func (p *pub) SendHook(m *mangos.Message) bool {
+	p.Lock()
+	id, raw := p.ident, p.raw
+	p.Unlock()
+	if id != nil && !raw {
+		m.AddIdentity(id)
+	}
+	return true
+}
+
 func (*pub) Number() uint16 {
 	return mangos.ProtoPub
 }
@@ -165,15 +422,126 @@ func (p *pub) SetOption(name string, v interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionIdentity:
+		id, err := mangos.IdentityValue(v)
+		if err != nil {
+			return err
+		}
+		p.Lock()
+		p.ident = id
+		p.Unlock()
+		return nil
+	case mangos.OptionSubscriberHook:
+		var hook func(string, bool)
+		switch v := v.(type) {
+		case func(string, bool):
+			hook = v
+		case nil:
+		default:
+			return mangos.ErrBadValue
+		}
+		p.Lock()
+		p.subhook = hook
+		p.Unlock()
+		return nil
+	case mangos.OptionDropUnsubscribed:
+		drop, ok := v.(bool)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		p.Lock()
+		p.dropun = drop
+		p.Unlock()
+		return nil
+	case mangos.OptionRetain:
+		var depths map[string]int
+		switch v := v.(type) {
+		case map[string]int:
+			depths = v
+		case nil:
+		default:
+			return mangos.ErrBadValue
+		}
+		for _, n := range depths {
+			if n < 0 {
+				return mangos.ErrBadValue
+			}
+		}
+		p.Lock()
+		defer p.Unlock()
+		retain := make(map[string]*retainRing, len(depths))
+		for t, n := range depths {
+			if n == 0 {
+				continue
+			}
+			r := p.retain[t]
+			if r == nil {
+				r = &retainRing{}
+			}
+			r.depth = n
+			r.trim(n)
+			retain[t] = r
+		}
+		for t, r := range p.retain {
+			if retain[t] == nil {
+				r.trim(0)
+			}
+		}
+		p.retain = retain
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (p *pub) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionIdentity, Type: reflect.TypeOf([]byte(nil))},
+		{Name: mangos.OptionSubscriberHook,
+			Type:      reflect.TypeOf((func(string, bool))(nil)),
+			WriteOnly: true},
+		{Name: mangos.OptionSubscribers,
+			Type:     reflect.TypeOf(map[string]int(nil)),
+			ReadOnly: true},
+		{Name: mangos.OptionDropUnsubscribed, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionUnsubscribedDrops,
+			Type:     reflect.TypeOf(uint64(0)),
+			ReadOnly: true},
+		{Name: mangos.OptionRetain, Type: reflect.TypeOf(map[string]int(nil))},
+	}
+}
+
 func (p *pub) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return p.raw, nil
+	case mangos.OptionIdentity:
+		p.Lock()
+		defer p.Unlock()
+		return p.ident, nil
+	case mangos.OptionSubscribers:
+		p.Lock()
+		defer p.Unlock()
+		counts := make(map[string]int, len(p.topics))
+		for t, n := range p.topics {
+			counts[t] = n
+		}
+		return counts, nil
+	case mangos.OptionDropUnsubscribed:
+		p.Lock()
+		defer p.Unlock()
+		return p.dropun, nil
+	case mangos.OptionUnsubscribedDrops:
+		return atomic.LoadUint64(&p.drops), nil
+	case mangos.OptionRetain:
+		p.Lock()
+		defer p.Unlock()
+		depths := make(map[string]int, len(p.retain))
+		for t, r := range p.retain {
+			depths[t] = r.depth
+		}
+		return depths, nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
@@ -17,14 +17,23 @@
 package pull
 
 import (
+	"encoding/binary"
+	"reflect"
+	"sync"
 	"time"
 
 	"nanomsg.org/go-mangos"
 )
 
 type pull struct {
-	sock mangos.ProtocolSocket
-	raw  bool
+	sock  mangos.ProtocolSocket
+	raw   bool
+	ack   bool
+	batch int
+	dedup int                 // window size, see OptionDedupWindow
+	seen  map[uint32]struct{} // recent sequence numbers
+	order []uint32            // seen, oldest first
+	sync.Mutex
 }
 
 func (x *pull) Init(sock mangos.ProtocolSocket) {
@@ -37,19 +46,106 @@ func (x *pull) Shutdown(time.Time) {} // No sender to drain
 func (x *pull) receiver(ep mangos.Endpoint) {
 	rq := x.sock.RecvChannel()
 	cq := x.sock.CloseChannel()
+	x.Lock()
+	ack := x.ack
+	batch := x.batch > 0 && !ack && !x.raw // as PUSH does
+	dedup := x.dedup > 0 && ack
+	x.Unlock()
 	for {
+		var seq [4]byte
 
 		m := ep.RecvMsg()
 		if m == nil {
 			return
 		}
 
+		// In ack mode, the sender prefixes a sequence number, which
+		// we return once the message has been queued for the
+		// application.
+		if ack {
+			if len(m.Body) < 4 {
+				m.Free()
+				x.sock.Garbled(ep)
+				continue
+			}
+			copy(seq[:], m.Body)
+			m.Body = m.Body[4:]
+		}
+
+		if dedup && x.duplicate(binary.BigEndian.Uint32(seq[:])) {
+			// Acknowledge it again, since the sender evidently
+			// did not get the first one.
+			m.Free()
+		} else if batch {
+			if !x.split(m, rq, cq) {
+				return
+			}
+		} else {
+			select {
+			case rq <- m:
+			case <-cq:
+				return
+			}
+		}
+
+		if ack {
+			a := mangos.NewMessage(len(seq))
+			a.Body = append(a.Body, seq[:]...)
+			if ep.SendMsg(a) != nil {
+				a.Free()
+				return
+			}
+		}
+	}
+}
+
+// duplicate records the sequence number, and returns true if it was
+// already among the most recent ones seen.
+func (x *pull) duplicate(seq uint32) bool {
+	x.Lock()
+	defer x.Unlock()
+	if _, ok := x.seen[seq]; ok {
+		return true
+	}
+	if x.seen == nil {
+		x.seen = make(map[uint32]struct{})
+	}
+	for len(x.order) > 0 && len(x.order) >= x.dedup {
+		delete(x.seen, x.order[0])
+		x.order = x.order[1:]
+	}
+	x.seen[seq] = struct{}{}
+	x.order = append(x.order, seq)
+	return false
+}
+
+// split delivers each of the messages coalesced into a frame by a batching
+// PUSH peer, as though each had arrived on its own from the same Port.  A
+// malformed frame is discarded from the point of the error.  It returns
+// false if the socket is closed.
+func (x *pull) split(f *mangos.Message, rq chan<- *mangos.Message,
+	cq <-chan struct{}) bool {
+
+	defer f.Free()
+	b := f.Body
+	for len(b) >= 4 {
+		l := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(l) > uint64(len(b)) {
+			return true
+		}
+		m := mangos.NewMessage(int(l))
+		m.Body = append(m.Body, b[:l]...)
+		m.Port = f.Port
+		b = b[l:]
 		select {
 		case rq <- m:
 		case <-cq:
-			return
+			m.Free()
+			return false
 		}
 	}
+	return true
 }
 
 func (*pull) Number() uint16 {
@@ -69,7 +165,7 @@ func (*pull) PeerName() string {
 }
 
 func (x *pull) AddEndpoint(ep mangos.Endpoint) {
-	go x.receiver(ep)
+	x.sock.Go(func() { x.receiver(ep) })
 }
 
 func (x *pull) RemoveEndpoint(ep mangos.Endpoint) {}
@@ -86,15 +182,63 @@ func (x *pull) SetOption(name string, v interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionAckMode:
+		x.Lock()
+		defer x.Unlock()
+		if x.ack, ok = v.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionBatchSize:
+		x.Lock()
+		defer x.Unlock()
+		if b, ok := v.(int); ok && b >= 0 {
+			x.batch = b
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionDedupWindow:
+		x.Lock()
+		defer x.Unlock()
+		if d, ok := v.(int); ok && d >= 0 {
+			x.dedup = d
+			for len(x.order) > d {
+				delete(x.seen, x.order[0])
+				x.order = x.order[1:]
+			}
+			return nil
+		}
+		return mangos.ErrBadValue
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (x *pull) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionAckMode, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionBatchSize, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionDedupWindow, Type: reflect.TypeOf(0)},
+	}
+}
+
 func (x *pull) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return x.raw, nil
+	case mangos.OptionAckMode:
+		x.Lock()
+		defer x.Unlock()
+		return x.ack, nil
+	case mangos.OptionBatchSize:
+		x.Lock()
+		defer x.Unlock()
+		return x.batch, nil
+	case mangos.OptionDedupWindow:
+		x.Lock()
+		defer x.Unlock()
+		return x.dedup, nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
@@ -17,6 +17,10 @@
 package push
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,53 +28,376 @@ import (
 )
 
 type push struct {
-	sock mangos.ProtocolSocket
-	raw  bool
-	w    mangos.Waiter
-	eps  map[uint32]*pushEp
+	sock    mangos.ProtocolSocket
+	raw     bool
+	ack     bool
+	batch   int
+	window  int // limit on unacknowledged messages per pipe
+	w       mangos.Waiter
+	bal     mangos.Balancer
+	eps     map[uint32]*pushEp
+	nextseq uint32
+	pending []*pushMsg    // messages awaiting retransmit (ack mode)
+	resendq chan struct{} // signaled when pending is non-empty
+	shut    bool          // true once Shutdown has given up on pending
 	sync.Mutex
 }
 
 type pushEp struct {
-	ep mangos.Endpoint
-	cq chan struct{}
+	ep      mangos.Endpoint
+	cq      chan struct{}
+	ackq    chan struct{} // signaled when acknowledgements arrive
+	unacked []*pushMsg
+	closed  bool
+
+	fwdq chan *mangos.Message // messages the SendSelector chose for us
+}
+
+// pushMsg tracks a message sent in ack mode.  We hold a reference to the
+// message until the peer acknowledges it.
+type pushMsg struct {
+	seq uint32
+	m   *mangos.Message
 }
 
 func (x *push) Init(sock mangos.ProtocolSocket) {
 	x.sock = sock
 	x.w.Init()
+	x.bal.Init()
 	x.sock.SetRecvError(mangos.ErrProtoOp)
 	x.eps = make(map[uint32]*pushEp)
+	x.resendq = make(chan struct{}, 1)
+	x.nextseq = seedSeq()
+}
+
+// seedSeq returns a random starting sequence number, so that receivers
+// removing duplicates (OptionDedupWindow) are unlikely to confuse the
+// messages of different senders.
+func seedSeq() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano()) // quasi-random
+	}
+	return binary.BigEndian.Uint32(b[:])
 }
 
 func (x *push) Shutdown(expire time.Time) {
 	x.w.WaitAbsTimeout(expire)
+
+	// Nothing is left to retransmit messages still unacknowledged, so
+	// they are freed, as are those of pipes that close after this.
+	x.Lock()
+	x.shut = true
+	pending := x.pending
+	x.pending = nil
+	x.Unlock()
+	for _, pm := range pending {
+		pm.m.Free()
+	}
 }
 
 func (x *push) sender(ep *pushEp) {
 	defer x.w.Done()
 	sq := x.sock.SendChannel()
 	cq := x.sock.CloseChannel()
+	x.Lock()
+	ack := x.ack
+	batch := x.batch
+	if ack || x.raw {
+		batch = 0
+	}
+	window := 0
+	if ack {
+		window = x.window
+	}
+	x.Unlock()
 
 	for {
-		select {
-		case <-cq:
-			return
-		case <-ep.cq:
-			return
-		case m := <-sq:
-			if m == nil {
-				sq = x.sock.SendChannel()
+		var m *mangos.Message
+		var pm *pushMsg
+		selected := false // by the SendSelector, see OptionSendSelector
+
+		// Wait for the peer to catch up, if its window is full.
+		for window > 0 && x.inFlight(ep) >= window {
+			select {
+			case <-ep.ackq:
+			case <-cq:
+				return
+			case <-ep.cq:
+				return
+			}
+		}
+
+		// Wait for our turn, if peers are weighted, or for the
+		// endpoint to be enabled.  Messages another sender took for
+		// us have had their turn already.
+		chg := x.bal.Changed()
+		for q := x.bal.Turn(ep.ep); q != nil && m == nil; q = x.bal.Turn(ep.ep) {
+			select {
+			case <-q:
+			case m = <-ep.fwdq:
+				selected = true
+			case <-cq:
+				return
+			case <-ep.cq:
+				return
+			}
+		}
+
+		// Retransmits always go ahead of new traffic, so that
+		// ordering is preserved as much as possible.
+		if m == nil {
+			pm = x.nextPending()
+		}
+		if m == nil && pm == nil {
+			select {
+			case <-cq:
+				return
+			case <-ep.cq:
+				return
+			case <-x.resendq:
+				continue
+			case <-chg:
 				continue
+			case m = <-ep.fwdq:
+				selected = true
+			case m = <-sq:
+				if m == nil {
+					sq = x.sock.SendChannel()
+					continue
+				}
+			}
+		}
+		if !selected {
+			x.bal.Took(ep.ep)
+		}
+		if m != nil && !selected {
+			if dst := x.bal.Select(m); dst != nil {
+				selected = true
+				if dst.GetID() != ep.ep.GetID() {
+					if !x.forward(ep, dst.GetID(), m, ack) {
+						return
+					}
+					continue
+				}
 			}
-			if ep.ep.SendMsg(m) != nil {
+		}
+		if batch > 0 && !selected {
+			m = x.coalesce(ep, m, sq, batch)
+		}
+		if !x.send(ep, m, pm, ack) {
+			return
+		}
+	}
+}
+
+// send writes a message (or a retransmission, if pm is not nil) to the
+// endpoint.  It returns false if the endpoint has failed.
+func (x *push) send(ep *pushEp, m *mangos.Message, pm *pushMsg, ack bool) bool {
+	if pm != nil || ack {
+		if pm == nil {
+			pm = x.newPushMsg(m)
+		}
+		if !x.track(ep, pm) {
+			return false
+		}
+		m = pm.m
+	}
+	if ep.ep.SendMsg(m) != nil {
+		m.Free()
+		return false
+	}
+	return true
+}
+
+// forward hands a message the SendSelector chose for another endpoint to
+// that endpoint's sender.  Meanwhile it sends any messages handed to us,
+// so that senders forwarding to one another cannot deadlock.  If the other
+// endpoint has gone, the message is sent here instead.  It returns false
+// if our own endpoint has failed.
+func (x *push) forward(ep *pushEp, id uint32, m *mangos.Message, ack bool) bool {
+	x.Lock()
+	dst := x.eps[id]
+	x.Unlock()
+	if dst == nil {
+		return x.send(ep, m, nil, ack)
+	}
+	ok := true
+	fq, epq := ep.fwdq, ep.cq
+	for {
+		select {
+		case dst.fwdq <- m:
+			return ok
+		case <-dst.cq:
+			if !ok {
 				m.Free()
-				return
+				return false
+			}
+			return x.send(ep, m, nil, ack)
+		case fm := <-fq:
+			if !x.send(ep, fm, nil, ack) {
+				ok = false
+				fq, epq = nil, nil
 			}
+		case <-epq:
+			// Still deliver this one, but take no more.
+			ok = false
+			fq, epq = nil, nil
+		case <-x.sock.CloseChannel():
+			m.Free()
+			return false
 		}
 	}
 }
 
+// maxFrameAlloc bounds the space reserved up front for a coalesced frame;
+// larger frames simply grow as needed.
+const maxFrameAlloc = 65536
+
+// coalesce returns a frame holding the message, followed by as many other
+// messages already queued as fit before the frame reaches limit bytes.
+// Each message, header and body together, is preceded by its 4 byte
+// length, and its OnSent function is called once the frame is written.
+// Each message taken uses up one of the endpoint's turns, as it would if
+// sent alone, and the frame ends with the turn.  (SendMsgEndpoint does not wait for batched messages; see
+// Unicast.)
+func (x *push) coalesce(ep *pushEp, m *mangos.Message,
+	sq <-chan *mangos.Message, limit int) *mangos.Message {
+
+	sz := limit
+	if sz > maxFrameAlloc {
+		sz = maxFrameAlloc
+	}
+	f := mangos.NewMessage(sz)
+	for m != nil {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(m.Header)+len(m.Body)))
+		f.Body = append(f.Body, l[:]...)
+		f.Body = append(f.Body, m.Header...)
+		f.Body = append(f.Body, m.Body...)
+		f.MergeOnSent(m)
+		m.Free()
+		m = nil
+		if len(f.Body) >= limit || x.bal.Turn(ep.ep) != nil {
+			break
+		}
+		select {
+		case m = <-sq:
+			if m != nil {
+				x.bal.Took(ep.ep)
+			}
+		default:
+		}
+	}
+	return f
+}
+
+// inFlight returns the number of messages sent on the endpoint that have
+// not yet been acknowledged.
+func (x *push) inFlight(ep *pushEp) int {
+	x.Lock()
+	defer x.Unlock()
+	return len(ep.unacked)
+}
+
+// nextPending returns the oldest message awaiting retransmission, if any.
+func (x *push) nextPending() *pushMsg {
+	x.Lock()
+	defer x.Unlock()
+	if len(x.pending) == 0 {
+		return nil
+	}
+	pm := x.pending[0]
+	x.pending = x.pending[1:]
+	return pm
+}
+
+// newPushMsg assigns the next sequence number to a message, and adds it
+// to the header.
+func (x *push) newPushMsg(m *mangos.Message) *pushMsg {
+	x.Lock()
+	seq := x.nextseq
+	x.nextseq++
+	x.Unlock()
+	m.Header = append(m.Header,
+		byte(seq>>24), byte(seq>>16), byte(seq>>8), byte(seq))
+	return &pushMsg{seq: seq, m: m}
+}
+
+// track records a message as unacknowledged on the endpoint.  It returns
+// false if the endpoint has been removed, in which case the message is put
+// back for retransmission on another endpoint.
+func (x *push) track(ep *pushEp, pm *pushMsg) bool {
+	x.Lock()
+	defer x.Unlock()
+	if ep.closed {
+		x.requeue([]*pushMsg{pm})
+		return false
+	}
+	// We hold one reference until acknowledged; the send consumes
+	// the other.
+	pm.m.Dup()
+	ep.unacked = append(ep.unacked, pm)
+	return true
+}
+
+// requeue adds messages to the pending list, keeping it in sequence
+// order, or frees them once the socket has shut down.  The caller must
+// hold the lock.
+func (x *push) requeue(msgs []*pushMsg) {
+	if len(msgs) == 0 {
+		return
+	}
+	if x.shut {
+		for _, pm := range msgs {
+			pm.m.Free()
+		}
+		return
+	}
+	x.pending = append(x.pending, msgs...)
+	sort.SliceStable(x.pending, func(i, j int) bool {
+		return int32(x.pending[i].seq-x.pending[j].seq) < 0
+	})
+	x.signalResend()
+}
+
+func (x *push) signalResend() {
+	select {
+	case x.resendq <- struct{}{}:
+	default:
+	}
+}
+
+// receiver processes acknowledgements from the peer.  Acknowledgements are
+// cumulative, since each pipe delivers in order.
+func (x *push) receiver(ep *pushEp) {
+	for {
+		m := ep.ep.RecvMsg()
+		if m == nil {
+			return
+		}
+		if len(m.Body) >= 4 {
+			seq := binary.BigEndian.Uint32(m.Body)
+			x.Lock()
+			n := 0
+			for _, pm := range ep.unacked {
+				if int32(pm.seq-seq) > 0 {
+					break
+				}
+				pm.m.Free()
+				n++
+			}
+			ep.unacked = ep.unacked[n:]
+			x.Unlock()
+			select {
+			case ep.ackq <- struct{}{}:
+			default:
+			}
+		}
+		m.Free()
+	}
+}
+
 func (*push) Number() uint16 {
 	return mangos.ProtoPush
 }
@@ -87,14 +414,33 @@ func (*push) PeerName() string {
 	return "pull"
 }
 
+// Unicast is false when batching, as the message is then copied into a
+// frame with others rather than written itself.
+func (x *push) Unicast() bool {
+	x.Lock()
+	defer x.Unlock()
+	return x.batch == 0 || x.ack || x.raw
+}
+
 func (x *push) AddEndpoint(ep mangos.Endpoint) {
-	pe := &pushEp{ep: ep, cq: make(chan struct{})}
+	pe := &pushEp{
+		ep:   ep,
+		cq:   make(chan struct{}),
+		ackq: make(chan struct{}, 1),
+		fwdq: make(chan *mangos.Message),
+	}
 	x.Lock()
 	x.eps[ep.GetID()] = pe
+	ack := x.ack
 	x.Unlock()
+	x.bal.Add(ep)
 	x.w.Add()
-	go x.sender(pe)
-	go mangos.NullRecv(ep)
+	x.sock.Go(func() { x.sender(pe) })
+	if ack {
+		x.sock.Go(func() { x.receiver(pe) })
+	} else {
+		x.sock.Go(func() { mangos.NullRecv(ep) })
+	}
 }
 
 func (x *push) RemoveEndpoint(ep mangos.Endpoint) {
@@ -102,7 +448,13 @@ func (x *push) RemoveEndpoint(ep mangos.Endpoint) {
 	x.Lock()
 	pe := x.eps[id]
 	delete(x.eps, id)
+	if pe != nil {
+		pe.closed = true
+		x.requeue(pe.unacked)
+		pe.unacked = nil
+	}
 	x.Unlock()
+	x.bal.Remove(ep)
 	if pe != nil {
 		close(pe.cq)
 	}
@@ -116,15 +468,80 @@ func (x *push) SetOption(name string, v interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionAckMode:
+		x.Lock()
+		defer x.Unlock()
+		if x.ack, ok = v.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionBatchSize:
+		x.Lock()
+		defer x.Unlock()
+		if b, ok := v.(int); ok && b >= 0 {
+			x.batch = b
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionSendWindow:
+		x.Lock()
+		defer x.Unlock()
+		if w, ok := v.(int); ok && w >= 0 {
+			x.window = w
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionRoundRobin:
+		rr, ok := v.(bool)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		x.bal.SetOrdered(rr)
+		return nil
+	case mangos.OptionSendSelector:
+		fn, err := mangos.SendSelectorValue(v)
+		if err != nil {
+			return err
+		}
+		x.bal.SetSelector(fn)
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (x *push) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionAckMode, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionBatchSize, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionSendWindow, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionRoundRobin, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSendSelector,
+			Type: reflect.TypeOf(mangos.SendSelector(nil))},
+	}
+}
+
 func (x *push) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return x.raw, nil
+	case mangos.OptionAckMode:
+		x.Lock()
+		defer x.Unlock()
+		return x.ack, nil
+	case mangos.OptionBatchSize:
+		x.Lock()
+		defer x.Unlock()
+		return x.batch, nil
+	case mangos.OptionSendWindow:
+		x.Lock()
+		defer x.Unlock()
+		return x.window, nil
+	case mangos.OptionRoundRobin:
+		return x.bal.Ordered(), nil
+	case mangos.OptionSendSelector:
+		return x.bal.Selector(), nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
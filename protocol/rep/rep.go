@@ -18,13 +18,16 @@ package rep
 
 import (
 	"encoding/binary"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nanomsg.org/go-mangos"
 )
 
 type repEp struct {
+	used uint64 // value of rep.tick when last used, for eviction
 	q    chan *mangos.Message
 	ep   mangos.Endpoint
 	sock mangos.ProtocolSocket
@@ -39,7 +42,12 @@ type rep struct {
 	backtrace    []byte
 	backtraceL   sync.Mutex
 	raw          bool
+	anyOrder     bool // true if OptionReplyAnyOrder is set
 	ttl          int
+	ttlDrops     uint64
+	maxPeers     int    // see OptionRouteTableSize
+	evictions    uint64 // see OptionRouteEvictions
+	tick         uint64 // advanced each time a peer is used
 	w            mangos.Waiter
 
 	sync.Mutex
@@ -53,7 +61,21 @@ func (r *rep) Init(sock mangos.ProtocolSocket) {
 	r.w.Init()
 	r.sock.SetSendError(mangos.ErrProtoState)
 	r.w.Add()
-	go r.sender()
+	r.sock.Go(r.sender)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (r *rep) Drain(expire time.Time) {
+	r.Lock()
+	qs := make([]chan *mangos.Message, 0, len(r.eps))
+	for _, peer := range r.eps {
+		qs = append(qs, peer.q)
+	}
+	r.Unlock()
+	for _, q := range qs {
+		mangos.DrainChannel(q, expire)
+	}
 }
 
 func (r *rep) Shutdown(expire time.Time) {
@@ -86,33 +108,40 @@ func (pe *repEp) sender() {
 	}
 }
 
-func (r *rep) receiver(ep mangos.Endpoint) {
+func (r *rep) receiver(pe *repEp) {
 
+	ep := pe.ep
 	rq := r.sock.RecvChannel()
 	cq := r.sock.CloseChannel()
 
+outer:
 	for {
 
 		m := ep.RecvMsg()
 		if m == nil {
 			return
 		}
+		r.touch(pe)
 
 		v := ep.GetID()
 		m.Header = append(m.Header,
 			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 
 		hops := 0
-		// Move backtrace from body to header.
+		// Move backtrace from body to header.  The TTL bounds the
+		// depth, so that an abusive peer can't make us build up an
+		// enormous header.
 		for {
 			if hops >= r.ttl {
+				atomic.AddUint64(&r.ttlDrops, 1)
 				m.Free() // ErrTooManyHops
-				return
+				continue outer
 			}
 			hops++
 			if len(m.Body) < 4 {
 				m.Free() // ErrGarbled
-				return
+				r.sock.Garbled(ep)
+				continue outer
 			}
 			m.Header = append(m.Header, m.Body[:4]...)
 			m.Body = m.Body[4:]
@@ -156,6 +185,13 @@ func (r *rep) sender() {
 		}
 		id := binary.BigEndian.Uint32(m.Header)
 		m.Header = m.Header[4:]
+		if len(m.Header)/4 > r.ttl {
+			// Too deep, such as a backtrace set by hand in raw
+			// mode; the peers would only drop it.
+			atomic.AddUint64(&r.ttlDrops, 1)
+			m.Free()
+			continue
+		}
 		r.Lock()
 		pe := r.eps[id]
 		if pe == nil {
@@ -163,6 +199,7 @@ func (r *rep) sender() {
 			m.Free()
 			continue
 		}
+		r.touch(pe)
 
 		select {
 		case pe.q <- m:
@@ -196,14 +233,41 @@ func (*rep) PeerName() string {
 	return "req"
 }
 
+func (*rep) Unicast() bool {
+	return true
+}
+
+// touch marks the peer as the most recently used.
+func (r *rep) touch(pe *repEp) {
+	atomic.StoreUint64(&pe.used, atomic.AddUint64(&r.tick, 1))
+}
+
 func (r *rep) AddEndpoint(ep mangos.Endpoint) {
 	pe := &repEp{ep: ep, r: r, q: make(chan *mangos.Message, 2)}
 	pe.w.Init()
+	r.touch(pe)
 	r.Lock()
+	var evict *repEp
+	if r.maxPeers > 0 && len(r.eps) >= r.maxPeers {
+		// Make room by dropping the peer we have heard from (or
+		// replied to) least recently.
+		for _, old := range r.eps {
+			if evict == nil || atomic.LoadUint64(&old.used) <
+				atomic.LoadUint64(&evict.used) {
+				evict = old
+			}
+		}
+		delete(r.eps, evict.ep.GetID())
+		close(evict.q)
+		atomic.AddUint64(&r.evictions, 1)
+	}
 	r.eps[ep.GetID()] = pe
 	r.Unlock()
-	go r.receiver(ep)
-	go pe.sender()
+	if evict != nil {
+		evict.ep.Close()
+	}
+	r.sock.Go(func() { r.receiver(pe) })
+	r.sock.Go(pe.sender)
 }
 
 func (r *rep) RemoveEndpoint(ep mangos.Endpoint) {
@@ -230,8 +294,11 @@ func (r *rep) RecvHook(m *mangos.Message) bool {
 	r.sock.SetSendError(nil)
 	r.backtraceL.Lock()
 	r.backtrace = append(r.backtracebuf[0:0], m.Header...)
+	anyOrder := r.anyOrder
 	r.backtraceL.Unlock()
-	m.Header = nil
+	if !anyOrder {
+		m.Header = nil
+	}
 	return true
 }
 
@@ -242,8 +309,17 @@ func (r *rep) SendHook(m *mangos.Message) bool {
 	if r.raw {
 		return true
 	}
-	r.sock.SetSendError(mangos.ErrProtoState)
 	r.backtraceL.Lock()
+	if r.anyOrder {
+		// The message carries the backtrace of the request it
+		// answers, so it is routed independently of any others.
+		if len(m.Header) > 0 {
+			r.backtraceL.Unlock()
+			return true
+		}
+	} else {
+		r.sock.SetSendError(mangos.ErrProtoState)
+	}
 	m.Header = append(m.Header[0:0], r.backtrace...)
 	r.backtrace = nil
 	r.backtraceL.Unlock()
@@ -266,6 +342,15 @@ func (r *rep) SetOption(name string, v interface{}) error {
 			r.sock.SetSendError(mangos.ErrProtoState)
 		}
 		return nil
+	case mangos.OptionReplyAnyOrder:
+		anyOrder, ok := v.(bool)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		r.backtraceL.Lock()
+		r.anyOrder = anyOrder
+		r.backtraceL.Unlock()
+		return nil
 	case mangos.OptionTTL:
 		if ttl, ok := v.(int); !ok {
 			return mangos.ErrBadValue
@@ -275,17 +360,53 @@ func (r *rep) SetOption(name string, v interface{}) error {
 			r.ttl = ttl
 		}
 		return nil
+	case mangos.OptionRouteTableSize:
+		n, ok := v.(int)
+		if !ok || n < 0 {
+			return mangos.ErrBadValue
+		}
+		r.Lock()
+		r.maxPeers = n
+		r.Unlock()
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (r *rep) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionReplyAnyOrder, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionTTL, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionTTLDrops,
+			Type:     reflect.TypeOf(uint64(0)),
+			ReadOnly: true},
+		{Name: mangos.OptionRouteTableSize, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionRouteEvictions,
+			Type:     reflect.TypeOf(uint64(0)),
+			ReadOnly: true},
+	}
+}
+
 func (r *rep) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return r.raw, nil
+	case mangos.OptionReplyAnyOrder:
+		r.backtraceL.Lock()
+		defer r.backtraceL.Unlock()
+		return r.anyOrder, nil
 	case mangos.OptionTTL:
 		return r.ttl, nil
+	case mangos.OptionTTLDrops:
+		return atomic.LoadUint64(&r.ttlDrops), nil
+	case mangos.OptionRouteTableSize:
+		r.Lock()
+		defer r.Unlock()
+		return r.maxPeers, nil
+	case mangos.OptionRouteEvictions:
+		return atomic.LoadUint64(&r.evictions), nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
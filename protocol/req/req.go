@@ -17,7 +17,9 @@
 package req
 
 import (
+	"crypto/rand"
 	"encoding/binary"
+	"reflect"
 	"sync"
 	"time"
 
@@ -31,20 +33,34 @@ type req struct {
 	eps    map[uint32]*reqEp
 	resend chan *mangos.Message
 	raw    bool
+	bcast  bool
+	fast   bool // fail fast when there are no peers
+	others bool // resend to untried peers, see OptionRetryOtherPeer
+	tries  int  // number of times reqmsg has been sent
+	maxtry int  // see OptionMaxAttempts
 	retry  time.Duration
 	nextid uint32
-	waker  *time.Timer
+	idfn   func() uint32 // request ID strategy for raw mode
+	clock  mangos.Clock
+	waker  mangos.ClockTimer
+	wakeq  chan struct{}
 	w      mangos.Waiter
+	bal    mangos.Balancer
 	init   sync.Once
 
 	// fields describing the outstanding request
 	reqmsg *mangos.Message
 	reqid  uint32
+	sentto map[uint32]bool // endpoints the request was sent on
+	sentat time.Time       // when the request was first sent
+
+	latency mangos.LatencyStats
 }
 
 type reqEp struct {
 	ep mangos.Endpoint
 	cq chan struct{}
+	bq chan *mangos.Message // broadcasts, and messages selected for us
 }
 
 func (r *req) Init(socket mangos.ProtocolSocket) {
@@ -52,10 +68,13 @@ func (r *req) Init(socket mangos.ProtocolSocket) {
 	r.eps = make(map[uint32]*reqEp)
 	r.resend = make(chan *mangos.Message)
 	r.w.Init()
+	r.bal.Init()
 
-	r.nextid = uint32(time.Now().UnixNano()) // quasi-random
-	r.retry = time.Minute * 1                // retry after a minute
-	r.waker = time.NewTimer(r.retry)
+	r.nextid = seedID()
+	r.retry = time.Minute * 1 // retry after a minute
+	r.wakeq = make(chan struct{}, 1)
+	r.clock = mangos.RealClock()
+	r.waker = r.clock.AfterFunc(r.retry, r.wake)
 	r.waker.Stop()
 	r.sock.SetRecvError(mangos.ErrProtoState)
 }
@@ -64,6 +83,17 @@ func (r *req) Shutdown(expire time.Time) {
 	r.w.WaitAbsTimeout(expire)
 }
 
+// seedID returns a random starting request ID, so that a restarted client
+// does not repeat the IDs it used before.  (Seeding from the clock is
+// prone to this when clocks are coarse, or are set back.)
+func seedID() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano()) // quasi-random
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
 // nextID returns the next request ID.
 func (r *req) nextID() uint32 {
 	// The high order bit is "special", and must always be set.  (This is
@@ -73,6 +103,14 @@ func (r *req) nextID() uint32 {
 	return v
 }
 
+// wake is called by the retry timer to kick the resender.
+func (r *req) wake() {
+	select {
+	case r.wakeq <- struct{}{}:
+	default:
+	}
+}
+
 // resend sends the request message again, after a timer has expired.
 func (r *req) resender() {
 
@@ -81,7 +119,7 @@ func (r *req) resender() {
 
 	for {
 		select {
-		case <-r.waker.C:
+		case <-r.wakeq:
 		case <-cq:
 			return
 		}
@@ -92,10 +130,26 @@ func (r *req) resender() {
 			r.Unlock()
 			continue
 		}
+		if r.maxtry > 0 && r.tries >= r.maxtry {
+			r.reqmsg = nil
+			r.sock.SetRecvError(mangos.ErrNoReply)
+			r.Unlock()
+			m.Free()
+			continue
+		}
+		r.tries++
 		m = m.Dup()
+		var bq chan *mangos.Message
+		if r.others {
+			bq = r.untried()
+		}
 		r.Unlock()
 
-		r.resend <- m
+		select {
+		case bq <- m:
+		default:
+			r.resend <- m
+		}
 		r.Lock()
 		if r.retry > 0 {
 			r.waker.Reset(r.retry)
@@ -106,6 +160,17 @@ func (r *req) resender() {
 	}
 }
 
+// untried returns the queue of a peer that the current request has not
+// been sent to, or nil if there are none.  The lock must be held.
+func (r *req) untried() chan *mangos.Message {
+	for id, pe := range r.eps {
+		if !r.sentto[id] && pe.ep.SendEnabled() {
+			return pe.bq
+		}
+	}
+	return nil
+}
+
 func (r *req) receiver(ep mangos.Endpoint) {
 	rq := r.sock.RecvChannel()
 	cq := r.sock.CloseChannel()
@@ -118,11 +183,20 @@ func (r *req) receiver(ep mangos.Endpoint) {
 
 		if len(m.Body) < 4 {
 			m.Free()
+			r.sock.Garbled(ep)
 			continue
 		}
 		m.Header = append(m.Header, m.Body[:4]...)
 		m.Body = m.Body[4:]
 
+		if !r.sentOn(ep, m) {
+			// A reply to our request, but from a peer that never
+			// received it, such as one restarted since it was
+			// last sent an earlier request with the same ID.
+			m.Free()
+			continue
+		}
+
 		select {
 		case rq <- m:
 		case <-cq:
@@ -145,23 +219,218 @@ func (r *req) sender(pe *reqEp) {
 
 	for {
 		var m *mangos.Message
+		picked := false // taken from the socket, rather than for us
+
+		// Wait for our turn, if peers are weighted, or for the
+		// endpoint to be enabled.  Broadcasts go to every peer, so
+		// they need not wait, and nor do messages that another sender
+		// took for us (see OptionSendSelector).
+		chg := r.bal.Changed()
+		for q := r.bal.Turn(pe.ep); q != nil && m == nil; q = r.bal.Turn(pe.ep) {
+			select {
+			case <-q:
+			case m = <-pe.bq:
+			case <-cq:
+				return
+			case <-pe.cq:
+				return
+			}
+		}
 
-		select {
-		case m = <-rq:
-		case m = <-sq:
-		case <-cq:
-			return
-		case <-pe.cq:
+		if m == nil {
+			select {
+			case m = <-rq:
+				r.bal.Took(pe.ep)
+				picked = true
+			case m = <-sq:
+				if done, ok := r.broadcast(pe, m); done {
+					if !ok {
+						return
+					}
+					continue
+				}
+				r.bal.Took(pe.ep)
+				picked = true
+			case m = <-pe.bq:
+			case <-chg:
+				continue
+			case <-cq:
+				return
+			case <-pe.cq:
+				return
+			}
+		}
+
+		if picked {
+			dst := r.bal.Select(m)
+			if dst != nil && dst.GetID() != pe.ep.GetID() {
+				if !r.forward(pe, dst.GetID(), m) {
+					return
+				}
+				continue
+			}
+		}
+
+		if !r.send(pe, m) {
 			return
 		}
+	}
+}
 
-		if pe.ep.SendMsg(m) != nil {
-			r.resend <- m
-			break
+// send writes the message to the endpoint.  If that fails, the message is
+// given to another sender to retry, and false is returned.
+func (r *req) send(pe *reqEp, m *mangos.Message) bool {
+	r.noteSent(pe.ep, m)
+	if pe.ep.SendMsg(m) != nil {
+		r.resend <- m
+		return false
+	}
+	return true
+}
+
+// forward hands a message the SendSelector chose for another endpoint to
+// that endpoint's sender.  Meanwhile it sends any messages handed to us,
+// so that senders forwarding to one another cannot deadlock.  If the other
+// endpoint has gone, the message is sent here instead.  It returns false
+// if our own endpoint has failed.
+func (r *req) forward(pe *reqEp, id uint32, m *mangos.Message) bool {
+	r.Lock()
+	dst := r.eps[id]
+	r.Unlock()
+	if dst == nil {
+		return r.send(pe, m)
+	}
+	ok := true
+	fq, epq := pe.bq, pe.cq
+	for {
+		select {
+		case dst.bq <- m:
+			return ok
+		case <-dst.cq:
+			if !ok {
+				r.resend <- m
+				return false
+			}
+			return r.send(pe, m)
+		case fm := <-fq:
+			if !r.send(pe, fm) {
+				ok = false
+				fq, epq = nil, nil
+			}
+		case <-epq:
+			// Still deliver this one, but take no more.
+			ok = false
+			fq, epq = nil, nil
+		case <-r.sock.CloseChannel():
+			m.Free()
+			return false
 		}
 	}
 }
 
+// noteSent records the endpoint as one that the current request has been
+// sent to, if the message is that request.  This is done before sending, as
+// the reply may arrive before SendMsg returns.
+func (r *req) noteSent(ep mangos.Endpoint, m *mangos.Message) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.raw && r.reqmsg != nil && len(m.Header) >= 4 &&
+		binary.BigEndian.Uint32(m.Header) == r.reqid {
+		r.sentto[ep.GetID()] = true
+	}
+}
+
+// sentOn returns false if the message is a reply to the current request,
+// but arrived on an endpoint the request was never sent on.
+func (r *req) sentOn(ep mangos.Endpoint, m *mangos.Message) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.raw || r.reqmsg == nil ||
+		binary.BigEndian.Uint32(m.Header) != r.reqid {
+		return true
+	}
+	return r.sentto[ep.GetID()]
+}
+
+// broadcast distributes the message to every endpoint, if in broadcast
+// mode; done is false if the message should be sent normally instead.
+// Our own copy is sent first, and each other copy then waits for its
+// peer's sender to take it, like any send, until OptionSendDeadline
+// passes.  Copies not taken by then are dropped, as DropReasonQueueFull.
+// Meanwhile it sends any messages handed to us, so that senders
+// broadcasting at once cannot deadlock.  ok is false if our own endpoint
+// has failed.
+func (r *req) broadcast(pe *reqEp, m *mangos.Message) (done, ok bool) {
+	r.Lock()
+	if !r.bcast || r.raw {
+		r.Unlock()
+		return false, true
+	}
+	var peers []*reqEp
+	var copies []*mangos.Message
+	for _, dst := range r.eps {
+		if dst == pe {
+			continue
+		}
+		dm := m.Dup()
+		select {
+		case dst.bq <- dm:
+		default:
+			peers = append(peers, dst)
+			copies = append(copies, dm)
+		}
+	}
+	clock := r.clock
+	r.Unlock()
+
+	var tq chan struct{}
+	if len(peers) != 0 {
+		v, err := r.sock.GetOption(mangos.OptionSendDeadline)
+		if d, _ := v.(time.Duration); err == nil && d > 0 {
+			tq = make(chan struct{})
+			t := clock.AfterFunc(d, func() { close(tq) })
+			defer t.Stop()
+		}
+	}
+
+	// Our copy goes first, lest it expire waiting on the others.
+	ok = r.send(pe, m)
+	fq, epq := pe.bq, pe.cq
+	if !ok {
+		fq, epq = nil, nil
+	}
+	for i, dst := range peers {
+		dm := copies[i]
+	wait:
+		for {
+			select {
+			case dst.bq <- dm:
+				break wait
+			case <-dst.cq:
+				dm.Free()
+				break wait
+			case <-tq:
+				r.sock.Dropped(dm, mangos.DropReasonQueueFull)
+				break wait
+			case fm := <-fq:
+				if !r.send(pe, fm) {
+					ok = false
+					fq, epq = nil, nil
+				}
+			case <-epq:
+				ok = false
+				fq, epq = nil, nil
+			case <-r.sock.CloseChannel():
+				for _, dm := range copies[i:] {
+					dm.Free()
+				}
+				return true, false
+			}
+		}
+	}
+	return true, ok
+}
+
 func (*req) Number() uint16 {
 	return mangos.ProtoReq
 }
@@ -178,21 +447,29 @@ func (*req) PeerName() string {
 	return "rep"
 }
 
+func (r *req) Unicast() bool {
+	r.Lock()
+	defer r.Unlock()
+	return !r.bcast || r.raw
+}
+
 func (r *req) AddEndpoint(ep mangos.Endpoint) {
 
 	r.init.Do(func() {
 		r.w.Add()
-		go r.resender()
+		r.sock.Go(r.resender)
 	})
 
 	pe := &reqEp{cq: make(chan struct{}), ep: ep}
+	pe.bq = make(chan *mangos.Message, 1)
 	r.Lock()
 	r.eps[ep.GetID()] = pe
-
+	r.updateSendError()
 	r.Unlock()
-	go r.receiver(ep)
+	r.bal.Add(ep)
+	r.sock.Go(func() { r.receiver(ep) })
 	r.w.Add()
-	go r.sender(pe)
+	r.sock.Go(func() { r.sender(pe) })
 }
 
 func (r *req) RemoveEndpoint(ep mangos.Endpoint) {
@@ -200,21 +477,40 @@ func (r *req) RemoveEndpoint(ep mangos.Endpoint) {
 	r.Lock()
 	pe := r.eps[id]
 	delete(r.eps, id)
+	r.updateSendError()
 	r.Unlock()
+	r.bal.Remove(ep)
 	if pe != nil {
 		close(pe.cq)
 	}
 }
 
+// updateSendError makes Send fail immediately if fail fast is enabled and
+// there are no peers.  The lock must be held.
+func (r *req) updateSendError() {
+	if r.fast && len(r.eps) == 0 {
+		r.sock.SetSendError(mangos.ErrNoPeers)
+	} else {
+		r.sock.SetSendError(nil)
+	}
+}
+
 func (r *req) SendHook(m *mangos.Message) bool {
 
+	r.Lock()
+	defer r.Unlock()
+
 	if r.raw {
 		// Raw mode has no automatic retry, and must include the
-		// request id in the header coming down.
+		// request id in the header coming down, unless the
+		// application supplied its own way to generate it.
+		if r.idfn != nil {
+			v := r.idfn()
+			m.Header = append(m.Header,
+				byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+		}
 		return true
 	}
-	r.Lock()
-	defer r.Unlock()
 
 	// We need to generate a new request id, and append it to the header.
 	r.reqid = r.nextID()
@@ -223,9 +519,12 @@ func (r *req) SendHook(m *mangos.Message) bool {
 		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 
 	r.reqmsg = m.Dup()
+	r.sentto = make(map[uint32]bool)
+	r.sentat = r.clock.Now()
+	r.tries = 1
 
 	// Schedule a retry, in case we don't get a reply.
-	if r.retry > 0 {
+	if r.retry > 0 && !r.bcast {
 		r.waker.Reset(r.retry)
 	} else {
 		r.waker.Stop()
@@ -252,6 +551,13 @@ func (r *req) RecvHook(m *mangos.Message) bool {
 	if binary.BigEndian.Uint32(m.Header) != r.reqid {
 		return false
 	}
+	// Resends do not reset the start time, so this includes any
+	// time spent waiting for a retry.
+	r.latency.Add(r.clock.Now().Sub(r.sentat))
+	if r.bcast {
+		// Further replies from other peers are still wanted.
+		return true
+	}
 	r.waker.Stop()
 	m = r.reqmsg
 	r.reqmsg = nil
@@ -281,11 +587,98 @@ func (r *req) SetOption(option string, value interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionBroadcast:
+		r.Lock()
+		r.bcast, ok = value.(bool)
+		r.Unlock()
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionRoundRobin:
+		rr, ok := value.(bool)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		r.bal.SetOrdered(rr)
+		return nil
+	case mangos.OptionSendSelector:
+		fn, err := mangos.SendSelectorValue(value)
+		if err != nil {
+			return err
+		}
+		r.bal.SetSelector(fn)
+		return nil
+	case mangos.OptionReqFailFast:
+		r.Lock()
+		defer r.Unlock()
+		if r.fast, ok = value.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		r.updateSendError()
+		return nil
+	case mangos.OptionRetryOtherPeer:
+		r.Lock()
+		defer r.Unlock()
+		if r.others, ok = value.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionMaxAttempts:
+		r.Lock()
+		defer r.Unlock()
+		if n, ok := value.(int); ok && n >= 0 {
+			r.maxtry = n
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionRequestID:
+		f, ok := value.(func() uint32)
+		if !ok && value != nil {
+			return mangos.ErrBadValue
+		}
+		r.Lock()
+		r.idfn = f
+		r.Unlock()
+		return nil
+	case mangos.OptionClock:
+		// The socket core records the value as well; we just need
+		// to move our retry timer over to the new clock.
+		clock, ok := value.(mangos.Clock)
+		if !ok || clock == nil {
+			return mangos.ErrBadValue
+		}
+		r.Lock()
+		r.waker.Stop()
+		r.clock = clock
+		r.waker = clock.AfterFunc(r.retry, r.wake)
+		r.waker.Stop()
+		r.Unlock()
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (r *req) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionRetryTime, Type: reflect.TypeOf(time.Duration(0))},
+		{Name: mangos.OptionBroadcast, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionReqFailFast, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionRetryOtherPeer, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionRoundRobin, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSendSelector,
+			Type: reflect.TypeOf(mangos.SendSelector(nil))},
+		{Name: mangos.OptionMaxAttempts, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionRequestID,
+			Type: reflect.TypeOf((func() uint32)(nil))},
+		{Name: mangos.OptionReplyLatency,
+			Type:     reflect.TypeOf(mangos.LatencyStats{}),
+			ReadOnly: true},
+	}
+}
+
 func (r *req) GetOption(option string) (interface{}, error) {
 	switch option {
 	case mangos.OptionRaw:
@@ -295,6 +688,40 @@ func (r *req) GetOption(option string) (interface{}, error) {
 		v := r.retry
 		r.Unlock()
 		return v, nil
+	case mangos.OptionBroadcast:
+		r.Lock()
+		v := r.bcast
+		r.Unlock()
+		return v, nil
+	case mangos.OptionRoundRobin:
+		return r.bal.Ordered(), nil
+	case mangos.OptionSendSelector:
+		return r.bal.Selector(), nil
+	case mangos.OptionReqFailFast:
+		r.Lock()
+		v := r.fast
+		r.Unlock()
+		return v, nil
+	case mangos.OptionRetryOtherPeer:
+		r.Lock()
+		v := r.others
+		r.Unlock()
+		return v, nil
+	case mangos.OptionMaxAttempts:
+		r.Lock()
+		v := r.maxtry
+		r.Unlock()
+		return v, nil
+	case mangos.OptionRequestID:
+		r.Lock()
+		v := r.idfn
+		r.Unlock()
+		return v, nil
+	case mangos.OptionReplyLatency:
+		r.Lock()
+		v := r.latency
+		r.Unlock()
+		return v, nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
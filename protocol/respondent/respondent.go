@@ -18,6 +18,7 @@ package respondent
 
 import (
 	"encoding/binary"
+	"reflect"
 	"sync"
 	"time"
 
@@ -31,25 +32,55 @@ type resp struct {
 	ttl       int
 	backbuf   []byte
 	backtrace []byte
+	copies    int           // extra copies of each response
+	interval  time.Duration // time between copies
+	rexmits   map[*rexmit]struct{}
+	clock     mangos.Clock // see OptionClock
 	w         mangos.Waiter
 	sync.Mutex
 }
 
+const defaultRetransmitInterval = time.Millisecond * 100
+
 type respPeer struct {
 	q  chan *mangos.Message
 	ep mangos.Endpoint
 	x  *resp
 }
 
+// rexmit is a copy of a response waiting to be sent again.
+type rexmit struct {
+	peer  *respPeer
+	m     *mangos.Message
+	timer mangos.ClockTimer
+}
+
 func (x *resp) Init(sock mangos.ProtocolSocket) {
 	x.sock = sock
 	x.ttl = 8
+	x.interval = defaultRetransmitInterval
 	x.peers = make(map[uint32]*respPeer)
+	x.rexmits = make(map[*rexmit]struct{})
+	x.clock = mangos.RealClock()
 	x.w.Init()
 	x.backbuf = make([]byte, 0, 64)
 	x.sock.SetSendError(mangos.ErrProtoState)
 	x.w.Add()
-	go x.sender()
+	x.sock.Go(x.sender)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (x *resp) Drain(expire time.Time) {
+	x.Lock()
+	qs := make([]chan *mangos.Message, 0, len(x.peers))
+	for _, peer := range x.peers {
+		qs = append(qs, peer.q)
+	}
+	x.Unlock()
+	for _, q := range qs {
+		mangos.DrainChannel(q, expire)
+	}
 }
 
 func (x *resp) Shutdown(expire time.Time) {
@@ -60,6 +91,7 @@ func (x *resp) Shutdown(expire time.Time) {
 		delete(x.peers, id)
 		peers[id] = peer
 	}
+	x.cancelRetransmits(nil)
 	x.Unlock()
 
 	for id, peer := range peers {
@@ -97,15 +129,23 @@ func (x *resp) sender() {
 		id := binary.BigEndian.Uint32(m.Header)
 		m.Header = m.Header[4:]
 
+		// We hold the lock while queueing, as the queue is closed
+		// when the peer is removed.
 		x.Lock()
 		peer := x.peers[id]
-		x.Unlock()
-
 		if peer == nil {
+			x.Unlock()
 			m.Free()
 			continue
 		}
 
+		for i := 1; i <= x.copies; i++ {
+			r := &rexmit{peer: peer, m: m.Dup()}
+			r.timer = x.clock.AfterFunc(x.interval*time.Duration(i),
+				func() { x.retransmit(r) })
+			x.rexmits[r] = struct{}{}
+		}
+
 		// Put it on the outbound queue
 		select {
 		case peer.q <- m:
@@ -113,6 +153,35 @@ func (x *resp) sender() {
 			// Backpressure, drop it.
 			m.Free()
 		}
+		x.Unlock()
+	}
+}
+
+// retransmit queues a copy of a response, unless it was cancelled, as
+// when the peer goes away (in which case its queue is closed).
+func (x *resp) retransmit(r *rexmit) {
+	x.Lock()
+	defer x.Unlock()
+	if _, ok := x.rexmits[r]; !ok {
+		return
+	}
+	delete(x.rexmits, r)
+	select {
+	case r.peer.q <- r.m:
+	default:
+		r.m.Free()
+	}
+}
+
+// cancelRetransmits stops the pending copies for the peer, or for every
+// peer if it is nil, and frees them.  The caller must hold the lock.
+func (x *resp) cancelRetransmits(peer *respPeer) {
+	for r := range x.rexmits {
+		if peer == nil || r.peer == peer {
+			delete(x.rexmits, r)
+			r.timer.Stop()
+			r.m.Free()
+		}
 	}
 }
 
@@ -155,6 +224,7 @@ outer:
 			hops++
 			if len(m.Body) < 4 {
 				m.Free()
+				x.sock.Garbled(ep)
 				continue outer
 			}
 			m.Header = append(m.Header, m.Body[:4]...)
@@ -214,8 +284,8 @@ func (x *resp) AddEndpoint(ep mangos.Endpoint) {
 	x.peers[ep.GetID()] = peer
 	x.Unlock()
 
-	go x.receiver(ep)
-	go peer.sender()
+	x.sock.Go(func() { x.receiver(ep) })
+	x.sock.Go(peer.sender)
 }
 
 func (x *resp) RemoveEndpoint(ep mangos.Endpoint) {
@@ -224,11 +294,11 @@ func (x *resp) RemoveEndpoint(ep mangos.Endpoint) {
 	x.Lock()
 	peer := x.peers[id]
 	delete(x.peers, id)
-	x.Unlock()
-
 	if peer != nil {
+		x.cancelRetransmits(peer)
 		close(peer.q)
 	}
+	x.Unlock()
 }
 
 func (*resp) Number() uint16 {
@@ -247,6 +317,10 @@ func (*resp) PeerName() string {
 	return "surveyor"
 }
 
+func (*resp) Unicast() bool {
+	return true
+}
+
 func (x *resp) SetOption(name string, v interface{}) error {
 	var ok bool
 	switch name {
@@ -269,17 +343,60 @@ func (x *resp) SetOption(name string, v interface{}) error {
 			x.ttl = ttl
 		}
 		return nil
+	case mangos.OptionRetransmitCount:
+		if n, ok := v.(int); ok && n >= 0 {
+			x.Lock()
+			x.copies = n
+			x.Unlock()
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionRetransmitInterval:
+		if d, ok := v.(time.Duration); ok && d > 0 {
+			x.Lock()
+			x.interval = d
+			x.Unlock()
+			return nil
+		}
+		return mangos.ErrBadValue
+	case mangos.OptionClock:
+		clock, ok := v.(mangos.Clock)
+		if !ok || clock == nil {
+			return mangos.ErrBadValue
+		}
+		x.Lock()
+		x.clock = clock
+		x.Unlock()
+		return nil
 	default:
 		return mangos.ErrBadOption
 	}
 }
 
+func (x *resp) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionTTL, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionRetransmitCount, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionRetransmitInterval,
+			Type: reflect.TypeOf(time.Duration(0))},
+	}
+}
+
 func (x *resp) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return x.raw, nil
 	case mangos.OptionTTL:
 		return x.ttl, nil
+	case mangos.OptionRetransmitCount:
+		x.Lock()
+		defer x.Unlock()
+		return x.copies, nil
+	case mangos.OptionRetransmitInterval:
+		x.Lock()
+		defer x.Unlock()
+		return x.interval, nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
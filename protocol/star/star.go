@@ -23,6 +23,7 @@
 package star
 
 import (
+	"reflect"
 	"sync"
 	"time"
 
@@ -51,7 +52,21 @@ func (x *star) Init(sock mangos.ProtocolSocket) {
 	x.ttl = 8
 	x.w.Init()
 	x.w.Add()
-	go x.sender()
+	x.sock.Go(x.sender)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (x *star) Drain(expire time.Time) {
+	x.Lock()
+	qs := make([]chan *mangos.Message, 0, len(x.eps))
+	for _, peer := range x.eps {
+		qs = append(qs, peer.q)
+	}
+	x.Unlock()
+	for _, q := range qs {
+		mangos.DrainChannel(q, expire)
+	}
 }
 
 func (x *star) Shutdown(expire time.Time) {
@@ -87,6 +102,7 @@ func (pe *starEp) sender() {
 
 func (x *star) broadcast(m *mangos.Message, sender *starEp) {
 
+	var full []*mangos.Message
 	x.Lock()
 	if sender == nil || !x.raw {
 		for _, pe := range x.eps {
@@ -98,13 +114,14 @@ func (x *star) broadcast(m *mangos.Message, sender *starEp) {
 			case pe.q <- m:
 			default:
 				// No room on outbound queue, drop it.
-				if m != nil {
-					m.Free()
-				}
+				full = append(full, m)
 			}
 		}
 	}
 	x.Unlock()
+	for _, m := range full {
+		x.sock.Dropped(m, mangos.DropReasonQueueFull)
+	}
 
 	// Grab a local copy and send it up if we aren't originator
 	if sender != nil {
@@ -115,7 +132,7 @@ func (x *star) broadcast(m *mangos.Message, sender *starEp) {
 			return
 		default:
 			// No room, so we just drop it.
-			m.Free()
+			x.sock.Dropped(m, mangos.DropReasonQueueFull)
 		}
 	} else {
 		// Not sending it up, so we need to release it.
@@ -151,11 +168,13 @@ func (pe *starEp) receiver() {
 
 		if len(m.Body) < 4 {
 			m.Free()
+			pe.x.sock.Garbled(pe.ep)
 			continue
 		}
 		if m.Body[0] != 0 || m.Body[1] != 0 || m.Body[2] != 0 {
 			// non-zero reserved fields are illegal
 			m.Free()
+			pe.x.sock.Garbled(pe.ep)
 			continue
 		}
 		if int(m.Body[3]) >= pe.x.ttl { // TTL expired?
@@ -174,15 +193,15 @@ func (pe *starEp) receiver() {
 
 func (x *star) AddEndpoint(ep mangos.Endpoint) {
 	depth := 16
-	if i, err := x.sock.GetOption(mangos.OptionWriteQLen); err == nil {
+	if i, err := x.sock.GetOption(mangos.OptionWriteQLenPerPipe); err == nil {
 		depth = i.(int)
 	}
 	pe := &starEp{ep: ep, x: x, q: make(chan *mangos.Message, depth)}
 	x.Lock()
 	x.eps[ep.GetID()] = pe
 	x.Unlock()
-	go pe.sender()
-	go pe.receiver()
+	x.sock.Go(pe.sender)
+	x.sock.Go(pe.receiver)
 }
 
 func (x *star) RemoveEndpoint(ep mangos.Endpoint) {
@@ -232,6 +251,13 @@ func (x *star) SetOption(name string, v interface{}) error {
 	}
 }
 
+func (x *star) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionTTL, Type: reflect.TypeOf(0)},
+	}
+}
+
 func (x *star) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
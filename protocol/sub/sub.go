@@ -24,6 +24,8 @@ package sub
 
 import (
 	"bytes"
+	"crypto/rand"
+	"reflect"
 	"sync"
 	"time"
 
@@ -31,27 +33,164 @@ import (
 )
 
 type sub struct {
-	sock mangos.ProtocolSocket
-	subs [][]byte
-	raw  bool
+	sock  mangos.ProtocolSocket
+	subs  [][]byte
+	all   bool
+	raw   bool
+	delim []byte
+	tlen  int
+	ident []byte
+	onact func(string, bool)   // see OptionSubActivityHook
+	idle  time.Duration        // see OptionSubIdleTime
+	acts  map[string]*subStats // activity of each subscription, by topic
+	match func([]byte) bool
+	eps   map[uint32]*subEp
+	probe []byte        // last synchronization probe sent, if any
+	syncq chan struct{} // closed when the probe is echoed back
+	fwd   bool          // true if OptionSubForward is set
+	pool  *matchPool    // see OptionSubMatchWorkers
+	clock mangos.Clock  // see OptionClock
 	sync.Mutex
 }
 
+// matchJob is a message awaiting the OptionSubMatch function on a worker.
+// The receiver queues jobs for each pipe in order, and they are delivered
+// in that order as their results come in.
+type matchJob struct {
+	m      *mangos.Message
+	match  func([]byte) bool
+	prefix int
+	raw    bool
+	delim  []byte
+	tlen   int
+	ok     chan bool // the result of the match
+}
+
+// matchPool is the set of goroutines running OptionSubMatch functions.
+type matchPool struct {
+	n     int
+	jobq  chan *matchJob
+	quitq chan struct{}
+}
+
+// matchQueue is how many matches each pipe may have outstanding.
+const matchQueue = 64
+
+// subStats tracks whether a subscription is matching traffic, for
+// OptionSubActivityHook.
+type subStats struct {
+	active bool              // matched within the idle time
+	last   time.Time         // when it last matched
+	timer  mangos.ClockTimer // checks for the idle time passing
+}
+
+type subEp struct {
+	ep     mangos.Endpoint
+	kickq  chan struct{} // signaled when subscriptions must be reported
+	closeq chan struct{} // closed when the endpoint is removed
+}
+
 func (s *sub) Init(sock mangos.ProtocolSocket) {
 	s.sock = sock
 	s.subs = [][]byte{}
+	s.eps = make(map[uint32]*subEp)
+	s.acts = make(map[string]*subStats)
+	s.clock = mangos.RealClock()
 	s.sock.SetSendError(mangos.ErrProtoOp)
 }
 
-func (*sub) Shutdown(time.Time) {} // No sender to drain.
+// Shutdown stops the match workers and idle timers, if any.  There is no
+// sender to drain.
+func (s *sub) Shutdown(time.Time) {
+	s.Lock()
+	if s.pool != nil {
+		close(s.pool.quitq)
+		s.pool = nil
+	}
+	for _, st := range s.acts {
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+		st.active = false
+	}
+	s.Unlock()
+}
+
+func newMatchPool(sock mangos.ProtocolSocket, n int) *matchPool {
+	p := &matchPool{
+		n:     n,
+		jobq:  make(chan *matchJob),
+		quitq: make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		sock.Go(p.worker)
+	}
+	return p
+}
+
+func (p *matchPool) worker() {
+	for {
+		select {
+		case j := <-p.jobq:
+			j.ok <- j.match(j.m.Body)
+		case <-p.quitq:
+			return
+		}
+	}
+}
+
+// run hands the job to a worker, or runs it here if the pool is being
+// replaced or shut down.
+func (p *matchPool) run(j *matchJob) {
+	select {
+	case p.jobq <- j:
+	case <-p.quitq:
+		j.ok <- j.match(j.m.Body)
+	}
+}
+
+// deliver passes matched messages from one pipe up to the socket, in the
+// order they were received, waiting for each match to finish.
+func deliver(sock mangos.ProtocolSocket, order <-chan *matchJob,
+	rq chan<- *mangos.Message, cq <-chan struct{}) {
+
+	for j := range order {
+		m := j.m
+		matched := <-j.ok
+		if matched && !j.raw {
+			matched = splitTopic(m, j.prefix, j.delim, j.tlen)
+		}
+		if !matched {
+			m.Free()
+			continue
+		}
+		select {
+		case rq <- m:
+		case <-cq:
+			m.Free()
+		default: // no room, drop it
+			sock.Dropped(m, mangos.DropReasonQueueFull)
+		}
+	}
+}
 
 func (s *sub) receiver(ep mangos.Endpoint) {
 
 	rq := s.sock.RecvChannel()
 	cq := s.sock.CloseChannel()
 
+	// Once matches for this pipe have been given to the workers, every
+	// later message must queue behind them, to keep them in order.
+	var order chan *matchJob
+	defer func() {
+		if order != nil {
+			close(order)
+		}
+	}()
+
 	for {
 		var matched = false
+		var prefix []byte
 
 		m := ep.RecvMsg()
 		if m == nil {
@@ -59,15 +198,94 @@ func (s *sub) receiver(ep mangos.Endpoint) {
 		}
 
 		s.Lock()
-		for _, sub := range s.subs {
-			if bytes.HasPrefix(m.Body, sub) {
-				// Matched, send it up.  Best effort.
-				matched = true
-				break
+		if s.probe != nil && mangos.IsSyncProbe(m) &&
+			bytes.Equal(m.Body, s.probe) {
+			// Our probe came back, so the publisher is attached,
+			// and everything it sends from now on will reach us.
+			// Later echoes, from other publishers, are dropped too.
+			if s.syncing() {
+				close(s.syncq)
+			}
+			s.Unlock()
+			m.Free()
+			continue
+		}
+		if _, _, ok := mangos.ParseSubscriptionReport(m); ok && s.fwd {
+			// One of our own reports, echoed by a publisher that
+			// does not understand them.  Without forwarding we
+			// send none, so this is just a message.
+			s.Unlock()
+			m.Free()
+			continue
+		}
+		if s.ident != nil && !s.raw && !m.StripIdentity() {
+			// Publisher is not sending identities, discard.
+			s.Unlock()
+			m.Free()
+			continue
+		}
+		if s.all {
+			// Everything matches, no need to scan.
+			matched = true
+		} else {
+			for _, sub := range s.subs {
+				if bytes.HasPrefix(m.Body, sub) {
+					// Matched, send it up.  Best effort.
+					matched = true
+					prefix = sub
+					break
+				}
 			}
 		}
+		started := prefix != nil && s.onact != nil && s.noteMatch(prefix)
+		onact := s.onact
+		raw, delim, tlen, match := s.raw, s.delim, s.tlen, s.match
+		pool := s.pool
 		s.Unlock()
 
+		if started {
+			onact(string(prefix), true)
+		}
+
+		if order == nil && matched && match != nil && pool != nil {
+			order = make(chan *matchJob, matchQueue)
+			s.sock.Go(func() { deliver(s.sock, order, rq, cq) })
+		}
+		if order != nil {
+			j := &matchJob{
+				m:      m,
+				match:  match,
+				prefix: len(prefix),
+				raw:    raw,
+				delim:  delim,
+				tlen:   tlen,
+				ok:     make(chan bool, 1),
+			}
+			switch {
+			case !matched || match == nil:
+				j.ok <- matched
+			case pool == nil:
+				j.ok <- match(m.Body)
+			default:
+				pool.run(j)
+			}
+			select {
+			case order <- j:
+			case <-cq:
+				<-j.ok
+				m.Free()
+				return
+			}
+			continue
+		}
+
+		if matched && match != nil {
+			matched = match(m.Body)
+		}
+		if matched && !raw {
+			matched = splitTopic(m, len(prefix), delim, tlen)
+		}
+
 		if !matched {
 			m.Free()
 			continue
@@ -79,11 +297,77 @@ func (s *sub) receiver(ep mangos.Endpoint) {
 			m.Free()
 			return
 		default: // no room, drop it
-			m.Free()
+			s.sock.Dropped(m, mangos.DropReasonQueueFull)
 		}
 	}
 }
 
+// noteMatch records that the subscription matched a message, and returns
+// true if it was not already active.  The lock must be held.
+func (s *sub) noteMatch(topic []byte) bool {
+	key := string(topic)
+	st := s.acts[key]
+	if st == nil {
+		st = &subStats{}
+		s.acts[key] = st
+	}
+	st.last = s.clock.Now()
+	if st.active {
+		return false
+	}
+	st.active = true
+	if s.idle > 0 {
+		st.timer = s.clock.AfterFunc(s.idle, func() { s.checkIdle(key, st) })
+	}
+	return true
+}
+
+// checkIdle runs when a subscription may have gone quiet, and reports it
+// if it has not matched since, or checks again later if it has.
+func (s *sub) checkIdle(key string, st *subStats) {
+	s.Lock()
+	if s.acts[key] != st || !st.active {
+		// Unsubscribed in the meantime.
+		s.Unlock()
+		return
+	}
+	if left := st.last.Add(s.idle).Sub(s.clock.Now()); left > 0 {
+		st.timer.Reset(left)
+		s.Unlock()
+		return
+	}
+	st.active = false
+	onact := s.onact
+	s.Unlock()
+	if onact != nil {
+		onact(key, false)
+	}
+}
+
+// splitTopic separates the topic from the payload, according to the
+// configured delimiter or fixed topic length.  The prefix length is that
+// of the subscription which matched, and is used as the topic if the
+// delimiter is absent.  It returns false if the message should be dropped.
+func splitTopic(m *mangos.Message, prefix int, delim []byte, tlen int) bool {
+	switch {
+	case len(delim) > 0:
+		if i := bytes.Index(m.Body, delim); i >= 0 {
+			m.SetTopic(m.Body[:i])
+			m.Body = m.Body[i+len(delim):]
+		} else {
+			m.SetTopic(m.Body[:prefix])
+			m.Body = m.Body[prefix:]
+		}
+	case tlen > 0:
+		if len(m.Body) < tlen {
+			return false
+		}
+		m.SetTopic(m.Body[:tlen])
+		m.Body = m.Body[tlen:]
+	}
+	return true
+}
+
 func (*sub) Number() uint16 {
 	return mangos.ProtoSub
 }
@@ -101,12 +385,162 @@ func (*sub) PeerName() string {
 }
 
 func (s *sub) AddEndpoint(ep mangos.Endpoint) {
-	go s.receiver(ep)
+	se := &subEp{
+		ep:     ep,
+		kickq:  make(chan struct{}, 1),
+		closeq: make(chan struct{}),
+	}
+	s.Lock()
+	s.eps[ep.GetID()] = se
+	if s.syncing() {
+		probe := s.probe
+		s.sock.Go(func() { sendProbe(ep, probe) })
+	}
+	if s.fwd {
+		se.kick()
+	}
+	s.Unlock()
+	s.sock.Go(func() { s.receiver(ep) })
+	s.sock.Go(func() { s.reporter(se) })
 }
 
-func (*sub) RemoveEndpoint(mangos.Endpoint) {}
+func (s *sub) RemoveEndpoint(ep mangos.Endpoint) {
+	s.Lock()
+	if se := s.eps[ep.GetID()]; se != nil {
+		close(se.closeq)
+		delete(s.eps, ep.GetID())
+	}
+	s.Unlock()
+}
+
+// kick arranges for the current subscriptions to be reported.  Changes
+// made in quick succession are coalesced into a single report.
+func (se *subEp) kick() {
+	select {
+	case se.kickq <- struct{}{}:
+	default:
+	}
+}
+
+// kickAll reports subscriptions to every publisher.  It is called with the
+// lock held, after a change that publishers should know about.
+func (s *sub) kickAll() {
+	for _, se := range s.eps {
+		se.kick()
+	}
+}
+
+// reporter sends subscription reports (see OptionSubForward) to one
+// publisher.  Each report carries the whole subscription set, so a
+// publisher only ever needs the most recent one.
+func (s *sub) reporter(se *subEp) {
+	for {
+		select {
+		case <-se.kickq:
+		case <-se.closeq:
+			return
+		}
+		s.Lock()
+		var m *mangos.Message
+		if s.fwd {
+			topics := s.subs
+			if s.all {
+				topics = append([][]byte{{}}, topics...)
+			}
+			m = mangos.NewSubscriptionReport(topics, true)
+		} else {
+			m = mangos.NewSubscriptionReport(nil, false)
+		}
+		s.Unlock()
+		if se.ep.SendMsg(m) != nil {
+			m.Free()
+			return
+		}
+	}
+}
+
+// sendProbe sends a synchronization probe to the publisher, which will
+// echo it back to us.  Failures are ignored; the pipe is being closed.
+func sendProbe(ep mangos.Endpoint, probe []byte) {
+	m := mangos.NewMessage(len(probe))
+	m.Body = append(m.Body, probe...)
+	if ep.SendMsg(m) != nil {
+		m.Free()
+	}
+}
+
+// syncing returns true while a synchronization probe is awaiting its echo.
+// The lock must be held.
+func (s *sub) syncing() bool {
+	if s.syncq == nil {
+		return false
+	}
+	select {
+	case <-s.syncq:
+		return false
+	default:
+		return true
+	}
+}
+
+// sync waits for at least one publisher to echo our probe back.  The
+// semantics of the duration match those of OptionRecvDeadline.
+func (s *sub) sync(d time.Duration) error {
+	s.Lock()
+	if !s.syncing() {
+		token := make([]byte, 8)
+		if _, err := rand.Read(token); err != nil {
+			s.Unlock()
+			return err
+		}
+		m := mangos.NewSyncProbe(token)
+		s.probe = append([]byte(nil), m.Body...)
+		m.Free()
+		s.syncq = make(chan struct{})
+		probe := s.probe
+		for _, se := range s.eps {
+			ep := se.ep
+			s.sock.Go(func() { sendProbe(ep, probe) })
+		}
+	}
+	q := s.syncq
+	clock := s.clock
+	s.Unlock()
+
+	if d < 0 {
+		select {
+		case <-q:
+			return nil
+		default:
+			return mangos.ErrRecvTimeout
+		}
+	}
+	var tq chan struct{}
+	if d > 0 {
+		tq = make(chan struct{})
+		t := clock.AfterFunc(d, func() { close(tq) })
+		defer t.Stop()
+	}
+	select {
+	case <-q:
+		return nil
+	case <-tq:
+		return mangos.ErrRecvTimeout
+	case <-s.sock.CloseChannel():
+		return mangos.ErrClosed
+	}
+}
 
 func (s *sub) SetOption(name string, value interface{}) error {
+	if name == mangos.OptionSync {
+		// Handled apart from the others, as it blocks.
+		d, ok := value.(time.Duration)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		return s.sync(d)
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -121,6 +555,83 @@ func (s *sub) SetOption(name string, value interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionSubscribeAll:
+		if s.all, ok = value.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		if s.fwd {
+			s.kickAll()
+		}
+		return nil
+	case mangos.OptionSubForward:
+		fwd, ok := value.(bool)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		if fwd != s.fwd {
+			s.fwd = fwd
+			s.kickAll()
+		}
+		return nil
+	case mangos.OptionClock:
+		clock, ok := value.(mangos.Clock)
+		if !ok || clock == nil {
+			return mangos.ErrBadValue
+		}
+		s.clock = clock
+		return nil
+	case mangos.OptionTopicLength:
+		if tlen, ok := value.(int); !ok || tlen < 0 {
+			return mangos.ErrBadValue
+		} else {
+			s.tlen = tlen
+		}
+		return nil
+	case mangos.OptionIdentity:
+		id, err := mangos.IdentityValue(value)
+		if err != nil {
+			return err
+		}
+		s.ident = id
+		return nil
+	case mangos.OptionSubMatch:
+		fn, ok := value.(func([]byte) bool)
+		if !ok && value != nil {
+			return mangos.ErrBadValue
+		}
+		s.match = fn
+		return nil
+	case mangos.OptionSubMatchWorkers:
+		n, ok := value.(int)
+		if !ok || n < 0 {
+			return mangos.ErrBadValue
+		}
+		if s.pool != nil {
+			if s.pool.n == n {
+				return nil
+			}
+			close(s.pool.quitq)
+			s.pool = nil
+		}
+		if n > 0 {
+			s.pool = newMatchPool(s.sock, n)
+		}
+		return nil
+	case mangos.OptionSubActivityHook:
+		fn, ok := value.(func(string, bool))
+		if !ok && value != nil {
+			return mangos.ErrBadValue
+		}
+		s.onact = fn
+		return nil
+	case mangos.OptionSubIdleTime:
+		d, ok := value.(time.Duration)
+		if !ok || d < 0 {
+			return mangos.ErrBadValue
+		}
+		s.idle = d
+		return nil
+	case mangos.OptionTopicDelimiter:
 	case mangos.OptionSubscribe:
 	case mangos.OptionUnsubscribe:
 	default:
@@ -136,6 +647,10 @@ func (s *sub) SetOption(name string, value interface{}) error {
 		return mangos.ErrBadValue
 	}
 	switch name {
+	case mangos.OptionTopicDelimiter:
+		s.delim = vb
+		return nil
+
 	case mangos.OptionSubscribe:
 		for _, sub := range s.subs {
 			if bytes.Equal(sub, vb) {
@@ -144,6 +659,9 @@ func (s *sub) SetOption(name string, value interface{}) error {
 			}
 		}
 		s.subs = append(s.subs, vb)
+		if s.fwd {
+			s.kickAll()
+		}
 		return nil
 
 	case mangos.OptionUnsubscribe:
@@ -151,6 +669,15 @@ func (s *sub) SetOption(name string, value interface{}) error {
 			if bytes.Equal(sub, vb) {
 				s.subs[i] = s.subs[len(s.subs)-1]
 				s.subs = s.subs[:len(s.subs)-1]
+				if st := s.acts[string(vb)]; st != nil {
+					if st.timer != nil {
+						st.timer.Stop()
+					}
+					delete(s.acts, string(vb))
+				}
+				if s.fwd {
+					s.kickAll()
+				}
 				return nil
 			}
 		}
@@ -162,10 +689,72 @@ func (s *sub) SetOption(name string, value interface{}) error {
 	}
 }
 
+func (s *sub) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSubscribe,
+			Type:      reflect.TypeOf([]byte(nil)),
+			WriteOnly: true},
+		{Name: mangos.OptionUnsubscribe,
+			Type:      reflect.TypeOf([]byte(nil)),
+			WriteOnly: true},
+		{Name: mangos.OptionSubscribeAll, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSubForward, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionTopicDelimiter, Type: reflect.TypeOf([]byte(nil))},
+		{Name: mangos.OptionTopicLength, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionIdentity, Type: reflect.TypeOf([]byte(nil))},
+		{Name: mangos.OptionSubMatch,
+			Type: reflect.TypeOf((func([]byte) bool)(nil))},
+		{Name: mangos.OptionSubMatchWorkers, Type: reflect.TypeOf(0)},
+		{Name: mangos.OptionSubActivityHook,
+			Type:      reflect.TypeOf((func(string, bool))(nil)),
+			WriteOnly: true},
+		{Name: mangos.OptionSubIdleTime, Type: reflect.TypeOf(time.Duration(0))},
+		{Name: mangos.OptionSync,
+			Type:      reflect.TypeOf(time.Duration(0)),
+			WriteOnly: true},
+	}
+}
+
 func (s *sub) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
 		return s.raw, nil
+	case mangos.OptionSubscribeAll:
+		s.Lock()
+		defer s.Unlock()
+		return s.all, nil
+	case mangos.OptionSubForward:
+		s.Lock()
+		defer s.Unlock()
+		return s.fwd, nil
+	case mangos.OptionTopicDelimiter:
+		s.Lock()
+		defer s.Unlock()
+		return s.delim, nil
+	case mangos.OptionTopicLength:
+		s.Lock()
+		defer s.Unlock()
+		return s.tlen, nil
+	case mangos.OptionIdentity:
+		s.Lock()
+		defer s.Unlock()
+		return s.ident, nil
+	case mangos.OptionSubIdleTime:
+		s.Lock()
+		defer s.Unlock()
+		return s.idle, nil
+	case mangos.OptionSubMatch:
+		s.Lock()
+		defer s.Unlock()
+		return s.match, nil
+	case mangos.OptionSubMatchWorkers:
+		s.Lock()
+		defer s.Unlock()
+		if s.pool == nil {
+			return 0, nil
+		}
+		return s.pool.n, nil
 	default:
 		return nil, mangos.ErrBadOption
 	}
@@ -18,6 +18,7 @@ package surveyor
 
 import (
 	"encoding/binary"
+	"reflect"
 	"sync"
 	"time"
 
@@ -34,10 +35,14 @@ type surveyor struct {
 	surveyID uint32
 	duration time.Duration
 	timeout  time.Time
-	timer    *time.Timer
+	clock    mangos.Clock
+	timer    mangos.ClockTimer
 	w        mangos.Waiter
 	init     sync.Once
 	ttl      int
+	dedup    bool
+	answered map[uint32]bool // endpoints already responded, with dedup
+	trace    bool            // see OptionSurveyBacktrace
 
 	sync.Mutex
 }
@@ -52,12 +57,44 @@ func (x *surveyor) Init(sock mangos.ProtocolSocket) {
 	x.sock = sock
 	x.peers = make(map[uint32]*surveyorP)
 	x.sock.SetRecvError(mangos.ErrProtoState)
-	x.timer = time.AfterFunc(x.duration,
-		func() { x.sock.SetRecvError(mangos.ErrProtoState) })
+	x.clock = mangos.RealClock()
+	x.timer = x.clock.AfterFunc(x.duration, x.expire)
 	x.timer.Stop()
 	x.w.Init()
 	x.w.Add()
-	go x.sender()
+	x.sock.Go(x.sender)
+}
+
+// expire is called by the survey timer when the survey concludes.
+func (x *surveyor) expire() {
+	x.sock.SetRecvError(mangos.ErrProtoState)
+}
+
+// cancel discards the current survey, if any.  Survey IDs always have the
+// high bit set, so clearing the ID ensures no response can match.
+func (x *surveyor) cancel() {
+	x.Lock()
+	defer x.Unlock()
+	if x.surveyID == 0 {
+		return
+	}
+	x.surveyID = 0
+	x.timer.Stop()
+	x.sock.SetRecvError(mangos.ErrCanceled)
+}
+
+// Drain waits for the messages queued for each peer to be taken for
+// writing, for Socket.Flush.
+func (x *surveyor) Drain(expire time.Time) {
+	x.Lock()
+	qs := make([]chan *mangos.Message, 0, len(x.peers))
+	for _, peer := range x.peers {
+		qs = append(qs, peer.q)
+	}
+	x.Unlock()
+	for _, q := range qs {
+		mangos.DrainChannel(q, expire)
+	}
 }
 
 func (x *surveyor) Shutdown(expire time.Time) {
@@ -130,14 +167,31 @@ func (peer *surveyorP) receiver() {
 		}
 		if len(m.Body) < 4 {
 			m.Free()
+			peer.x.sock.Garbled(peer.ep)
 			continue
 		}
 
+		peer.x.Lock()
+		trace := peer.x.raw && peer.x.trace
+		peer.x.Unlock()
+		if trace {
+			// Say who responded, as REP and RESPONDENT do, ahead
+			// of the survey ID.
+			v := peer.ep.GetID()
+			m.Header = append(m.Header,
+				byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+		}
+
 		// Get survery ID -- this will be passed in the header up
 		// to the application.  It should include that in the response.
 		m.Header = append(m.Header, m.Body[:4]...)
 		m.Body = m.Body[4:]
 
+		if !peer.x.firstResponse(peer.ep, m) {
+			m.Free()
+			continue
+		}
+
 		select {
 		case rq <- m:
 		case <-cq:
@@ -146,12 +200,27 @@ func (peer *surveyorP) receiver() {
 	}
 }
 
+// firstResponse returns false if dedup is enabled, and the endpoint has
+// already responded to the current survey.
+func (x *surveyor) firstResponse(ep mangos.Endpoint, m *mangos.Message) bool {
+	x.Lock()
+	defer x.Unlock()
+	if !x.dedup || x.raw || binary.BigEndian.Uint32(m.Header) != x.surveyID {
+		return true
+	}
+	if x.answered[ep.GetID()] {
+		return false
+	}
+	x.answered[ep.GetID()] = true
+	return true
+}
+
 func (x *surveyor) AddEndpoint(ep mangos.Endpoint) {
 	peer := &surveyorP{ep: ep, x: x, q: make(chan *mangos.Message, 1)}
 	x.Lock()
 	x.peers[ep.GetID()] = peer
-	go peer.receiver()
-	go peer.sender()
+	x.sock.Go(peer.receiver)
+	x.sock.Go(peer.sender)
 	x.Unlock()
 }
 
@@ -193,6 +262,7 @@ func (x *surveyor) SendHook(m *mangos.Message) bool {
 	x.Lock()
 	x.surveyID = x.nextID | 0x80000000
 	x.nextID++
+	x.answered = make(map[uint32]bool)
 	x.sock.SetRecvError(nil)
 	v := x.surveyID
 	m.Header = append(m.Header,
@@ -246,6 +316,41 @@ func (x *surveyor) SetOption(name string, val interface{}) error {
 			return mangos.ErrBadValue
 		}
 		return nil
+	case mangos.OptionSurveyCancel:
+		if v, ok := val.(bool); !ok || !v {
+			return mangos.ErrBadValue
+		}
+		if x.raw {
+			return mangos.ErrProtoState
+		}
+		x.cancel()
+		return nil
+	case mangos.OptionSurveyDedup:
+		x.Lock()
+		defer x.Unlock()
+		if x.dedup, ok = val.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionSurveyBacktrace:
+		x.Lock()
+		defer x.Unlock()
+		if x.trace, ok = val.(bool); !ok {
+			return mangos.ErrBadValue
+		}
+		return nil
+	case mangos.OptionClock:
+		clock, ok := val.(mangos.Clock)
+		if !ok || clock == nil {
+			return mangos.ErrBadValue
+		}
+		x.Lock()
+		x.timer.Stop()
+		x.clock = clock
+		x.timer = clock.AfterFunc(x.duration, x.expire)
+		x.timer.Stop()
+		x.Unlock()
+		return nil
 	case mangos.OptionTTL:
 		// We don't do anything with this, but support it for
 		// symmetry with the respondent socket.
@@ -262,6 +367,19 @@ func (x *surveyor) SetOption(name string, val interface{}) error {
 	}
 }
 
+func (x *surveyor) Options() []mangos.OptionInfo {
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRaw, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSurveyTime, Type: reflect.TypeOf(time.Duration(0))},
+		{Name: mangos.OptionSurveyCancel,
+			Type:      reflect.TypeOf(false),
+			WriteOnly: true},
+		{Name: mangos.OptionSurveyDedup, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionSurveyBacktrace, Type: reflect.TypeOf(false)},
+		{Name: mangos.OptionTTL, Type: reflect.TypeOf(0)},
+	}
+}
+
 func (x *surveyor) GetOption(name string) (interface{}, error) {
 	switch name {
 	case mangos.OptionRaw:
@@ -271,6 +389,14 @@ func (x *surveyor) GetOption(name string) (interface{}, error) {
 		d := x.duration
 		x.Unlock()
 		return d, nil
+	case mangos.OptionSurveyDedup:
+		x.Lock()
+		defer x.Unlock()
+		return x.dedup, nil
+	case mangos.OptionSurveyBacktrace:
+		x.Lock()
+		defer x.Unlock()
+		return x.trace, nil
 	case mangos.OptionTTL:
 		return x.ttl, nil
 	default:
@@ -0,0 +1,135 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"sync"
+)
+
+// ProtocolInfo describes a protocol that is not part of mangos, so that
+// it can be registered with RegisterProtocol.  This is intended for
+// experimenting with new members of the SP family without modifying the
+// package.
+type ProtocolInfo struct {
+	// Number is the protocol number, which is exchanged in the SP
+	// greeting.  It must be distinct from those of the standard
+	// protocols, and from any other registered protocol.
+	Number uint16
+
+	// Name is the protocol name, used by ProtocolName, NewProtocolSocket,
+	// and the WebSocket subprotocol negotiation.
+	Name string
+
+	// Peers lists the numbers of protocols that may be connected to
+	// this one, in addition to the PeerNumber of the Protocol itself.
+	Peers []uint16
+
+	// Cooked returns a new Protocol instance in its normal mode.
+	Cooked func() Protocol
+
+	// Raw returns a new Protocol instance in raw mode.  It may be nil
+	// if the protocol has no raw mode.
+	Raw func() Protocol
+}
+
+var protocols = struct {
+	byNumber map[uint16]ProtocolInfo
+	sync.Mutex
+}{byNumber: make(map[uint16]ProtocolInfo)}
+
+// RegisterProtocol makes a custom protocol known to mangos.  Once it is
+// registered, sockets using it accept connections from any of its Peers
+// (the SP greeting of a standard protocol only admits its one peer), its
+// name is reported by ProtocolName, and sockets may be created with
+// NewProtocolSocket.  It returns ErrProtoInUse if the number or name is
+// already taken, and ErrBadProto if the name or Cooked factory is
+// missing.
+func RegisterProtocol(info ProtocolInfo) error {
+	if info.Name == "" || info.Cooked == nil {
+		return ErrBadProto
+	}
+	if _, ok := stdProtocols[info.Number]; ok {
+		return ErrProtoInUse
+	}
+	protocols.Lock()
+	defer protocols.Unlock()
+	if _, ok := protocols.byNumber[info.Number]; ok {
+		return ErrProtoInUse
+	}
+	for _, name := range stdProtocols {
+		if name == info.Name {
+			return ErrProtoInUse
+		}
+	}
+	for _, pi := range protocols.byNumber {
+		if pi.Name == info.Name {
+			return ErrProtoInUse
+		}
+	}
+	info.Peers = append([]uint16(nil), info.Peers...)
+	protocols.byNumber[info.Number] = info
+	return nil
+}
+
+// LookupProtocol returns the registration of the protocol with the given
+// number, if there is one.  The standard protocols are not registered.
+func LookupProtocol(number uint16) (ProtocolInfo, bool) {
+	protocols.Lock()
+	defer protocols.Unlock()
+	info, ok := protocols.byNumber[number]
+	return info, ok
+}
+
+// NewProtocolSocket creates a Socket using the registered protocol with
+// the given name, in raw mode if raw is true.
+func NewProtocolSocket(name string, raw bool) (Socket, error) {
+	var info ProtocolInfo
+	found := false
+	protocols.Lock()
+	for _, pi := range protocols.byNumber {
+		if pi.Name == name {
+			info, found = pi, true
+			break
+		}
+	}
+	protocols.Unlock()
+	switch {
+	case !found:
+		return nil, ErrBadProto
+	case raw && info.Raw == nil:
+		return nil, ErrProtoOp
+	case raw:
+		return MakeSocket(info.Raw()), nil
+	}
+	return MakeSocket(info.Cooked()), nil
+}
+
+// ValidPeer returns true if the protocol may be connected to a peer that
+// identifies itself with the remote protocol number.  That is its
+// PeerNumber, or for a registered protocol any of its Peers.  Transports
+// use this to check the SP greeting.
+func ValidPeer(p Protocol, remote uint16) bool {
+	if remote == p.PeerNumber() {
+		return true
+	}
+	if info, ok := LookupProtocol(p.Number()); ok {
+		for _, n := range info.Peers {
+			if n == remote {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"sync"
+)
+
+// Resolver maps an address with a custom scheme (for example a service
+// discovery name such as "disco://orders") to one or more concrete
+// transport addresses.  Resolvers are registered on a socket with
+// AddResolver, and are consulted on every connection attempt, so that
+// changes in the set of addresses are picked up on reconnect.
+type Resolver interface {
+	// Resolve returns the concrete addresses for the given address,
+	// in order of preference.  Each must use a scheme for which a
+	// Transport has been added to the socket.
+	Resolve(addr string) ([]string, error)
+}
+
+// ResolverFunc is an adapter to allow the use of an ordinary function
+// as a Resolver.
+type ResolverFunc func(addr string) ([]string, error)
+
+// Resolve implements the Resolver interface.
+func (f ResolverFunc) Resolve(addr string) ([]string, error) {
+	return f(addr)
+}
+
+// resolvedDialer is a PipeDialer that resolves its address each time
+// it dials, and then dials the concrete addresses returned, in order,
+// until one succeeds.  Options are saved, and applied to each concrete
+// dialer; as the transport is not known in advance they cannot be
+// validated when set, and those the concrete dialer does not know (such
+// as TLS options on a tcp address) are skipped.
+type resolvedDialer struct {
+	sock *socket
+	addr string
+	r    Resolver
+	opts map[string]interface{}
+	sync.Mutex
+}
+
+func (d *resolvedDialer) Dial() (Pipe, error) {
+	addrs, err := d.r.Resolve(d.addr)
+	if err != nil {
+		return nil, err
+	}
+	err = ErrBadAddr // if no addresses are returned
+	for _, addr := range addrs {
+		var pd PipeDialer
+		var p Pipe
+		t := d.sock.getTransport(addr)
+		if t == nil {
+			err = ErrBadTran
+			continue
+		}
+		if pd, err = t.NewDialer(addr, d.sock); err != nil {
+			continue
+		}
+		if err = d.configure(pd); err != nil {
+			continue
+		}
+		if p, err = pd.Dial(); err == nil {
+			return p, nil
+		}
+	}
+	return nil, err
+}
+
+func (d *resolvedDialer) configure(pd PipeDialer) error {
+	d.Lock()
+	defer d.Unlock()
+	for n, v := range d.opts {
+		if err := pd.SetOption(n, v); err != nil && err != ErrBadOption {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *resolvedDialer) SetOption(name string, value interface{}) error {
+	d.Lock()
+	d.opts[name] = value
+	d.Unlock()
+	return nil
+}
+
+func (d *resolvedDialer) GetOption(name string) (interface{}, error) {
+	d.Lock()
+	defer d.Unlock()
+	if v, ok := d.opts[name]; ok {
+		return v, nil
+	}
+	return nil, ErrBadOption
+}
@@ -14,6 +14,11 @@
 
 package mangos
 
+import (
+	"context"
+	"time"
+)
+
 // Socket is the main access handle applications use to access the SP
 // system.  It is an abstraction of an application's "connection" to a
 // messaging topology.  Applications can have more than one Socket open
@@ -27,9 +32,21 @@ type Socket interface {
 	// until the message can be queued, or the send deadline expires.
 	// If a queued message is later dropped for any reason,
 	// there will be no notification back to the application.
+	//
+	// Send (and SendMsg) may be called concurrently from multiple
+	// goroutines.  Callers that must wait for room in the queue are
+	// served in the order they started waiting (the send queue is a
+	// channel, and Go queues blocked channel senders in FIFO order), so
+	// no caller is starved under contention, although callers that find
+	// room immediately do not wait behind those that do not.
+	//
+	// On a socket whose protocol never sends, such as SUB or PULL, Send
+	// returns ErrProtoOp at once.
 	Send([]byte) error
 
 	// Recv receives a complete message.  The entire message is received.
+	// On a socket whose protocol never receives, such as PUB or PUSH, it
+	// returns ErrProtoOp at once.
 	Recv() ([]byte, error)
 
 	// SendMsg puts the message on the outbound send.  It works like Send,
@@ -37,6 +54,38 @@ type Socket interface {
 	// ASSUMES OWNERSHIP OF THE MESSAGE.
 	SendMsg(*Message) error
 
+	// SendMsgEndpoint works like SendMsg, but also reports the Endpoint
+	// the message was written to, so that follow-up messages can be
+	// directed to the same peer (for example with SendToEndpoint on a
+	// raw socket), or for debugging.  For protocols that pick a single
+	// peer for each message (PAIR, PUSH, REQ, REP and RESPONDENT) it
+	// blocks until the message has been handed to a transport, or the
+	// send deadline passes.  In that case the message is discarded if it
+	// is still queued, so ErrSendTimeout comes with a nil Endpoint, unless
+	// its write had already begun, when the Endpoint being written to is
+	// returned with the error.  If the write fails, the Endpoint is
+	// returned along with the error, although a protocol that retries on
+	// another peer (REQ) may still deliver the message.  The Endpoint is
+	// nil if the protocol discarded the message instead.  Protocols that
+	// copy each message to several peers, such as PUB or BUS, return a
+	// nil Endpoint as soon as the message is queued.
+	SendMsgEndpoint(*Message) (Endpoint, error)
+
+	// SendToEndpoint sends the message directly on the given Endpoint,
+	// bypassing the protocol's choice of peer.  The Endpoint is usually
+	// a Port obtained from a received message or a PortHook, which can
+	// be converted with a type assertion, for example
+	// m.Port.(mangos.Endpoint).  It is only permitted on sockets in raw
+	// mode (ErrProtoOp otherwise), and the message is sent exactly as
+	// given, so it must already carry any header the peer expects.  The
+	// call blocks until the message has been handed to the transport, or
+	// the send deadline passes (ErrSendTimeout), in which case the message
+	// is discarded unless its write had already begun.  It returns
+	// ErrBadEndpoint if the Endpoint is not currently connected to this
+	// socket.  There is no ordering with respect to messages queued with
+	// SendMsg.  The Socket assumes ownership of the message.
+	SendToEndpoint(Endpoint, *Message) error
+
 	// RecvMsg receives a complete message, including the message header,
 	// which is useful for protocols in raw mode.
 	RecvMsg() (*Message, error)
@@ -49,6 +98,13 @@ type Socket interface {
 
 	DialOptions(addr string, options map[string]interface{}) error
 
+	// DialContext is like Dial, but the connection is tied to ctx.  When
+	// the context is done, the dialer stops retrying and is closed,
+	// along with any connection it has established, just as if Close had
+	// been called on it.  If ctx is already done, nothing is dialed and
+	// the context's error is returned.
+	DialContext(ctx context.Context, addr string) error
+
 	// NewDialer returns a Dialer object which can be used to get
 	// access to the underlying configuration for dialing.
 	NewDialer(addr string, options map[string]interface{}) (Dialer, error)
@@ -63,12 +119,36 @@ type Socket interface {
 
 	NewListener(addr string, options map[string]interface{}) (Listener, error)
 
+	// CloseListener stops accepting new connections on the given
+	// address, which is either the address passed to Listen, or the
+	// one reported by the Listener.  Connections already accepted are
+	// not affected, and continue to be serviced, so this can be used to
+	// drain a server gracefully.  ErrBadAddr is returned if the socket
+	// is not listening on the address.
+	CloseListener(addr string) error
+
+	// ReconnectEndpoint makes the dialers for the given address (as
+	// passed to Dial) connect again at once: a connection they have is
+	// closed, with CloseReasonReconnect, and redialed without waiting,
+	// and one waiting out a reconnect backoff (see OptionReconnectTime)
+	// tries again immediately, with the backoff reset.  This is useful
+	// when the address is known to point somewhere new, after a DNS
+	// change or a server restart.  ErrBadAddr is returned if no dialer
+	// for the address is running.
+	ReconnectEndpoint(addr string) error
+
 	// GetOption is used to retrieve an option for a socket.
 	GetOption(name string) (interface{}, error)
 
 	// SetOption is used to set an option for a socket.
 	SetOption(name string, value interface{}) error
 
+	// Options lists the options that the socket and its protocol
+	// understand, ordered by name, with the type of each value.
+	// Transport options, which are given to Dialers and Listeners, are
+	// not included.
+	Options() []OptionInfo
+
 	// Protocol is used to get the underlying Protocol.
 	GetProtocol() Protocol
 
@@ -76,8 +156,94 @@ type Socket interface {
 	// options may have been configured on the Transport prior to this.
 	AddTransport(Transport)
 
+	// AddResolver registers a Resolver for addresses using the given
+	// scheme.  Dialing such an address resolves it to concrete transport
+	// addresses, anew on each connection attempt.  Resolvers are only
+	// used for dialing.  A nil Resolver removes any registration.
+	AddResolver(scheme string, r Resolver)
+
 	// SetPortHook sets a PortHook function to be called when a Port is
 	// added or removed from this socket (connect/disconnect).  The previous
 	// hook is returned (nil if none.)
 	SetPortHook(PortHook) PortHook
+
+	// SetDropHook sets a DropHook function to be called whenever the
+	// socket discards a message that it could not queue or deliver, so
+	// that drops are not silent.  The previous hook is returned (nil if
+	// none.)
+	SetDropHook(DropHook) DropHook
+
+	// WaitConnected blocks until the socket has at least one connected
+	// Port, so that an application can Dial and then wait for the
+	// connection to be made before its first Send, rather than sleeping.
+	// It returns nil once connected, ErrClosed if the socket is closed,
+	// or the context's error if ctx is done first.
+	WaitConnected(ctx context.Context) error
+
+	// Endpoints returns the Endpoints currently connected to the socket,
+	// ordered by ID.  The slice is a snapshot, which the caller may keep
+	// or modify; Endpoints in it may disconnect at any time afterwards.
+	// Each is also a Port, which can be had with a type assertion, for
+	// example ep.(mangos.Port).Address().
+	Endpoints() []Endpoint
+
+	// MoveEndpoint detaches a connected Endpoint from this socket, and
+	// attaches it to another socket of the same protocol in the same
+	// process, without disturbing the connection, so that handler logic
+	// can be replaced without peers noticing.  The Endpoint returned is
+	// the one on the new socket; it keeps the ID, Port properties and
+	// metadata of the old one.  Messages this socket had already read
+	// from the peer, or queued for it, are still delivered (Recv, or
+	// written to the connection) by this socket, and anything read from
+	// then on goes to the new one.  So that a REP socket can answer a
+	// request, it should be answered before the move; a raw socket can
+	// still reply to one received on the old socket, as the ID is kept.
+	// Dialers stay behind with the socket they belong to: a moved
+	// connection is not redialed by either socket if it is lost, nor
+	// does the old dialer redial when it is moved away.  It fails with
+	// ErrBadEndpoint if the Endpoint is not connected to this socket,
+	// ErrBadValue if the new socket is this one, ErrBadProto if the
+	// protocols differ, ErrClosed if either end is closed, and
+	// ErrConnRefused if the new socket's PortHook rejects it (the
+	// connection is closed then).
+	MoveEndpoint(ep Endpoint, to Socket) (Endpoint, error)
+
+	// Flush writes out any data that transports are holding back to
+	// combine writes (see OptionTLSCoalesce), on every connected Port, so
+	// that an application batching messages can decide itself when they
+	// go out, rather than waiting for the coalescing delay.  Messages
+	// still waiting in the socket's queues, or the protocol's queues for
+	// each peer, are waited for first, as Close does, for up to the send
+	// deadline (or the linger time, if there is none); those that have not
+	// reached a transport by then are written as usual when they do.
+	// Transports that do not buffer are not affected.  The first error
+	// from any Port is returned, or ErrClosed if the socket is closed.
+	Flush() error
+
+	// Ping checks that the socket's peers are alive, by sending a small
+	// control frame on each connected Port, which the peer's mangos
+	// socket answers itself without involving (or delivering anything
+	// to) the application.  It returns nil as soon as one peer answers,
+	// ErrRecvTimeout if none does within the timeout (zero means no
+	// limit), and ErrClosed if the socket is closed.  A peer only answers
+	// while its socket is reading from the connection, so this detects
+	// hung peers, and ones that have stopped reading (for example with
+	// PauseRecv), as well as broken connections.  It is only available
+	// with PAIR, REQ and REP, and returns ErrProtoOp on other sockets;
+	// only those protocols answer pings, too.  The peer must be mangos:
+	// other implementations do not know the frame, and may deliver it
+	// to their application.
+	Ping(timeout time.Duration) error
+
+	// PauseRecv stops the socket reading messages from its Ports, until
+	// ResumeRecv is called.  Pipes stay connected, but as nothing is read
+	// from them, peers are pushed back on once the transport buffers are
+	// full, instead of the receive queue growing.  Messages already
+	// queued, and at most one per Port that was being read when the call
+	// was made, can still be had with Recv.  Pausing a paused socket, or
+	// resuming one that is not paused, does nothing.
+	PauseRecv()
+
+	// ResumeRecv undoes PauseRecv.
+	ResumeRecv()
 }
@@ -0,0 +1,183 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+)
+
+// stream adapts a Socket to io.ReadWriteCloser.
+type stream struct {
+	sock Socket
+	buf  []byte
+	eof  bool
+}
+
+// NewStream returns an io.ReadWriteCloser that carries a byte stream
+// over the Socket, which is normally a PAIR socket connected to a single
+// peer using a stream of its own.
+//
+// The framing is as follows.  Each Write is sent as a single message,
+// so message boundaries follow the sizes of the writes.  Read ignores
+// those boundaries, and returns the message bodies concatenated; a
+// message that does not fit in the caller's buffer is returned over
+// several calls.  An empty message marks the end of the stream: Close
+// sends one before closing the Socket, and Read returns io.EOF once it
+// has been received.  (Empty writes are not sent, so they cannot end the
+// stream early.)  Errors from the Socket, such as ErrRecvTimeout, are
+// returned as is.
+//
+// The stream is not safe for concurrent use by multiple readers, or by
+// multiple writers, although a single reader and a single writer may
+// operate concurrently.
+func NewStream(sock Socket) io.ReadWriteCloser {
+	return &stream{sock: sock}
+}
+
+func (s *stream) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		m, err := s.sock.RecvMsg()
+		if err != nil {
+			return 0, err
+		}
+		if len(m.Body) == 0 {
+			s.eof = true
+		} else {
+			s.buf = append(s.buf[:0], m.Body...)
+		}
+		m.Free()
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	m := NewMessage(len(b))
+	m.Body = append(m.Body, b...)
+	if err := s.sock.SendMsg(m); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends the end of stream marker, and then closes the Socket.
+func (s *stream) Close() error {
+	s.sock.SendMsg(NewMessage(0))
+	return s.sock.Close()
+}
+
+// chunkHdrLen is the size of the header on each message sent by
+// SendStream: a 32-bit stream ID, a 32-bit sequence number, and a byte of
+// flags, of which only chunkLast is defined.
+const chunkHdrLen = 9
+
+const chunkLast = 1
+
+// SendStream sends everything read from r, until io.EOF, as a single
+// payload that RecvStream on the peer writes out in the same order.  The
+// payload is read and sent in chunks of OptionStreamChunkSize bytes, so
+// memory use is bounded by the chunk size and the socket's queues, not
+// the payload.  It returns ErrProtoOp if the option is not set, or the
+// first error from r or from sending; in the latter cases the peer is
+// left with an incomplete stream, which its RecvStream reports as
+// ErrGarbled once another message arrives.  SendStream blocks until the
+// last chunk is queued.  The stream is intended for sockets connected to
+// a single peer, such as PAIR, and concurrent calls on one socket must
+// not be made.
+func SendStream(sock Socket, r io.Reader) error {
+	v, err := sock.GetOption(OptionStreamChunkSize)
+	if err != nil {
+		return err
+	}
+	size := v.(int)
+	if size <= 0 {
+		return ErrProtoOp
+	}
+	id := rand.Uint32()
+	for seq := uint32(0); ; seq++ {
+		m := NewMessage(chunkHdrLen + size)
+		m.Body = m.Body[:chunkHdrLen+size]
+		n, err := io.ReadFull(r, m.Body[chunkHdrLen:])
+		m.Body = m.Body[:chunkHdrLen+n]
+		binary.BigEndian.PutUint32(m.Body, id)
+		binary.BigEndian.PutUint32(m.Body[4:], seq)
+		switch err {
+		case nil:
+			m.Body[8] = 0
+		case io.EOF, io.ErrUnexpectedEOF:
+			m.Body[8] = chunkLast
+		default:
+			m.Free()
+			return err
+		}
+		if err := sock.SendMsg(m); err != nil {
+			m.Free()
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// RecvStream receives a payload sent with SendStream, writing it to w as
+// each chunk arrives, and returns the number of bytes written.  It
+// returns ErrProtoOp if OptionStreamChunkSize is not set, and ErrGarbled
+// if a message is not the next chunk of the stream, which happens if a
+// chunk was lost or the peer sent something else.  Errors receiving,
+// including ErrRecvTimeout, and from w, are returned as is.
+func RecvStream(sock Socket, w io.Writer) (int64, error) {
+	v, err := sock.GetOption(OptionStreamChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	if v.(int) <= 0 {
+		return 0, ErrProtoOp
+	}
+	var total int64
+	var id uint32
+	for seq := uint32(0); ; seq++ {
+		m, err := sock.RecvMsg()
+		if err != nil {
+			return total, err
+		}
+		b := m.Body
+		if len(b) < chunkHdrLen || binary.BigEndian.Uint32(b[4:]) != seq ||
+			(seq > 0 && binary.BigEndian.Uint32(b) != id) {
+			m.Free()
+			return total, ErrGarbled
+		}
+		id = binary.BigEndian.Uint32(b)
+		last := b[8]&chunkLast != 0
+		n, err := w.Write(b[chunkHdrLen:])
+		total += int64(n)
+		m.Free()
+		if err != nil {
+			return total, err
+		}
+		if last {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangos
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// subReportMagic begins the body of a subscription report.  A SUB socket
+// using OptionSubForward sends these upstream.
+var subReportMagic = []byte("\x00\x00SUBS")
+
+// syncProbeMagic begins the body of a synchronization probe (see
+// OptionSync), which is followed by eight random bytes.
+var syncProbeMagic = []byte("\x00\x00SYNC")
+
+// syncProbeLen is the length of a synchronization probe.
+const syncProbeLen = 6 + 8
+
+// NewSyncProbe makes the synchronization probe which SUB sends for
+// OptionSync, from eight random bytes.  PUB echoes these back, and
+// nothing else.  This is intended for use by Protocol implementations.
+func NewSyncProbe(token []byte) *Message {
+	m := NewMessage(syncProbeLen)
+	m.Body = append(m.Body, syncProbeMagic...)
+	m.Body = append(m.Body, token...)
+	return m
+}
+
+// IsSyncProbe returns true if the message is a probe made by NewSyncProbe.
+// This is intended for use by Protocol implementations.
+func IsSyncProbe(m *Message) bool {
+	return len(m.Header) == 0 && len(m.Body) == syncProbeLen &&
+		bytes.HasPrefix(m.Body, syncProbeMagic)
+}
+
+// NewSubscriptionReport encodes the complete set of subscriptions of a SUB
+// socket into a message for its publishers.  If forwarding is false, the
+// report instead tells the publisher that the subscriber no longer reports
+// its subscriptions, and the topics are ignored.  Each report replaces any
+// earlier one.  This is intended for use by Protocol implementations.
+func NewSubscriptionReport(topics [][]byte, forwarding bool) *Message {
+	n := len(subReportMagic) + 1
+	for _, t := range topics {
+		n += 4 + len(t)
+	}
+	m := NewMessage(n)
+	m.Body = append(m.Body, subReportMagic...)
+	if !forwarding {
+		m.Body = append(m.Body, 0)
+		return m
+	}
+	m.Body = append(m.Body, 1)
+	var l [4]byte
+	for _, t := range topics {
+		binary.BigEndian.PutUint32(l[:], uint32(len(t)))
+		m.Body = append(m.Body, l[:]...)
+		m.Body = append(m.Body, t...)
+	}
+	return m
+}
+
+// ParseSubscriptionReport decodes a message made by NewSubscriptionReport.
+// It returns false if the message is not a valid report.  The topics share
+// storage with the message.  This is intended for use by Protocol
+// implementations.
+func ParseSubscriptionReport(m *Message) ([][]byte, bool, bool) {
+	b := m.Body
+	if len(m.Header) != 0 || len(b) <= len(subReportMagic) ||
+		!bytes.HasPrefix(b, subReportMagic) {
+		return nil, false, false
+	}
+	b = b[len(subReportMagic):]
+	switch b[0] {
+	case 0:
+		return nil, false, len(b) == 1
+	case 1:
+	default:
+		return nil, false, false
+	}
+	b = b[1:]
+	topics := [][]byte{}
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, false, false
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(len(b)) < uint64(n) {
+			return nil, false, false
+		}
+		topics = append(topics, b[:n])
+		b = b[n:]
+	}
+	return topics, true, true
+}
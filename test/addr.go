@@ -0,0 +1,67 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// We have to expose these, so that device and transport tests can use
+// them.
+
+var currPort uint16
+var currLock sync.Mutex
+
+func init() {
+	currPort = uint16(time.Now().UnixNano()%20000 + 20000)
+}
+
+func NextPort() uint16 {
+	currLock.Lock()
+	defer currLock.Unlock()
+	p := currPort
+	currPort++
+	return p
+}
+
+func AddrTestIPC() string {
+	return (fmt.Sprintf("ipc://mangostest%d", NextPort()))
+}
+
+func AddrTestWSS() string {
+	return (fmt.Sprintf("wss://127.0.0.1:%d/", NextPort()))
+}
+
+func AddrTestWS() string {
+	return (fmt.Sprintf("ws://127.0.0.1:%d/", NextPort()))
+}
+
+func AddrTestTCP() string {
+	return (fmt.Sprintf("tcp://127.0.0.1:%d", NextPort()))
+}
+
+func AddrTestUDP() string {
+	return (fmt.Sprintf("udp://127.0.0.1:%d", NextPort()))
+}
+
+func AddrTestTLS() string {
+	return (fmt.Sprintf("tls+tcp://127.0.0.1:%d", NextPort()))
+}
+
+func AddrTestInp() string {
+	return (fmt.Sprintf("inproc://test_%d", NextPort()))
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/transport/ipc"
+	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/tlstcp"
+	"nanomsg.org/go-mangos/transport/ws"
+)
+
+func listenTwice(t *testing.T, addr string, opts map[string]interface{}) {
+	var socks [2]mangos.Socket
+	for i := range socks {
+		s, err := rep.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(tcp.NewTransport())
+		s.AddTransport(tlstcp.NewTransport())
+		s.AddTransport(ipc.NewTransport())
+		s.AddTransport(ws.NewTransport())
+		socks[i] = s
+	}
+	if err := socks[0].ListenOptions(addr, opts); err != nil {
+		t.Fatalf("First listen on %s failed: %v", addr, err)
+	}
+	if err := socks[1].ListenOptions(addr, opts); err != mangos.ErrAddrInUse {
+		t.Errorf("Second listen on %s: expected ErrAddrInUse, got %v", addr, err)
+	}
+}
+
+func TestListenAddrInUseTCP(t *testing.T) {
+	listenTwice(t, AddrTestTCP(), nil)
+}
+
+func TestListenAddrInUseTLS(t *testing.T) {
+	opts := map[string]interface{}{mangos.OptionTLSConfig: srvCfg}
+	listenTwice(t, AddrTestTLS(), opts)
+}
+
+func TestListenAddrInUseWS(t *testing.T) {
+	listenTwice(t, AddrTestWS(), nil)
+}
+
+func TestListenAddrInUseIPC(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("IPC is not a socket path here")
+	}
+	listenTwice(t, AddrTestIPC(), nil)
+}
+
+func TestListenErrorOther(t *testing.T) {
+	// Errors other than a conflict are passed through untouched.
+	other := &net.OpError{Op: "listen", Net: "tcp", Err: errors.New("boom")}
+	if err := mangos.ListenError(other); err != other {
+		t.Errorf("Expected original error, got %v", err)
+	}
+	if err := mangos.ListenError(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestBacktraceValidate(t *testing.T) {
+	m := mangos.NewMessage(0)
+	defer m.Free()
+	bad := [][]uint32{
+		nil,
+		{1, 2},                   // no request ID
+		{0x80000001, 0x80000002}, // request ID not last
+		make([]uint32, mangos.MaxBacktrace+1),
+	}
+	bad[3][mangos.MaxBacktrace] = 0x80000000
+	for _, bt := range bad {
+		if err := m.SetBacktrace(bt); err != mangos.ErrBadTrace {
+			t.Errorf("SetBacktrace(%x): expected ErrBadTrace, got %v", bt, err)
+		}
+	}
+	if len(m.Header) != 0 {
+		t.Errorf("Failed SetBacktrace modified header: %x", m.Header)
+	}
+
+	bt := []uint32{0x1234, 0x56789abc, 0x80000007}
+	if err := m.SetBacktrace(bt); err != nil {
+		t.Fatalf("SetBacktrace failed: %v", err)
+	}
+	wire := []byte{0, 0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0x80, 0, 0, 7}
+	if !bytes.Equal(m.Header, wire) {
+		t.Errorf("Header %x, expected %x", m.Header, wire)
+	}
+	got := m.Backtrace()
+	if len(got) != len(bt) {
+		t.Fatalf("Backtrace %x, expected %x", got, bt)
+	}
+	for i := range bt {
+		if got[i] != bt[i] {
+			t.Errorf("Backtrace %x, expected %x", got, bt)
+		}
+	}
+
+	m.Header = m.Header[:3]
+	if got := m.Backtrace(); got != nil {
+		t.Errorf("Partial header gave %x", got)
+	}
+}
+
+func rawSock(t *testing.T, newSock func() (mangos.Socket, error)) mangos.Socket {
+	s, err := newSock()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err := s.SetOption(mangos.OptionRaw, true); err != nil {
+		t.Fatalf("Failed to set raw: %v", err)
+	}
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	s.SetOption(mangos.OptionSendDeadline, time.Second)
+	return s
+}
+
+func TestBacktraceDevice(t *testing.T) {
+	front, back := AddrTestInp(), AddrTestInp()
+
+	// REQ -> raw REP | router | raw REQ -> raw REP server
+	frep := rawSock(t, rep.NewSocket)
+	defer frep.Close()
+	breq := rawSock(t, req.NewSocket)
+	defer breq.Close()
+	srv := rawSock(t, rep.NewSocket)
+	defer srv.Close()
+	if err := frep.Listen(front); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := srv.Listen(back); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := breq.Dial(back); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(inproc.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := cli.Dial(front); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	// The router sees the client pipe and the request ID.
+	m, err := frep.RecvMsg()
+	if err != nil {
+		t.Fatalf("Router recv failed: %v", err)
+	}
+	fbt := m.Backtrace()
+	if len(fbt) != 2 || fbt[0]&0x80000000 != 0 || fbt[1]&0x80000000 == 0 {
+		t.Fatalf("Bad router backtrace %x", fbt)
+	}
+	if err := m.SetBacktrace(fbt); err != nil {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+	if err := breq.SendMsg(m); err != nil {
+		t.Fatalf("Router send failed: %v", err)
+	}
+
+	// The server sees its own pipe, then the hop through the router.
+	m, err = srv.RecvMsg()
+	if err != nil {
+		t.Fatalf("Server recv failed: %v", err)
+	}
+	sbt := m.Backtrace()
+	if len(sbt) != 3 || sbt[1] != fbt[0] || sbt[2] != fbt[1] {
+		t.Fatalf("Server backtrace %x, router had %x", sbt, fbt)
+	}
+	m.Body = append(m.Body[:0], "pong"...)
+	if err := m.SetBacktrace(sbt); err != nil {
+		t.Fatalf("SetBacktrace failed: %v", err)
+	}
+	if err := srv.SendMsg(m); err != nil {
+		t.Fatalf("Server send failed: %v", err)
+	}
+
+	// Raw REQ hands the router back the client pipe it must reply on.
+	m, err = breq.RecvMsg()
+	if err != nil {
+		t.Fatalf("Router reply recv failed: %v", err)
+	}
+	if bt := m.Backtrace(); len(bt) != 1 || bt[0] != fbt[0] {
+		t.Fatalf("Reply backtrace %x, expected %x", bt, fbt[:1])
+	}
+	if err := frep.SendMsg(m); err != nil {
+		t.Fatalf("Router reply send failed: %v", err)
+	}
+
+	if b, err := cli.Recv(); err != nil || string(b) != "pong" {
+		t.Errorf("Client got %q %v", b, err)
+	}
+}
+
+func TestBacktraceTTL(t *testing.T) {
+	addr := AddrTestInp()
+	srv := rawSock(t, rep.NewSocket)
+	defer srv.Close()
+	srv.SetOption(mangos.OptionTTL, 1)
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(inproc.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Millisecond*100)
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	m, err := srv.RecvMsg()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	// An extra hop makes the reply deeper than the TTL allows.
+	bt := m.Backtrace()
+	if err := m.SetBacktrace([]uint32{bt[0], 5, bt[1]}); err != nil {
+		t.Fatalf("SetBacktrace failed: %v", err)
+	}
+	if err := srv.SendMsg(m); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := cli.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	if v, err := srv.GetOption(mangos.OptionTTLDrops); err != nil || v.(uint64) != 1 {
+		t.Errorf("TTL drops %v %v", v, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestBestEffortPerMessage(t *testing.T) {
+	addr := AddrTestInp()
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	if err = tx.SetOption(mangos.OptionWriteQLen, 1); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = tx.SetOption(mangos.OptionBestEffort, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = tx.SetOption(mangos.OptionSendDeadline, time.Second*5); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	// With no consumer yet, the first message fills the queue and the
+	// second is discarded under the socket default.
+	if err = tx.Send([]byte("first")); err != nil {
+		t.Fatalf("Send first: %v", err)
+	}
+	if err = tx.Send([]byte("dropped")); err != nil {
+		t.Fatalf("Send dropped: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, []byte("blocking")...)
+		m.SetBestEffort(false)
+		done <- tx.SendMsg(m)
+	}()
+
+	select {
+	case err = <-done:
+		t.Fatalf("blocking send returned early: %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	rx, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(inproc.NewTransport())
+	if err = rx.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("blocking send: %v", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("blocking send never completed")
+	}
+
+	for _, want := range []string{"first", "blocking"} {
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("got %q, want %q", b, want)
+		}
+	}
+}
+
+func TestBestEffortPerMessageDiscard(t *testing.T) {
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	if err = tx.SetOption(mangos.OptionWriteQLen, 1); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = tx.SetOption(mangos.OptionSendDeadline, time.Second*5); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = tx.Dial(AddrTestInp()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err = tx.Send([]byte("first")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	m := mangos.NewMessage(0)
+	m.SetBestEffort(true)
+	start := time.Now()
+	if err = tx.SendMsg(m); err != nil {
+		t.Fatalf("SendMsg: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("best-effort message blocked")
+	}
+}
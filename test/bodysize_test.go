@@ -0,0 +1,159 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestDefaultBodySizeOption(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+
+	if v, err := s.GetOption(mangos.OptionDefaultBodySize); err != nil || v.(int) != 0 {
+		t.Errorf("Default got %v, %v", v, err)
+	}
+	if err = s.SetOption(mangos.OptionDefaultBodySize, -1); err != mangos.ErrBadValue {
+		t.Errorf("Negative size got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionDefaultBodySize, "big"); err != mangos.ErrBadValue {
+		t.Errorf("String size got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionDefaultBodySize, 4096); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, _ := s.GetOption(mangos.OptionDefaultBodySize); v.(int) != 4096 {
+		t.Errorf("Got %v, expected 4096", v)
+	}
+}
+
+func TestDefaultBodySizeRecv(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionDefaultBodySize, 4096)
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	cli, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, sz := range []int{10, 8192} {
+		if err = cli.Send(make([]byte, sz)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		m, err := srv.RecvMsg()
+		if err != nil {
+			t.Fatalf("RecvMsg: %v", err)
+		}
+		if len(m.Body) != sz {
+			t.Errorf("Got body len %d, expected %d", len(m.Body), sz)
+		}
+		if cap(m.Body) < 4096 {
+			t.Errorf("Got body cap %d for %d bytes", cap(m.Body), sz)
+		}
+		m.Free()
+	}
+}
+
+// benchmarkBodySize measures a receiver that appends a trailer to each
+// message it gets, which is where sizing the body up front pays off.
+func benchmarkBodySize(b *testing.B, size int, bodySize int) {
+	addr := AddrTestTCP()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		b.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionDefaultBodySize, bodySize)
+
+	cli, err := pair.NewSocket()
+	if err != nil {
+		b.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+
+	if err = srv.Listen(addr); err != nil {
+		b.Fatalf("Listen: %v", err)
+	}
+	if err = cli.Dial(addr); err != nil {
+		b.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	trailer := make([]byte, 1024)
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			m, err := srv.RecvMsg()
+			if err != nil {
+				b.Errorf("RecvMsg %d: %v", i, err)
+				return
+			}
+			m.Body = append(m.Body, trailer...)
+			m.Free()
+		}
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = cli.SendMsg(mangos.NewMessage(size)); err != nil {
+			b.Fatalf("SendMsg: %v", err)
+		}
+	}
+	<-done
+	b.StopTimer()
+}
+
+func BenchmarkBodySizeSmallDefault(b *testing.B) {
+	benchmarkBodySize(b, 256, 0)
+}
+func BenchmarkBodySizeSmall4K(b *testing.B) {
+	benchmarkBodySize(b, 256, 4096)
+}
+func BenchmarkBodySizeLargeDefault(b *testing.B) {
+	benchmarkBodySize(b, 8192, 0)
+}
+func BenchmarkBodySizeLarge4K(b *testing.B) {
+	benchmarkBodySize(b, 8192, 4096)
+}
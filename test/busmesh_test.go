@@ -0,0 +1,103 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestBusMeshBadProto(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	if err := mangos.BusMesh(s, AddrTestTCP(), nil); err != mangos.ErrBadProto {
+		t.Errorf("Expected ErrBadProto, got %v", err)
+	}
+}
+
+func TestBusMesh(t *testing.T) {
+	const nodes = 3
+	addrs := make([]string, nodes)
+	for i := range addrs {
+		addrs[i] = AddrTestTCP()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(nodes * (nodes - 1))
+	socks := make([]mangos.Socket, nodes)
+	for i := range socks {
+		s, err := bus.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(tcp.NewTransport())
+		s.SetOption(mangos.OptionReconnectTime, time.Millisecond*10)
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		s.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+			if a == mangos.PortActionAdd {
+				wg.Done()
+			}
+			return true
+		})
+		socks[i] = s
+	}
+
+	// The first members dial peers that are not listening yet.
+	for i, s := range socks {
+		if err := mangos.BusMesh(s, addrs[i], addrs); err != nil {
+			t.Fatalf("BusMesh %d failed: %v", i, err)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	wg.Wait()
+
+	for i, s := range socks {
+		msg := []byte{byte(i)}
+		if err := s.Send(msg); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+		for j, r := range socks {
+			if j == i {
+				continue
+			}
+			m, err := r.Recv()
+			if err != nil {
+				t.Fatalf("Node %d recv from %d failed: %v", j, i, err)
+			}
+			if len(m) != 1 || m[0] != byte(i) {
+				t.Errorf("Node %d got %v, expected %d", j, m, i)
+			}
+		}
+	}
+
+	// Each pair is joined once, so nothing should be delivered twice.
+	for j, r := range socks {
+		r.SetOption(mangos.OptionRecvDeadline, time.Millisecond*50)
+		if m, err := r.Recv(); err != mangos.ErrRecvTimeout {
+			t.Errorf("Node %d got extra message %v (%v)", j, m, err)
+		}
+	}
+}
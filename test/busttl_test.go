@@ -0,0 +1,133 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newBusTTL(t *testing.T, raw bool) mangos.Socket {
+	s, err := bus.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	s.SetOption(mangos.OptionRaw, raw)
+	if err = s.SetOption(mangos.OptionTTL, 4); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if !raw {
+		// Relays must not time out, or their Devices would stop.
+		s.SetOption(mangos.OptionRecvDeadline, 200*time.Millisecond)
+	}
+	return s
+}
+
+func TestBusTTL(t *testing.T) {
+	// A chain of relays, each with an observer:
+	//
+	//	src - relay1 - relay2 - relay3
+	//	        |        |        |
+	//	       obs1     obs2     obs3
+	src := newBusTTL(t, false)
+	defer src.Close()
+	if err := src.SetOption(mangos.OptionTTL, 256); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	prev := AddrTestInp()
+	if err := src.Listen(prev); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	var relays, obs []mangos.Socket
+	for i := 0; i < 3; i++ {
+		r := newBusTTL(t, true)
+		defer r.Close()
+		o := newBusTTL(t, false)
+		defer o.Close()
+		addr := AddrTestInp()
+		if err := r.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		if err := r.Dial(prev); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		if err := o.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		go mangos.Device(r, r)
+		relays = append(relays, r)
+		obs = append(obs, o)
+		prev = addr
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// send delivers a message with the given TTL, and returns how many
+	// observers got it.  The hops left are counted from hops.
+	send := func(ttl, hops int) int {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, byte(ttl))
+		m.SetTTL(ttl)
+		if err := src.SendMsg(m); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+		got := 0
+		for i, o := range obs {
+			m, err := o.RecvMsg()
+			if err != nil {
+				break
+			}
+			if got != i || m.Body[0] != byte(ttl) {
+				t.Errorf("Observer %d got %v", i, m.Body)
+			}
+			if left := hops - i - 1; m.TTL() != left {
+				t.Errorf("Observer %d: TTL %d, expected %d", i, m.TTL(), left)
+			}
+			m.Free()
+			got++
+		}
+		return got
+	}
+
+	// Each relay uses up a hop, and the observer of a relay is one
+	// further hop away from the source.
+	for ttl, want := range map[int]int{1: 0, 2: 1, 3: 2, 4: 3} {
+		if got := send(ttl, ttl); got != want {
+			t.Errorf("TTL %d reached %d observers, expected %d", ttl, got, want)
+		}
+	}
+	// Without SetTTL, the socket's OptionTTL applies.
+	src.SetOption(mangos.OptionTTL, 3)
+	if got := send(0, 3); got != 2 {
+		t.Errorf("Default TTL reached %d observers, expected 2", got)
+	}
+
+	// The relay where each message ran out counted it.
+	var drops uint64
+	for _, r := range relays {
+		v, err := r.GetOption(mangos.OptionTTLDrops)
+		if err != nil {
+			t.Fatalf("GetOption: %v", err)
+		}
+		drops += v.(uint64)
+	}
+	if drops != 4 {
+		t.Errorf("Expected 4 drops, got %d", drops)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func byteCount(t *testing.T, s mangos.Socket, name string) uint64 {
+	v, err := s.GetOption(name)
+	if err != nil {
+		t.Fatalf("GetOption %s: %v", name, err)
+	}
+	n, ok := v.(uint64)
+	if !ok {
+		t.Fatalf("GetOption %s: bad type %T", name, v)
+	}
+	return n
+}
+
+func TestSocketByteCounters(t *testing.T) {
+	addr := AddrTestTCP()
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(tcp.NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(tcp.NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	for _, s := range []mangos.Socket{sreq, srep} {
+		if n := byteCount(t, s, mangos.OptionBytesSent); n != 0 {
+			t.Errorf("Initial bytes sent %d", n)
+		}
+		if n := byteCount(t, s, mangos.OptionBytesRecv); n != 0 {
+			t.Errorf("Initial bytes recv %d", n)
+		}
+	}
+
+	const rounds = 10
+	const reqSize = 100
+	const repSize = 37
+	last := uint64(0)
+	for i := 0; i < rounds; i++ {
+		if err = sreq.Send(make([]byte, reqSize)); err != nil {
+			t.Fatalf("Send request: %v", err)
+		}
+		if _, err = srep.Recv(); err != nil {
+			t.Fatalf("Recv request: %v", err)
+		}
+		if err = srep.Send(make([]byte, repSize)); err != nil {
+			t.Fatalf("Send reply: %v", err)
+		}
+		if _, err = sreq.Recv(); err != nil {
+			t.Fatalf("Recv reply: %v", err)
+		}
+		n := byteCount(t, sreq, mangos.OptionBytesSent)
+		if n <= last {
+			t.Errorf("Counter not increasing: %d after %d", n, last)
+		}
+		last = n
+	}
+
+	// Each message carries the 4 byte request ID in its header.
+	wantReq := uint64(rounds * (reqSize + 4))
+	wantRep := uint64(rounds * (repSize + 4))
+	if n := byteCount(t, sreq, mangos.OptionBytesSent); n != wantReq {
+		t.Errorf("REQ bytes sent %d, want %d", n, wantReq)
+	}
+	if n := byteCount(t, srep, mangos.OptionBytesRecv); n != wantReq {
+		t.Errorf("REP bytes recv %d, want %d", n, wantReq)
+	}
+	if n := byteCount(t, srep, mangos.OptionBytesSent); n != wantRep {
+		t.Errorf("REP bytes sent %d, want %d", n, wantRep)
+	}
+	if n := byteCount(t, sreq, mangos.OptionBytesRecv); n != wantRep {
+		t.Errorf("REQ bytes recv %d, want %d", n, wantRep)
+	}
+
+	if err = sreq.SetOption(mangos.OptionBytesSent, uint64(0)); err == nil {
+		t.Errorf("OptionBytesSent should be read-only")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newStreamPair(t *testing.T, chunk int) (mangos.Socket, mangos.Socket) {
+	addr := AddrTestInp()
+	a, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	a.AddTransport(inproc.NewTransport())
+	b, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	b.AddTransport(inproc.NewTransport())
+	for _, s := range []mangos.Socket{a, b} {
+		if err = s.SetOption(mangos.OptionStreamChunkSize, chunk); err != nil {
+			t.Fatalf("SetOption: %v", err)
+		}
+		s.SetOption(mangos.OptionRecvDeadline, 5*time.Second)
+	}
+	if err = a.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err = b.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return a, b
+}
+
+func TestSendStreamLarge(t *testing.T) {
+	const total = 100 * 1024 * 1024
+	a, b := newStreamPair(t, 32*1024)
+	defer a.Close()
+	defer b.Close()
+
+	// Watch the heap while the stream runs.
+	var peak uint64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > peak {
+				peak = ms.HeapInuse
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+
+	src := io.LimitReader(rand.New(rand.NewSource(1)), total)
+	sum := crc32.NewIEEE()
+	errq := make(chan error, 1)
+	go func() {
+		errq <- mangos.SendStream(a, io.TeeReader(src, sum))
+	}()
+
+	got := crc32.NewIEEE()
+	n, err := mangos.RecvStream(b, got)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("RecvStream failed after %d bytes: %v", n, err)
+	}
+	if err = <-errq; err != nil {
+		t.Fatalf("SendStream: %v", err)
+	}
+	if n != total {
+		t.Errorf("Received %d bytes, expected %d", n, total)
+	}
+	if got.Sum32() != sum.Sum32() {
+		t.Errorf("Checksum mismatch")
+	}
+	if peak > total/2 {
+		t.Errorf("Heap reached %d bytes", peak)
+	}
+	t.Logf("Peak heap in use %d bytes", peak)
+}
+
+func TestSendStreamSmall(t *testing.T) {
+	a, b := newStreamPair(t, 4)
+	defer a.Close()
+	defer b.Close()
+
+	for _, data := range []string{"", "abc", "abcd", "hello, world"} {
+		if err := mangos.SendStream(a, bytes.NewReader([]byte(data))); err != nil {
+			t.Fatalf("SendStream: %v", err)
+		}
+		var out bytes.Buffer
+		if _, err := mangos.RecvStream(b, &out); err != nil {
+			t.Fatalf("RecvStream: %v", err)
+		}
+		if out.String() != data {
+			t.Errorf("Got %q, expected %q", out.String(), data)
+		}
+	}
+
+	// An ordinary message is not a stream.
+	a.Send([]byte("no"))
+	if _, err := mangos.RecvStream(b, &bytes.Buffer{}); err != mangos.ErrGarbled {
+		t.Errorf("Expected ErrGarbled, got %v", err)
+	}
+}
+
+func TestSendStreamDisabled(t *testing.T) {
+	a, b := newStreamPair(t, 0)
+	defer a.Close()
+	defer b.Close()
+	if err := mangos.SendStream(a, bytes.NewReader(nil)); err != mangos.ErrProtoOp {
+		t.Errorf("SendStream got %v", err)
+	}
+	if _, err := mangos.RecvStream(b, &bytes.Buffer{}); err != mangos.ErrProtoOp {
+		t.Errorf("RecvStream got %v", err)
+	}
+	if err := a.SetOption(mangos.OptionStreamChunkSize, -1); err != mangos.ErrBadValue {
+		t.Errorf("Negative chunk size got %v", err)
+	}
+}
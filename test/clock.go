@@ -0,0 +1,105 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"time"
+
+	"nanomsg.org/go-mangos"
+)
+
+// FakeClock is a mangos.Clock whose notion of time only moves forward when
+// Advance is called.  Timers scheduled against it fire synchronously from
+// within Advance, which lets tests exercise timer driven behavior (such as
+// REQ retries) without sleeping.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	sync.Mutex
+}
+
+type fakeTimer struct {
+	c      *FakeClock
+	when   time.Time
+	f      func()
+	active bool
+}
+
+// NewFakeClock returns a new FakeClock, starting at the current time.
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Now()}
+}
+
+// Now returns the fake clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f to be called once the clock has been advanced
+// by at least d.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) mangos.ClockTimer {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, and runs any timers that have
+// expired as a result, in order of expiration.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	var expired []*fakeTimer
+	for _, t := range c.timers {
+		if t.active && !t.when.After(c.now) {
+			t.active = false
+			expired = append(expired, t)
+		}
+	}
+	c.Unlock()
+
+	for len(expired) > 0 {
+		first := 0
+		for i, t := range expired {
+			if t.when.Before(expired[first].when) {
+				first = i
+			}
+		}
+		t := expired[first]
+		expired = append(expired[:first], expired[first+1:]...)
+		t.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.Lock()
+	defer t.c.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.Lock()
+	defer t.c.Unlock()
+	active := t.active
+	t.when = t.c.now.Add(d)
+	t.active = true
+	return active
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestFakeClockReqRetry(t *testing.T) {
+	addr := AddrTestInp()
+	clock := NewFakeClock()
+
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+
+	if err = sreq.SetOption(mangos.OptionClock, clock); err != nil {
+		t.Fatalf("Failed set clock: %v", err)
+	}
+	if v, err := sreq.GetOption(mangos.OptionClock); err != nil {
+		t.Fatalf("Failed get clock: %v", err)
+	} else if v != mangos.Clock(clock) {
+		t.Fatalf("Clock not retained")
+	}
+	if err = sreq.SetOption(mangos.OptionRetryTime, time.Hour); err != nil {
+		t.Fatalf("Failed set retry: %v", err)
+	}
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	if err = srep.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+
+	if err = sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if _, err = srep.Recv(); err != nil {
+		t.Fatalf("Failed first recv: %v", err)
+	}
+
+	// Nothing should be resent until the clock moves past the retry.
+	clock.Advance(time.Minute)
+	if err = srep.SetOption(mangos.OptionRecvDeadline, time.Millisecond*50); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if _, err = srep.Recv(); err != mangos.ErrRecvTimeout {
+		t.Fatalf("Premature resend: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if err = srep.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	v, err := srep.Recv()
+	if err != nil {
+		t.Fatalf("Failed to get resend: %v", err)
+	}
+	if !bytes.Equal(v, []byte("ping")) {
+		t.Fatalf("Got wrong message: %v", v)
+	}
+}
+
+func TestFakeClockBadValue(t *testing.T) {
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionClock, "garbage"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestCloseListenerKeepsPipes(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	cli, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = srv.CloseListener(addr); err != nil {
+		t.Fatalf("CloseListener failed: %v", err)
+	}
+	if err = srv.CloseListener(addr); err != mangos.ErrBadAddr {
+		t.Errorf("Expected ErrBadAddr on second close, got %v", err)
+	}
+
+	// New connections are refused.
+	if c, err := net.Dial("tcp", strings.TrimPrefix(addr, "tcp://")); err == nil {
+		c.Close()
+		t.Errorf("Dial succeeded after CloseListener")
+	}
+
+	// But the existing connection still works, both ways.
+	for _, s := range []mangos.Socket{srv, cli} {
+		if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+			t.Fatalf("Failed set recv deadline: %v", err)
+		}
+	}
+	if err = cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if b, err := srv.Recv(); err != nil || string(b) != "ping" {
+		t.Fatalf("Failed recv on server: %v", err)
+	}
+	if err = srv.Send([]byte("pong")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if b, err := cli.Recv(); err != nil || string(b) != "pong" {
+		t.Fatalf("Failed recv on client: %v", err)
+	}
+}
+
+func TestCloseListenerStopsServing(t *testing.T) {
+	// Counted, as other tests' sockets may be serving still.
+	serving := func() int {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		return strings.Count(string(buf), "(*listener).serve")
+	}
+	before := serving()
+
+	addr := AddrTestTCP()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	for i := 0; i < 100 && serving() == before; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := serving(); n != before+1 {
+		t.Fatalf("Expected %d listeners serving, got %d", before+1, n)
+	}
+	if err = srv.CloseListener(addr); err != nil {
+		t.Fatalf("CloseListener failed: %v", err)
+	}
+
+	// The goroutine accepting for the listener goes, rather than
+	// spinning on the closed net listener until the socket closes.
+	for i := 0; i < 100 && serving() > before; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if serving() > before {
+		t.Errorf("Listener still serving after CloseListener")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// reasonSocket is a PAIR socket reporting its ports as they are added,
+// and their close reasons as they are removed.
+type reasonSocket struct {
+	mangos.Socket
+	added   chan mangos.Port
+	removed chan mangos.CloseReason
+}
+
+func newReasonSocket(t *testing.T, accept bool) *reasonSocket {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	return wrapReasonSocket(s, accept)
+}
+
+// wrapReasonSocket makes a reasonSocket of any socket, using TCP.
+func wrapReasonSocket(s mangos.Socket, accept bool) *reasonSocket {
+	s.AddTransport(tcp.NewTransport())
+	s.SetOption(mangos.OptionReconnectTime, time.Hour)
+	rs := &reasonSocket{
+		Socket:  s,
+		added:   make(chan mangos.Port, 4),
+		removed: make(chan mangos.CloseReason, 4),
+	}
+	s.SetPortHook(func(act mangos.PortAction, p mangos.Port) bool {
+		switch act {
+		case mangos.PortActionAdd:
+			rs.added <- p
+			return accept
+		case mangos.PortActionRemove:
+			rs.removed <- p.CloseReason()
+		}
+		return true
+	})
+	return rs
+}
+
+func (rs *reasonSocket) port(t *testing.T) mangos.Port {
+	select {
+	case p := <-rs.added:
+		return p
+	case <-time.After(time.Second):
+		t.Fatalf("No port added")
+	}
+	return nil
+}
+
+func (rs *reasonSocket) expect(t *testing.T, side string, want mangos.CloseReason) {
+	select {
+	case r := <-rs.removed:
+		if r != want {
+			t.Errorf("%s: close reason %q, want %q", side, r, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("%s: no port removed", side)
+	}
+}
+
+func reasonPair(t *testing.T, srvAccept bool) (*reasonSocket, *reasonSocket) {
+	addr := AddrTestTCP()
+	srv := newReasonSocket(t, srvAccept)
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	cli := newReasonSocket(t, true)
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	return srv, cli
+}
+
+func TestCloseReasonLocal(t *testing.T) {
+	srv, cli := reasonPair(t, true)
+	defer srv.Close()
+	defer cli.Close()
+	srv.port(t)
+	p := cli.port(t)
+	if r := p.CloseReason(); r != mangos.CloseReasonNone {
+		t.Errorf("Open port reports %q", r)
+	}
+	p.Close()
+	cli.expect(t, "client", mangos.CloseReasonLocal)
+	srv.expect(t, "server", mangos.CloseReasonPeer)
+}
+
+func TestCloseReasonShutdown(t *testing.T) {
+	srv, cli := reasonPair(t, true)
+	defer srv.Close()
+	srv.port(t)
+	cli.port(t)
+	cli.Close()
+	cli.expect(t, "client", mangos.CloseReasonShutdown)
+	srv.expect(t, "server", mangos.CloseReasonPeer)
+}
+
+func TestCloseReasonRejected(t *testing.T) {
+	srv, cli := reasonPair(t, false)
+	defer srv.Close()
+	defer cli.Close()
+	p := srv.port(t)
+	cli.port(t)
+	cli.expect(t, "client", mangos.CloseReasonPeer)
+	if r := p.CloseReason(); r != mangos.CloseReasonRejected {
+		t.Errorf("Rejected port reports %q", r)
+	}
+}
+
+func TestCloseReasonTooLong(t *testing.T) {
+	addr := AddrTestTCP()
+	srv := newReasonSocket(t, true)
+	defer srv.Close()
+	srv.SetOption(mangos.OptionMaxRecvSize, 16)
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	cli := newReasonSocket(t, true)
+	defer cli.Close()
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	srv.port(t)
+	cli.port(t)
+	if err := cli.Send(make([]byte, 100)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	srv.expect(t, "server", mangos.CloseReasonTooLong)
+}
+
+func TestCloseReasonIOError(t *testing.T) {
+	srv, cli := reasonPair(t, true)
+	defer srv.Close()
+	defer cli.Close()
+	srv.port(t)
+	p := cli.port(t)
+	// Pull the connection out from under the pipe.
+	mangos.NetConn(p).Close()
+	cli.expect(t, "client", mangos.CloseReasonIOError)
+	srv.expect(t, "server", mangos.CloseReasonPeer)
+}
+
+func TestCloseReasonString(t *testing.T) {
+	if s := mangos.CloseReasonTooLong.String(); s != "message too long" {
+		t.Errorf("Got %q", s)
+	}
+	if s := mangos.CloseReason(99).String(); s != "unknown" {
+		t.Errorf("Got %q", s)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/protocol/respondent"
+	"nanomsg.org/go-mangos/protocol/star"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/protocol/surveyor"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/udp"
+)
+
+func TestCloseWaitOption(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionCloseWait, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionCloseWait, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionCloseWait); err != nil || v != true {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+}
+
+func TestCloseWaitNoLeaks(t *testing.T) {
+	type maker func() (mangos.Socket, error)
+	pairs := []struct {
+		name     string
+		srv, cli maker
+	}{
+		{"pair", pair.NewSocket, pair.NewSocket},
+		{"reqrep", rep.NewSocket, req.NewSocket},
+		{"pubsub", pub.NewSocket, sub.NewSocket},
+		{"pushpull", pull.NewSocket, push.NewSocket},
+		{"survey", surveyor.NewSocket, respondent.NewSocket},
+		{"bus", bus.NewSocket, bus.NewSocket},
+		{"star", star.NewSocket, star.NewSocket},
+	}
+	addrs := []func() string{AddrTestInp, AddrTestTCP, AddrTestUDP}
+
+	// Goroutines left behind by earlier tests can only go away while
+	// we run, so this can only hide leaks, not invent them.
+	before := runtime.NumGoroutine()
+	for _, pp := range pairs {
+		for _, addrfn := range addrs {
+			addr := addrfn()
+			var socks []mangos.Socket
+			for _, fn := range []maker{pp.srv, pp.cli} {
+				s, err := fn()
+				if err != nil {
+					t.Fatalf("NewSocket: %v", err)
+				}
+				s.AddTransport(inproc.NewTransport())
+				s.AddTransport(tcp.NewTransport())
+				s.AddTransport(udp.NewTransport())
+				s.SetOption(mangos.OptionCloseWait, true)
+				s.SetOption(mangos.OptionLinger, time.Duration(0))
+				socks = append(socks, s)
+			}
+			if pp.name == "pubsub" {
+				socks[1].SetOption(mangos.OptionSubscribe, []byte{})
+				socks[1].SetOption(mangos.OptionSubMatchWorkers, 2)
+			}
+			if err := socks[0].Listen(addr); err != nil {
+				t.Fatalf("%s Listen %s: %v", pp.name, addr, err)
+			}
+			if err := socks[1].Dial(addr); err != nil {
+				t.Fatalf("%s Dial %s: %v", pp.name, addr, err)
+			}
+			for _, s := range socks {
+				for i := 0; len(s.Endpoints()) == 0; i++ {
+					if i == 500 {
+						t.Fatalf("%s over %s did not connect",
+							pp.name, addr)
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+			// Something in flight, so the senders have work.
+			socks[0].SetOption(mangos.OptionBestEffort, true)
+			socks[0].Send([]byte("hello"))
+
+			if n := runtime.NumGoroutine(); n <= before {
+				t.Fatalf("Only %d goroutines with sockets open", n)
+			}
+			for _, s := range socks {
+				if err := s.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+			}
+			if n := runtime.NumGoroutine(); n > before {
+				buf := make([]byte, 1<<20)
+				buf = buf[:runtime.Stack(buf, true)]
+				t.Fatalf("%s over %s: %d goroutines after Close, "+
+					"%d before\n%s", pp.name, addr, n, before, buf)
+			}
+		}
+	}
+}
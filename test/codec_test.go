@@ -0,0 +1,162 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+type codecQuery struct {
+	Name  string
+	Count int
+}
+
+type codecAnswer struct {
+	Items []string
+	Total int `json:"total"`
+}
+
+func newCodecPair(t *testing.T) (mangos.Socket, mangos.Socket) {
+	addr := AddrTestInp()
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	srep.AddTransport(inproc.NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	sreq.AddTransport(inproc.NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	return sreq, srep
+}
+
+func TestCodecJSONReqRep(t *testing.T) {
+	sreq, srep := newCodecPair(t)
+	defer sreq.Close()
+	defer srep.Close()
+
+	go func() {
+		q, err := mangos.RecvJSON[codecQuery](srep)
+		if err != nil {
+			t.Errorf("Server recv failed: %v", err)
+			return
+		}
+		a := codecAnswer{Total: q.Count}
+		for i := 0; i < q.Count; i++ {
+			a.Items = append(a.Items, q.Name)
+		}
+		if err = mangos.SendJSON(srep, a); err != nil {
+			t.Errorf("Server send failed: %v", err)
+		}
+	}()
+
+	if err := mangos.SendJSON(sreq, codecQuery{Name: "x", Count: 3}); err != nil {
+		t.Fatalf("Client send failed: %v", err)
+	}
+	a, err := mangos.RecvJSON[codecAnswer](sreq)
+	if err != nil {
+		t.Fatalf("Client recv failed: %v", err)
+	}
+	if a.Total != 3 || len(a.Items) != 3 || a.Items[2] != "x" {
+		t.Errorf("Bad answer: %+v", a)
+	}
+}
+
+func TestCodecDecodeError(t *testing.T) {
+	sreq, srep := newCodecPair(t)
+	defer sreq.Close()
+	defer srep.Close()
+
+	if err := sreq.Send([]byte("not json")); err != nil {
+		t.Fatalf("Client send failed: %v", err)
+	}
+	_, err := mangos.RecvJSON[codecQuery](srep)
+	if _, ok := err.(*json.SyntaxError); !ok {
+		t.Errorf("Expected a JSON syntax error, got %v", err)
+	}
+}
+
+// angleCodec wraps strings in angle brackets, to exercise registration.
+type angleCodec struct{}
+
+func (angleCodec) Marshal(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, mangos.ErrBadValue
+	}
+	return []byte("<" + s + ">"), nil
+}
+
+func (angleCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*string)
+	if !ok || len(data) < 2 {
+		return mangos.ErrBadValue
+	}
+	*p = string(data[1 : len(data)-1])
+	return nil
+}
+
+func TestCodecRegister(t *testing.T) {
+	if mangos.LookupCodec("json") != mangos.JSONCodec {
+		t.Errorf("JSON codec not registered")
+	}
+	mangos.RegisterCodec("angle", angleCodec{})
+	defer mangos.RegisterCodec("angle", nil)
+
+	sreq, srep := newCodecPair(t)
+	defer sreq.Close()
+	defer srep.Close()
+
+	c := mangos.LookupCodec("angle")
+	if c == nil {
+		t.Fatalf("Codec not found")
+	}
+	if err := mangos.SendTyped(sreq, c, "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	m, err := srep.RecvMsg()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if string(m.Body) != "<hello>" {
+		t.Errorf("Bad encoding %q", string(m.Body))
+	}
+	m.Free()
+	if err = mangos.SendTyped(srep, c, "world"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if s, err := mangos.RecvTyped[string](sreq, c); err != nil || s != "world" {
+		t.Errorf("Got %q, %v; expected world", s, err)
+	}
+}
@@ -617,46 +617,6 @@ func RunTests(t *testing.T, addr string, cases []TestCase) {
 	}
 }
 
-// We have to expose these, so that device tests can use them.
-
-var currPort uint16
-var currLock sync.Mutex
-
-func init() {
-	currPort = uint16(time.Now().UnixNano()%20000 + 20000)
-}
-
-func NextPort() uint16 {
-	currLock.Lock()
-	defer currLock.Unlock()
-	p := currPort
-	currPort++
-	return p
-}
-
-func AddrTestIPC() string {
-	return (fmt.Sprintf("ipc://mangostest%d", NextPort()))
-}
-
-func AddrTestWSS() string {
-	return (fmt.Sprintf("wss://127.0.0.1:%d/", NextPort()))
-}
-
-func AddrTestWS() string {
-	return (fmt.Sprintf("ws://127.0.0.1:%d/", NextPort()))
-}
-func AddrTestTCP() string {
-	return (fmt.Sprintf("tcp://127.0.0.1:%d", NextPort()))
-}
-
-func AddrTestTLS() string {
-	return (fmt.Sprintf("tls+tcp://127.0.0.1:%d", NextPort()))
-}
-
-func AddrTestInp() string {
-	return (fmt.Sprintf("inproc://test_%d", NextPort()))
-}
-
 // RunTestsTCP runs the TCP tests.
 func RunTestsTCP(t *testing.T, cases []TestCase) {
 	RunTests(t, AddrTestTCP(), cases)
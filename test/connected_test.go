@@ -0,0 +1,72 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestOptionConnected(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(inproc.NewTransport())
+
+	connected := func() bool {
+		v, err := srv.GetOption(mangos.OptionConnected)
+		if err != nil {
+			t.Fatalf("GetOption: %v", err)
+		}
+		return v.(bool)
+	}
+	waitFor := func(want bool) {
+		for i := 0; connected() != want; i++ {
+			if i == 100 {
+				t.Fatalf("Connected did not become %v", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err = srv.SetOption(mangos.OptionConnected, true); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption, got %v", err)
+	}
+
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if connected() {
+		t.Errorf("Connected with no peers")
+	}
+	cli, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(inproc.NewTransport())
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	waitFor(true)
+	cli.Close()
+	waitFor(false)
+}
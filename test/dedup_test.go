@@ -0,0 +1,113 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// dedupRecv sends the given sequence numbers to a PULL socket in ack mode,
+// from a PUSH that does not itself use ack mode (so that we control the
+// sequence numbers), and returns the positions in seqs of the messages the
+// application receives.
+func dedupRecv(t *testing.T, window int, seqs ...uint32) []int {
+	addr := AddrTestInp()
+	rx, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(inproc.NewTransport())
+	rx.SetOption(mangos.OptionAckMode, true)
+	rx.SetOption(mangos.OptionRecvDeadline, 100*time.Millisecond)
+	if err = rx.SetOption(mangos.OptionDedupWindow, window); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	for i, seq := range seqs {
+		b := make([]byte, 5)
+		binary.BigEndian.PutUint32(b, seq)
+		b[4] = byte(i)
+		if err = tx.Send(b); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	var got []int
+	for {
+		b, err := rx.Recv()
+		if err != nil {
+			break
+		}
+		if len(b) != 1 {
+			t.Fatalf("Got body %v", b)
+		}
+		got = append(got, int(b[0]))
+	}
+	return got
+}
+
+func TestPullDedup(t *testing.T) {
+	if got := dedupRecv(t, 0, 1, 1, 2); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("Without dedup got %v", got)
+	}
+	if got := dedupRecv(t, 4, 1, 1, 2, 1); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("With dedup got %v", got)
+	}
+	// Once it leaves the window, a sequence number is accepted again.
+	if got := dedupRecv(t, 2, 1, 2, 3, 1); !reflect.DeepEqual(got, []int{0, 1, 2, 3}) {
+		t.Errorf("With small window got %v", got)
+	}
+}
+
+func TestPullDedupOption(t *testing.T) {
+	s, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	if v, err := s.GetOption(mangos.OptionDedupWindow); err != nil || v.(int) != 0 {
+		t.Errorf("Default got %v, %v", v, err)
+	}
+	if err = s.SetOption(mangos.OptionDedupWindow, -1); err != mangos.ErrBadValue {
+		t.Errorf("Negative window got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionDedupWindow, 16); err != nil {
+		t.Errorf("SetOption: %v", err)
+	}
+	if v, _ := s.GetOption(mangos.OptionDedupWindow); v.(int) != 16 {
+		t.Errorf("Got %v, expected 16", v)
+	}
+}
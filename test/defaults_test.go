@@ -0,0 +1,152 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/tlstcp"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	if err := mangos.SetDefaultOption(mangos.OptionRecvDeadline, "1s"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := mangos.SetDefaultOption(mangos.OptionBytesSent, uint64(0)); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption, got %v", err)
+	}
+
+	cfg := &tls.Config{ServerName: "example.com"}
+	defaults := map[string]interface{}{
+		mangos.OptionRecvDeadline: time.Millisecond * 123,
+		mangos.OptionTTL:          4, // REP, but not PUB
+		mangos.OptionTLSConfig:    cfg,
+	}
+	for n, v := range defaults {
+		if err := mangos.SetDefaultOption(n, v); err != nil {
+			t.Fatalf("SetDefaultOption %s: %v", n, err)
+		}
+		defer mangos.SetDefaultOption(n, nil)
+	}
+	if n := len(mangos.DefaultOptions()); n != 3 {
+		t.Errorf("Expected 3 defaults, got %d", n)
+	}
+
+	s, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	for _, n := range []string{mangos.OptionRecvDeadline, mangos.OptionTTL} {
+		if v, err := s.GetOption(n); err != nil || v != defaults[n] {
+			t.Errorf("Option %s: %v %v", n, v, err)
+		}
+	}
+	// The TLS configuration is a transport option.
+	s.AddTransport(tlstcp.NewTransport())
+	d, err := s.NewDialer(AddrTestTLS(), nil)
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionTLSConfig); err != nil || v != cfg {
+		t.Errorf("TLS config: %v %v", v, err)
+	}
+
+	// Each socket can still override them, and other protocols skip
+	// what they do not use.
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	if v, _ := p.GetOption(mangos.OptionRecvDeadline); v != defaults[mangos.OptionRecvDeadline] {
+		t.Errorf("PUB did not inherit the deadline: %v", v)
+	}
+	p.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if v, _ := p.GetOption(mangos.OptionRecvDeadline); v != time.Second {
+		t.Errorf("Override lost: %v", v)
+	}
+
+	// Removing a default leaves sockets that have it alone, and later
+	// ones without.
+	mangos.SetDefaultOption(mangos.OptionRecvDeadline, nil)
+	if v, _ := s.GetOption(mangos.OptionRecvDeadline); v != defaults[mangos.OptionRecvDeadline] {
+		t.Errorf("Existing socket changed: %v", v)
+	}
+	s2, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s2.Close()
+	if v, _ := s2.GetOption(mangos.OptionRecvDeadline); v != time.Duration(0) {
+		t.Errorf("Removed default still applied: %v", v)
+	}
+}
+
+func TestDefaultOptionsResolved(t *testing.T) {
+	cfg := &tls.Config{ServerName: "example.com"}
+	if err := mangos.SetDefaultOption(mangos.OptionTLSConfig, cfg); err != nil {
+		t.Fatalf("SetDefaultOption: %v", err)
+	}
+	defer mangos.SetDefaultOption(mangos.OptionTLSConfig, nil)
+
+	addr := AddrTestTCP()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	// The name resolves to a tcp address, to which the TLS default does
+	// not apply, so the dialer goes ahead without it.
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+	cli.AddResolver("disco", mangos.ResolverFunc(func(string) ([]string, error) {
+		return []string{addr}, nil
+	}))
+	if err = cli.Dial("disco://server"); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err = cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	b, err := srv.Recv()
+	if err != nil || string(b) != "ping" {
+		t.Fatalf("Server got %q, %v", b, err)
+	}
+	if err = srv.Send([]byte("pong")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err = cli.Recv(); err != nil || string(b) != "pong" {
+		t.Errorf("Client got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestDialAttempts(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	s.SetOption(mangos.OptionReconnectTime, 10*time.Millisecond)
+
+	type event struct {
+		err   error
+		final bool
+	}
+	var lk sync.Mutex
+	var events []event
+	hook := func(d mangos.Dialer, err error, final bool) {
+		lk.Lock()
+		events = append(events, event{err, final})
+		lk.Unlock()
+	}
+	count := func() int {
+		lk.Lock()
+		defer lk.Unlock()
+		return len(events)
+	}
+
+	// Nothing is listening on the address.
+	d, err := s.NewDialer(AddrTestTCP(), map[string]interface{}{
+		mangos.OptionDialAttempts: 1,
+		mangos.OptionDialHook:     hook,
+	})
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionDialAttempts, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.Dial(); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	for i := 0; i < 100 && count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Well past several reconnect intervals, there were no more tries.
+	time.Sleep(200 * time.Millisecond)
+
+	lk.Lock()
+	if len(events) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(events))
+	} else if events[0].err == nil || !events[0].final {
+		t.Errorf("Bad event %+v", events[0])
+	}
+	lk.Unlock()
+
+	// Having given up, the dialer can be started again.
+	if err = d.Dial(); err != nil {
+		t.Fatalf("Dial again: %v", err)
+	}
+	for i := 0; i < 100 && count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+}
+
+func TestDialAttemptsSeveral(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	s.SetOption(mangos.OptionReconnectTime, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	finals, fails := 0, 0
+	hook := func(d mangos.Dialer, err error, final bool) {
+		fails++
+		if final {
+			finals++
+			close(done)
+		}
+	}
+	err = s.DialOptions(AddrTestTCP(), map[string]interface{}{
+		mangos.OptionDialAttempts: 3,
+		mangos.OptionDialHook:     hook,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Dialer did not give up")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if fails != 3 || finals != 1 {
+		t.Errorf("Got %d failures, %d final", fails, finals)
+	}
+}
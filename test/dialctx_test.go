@@ -0,0 +1,187 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestDialContextCancelRetry(t *testing.T) {
+	// A listener that is not SP: every attempt fails the handshake, so
+	// the dialer keeps retrying.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	var attempts int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&attempts, 1)
+			c.Close()
+		}
+	}()
+
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	s.SetOption(mangos.OptionReconnectTime, time.Millisecond*5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.DialContext(ctx, "tcp://"+l.Addr().String()); err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&attempts) < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := atomic.LoadInt32(&attempts); n < 3 {
+		t.Fatalf("Only %d dial attempts", n)
+	}
+
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	before := atomic.LoadInt32(&attempts)
+	time.Sleep(time.Millisecond * 100)
+	if after := atomic.LoadInt32(&attempts); after != before {
+		t.Errorf("Dialer still retrying after cancel: %d -> %d", before, after)
+	}
+
+	// The dialer is gone, so the address can be dialed afresh.
+	if err := s.Dial("tcp://" + l.Addr().String()); err != nil {
+		t.Errorf("Redial failed: %v", err)
+	}
+}
+
+func TestDialContextCancelConnected(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	portq := make(chan mangos.PortAction, 4)
+	srv.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		portq <- a
+		return true
+	})
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	c, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer c.Close()
+	c.AddTransport(tcp.NewTransport())
+	c.SetOption(mangos.OptionReconnectTime, time.Millisecond*5)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.DialContext(ctx, addr); err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	expect := func(a mangos.PortAction) {
+		select {
+		case got := <-portq:
+			if got != a {
+				t.Fatalf("Got port action %v, expected %v", got, a)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing port action %v", a)
+		}
+	}
+	expect(mangos.PortActionAdd)
+
+	// Cancelling takes the connection down, and it stays down.
+	cancel()
+	expect(mangos.PortActionRemove)
+	select {
+	case a := <-portq:
+		t.Errorf("Unexpected port action %v after cancel", a)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestDialContextDone(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.DialContext(ctx, AddrTestTCP()); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDialContextGiveUp(t *testing.T) {
+	final := make(chan bool, 1)
+	mangos.SetDefaultOption(mangos.OptionDialAttempts, 1)
+	mangos.SetDefaultOption(mangos.OptionDialHook,
+		mangos.DialHook(func(d mangos.Dialer, err error, last bool) {
+			if last {
+				final <- true
+			}
+		}))
+	defer mangos.SetDefaultOption(mangos.OptionDialAttempts, nil)
+	defer mangos.SetDefaultOption(mangos.OptionDialHook, nil)
+
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+
+	// Nothing is listening, so the dialer gives up at once; the
+	// goroutine watching the context must go with it.
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.DialContext(ctx, AddrTestTCP()); err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	select {
+	case <-final:
+	case <-time.After(time.Second):
+		t.Fatalf("Dialer did not give up")
+	}
+	n := runtime.NumGoroutine()
+	for i := 0; i < 100 && n > before; i++ {
+		time.Sleep(time.Millisecond * 10)
+		n = runtime.NumGoroutine()
+	}
+	if n > before {
+		t.Errorf("Goroutines left after giving up: %d, was %d", n, before)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/protocol/star"
+)
+
+func TestDropHookBestEffort(t *testing.T) {
+	s, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.SetOption(mangos.OptionWriteQLen, 1)
+	s.SetOption(mangos.OptionBestEffort, true)
+	s.SetOption(mangos.OptionLinger, time.Duration(0))
+
+	type drop struct {
+		body   string
+		reason mangos.DropReason
+	}
+	var drops []drop
+	hook := func(m *mangos.Message, r mangos.DropReason) {
+		drops = append(drops, drop{string(m.Body), r})
+	}
+	if old := s.SetDropHook(hook); old != nil {
+		t.Errorf("Expected no previous hook")
+	}
+
+	// With no peers, the first message fills the queue.
+	for _, body := range []string{"kept", "lost"} {
+		if err = s.Send([]byte(body)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if len(drops) != 1 {
+		t.Fatalf("Expected 1 drop, got %v", drops)
+	}
+	if d := drops[0]; d.body != "lost" || d.reason != mangos.DropReasonBestEffort {
+		t.Errorf("Got drop of %q for %v", d.body, d.reason)
+	}
+	if s := drops[0].reason.String(); s != "best effort send" {
+		t.Errorf("Reason string is %q", s)
+	}
+
+	if old := s.SetDropHook(nil); old == nil {
+		t.Errorf("Expected the previous hook back")
+	}
+	s.Send([]byte("quiet"))
+	if len(drops) != 1 {
+		t.Errorf("Hook called after removal")
+	}
+}
+
+// testDropHookQueueFull sends to a single peer that stalls on its first
+// message, so that the rest overflow its queue of one.
+func testDropHookQueueFull(t *testing.T, s mangos.Socket) {
+	defer s.Close()
+	tran := stallTran{releaseq: make(chan struct{}), sent: new(int32)}
+	defer close(tran.releaseq)
+	s.AddTransport(tran)
+	s.SetOption(mangos.OptionWriteQLenPerPipe, 1)
+	var full int32
+	s.SetDropHook(func(m *mangos.Message, r mangos.DropReason) {
+		if r == mangos.DropReasonQueueFull {
+			atomic.AddInt32(&full, 1)
+		}
+	})
+	if err := s.Dial("stall://nowhere"); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.Send([]byte("stalls")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if err := s.Send([]byte("more")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&full); n != 3 {
+		t.Errorf("Expected 3 drops for a full queue, got %d", n)
+	}
+}
+
+func TestDropHookQueueFullBus(t *testing.T) {
+	s, err := bus.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	testDropHookQueueFull(t, s)
+}
+
+func TestDropHookQueueFullStar(t *testing.T) {
+	s, err := star.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	testDropHookQueueFull(t, s)
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// waitEndpoints polls until the socket has n endpoints, and returns their
+// sorted addresses.
+func waitEndpoints(t *testing.T, s mangos.Socket, n int) []string {
+	var eps []mangos.Endpoint
+	for i := 0; i < 200; i++ {
+		if eps = s.Endpoints(); len(eps) == n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(eps) != n {
+		t.Fatalf("Got %d endpoints, expected %d", len(eps), n)
+	}
+	addrs := make([]string, 0, n)
+	for i, ep := range eps {
+		if i > 0 && eps[i-1].GetID() >= ep.GetID() {
+			t.Errorf("Endpoints not ordered by ID")
+		}
+		addrs = append(addrs, ep.(mangos.Port).Address())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestSocketEndpoints(t *testing.T) {
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	p.AddTransport(tcp.NewTransport())
+	if eps := p.Endpoints(); len(eps) != 0 {
+		t.Errorf("New socket has %d endpoints", len(eps))
+	}
+
+	var addrs []string
+	var pulls []mangos.Socket
+	for i := 0; i < 3; i++ {
+		addr := AddrTestTCP()
+		s, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(tcp.NewTransport())
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		if err = p.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		addrs = append(addrs, addr)
+		pulls = append(pulls, s)
+	}
+	sort.Strings(addrs)
+
+	got := waitEndpoints(t, p, 3)
+	for i := range addrs {
+		if got[i] != addrs[i] {
+			t.Errorf("Got addresses %v, expected %v", got, addrs)
+			break
+		}
+	}
+
+	// The result is a snapshot.
+	eps := p.Endpoints()
+	eps[0] = nil
+	if p.Endpoints()[0] == nil {
+		t.Errorf("Snapshot shares storage with the socket")
+	}
+
+	pulls[0].Close()
+	waitEndpoints(t, p, 2)
+
+	p.Close()
+	if eps := p.Endpoints(); len(eps) != 0 {
+		t.Errorf("Closed socket has %d endpoints", len(eps))
+	}
+}
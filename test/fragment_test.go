@@ -0,0 +1,246 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/udp"
+)
+
+// bigBody returns a recognizable body of n bytes.
+func bigBody(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestFragmentUDP(t *testing.T) {
+	addr := AddrTestUDP()
+	var socks []mangos.Socket
+	for i := 0; i < 2; i++ {
+		s, err := pair.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(udp.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, 200*time.Millisecond)
+		if err = s.SetOption(mangos.OptionFragment, 1); err != mangos.ErrBadValue {
+			t.Errorf("Expected ErrBadValue, got %v", err)
+		}
+		socks = append(socks, s)
+	}
+	rx, tx := socks[0], socks[1]
+	if err := rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Too big for a datagram, so lost without fragmentation.
+	body := bigBody(80000)
+	if err := tx.Send(body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := rx.Recv(); err != mangos.ErrRecvTimeout {
+		t.Fatalf("Expected a timeout, got %d bytes, %v", len(b), err)
+	}
+
+	for _, s := range socks {
+		if err := s.SetOption(mangos.OptionFragment, true); err != nil {
+			t.Fatalf("SetOption: %v", err)
+		}
+		if v, err := s.GetOption(mangos.OptionFragment); err != nil || v != true {
+			t.Errorf("GetOption got %v, %v", v, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, n := range []int{80000, 100, 1500} {
+		body = bigBody(n)
+		if err := tx.Send(body); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv of %d bytes: %v", n, err)
+		}
+		if !bytes.Equal(b, body) {
+			t.Errorf("Got %d bytes back, not the %d sent", len(b), n)
+		}
+	}
+}
+
+func TestFragmentEarlySend(t *testing.T) {
+	addr := AddrTestUDP()
+	var socks []mangos.Socket
+	for i := 0; i < 2; i++ {
+		s, err := pair.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(udp.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		s.SetOption(mangos.OptionFragment, true)
+		socks = append(socks, s)
+	}
+	rx, tx := socks[0], socks[1]
+	if err := rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	// Sent at once, before the hellos can have been exchanged, yet
+	// still split rather than lost.
+	body := bigBody(80000)
+	if err := tx.Send(body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	b, err := rx.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Errorf("Got %d bytes back, not the %d sent", len(b), len(body))
+	}
+}
+
+func TestFragmentReqRep(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	for _, s := range []mangos.Socket{srv, cli} {
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		s.SetOption(mangos.OptionFragment, true)
+	}
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Several frames each way, with the protocol headers split off
+	// along with the rest.
+	body := bigBody(3*mangos.DefaultFrameSizeHint + 5)
+	if err = cli.Send(body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	b, err := srv.Recv()
+	if err != nil {
+		t.Fatalf("Recv request: %v", err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Errorf("Request of %d bytes arrived as %d", len(body), len(b))
+	}
+	if err = srv.Send(b[:len(b)-1]); err != nil {
+		t.Fatalf("Send reply: %v", err)
+	}
+	if b, err = cli.Recv(); err != nil {
+		t.Fatalf("Recv reply: %v", err)
+	}
+	if !bytes.Equal(b, body[:len(body)-1]) {
+		t.Errorf("Reply arrived as %d bytes", len(b))
+	}
+}
+
+// fragFrame builds a fragment as OptionFragment puts it on the wire.
+func fragFrame(id uint32, idx, count uint16, part string) []byte {
+	b := []byte("\x00\x00\x00\x00MANGOS-FRAG")
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = binary.BigEndian.AppendUint16(b, idx)
+	b = binary.BigEndian.AppendUint16(b, count)
+	return append(b, part...)
+}
+
+func TestFragmentMalformed(t *testing.T) {
+	addr := AddrTestInp()
+	var socks []mangos.Socket
+	for i := 0; i < 2; i++ {
+		s, err := pair.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		socks = append(socks, s)
+	}
+	rx, tx := socks[0], socks[1]
+	if err := rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Without the option, something that looks like a fragment is just
+	// a message.
+	frame := fragFrame(7, 0, 2, "ab")
+	if err := tx.Send(frame); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := rx.Recv(); err != nil || !bytes.Equal(b, frame) {
+		t.Fatalf("Got %q, %v", b, err)
+	}
+
+	for _, s := range socks {
+		s.SetOption(mangos.OptionFragment, true)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// An index beyond the count given first, and a count that changes,
+	// are thrown away rather than upsetting the receiver.
+	for _, f := range [][]byte{
+		fragFrame(7, 0, 2, "ab"),
+		fragFrame(7, 5, 2, "xx"),
+		fragFrame(7, 1, 3, "yy"),
+		fragFrame(8, 9, 2, "zz"),
+		fragFrame(7, 1, 2, "cd"),
+	} {
+		if err := tx.Send(f); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if b, err := rx.Recv(); err != nil || string(b) != "abcd" {
+		t.Fatalf("Got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// frameHint connects a pair of sockets over addr, and returns the frame
+// size hint reported by each end's Port.
+func frameHint(t *testing.T, tran mangos.Transport, addr string) (int, int) {
+	srv, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	cli, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cli.Close()
+
+	srvq := make(chan mangos.Port, 1)
+	cliq := make(chan mangos.Port, 1)
+	for s, q := range map[mangos.Socket]chan mangos.Port{srv: srvq, cli: cliq} {
+		q := q
+		s.AddTransport(tran)
+		s.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+			if a == mangos.PortActionAdd {
+				q <- p
+			}
+			return true
+		})
+	}
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return portHint(t, srvq), portHint(t, cliq)
+}
+
+func portHint(t *testing.T, ports chan mangos.Port) int {
+	select {
+	case p := <-ports:
+		v, err := p.GetProp(mangos.PropFrameSizeHint)
+		if err != nil {
+			t.Fatalf("GetProp failed: %v", err)
+		}
+		return v.(int)
+	case <-time.After(time.Second):
+		t.Fatalf("No connection")
+	}
+	return 0
+}
+
+func TestFrameSizeHintTCP(t *testing.T) {
+	srv, cli := frameHint(t, tcp.NewTransport(), AddrTestTCP())
+	if srv != mangos.DefaultFrameSizeHint || cli != mangos.DefaultFrameSizeHint {
+		t.Errorf("Got hints %d/%d, expected %d", srv, cli, mangos.DefaultFrameSizeHint)
+	}
+}
+
+func TestFrameSizeHintInp(t *testing.T) {
+	srv, cli := frameHint(t, inproc.NewTransport(), AddrTestInp())
+	if srv != mangos.DefaultFrameSizeHint || cli != mangos.DefaultFrameSizeHint {
+		t.Errorf("Got hints %d/%d, expected %d", srv, cli, mangos.DefaultFrameSizeHint)
+	}
+}
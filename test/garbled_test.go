@@ -0,0 +1,127 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestGarbledHeaders(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(inproc.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	var mx sync.Mutex
+	var counts []int
+	var port mangos.Port
+	hook := func(p mangos.Port, n int) {
+		mx.Lock()
+		port = p
+		counts = append(counts, n)
+		mx.Unlock()
+	}
+	if err = srv.SetOption(mangos.OptionGarbledHook, hook); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srv.SetOption(mangos.OptionGarbledLimit, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = srv.SetOption(mangos.OptionGarbledLimit, 3); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	// A raw REQ socket sends whatever it is given, headers and all.
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(inproc.NewTransport())
+	cli.SetOption(mangos.OptionRaw, true)
+	cli.SetOption(mangos.OptionReconnectTime, time.Hour)
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	drops := func() uint64 {
+		v, err := srv.GetOption(mangos.OptionGarbledDrops)
+		if err != nil {
+			t.Fatalf("GetOption: %v", err)
+		}
+		return v.(uint64)
+	}
+	send := func(body ...byte) {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, body...)
+		if err := cli.SendMsg(m); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+	}
+	wait := func(n uint64) {
+		for i := 0; drops() != n; i++ {
+			if i == 100 {
+				t.Fatalf("Expected %d drops, got %d", n, drops())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Too short for a request ID, and a backtrace that never ends.
+	send('h', 'i')
+	send(0, 0, 0, 1, 'h', 'i')
+	wait(2)
+	// A good request still gets through.
+	send(0x80, 0, 0, 1, 'o', 'k')
+	if b, err := srv.Recv(); err != nil || string(b) != "ok" {
+		t.Errorf("Recv: %q %v", b, err)
+	}
+	if n := len(srv.Endpoints()); n != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", n)
+	}
+
+	// One more, and the peer is disconnected.
+	send(0x80, 0)
+	wait(3)
+	for i := 0; len(srv.Endpoints()) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Endpoint not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if len(counts) != 3 || counts[0] != 1 || counts[2] != 3 {
+		t.Errorf("Hook called with %v", counts)
+	}
+	if r := port.CloseReason(); r != mangos.CloseReasonGarbled {
+		t.Errorf("Closed for %v", r)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestBusIdentity(t *testing.T) {
+	addr := AddrTestInp()
+	names := []string{"alice", "bob"}
+	var socks []mangos.Socket
+	for i, name := range names {
+		s, err := bus.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to make BUS: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(inproc.NewTransport())
+		if err = s.SetOption(mangos.OptionIdentity, name); err != nil {
+			t.Fatalf("Failed set identity: %v", err)
+		}
+		if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+			t.Fatalf("Failed set recv deadline: %v", err)
+		}
+		if i == 0 {
+			err = s.Listen(addr)
+		} else {
+			err = s.Dial(addr)
+		}
+		if err != nil {
+			t.Fatalf("Failed connect: %v", err)
+		}
+		socks = append(socks, s)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	for i, s := range socks {
+		if err := s.Send([]byte("hello")); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		m, err := socks[1-i].RecvMsg()
+		if err != nil {
+			t.Fatalf("Failed recv: %v", err)
+		}
+		if string(m.Body) != "hello" {
+			t.Errorf("Bad body %q", m.Body)
+		}
+		if string(m.SenderIdentity()) != names[i] {
+			t.Errorf("Bad identity %q, expected %s", m.SenderIdentity(), names[i])
+		}
+		m.Free()
+	}
+}
+
+func TestSubIdentity(t *testing.T) {
+	addr := AddrTestInp()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	if err = p.SetOption(mangos.OptionIdentity, []byte("station-1")); err != nil {
+		t.Fatalf("Failed set identity: %v", err)
+	}
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	// An empty identity enables receiving identities.
+	if err = s.SetOption(mangos.OptionIdentity, ""); err != nil {
+		t.Fatalf("Failed set identity: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionSubscribe, "weather"); err != nil {
+		t.Fatalf("Failed subscribe: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// The subscription matches the body, not the identity frame.
+	if err = p.Send([]byte("weather: sunny")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	m, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("Failed recv: %v", err)
+	}
+	if string(m.Body) != "weather: sunny" || string(m.SenderIdentity()) != "station-1" {
+		t.Errorf("Bad message %q from %q", m.Body, m.SenderIdentity())
+	}
+	m.Free()
+
+	if err = s.SetOption(mangos.OptionIdentity, 42); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newInprocPair(t testing.TB, addr string, nocopy bool) (mangos.Socket, mangos.Socket) {
+	rx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	rx.AddTransport(inproc.NewTransport())
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	tx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	opts := map[string]interface{}{mangos.OptionInprocNoCopy: nocopy}
+	if err = tx.DialOptions(addr, opts); err != nil {
+		t.Fatalf("DialOptions: %v", err)
+	}
+	return tx, rx
+}
+
+func TestInprocNoCopy(t *testing.T) {
+	for _, nocopy := range []bool{false, true} {
+		tx, rx := newInprocPair(t, AddrTestInp(), nocopy)
+
+		body := []byte("shared body")
+		sm := mangos.NewMessage(0)
+		sm.Body = body
+		if err := tx.SendMsg(sm); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+		m, err := rx.RecvMsg()
+		if err != nil {
+			t.Fatalf("RecvMsg: %v", err)
+		}
+		if string(m.Body) != "shared body" {
+			t.Errorf("Got %q", m.Body)
+		}
+		shared := &m.Body[0] == &body[0]
+		if shared != nocopy {
+			t.Errorf("nocopy %v: body shared %v", nocopy, shared)
+		}
+		// Appends by the receiver must never land in the sender's memory.
+		m.Body = append(m.Body, '!')
+		if &m.Body[0] == &body[0] {
+			t.Errorf("Append reused the sender's buffer")
+		}
+		m.Free()
+		tx.Close()
+		rx.Close()
+	}
+}
+
+func TestInprocNoCopyOption(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	d, err := s.NewDialer(AddrTestInp(), nil)
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionInprocNoCopy, "yes"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.SetOption(mangos.OptionInprocNoCopy, true); err != nil {
+		t.Errorf("SetOption: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionInprocNoCopy); err != nil || v != true {
+		t.Errorf("GetOption: %v %v", v, err)
+	}
+}
+
+func benchmarkInprocCopy(b *testing.B, nocopy bool) {
+	const size = 64 * 1024
+	tx, rx := newInprocPair(b, AddrTestInp(), nocopy)
+	defer tx.Close()
+	defer rx.Close()
+
+	body := make([]byte, size)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			m, err := rx.RecvMsg()
+			if err != nil {
+				b.Errorf("RecvMsg %d: %v", i, err)
+				return
+			}
+			m.Free()
+		}
+	}()
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := mangos.NewMessage(0)
+		m.Body = body
+		if err := tx.SendMsg(m); err != nil {
+			b.Fatalf("SendMsg: %v", err)
+		}
+	}
+	<-done
+	b.StopTimer()
+}
+
+func BenchmarkInprocCopy64K(b *testing.B) {
+	benchmarkInprocCopy(b, false)
+}
+
+func BenchmarkInprocNoCopy64K(b *testing.B) {
+	benchmarkInprocCopy(b, true)
+}
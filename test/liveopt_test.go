@@ -0,0 +1,114 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/tlstcp"
+)
+
+func TestLiveDialerOptions(t *testing.T) {
+	addr := AddrTestTLS()
+
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(tlstcp.NewTransport())
+	l, err := srep.NewListener(addr, map[string]interface{}{
+		mangos.OptionTLSConfig: srvCfg,
+	})
+	if err != nil {
+		t.Fatalf("Failed to make listener: %v", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	// Listener options cannot change once listening.
+	if err = l.SetOption(mangos.OptionTLSConfig, srvCfg); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption, got %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(tlstcp.NewTransport())
+	sreq.SetOption(mangos.OptionReconnectTime, time.Millisecond*10)
+	sreq.SetOption(mangos.OptionMaxReconnectTime, time.Millisecond*10)
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Millisecond*200)
+
+	// This configuration does not trust the server certificate, so
+	// every connection attempt fails.
+	d, err := sreq.NewDialer(addr, map[string]interface{}{
+		mangos.OptionTLSConfig: &tls.Config{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to make dialer: %v", err)
+	}
+	if err = d.Dial(); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	go func() {
+		for {
+			m, err := srep.RecvMsg()
+			if err != nil {
+				return
+			}
+			srep.SendMsg(m)
+		}
+	}()
+
+	sreq.Send([]byte("ping"))
+	if _, err = sreq.Recv(); err != mangos.ErrRecvTimeout {
+		t.Fatalf("Expected ErrRecvTimeout, got %v", err)
+	}
+
+	// The weight applies immediately.
+	if err = d.SetOption(mangos.OptionWeight, 3); err != nil {
+		t.Fatalf("Failed set weight: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionWeight); err != nil || v.(int) != 3 {
+		t.Errorf("Bad weight %v: %v", v, err)
+	}
+
+	// Transport options are validated right away, but are applied on
+	// the next connection attempt.
+	if err = d.SetOption(mangos.OptionTLSConfig, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTLSConfig, cliCfg); err != nil {
+		t.Fatalf("Failed set TLS config: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionTLSConfig); err != nil || v.(*tls.Config) != cliCfg {
+		t.Errorf("Bad TLS config %v: %v", v, err)
+	}
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if b, err := sreq.Recv(); err != nil || string(b) != "ping" {
+		t.Errorf("Got %q, %v; expected ping", string(b), err)
+	}
+}
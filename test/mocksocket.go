@@ -0,0 +1,445 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"nanomsg.org/go-mangos"
+)
+
+// MockSocket is a mangos.Socket that has no transports at all.  Messages
+// sent on it are captured on a channel the test reads from (see Sent), and
+// messages received from it are whatever the test supplies (see Inject).
+// It allows application code written against the mangos.Socket interface
+// to be unit tested without setting up real peers.
+//
+// Dial and Listen simply record their addresses.  Options are stored and
+// returned verbatim, except that OptionSendDeadline and OptionRecvDeadline
+// are honored by Send and Recv.
+type MockSocket struct {
+	proto    mangos.Protocol
+	sentq    chan *mangos.Message
+	recvq    chan *mangos.Message
+	closeq   chan struct{}
+	opts     map[string]interface{}
+	dials    []string
+	listens  []string
+	closed   bool
+	porthook mangos.PortHook
+	drophook mangos.DropHook
+	sync.Mutex
+}
+
+// mockEndpoint serves as both the Dialer and Listener of a MockSocket.
+type mockEndpoint struct {
+	sock   *MockSocket
+	addr   string
+	listen bool
+	opts   map[string]interface{}
+}
+
+// mockQLen is the depth of the sent and received message queues.
+const mockQLen = 128
+
+// NewMockSocket returns a new MockSocket.  The protocol, which may be nil,
+// is only reported by GetProtocol; it plays no part in message handling.
+func NewMockSocket(proto mangos.Protocol) *MockSocket {
+	return &MockSocket{
+		proto:  proto,
+		sentq:  make(chan *mangos.Message, mockQLen),
+		recvq:  make(chan *mangos.Message, mockQLen),
+		closeq: make(chan struct{}),
+		opts:   make(map[string]interface{}),
+	}
+}
+
+// Sent returns the channel on which messages sent on the socket are
+// delivered.  Once mockQLen messages are waiting, further sends block
+// (subject to the send deadline) until the test drains some.
+func (s *MockSocket) Sent() <-chan *mangos.Message {
+	return s.sentq
+}
+
+// Inject arranges for the message to be returned by a later Recv.  The
+// socket assumes ownership of the message.  It blocks if mockQLen messages
+// are already waiting to be received, and returns ErrClosed if the socket
+// is closed.
+func (s *MockSocket) Inject(m *mangos.Message) error {
+	select {
+	case <-s.closeq:
+		return mangos.ErrClosed
+	default:
+	}
+	select {
+	case s.recvq <- m:
+		return nil
+	case <-s.closeq:
+		return mangos.ErrClosed
+	}
+}
+
+// InjectBytes is a convenience wrapper for Inject, using a message body.
+func (s *MockSocket) InjectBytes(b []byte) error {
+	m := mangos.NewMessage(len(b))
+	m.Body = append(m.Body, b...)
+	return s.Inject(m)
+}
+
+// Dialed returns the addresses that have been dialed, in order.
+func (s *MockSocket) Dialed() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.dials...)
+}
+
+// Listened returns the addresses that have been listened on, in order.
+func (s *MockSocket) Listened() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.listens...)
+}
+
+func (s *MockSocket) deadline(name string) <-chan time.Time {
+	s.Lock()
+	d, _ := s.opts[name].(time.Duration)
+	s.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	return time.After(d)
+}
+
+// Close closes the socket.  Further operations on it return ErrClosed.
+func (s *MockSocket) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return mangos.ErrClosed
+	}
+	s.closed = true
+	close(s.closeq)
+	return nil
+}
+
+// Send sends a message with the given body.
+func (s *MockSocket) Send(b []byte) error {
+	m := mangos.NewMessage(len(b))
+	m.Body = append(m.Body, b...)
+	return s.SendMsg(m)
+}
+
+// SendMsg delivers the message to the channel returned by Sent.
+func (s *MockSocket) SendMsg(m *mangos.Message) error {
+	select {
+	case <-s.closeq:
+		return mangos.ErrClosed
+	default:
+	}
+	select {
+	case s.sentq <- m:
+		return nil
+	case <-s.closeq:
+		return mangos.ErrClosed
+	case <-s.deadline(mangos.OptionSendDeadline):
+		return mangos.ErrSendTimeout
+	}
+}
+
+// SendMsgEndpoint sends the message with SendMsg.  As a MockSocket has
+// no endpoints, the Endpoint returned is always nil.
+func (s *MockSocket) SendMsgEndpoint(m *mangos.Message) (mangos.Endpoint, error) {
+	return nil, s.SendMsg(m)
+}
+
+// SendToEndpoint always fails, as a MockSocket has no endpoints.
+func (s *MockSocket) SendToEndpoint(mangos.Endpoint, *mangos.Message) error {
+	return mangos.ErrBadEndpoint
+}
+
+// Recv returns a copy of the body of the next injected message.
+func (s *MockSocket) Recv() ([]byte, error) {
+	m, err := s.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, len(m.Body))
+	b = append(b, m.Body...)
+	m.Free()
+	return b, nil
+}
+
+// RecvMsg returns the next injected message.
+func (s *MockSocket) RecvMsg() (*mangos.Message, error) {
+	select {
+	case <-s.closeq:
+		return nil, mangos.ErrClosed
+	default:
+	}
+	select {
+	case m := <-s.recvq:
+		return m, nil
+	case <-s.closeq:
+		return nil, mangos.ErrClosed
+	case <-s.deadline(mangos.OptionRecvDeadline):
+		return nil, mangos.ErrRecvTimeout
+	}
+}
+
+func (s *MockSocket) newEndpoint(addr string, listen bool,
+	opts map[string]interface{}) (*mockEndpoint, error) {
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil, mangos.ErrClosed
+	}
+	ep := &mockEndpoint{
+		sock:   s,
+		addr:   addr,
+		listen: listen,
+		opts:   make(map[string]interface{}),
+	}
+	for n, v := range opts {
+		ep.opts[n] = v
+	}
+	return ep, nil
+}
+
+// Dial records the address as dialed.
+func (s *MockSocket) Dial(addr string) error {
+	return s.DialOptions(addr, nil)
+}
+
+// DialContext records the address as dialed, unless ctx is already done.
+// Cancelling ctx later has no effect.
+func (s *MockSocket) DialContext(ctx context.Context, addr string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Dial(addr)
+}
+
+// DialOptions records the address as dialed.
+func (s *MockSocket) DialOptions(addr string, opts map[string]interface{}) error {
+	d, err := s.NewDialer(addr, opts)
+	if err != nil {
+		return err
+	}
+	return d.Dial()
+}
+
+// NewDialer returns a Dialer that records the address when dialed.
+func (s *MockSocket) NewDialer(addr string, opts map[string]interface{}) (mangos.Dialer, error) {
+	return s.newEndpoint(addr, false, opts)
+}
+
+// Listen records the address as listened on.
+func (s *MockSocket) Listen(addr string) error {
+	return s.ListenOptions(addr, nil)
+}
+
+// ListenOptions records the address as listened on.
+func (s *MockSocket) ListenOptions(addr string, opts map[string]interface{}) error {
+	l, err := s.NewListener(addr, opts)
+	if err != nil {
+		return err
+	}
+	return l.Listen()
+}
+
+// NewListener returns a Listener that records the address when started.
+func (s *MockSocket) NewListener(addr string, opts map[string]interface{}) (mangos.Listener, error) {
+	return s.newEndpoint(addr, true, opts)
+}
+
+// CloseListener forgets a recorded listen address.
+func (s *MockSocket) CloseListener(addr string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, a := range s.listens {
+		if a == addr {
+			s.listens = append(s.listens[:i], s.listens[i+1:]...)
+			return nil
+		}
+	}
+	return mangos.ErrBadAddr
+}
+
+// ReconnectEndpoint succeeds for a recorded dial address; there are no
+// connections to redial.
+func (s *MockSocket) ReconnectEndpoint(addr string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, a := range s.dials {
+		if a == addr {
+			return nil
+		}
+	}
+	return mangos.ErrBadAddr
+}
+
+// GetOption returns a value previously stored with SetOption.
+func (s *MockSocket) GetOption(name string) (interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.opts[name]; ok {
+		return v, nil
+	}
+	return nil, mangos.ErrBadOption
+}
+
+// Options reports only OptionSendDeadline and OptionRecvDeadline, as the
+// MockSocket accepts any other option without interpreting it.
+func (s *MockSocket) Options() []mangos.OptionInfo {
+	d := reflect.TypeOf(time.Duration(0))
+	return []mangos.OptionInfo{
+		{Name: mangos.OptionRecvDeadline, Type: d},
+		{Name: mangos.OptionSendDeadline, Type: d},
+	}
+}
+
+// SetOption stores the option value.
+func (s *MockSocket) SetOption(name string, value interface{}) error {
+	switch name {
+	case mangos.OptionSendDeadline, mangos.OptionRecvDeadline:
+		if _, ok := value.(time.Duration); !ok {
+			return mangos.ErrBadValue
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.opts[name] = value
+	return nil
+}
+
+// GetProtocol returns the protocol supplied to NewMockSocket.
+func (s *MockSocket) GetProtocol() mangos.Protocol {
+	return s.proto
+}
+
+// AddTransport does nothing; a MockSocket has no transports.
+func (s *MockSocket) AddTransport(mangos.Transport) {}
+
+// AddResolver does nothing; a MockSocket never resolves addresses.
+func (s *MockSocket) AddResolver(string, mangos.Resolver) {}
+
+// SetPortHook stores the hook, which is never called, and returns the
+// previous one.
+func (s *MockSocket) SetPortHook(h mangos.PortHook) mangos.PortHook {
+	s.Lock()
+	defer s.Unlock()
+	old := s.porthook
+	s.porthook = h
+	return old
+}
+
+// SetDropHook stores the hook, which is never called, and returns the
+// previous one.
+func (s *MockSocket) SetDropHook(h mangos.DropHook) mangos.DropHook {
+	s.Lock()
+	defer s.Unlock()
+	old := s.drophook
+	s.drophook = h
+	return old
+}
+
+// WaitConnected returns as soon as the MockSocket has an active dialer or
+// listener, which stand in for connected Ports.
+func (s *MockSocket) WaitConnected(ctx context.Context) error {
+	for {
+		s.Lock()
+		n := len(s.dials) + len(s.listens)
+		s.Unlock()
+		if n > 0 {
+			return nil
+		}
+		select {
+		case <-s.closeq:
+			return mangos.ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
+
+// PauseRecv does nothing, as a MockSocket reads nothing from peers.
+func (s *MockSocket) PauseRecv() {}
+
+// ResumeRecv does nothing, as a MockSocket reads nothing from peers.
+func (s *MockSocket) ResumeRecv() {}
+
+// Endpoints always returns an empty slice, as a MockSocket has no
+// endpoints.
+func (s *MockSocket) Endpoints() []mangos.Endpoint {
+	return []mangos.Endpoint{}
+}
+
+// Flush does nothing, as a MockSocket has no transports.
+func (s *MockSocket) Flush() error {
+	return nil
+}
+
+// Ping always fails with ErrRecvTimeout, without waiting, as a MockSocket
+// has no peers to answer it.
+func (s *MockSocket) Ping(time.Duration) error {
+	return mangos.ErrRecvTimeout
+}
+
+// MoveEndpoint always fails, as a MockSocket has no endpoints.
+func (s *MockSocket) MoveEndpoint(mangos.Endpoint, mangos.Socket) (mangos.Endpoint, error) {
+	return nil, mangos.ErrBadEndpoint
+}
+
+func (ep *mockEndpoint) start() error {
+	s := ep.sock
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return mangos.ErrClosed
+	}
+	if ep.listen {
+		s.listens = append(s.listens, ep.addr)
+	} else {
+		s.dials = append(s.dials, ep.addr)
+	}
+	return nil
+}
+
+func (ep *mockEndpoint) Dial() error   { return ep.start() }
+func (ep *mockEndpoint) Listen() error { return ep.start() }
+func (ep *mockEndpoint) Close() error  { return nil }
+
+func (ep *mockEndpoint) Address() string { return ep.addr }
+
+func (ep *mockEndpoint) SetOption(name string, value interface{}) error {
+	ep.sock.Lock()
+	defer ep.sock.Unlock()
+	ep.opts[name] = value
+	return nil
+}
+
+func (ep *mockEndpoint) GetOption(name string) (interface{}, error) {
+	ep.sock.Lock()
+	defer ep.sock.Unlock()
+	if v, ok := ep.opts[name]; ok {
+		return v, nil
+	}
+	return nil, mangos.ErrBadOption
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/req"
+)
+
+// lookupUser is an example of application code under test: a REQ client
+// that asks a directory service for a user's name.
+func lookupUser(sock mangos.Socket, id string) (string, error) {
+	if err := sock.Send([]byte("user " + id)); err != nil {
+		return "", err
+	}
+	b, err := sock.Recv()
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", errors.New("no such user")
+	}
+	return string(b), nil
+}
+
+func TestMockSocketReqClient(t *testing.T) {
+	sock := NewMockSocket(req.NewProtocol())
+	defer sock.Close()
+
+	if err := sock.InjectBytes([]byte("Alice")); err != nil {
+		t.Fatalf("Inject failed: %v", err)
+	}
+	name, err := lookupUser(sock, "42")
+	if err != nil {
+		t.Fatalf("lookupUser failed: %v", err)
+	}
+	if name != "Alice" {
+		t.Errorf("Got name %q, expected Alice", name)
+	}
+
+	select {
+	case m := <-sock.Sent():
+		if string(m.Body) != "user 42" {
+			t.Errorf("Sent %q, expected \"user 42\"", string(m.Body))
+		}
+	default:
+		t.Fatalf("No request was sent")
+	}
+
+	// An empty reply is an application level error.
+	sock.InjectBytes([]byte{})
+	if _, err = lookupUser(sock, "7"); err == nil {
+		t.Errorf("Expected error for empty reply")
+	}
+	<-sock.Sent()
+}
+
+func TestMockSocketDeadline(t *testing.T) {
+	sock := NewMockSocket(nil)
+	defer sock.Close()
+
+	sock.SetOption(mangos.OptionRecvDeadline, time.Millisecond*20)
+	if _, err := lookupUser(sock, "1"); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	if err := sock.SetOption(mangos.OptionSendDeadline, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
+
+func TestMockSocketClose(t *testing.T) {
+	sock := NewMockSocket(nil)
+	if err := sock.Dial("tcp://127.0.0.1:1"); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if d := sock.Dialed(); len(d) != 1 || d[0] != "tcp://127.0.0.1:1" {
+		t.Errorf("Bad dialed addresses: %v", d)
+	}
+	sock.Close()
+	if _, err := sock.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed on Recv, got %v", err)
+	}
+	if err := sock.Send([]byte{}); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed on Send, got %v", err)
+	}
+	if err := sock.Inject(mangos.NewMessage(0)); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed on Inject, got %v", err)
+	}
+}
+
+func TestMockSocketRecvCopies(t *testing.T) {
+	sock := NewMockSocket(nil)
+	defer sock.Close()
+
+	sock.InjectBytes([]byte("first"))
+	b, err := sock.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	// The freed message's buffer may be reused for the next one.
+	sock.InjectBytes([]byte("later"))
+	if _, err = sock.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if string(b) != "first" {
+		t.Errorf("Body changed to %q after a later message", string(b))
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestMoveEndpoint(t *testing.T) {
+	addr := AddrTestTCP()
+	newRep := func() mangos.Socket {
+		s, err := rep.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		s.AddTransport(tcp.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		return s
+	}
+	old := newRep()
+	defer old.Close()
+	if err := old.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := newRep()
+	defer srv.Close()
+
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+	// A resent request would hide a lost one.
+	cli.SetOption(mangos.OptionRetryTime, time.Duration(0))
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	ask := func(s mangos.Socket, req, rep string) {
+		if err := cli.Send([]byte(req)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(b) != req {
+			t.Fatalf("Got request %q, expected %q", b, req)
+		}
+		if err = s.Send([]byte(rep)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if b, err = cli.Recv(); err != nil {
+			t.Fatalf("Recv reply: %v", err)
+		}
+		if string(b) != rep {
+			t.Fatalf("Got reply %q, expected %q", b, rep)
+		}
+	}
+	ask(old, "one", "1")
+
+	eps := old.Endpoints()
+	if len(eps) != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", len(eps))
+	}
+	if _, err = srv.MoveEndpoint(eps[0], old); err != mangos.ErrBadEndpoint {
+		t.Errorf("Expected ErrBadEndpoint, got %v", err)
+	}
+	if _, err = old.MoveEndpoint(eps[0], old); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	eps[0].(mangos.Port).SetMeta("user", "bob")
+	ep, err := old.MoveEndpoint(eps[0], srv)
+	if err != nil {
+		t.Fatalf("MoveEndpoint: %v", err)
+	}
+	if ep.GetID() != eps[0].GetID() {
+		t.Errorf("ID changed from %x to %x", eps[0].GetID(), ep.GetID())
+	}
+	if v, _ := ep.(mangos.Port).GetMeta("user"); v != "bob" {
+		t.Errorf("Metadata lost: %v", v)
+	}
+	if n := len(old.Endpoints()); n != 0 {
+		t.Errorf("Old socket still has %d endpoints", n)
+	}
+	if n := len(srv.Endpoints()); n != 1 {
+		t.Errorf("New socket has %d endpoints", n)
+	}
+	if _, err = old.MoveEndpoint(eps[0], srv); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	// The conversation carries on with the new socket, over the same
+	// connection, even once the old socket is gone.
+	ask(srv, "two", "2")
+	old.Close()
+	for i := 0; i < 10; i++ {
+		ask(srv, "more", "ok")
+	}
+	if _, err = old.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMoveEndpointFragment(t *testing.T) {
+	addr := AddrTestInp()
+	newPair := func() mangos.Socket {
+		s, err := pair.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		s.SetOption(mangos.OptionFragment, true)
+		return s
+	}
+	old := newPair()
+	defer old.Close()
+	srv := newPair()
+	defer srv.Close()
+	cli := newPair()
+	defer cli.Close()
+	if err := old.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	body := bigBody(3*mangos.DefaultFrameSizeHint + 5)
+	pass := func(from, to mangos.Socket) {
+		if err := from.Send(body); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		b, err := to.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if !bytes.Equal(b, body) {
+			t.Fatalf("Sent %d bytes, got %d", len(body), len(b))
+		}
+	}
+	pass(cli, old)
+
+	// The new socket knows the peer reassembles, and reassembles the
+	// peer's fragments itself, rather than passing them on as they are.
+	eps := old.Endpoints()
+	if len(eps) != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", len(eps))
+	}
+	if _, err := old.MoveEndpoint(eps[0], srv); err != nil {
+		t.Fatalf("MoveEndpoint: %v", err)
+	}
+	pass(cli, srv)
+	pass(srv, cli)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// dialPort connects a REQ to a REP over the transport, and returns the
+// client side Port.
+func dialPort(t *testing.T, tran mangos.Transport, addr string) (mangos.Port, func()) {
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	srep.AddTransport(tran)
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	sreq.AddTransport(tran)
+	ports := make(chan mangos.Port, 1)
+	sreq.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		if a == mangos.PortActionAdd {
+			ports <- p
+		}
+		return true
+	})
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	cleanup := func() {
+		sreq.Close()
+		srep.Close()
+	}
+	select {
+	case p := <-ports:
+		return p, cleanup
+	case <-time.After(time.Second):
+		cleanup()
+		t.Fatalf("Timed out waiting for connection")
+	}
+	return nil, nil
+}
+
+func TestNetConnTCP(t *testing.T) {
+	addr := AddrTestTCP()
+	p, cleanup := dialPort(t, tcp.NewTransport(), addr)
+	defer cleanup()
+
+	c := mangos.NetConn(p)
+	if c == nil {
+		t.Fatalf("No net.Conn for TCP")
+	}
+	if ra := c.RemoteAddr().String(); ra != strings.TrimPrefix(addr, "tcp://") {
+		t.Errorf("Got remote address %s, expected %s", ra, addr)
+	}
+	if v, err := p.GetProp(mangos.PropNetConn); err != nil || v != c {
+		t.Errorf("Bad property %v: %v", v, err)
+	}
+}
+
+func TestNetConnInproc(t *testing.T) {
+	p, cleanup := dialPort(t, inproc.NewTransport(), AddrTestInp())
+	defer cleanup()
+
+	if c := mangos.NetConn(p); c != nil {
+		t.Errorf("Expected no net.Conn for inproc, got %v", c)
+	}
+}
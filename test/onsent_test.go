@@ -0,0 +1,150 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+var errFailPipe = errors.New("write failed")
+
+// failTran is a transport whose pipes always fail to send.
+type failTran struct{}
+
+type failPipe struct {
+	proto  mangos.Protocol
+	closeq chan struct{}
+}
+
+type failDialer struct {
+	proto mangos.Protocol
+}
+
+func (failTran) Scheme() string { return "fail" }
+
+func (failTran) NewDialer(addr string, sock mangos.Socket) (mangos.PipeDialer, error) {
+	return &failDialer{proto: sock.GetProtocol()}, nil
+}
+
+func (failTran) NewListener(string, mangos.Socket) (mangos.PipeListener, error) {
+	return nil, mangos.ErrBadTran
+}
+
+func (d *failDialer) Dial() (mangos.Pipe, error) {
+	return &failPipe{proto: d.proto, closeq: make(chan struct{})}, nil
+}
+
+func (*failDialer) SetOption(string, interface{}) error   { return mangos.ErrBadOption }
+func (*failDialer) GetOption(string) (interface{}, error) { return nil, mangos.ErrBadOption }
+
+func (p *failPipe) Send(*mangos.Message) error { return errFailPipe }
+
+func (p *failPipe) Recv() (*mangos.Message, error) {
+	<-p.closeq
+	return nil, mangos.ErrClosed
+}
+
+func (p *failPipe) Close() error {
+	select {
+	case <-p.closeq:
+	default:
+		close(p.closeq)
+	}
+	return nil
+}
+
+func (p *failPipe) LocalProtocol() uint16  { return p.proto.Number() }
+func (p *failPipe) RemoteProtocol() uint16 { return p.proto.PeerNumber() }
+func (p *failPipe) IsOpen() bool           { return true }
+
+func (p *failPipe) GetProp(string) (interface{}, error) {
+	return nil, mangos.ErrBadProperty
+}
+
+func waitSent(t *testing.T, ch chan error) error {
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("Callback never fired")
+	}
+	return nil
+}
+
+func TestOnSentSuccess(t *testing.T) {
+	addr := AddrTestInp()
+	sp, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer sp.Close()
+	sp.AddTransport(inproc.NewTransport())
+	if err = sp.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sl, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PULL: %v", err)
+	}
+	defer sl.Close()
+	sl.AddTransport(inproc.NewTransport())
+	if err = sl.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	ch := make(chan error, 1)
+	m := mangos.NewMessage(0)
+	m.Body = append(m.Body, []byte("hello")...)
+	m.OnSent(func(err error) { ch <- err })
+	if err = sp.SendMsg(m); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if err = waitSent(t, ch); err != nil {
+		t.Errorf("Callback got error: %v", err)
+	}
+	if _, err = sl.Recv(); err != nil {
+		t.Errorf("Failed recv: %v", err)
+	}
+}
+
+func TestOnSentFailure(t *testing.T) {
+	sp, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer sp.Close()
+	sp.AddTransport(failTran{})
+	if err = sp.Dial("fail://nowhere"); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	ch := make(chan error, 1)
+	m := mangos.NewMessage(0)
+	m.OnSent(func(err error) { ch <- err })
+	if err = sp.SendMsg(m); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if err = waitSent(t, ch); err != errFailPipe {
+		t.Errorf("Callback got wrong error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/req"
+)
+
+func TestOptionInfo(t *testing.T) {
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+
+	opts := s.Options()
+	byName := make(map[string]mangos.OptionInfo)
+	for i, o := range opts {
+		if i > 0 && opts[i-1].Name >= o.Name {
+			t.Errorf("Options not sorted: %q then %q", opts[i-1].Name, o.Name)
+		}
+		byName[o.Name] = o
+	}
+
+	durType := reflect.TypeOf(time.Duration(0))
+	if o, ok := byName[mangos.OptionRetryTime]; !ok {
+		t.Errorf("OptionRetryTime missing")
+	} else if o.Type != durType || o.ReadOnly || o.WriteOnly {
+		t.Errorf("OptionRetryTime reported as %+v", o)
+	}
+	if o := byName[mangos.OptionRecvDeadline]; o.Type != durType {
+		t.Errorf("OptionRecvDeadline reported as %+v", o)
+	}
+	if o := byName[mangos.OptionBytesSent]; !o.ReadOnly {
+		t.Errorf("OptionBytesSent reported as %+v", o)
+	}
+	if _, ok := byName[mangos.OptionSubscribe]; ok {
+		t.Errorf("REQ reports OptionSubscribe")
+	}
+
+	// Each option that can be read yields a value of the reported type.
+	for _, o := range opts {
+		if o.WriteOnly {
+			continue
+		}
+		v, err := s.GetOption(o.Name)
+		if err != nil {
+			t.Errorf("GetOption %s: %v", o.Name, err)
+			continue
+		}
+		if v != nil && !reflect.TypeOf(v).AssignableTo(o.Type) {
+			t.Errorf("%s: got %T, reported %v", o.Name, v, o.Type)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestPauseRecv(t *testing.T) {
+	addr := AddrTestInp()
+	r, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer r.Close()
+	r.AddTransport(inproc.NewTransport())
+	r.SetOption(mangos.OptionRecvDeadline, 200*time.Millisecond)
+	if err = r.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	s, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err = s.Send([]byte{0}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := r.Recv(); err != nil || b[0] != 0 {
+		t.Fatalf("Got %v, %v", b, err)
+	}
+
+	// While paused, the sender's queues fill and it is pushed back on.
+	r.PauseRecv()
+	r.PauseRecv()
+	const total = 2000
+	var sent int32
+	done := make(chan error, 1)
+	go func() {
+		for i := 1; i < total; i++ {
+			if err := s.Send([]byte{byte(i)}); err != nil {
+				done <- err
+				return
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+		done <- nil
+	}()
+	time.Sleep(200 * time.Millisecond)
+	before := atomic.LoadInt32(&sent)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n >= total-1 || n != before {
+		t.Fatalf("Sender not pushed back on: sent %d then %d", before, n)
+	}
+
+	// At most the message being read when paused gets through.
+	got := 1
+	for ; got < total; got++ {
+		b, err := r.Recv()
+		if err != nil {
+			break
+		}
+		if b[0] != byte(got) {
+			t.Fatalf("Message %d out of order: %d", got, b[0])
+		}
+	}
+	if got > 2 {
+		t.Errorf("Received %d messages while paused", got-1)
+	}
+
+	r.ResumeRecv()
+	r.ResumeRecv()
+	for ; got < total; got++ {
+		b, err := r.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d: %v", got, err)
+		}
+		if b[0] != byte(got) {
+			t.Fatalf("Message %d out of order: %d", got, b[0])
+		}
+	}
+	if err = <-done; err != nil {
+		t.Errorf("Send: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestPingPair(t *testing.T) {
+	tx, rx := newInprocPair(t, AddrTestInp(), false)
+	defer tx.Close()
+	defer rx.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	for _, s := range []mangos.Socket{tx, rx} {
+		if err := s.Ping(time.Second); err != nil {
+			t.Errorf("Ping: %v", err)
+		}
+	}
+	// Neither application saw anything, and traffic is undisturbed.
+	rx.SetOption(mangos.OptionRecvDeadline, 50*time.Millisecond)
+	if m, err := rx.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %q %v", m, err)
+	}
+	if err := tx.Send([]byte("data")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if m, err := rx.Recv(); err != nil || string(m) != "data" {
+		t.Errorf("Recv: %q %v", m, err)
+	}
+
+	// A peer that has stopped reading does not answer.  (The read
+	// already under way when it paused takes this message.)
+	rx.PauseRecv()
+	if err := tx.Send([]byte("held")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	if err := tx.Ping(100 * time.Millisecond); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Gave up after %v", d)
+	}
+	rx.ResumeRecv()
+	if err := tx.Ping(time.Second); err != nil {
+		t.Errorf("Ping after resume: %v", err)
+	}
+	if m, err := rx.Recv(); err != nil || string(m) != "held" {
+		t.Errorf("Recv: %q %v", m, err)
+	}
+
+	rx.Close()
+	time.Sleep(50 * time.Millisecond)
+	if err := tx.Ping(100 * time.Millisecond); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	tx.Close()
+	if err := tx.Ping(time.Second); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestPingReqRep(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Either side can ask, with or without a request outstanding.
+	if err = cli.Ping(time.Second); err != nil {
+		t.Errorf("REQ Ping: %v", err)
+	}
+	if err = cli.Send([]byte("ping?")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err = srv.Ping(time.Second); err != nil {
+		t.Errorf("REP Ping: %v", err)
+	}
+	if m, err := srv.Recv(); err != nil || string(m) != "ping?" {
+		t.Fatalf("Recv: %q %v", m, err)
+	}
+	if err = srv.Send([]byte("pong!")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if m, err := cli.Recv(); err != nil || string(m) != "pong!" {
+		t.Errorf("Recv: %q %v", m, err)
+	}
+}
+
+func TestPingOtherProtocols(t *testing.T) {
+	addr := AddrTestInp()
+	var socks []mangos.Socket
+	for i := 0; i < 2; i++ {
+		s, err := bus.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		socks = append(socks, s)
+	}
+	if err := socks[0].Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := socks[1].Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := socks[1].Ping(time.Second); err != mangos.ErrProtoOp {
+		t.Errorf("Expected ErrProtoOp, got %v", err)
+	}
+	// What would be a ping elsewhere is just a message here.
+	frame := []byte("\x00\x00\x00\x00MANGOS-PING\x00\x00\x00\x00\x00\x00\x00\x01")
+	if err := socks[1].Send(frame); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := socks[0].Recv(); err != nil || string(b) != string(frame) {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// pipeIDSeq returns a generator counting up from start.
+func pipeIDSeq(start uint32) func() uint32 {
+	var mx sync.Mutex
+	next := start
+	return func() uint32 {
+		mx.Lock()
+		defer mx.Unlock()
+		id := next
+		next++
+		return id
+	}
+}
+
+func TestPipeIDDeterministic(t *testing.T) {
+	addr := AddrTestInp()
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srep.SetOption(mangos.OptionRaw, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srep.SetOption(mangos.OptionPipeID, pipeIDSeq(0x5eed0001)); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	if err = sreq.SetOption(mangos.OptionPipeID, 42); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = sreq.SetOption(mangos.OptionPipeID, pipeIDSeq(0x5eed1001)); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	ids := make(chan uint32, 1)
+	sreq.SetPortHook(func(act mangos.PortAction, p mangos.Port) bool {
+		if act == mangos.PortActionAdd {
+			ids <- p.(mangos.Endpoint).GetID()
+		}
+		return true
+	})
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	select {
+	case id := <-ids:
+		if id != 0x5eed1001 {
+			t.Errorf("First client pipe ID %x, want 5eed1001", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Pipe not added")
+	}
+
+	// Raw REP exposes the pipe ID at the front of the backtrace.
+	if err = sreq.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m, err := srep.RecvMsg()
+	if err != nil {
+		t.Fatalf("RecvMsg: %v", err)
+	}
+	if len(m.Header) < 4 {
+		t.Fatalf("Header too short: %v", m.Header)
+	}
+	if id := binary.BigEndian.Uint32(m.Header); id != 0x5eed0001 {
+		t.Errorf("First server pipe ID %x, want 5eed0001", id)
+	}
+	if m.Port.(mangos.Endpoint).GetID() != 0x5eed0001 {
+		t.Errorf("Port ID mismatch")
+	}
+	m.Free()
+}
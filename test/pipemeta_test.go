@@ -0,0 +1,151 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestPipeMeta(t *testing.T) {
+	const clients = 3
+	addr := AddrTestTCP()
+
+	srv, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	var lk sync.Mutex
+	users := make(map[mangos.Port]int)
+	var wg sync.WaitGroup
+	srv.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		if a != mangos.PortActionAdd {
+			return true
+		}
+		if _, ok := p.GetMeta("user"); ok {
+			t.Errorf("Fresh port already has meta")
+		}
+		lk.Lock()
+		user := len(users) + 1
+		users[p] = user
+		lk.Unlock()
+		p.SetMeta("user", user)
+		p.SetMeta("scratch", "x")
+		p.SetMeta("scratch", nil)
+		wg.Done()
+		return true
+	})
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	socks := make([]mangos.Socket, clients)
+	for i := range socks {
+		c, err := push.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		defer c.Close()
+		c.AddTransport(tcp.NewTransport())
+		wg.Add(1)
+		if err := c.Dial(addr); err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		wg.Wait()
+		socks[i] = c
+	}
+
+	// Client i was attached i+1'th, so the meta on the pipe its
+	// messages arrive on must say so.
+	for i, c := range socks {
+		if err := c.Send([]byte{byte(i + 1)}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		m, err := srv.RecvMsg()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if m.Port == nil {
+			t.Fatalf("Message has no Port")
+		}
+		v, ok := m.Port.GetMeta("user")
+		if !ok {
+			t.Errorf("No user meta on received message")
+		} else if v.(int) != int(m.Body[0]) {
+			t.Errorf("Got user %v, expected %d", v, m.Body[0])
+		}
+		if _, ok := m.Port.GetMeta("scratch"); ok {
+			t.Errorf("Removed key still present")
+		}
+		m.Free()
+	}
+}
+
+func TestPipeMetaConcurrent(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	portq := make(chan mangos.Port, 1)
+	srv.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		if a == mangos.PortActionAdd {
+			portq <- p
+		}
+		return true
+	})
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	c, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer c.Close()
+	c.AddTransport(tcp.NewTransport())
+	if err := c.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	p := <-portq
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				p.SetMeta("k", i)
+				if v, ok := p.GetMeta("k"); !ok || v == nil {
+					t.Errorf("Lost meta value")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// stallSub is a custom SUB that does not read from its pipe until the
+// test releases it, so its publisher sees a peer that has stopped.
+type stallSub struct {
+	release chan struct{}
+	msgs    chan *mangos.Message
+}
+
+func (x *stallSub) Init(mangos.ProtocolSocket)     {}
+func (x *stallSub) Shutdown(time.Time)             {}
+func (x *stallSub) RemoveEndpoint(mangos.Endpoint) {}
+func (x *stallSub) Number() uint16                 { return mangos.ProtoSub }
+func (x *stallSub) PeerNumber() uint16             { return mangos.ProtoPub }
+func (x *stallSub) Name() string                   { return "stallsub" }
+func (x *stallSub) PeerName() string               { return "pub" }
+
+func (x *stallSub) AddEndpoint(ep mangos.Endpoint) {
+	go func() {
+		<-x.release
+		for {
+			m := ep.RecvMsg()
+			if m == nil {
+				return
+			}
+			x.msgs <- m
+		}
+	}()
+}
+
+func (x *stallSub) SetOption(string, interface{}) error {
+	return mangos.ErrBadOption
+}
+
+func (x *stallSub) GetOption(string) (interface{}, error) {
+	return nil, mangos.ErrBadOption
+}
+
+// pipeQLenRun publishes to a fast subscriber and a stalled one, and
+// reports how many messages the stalled one ends up with.
+func pipeQLenRun(t *testing.T, depth int) int {
+	const total = 40
+	addr := AddrTestInp()
+
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	if err = p.SetOption(mangos.OptionWriteQLenPerPipe, depth); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	fast, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer fast.Close()
+	fast.AddTransport(inproc.NewTransport())
+	fast.SetOption(mangos.OptionSubscribe, []byte{})
+	fast.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = fast.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	stalled := &stallSub{
+		release: make(chan struct{}),
+		msgs:    make(chan *mangos.Message, total),
+	}
+	slow := mangos.MakeSocket(stalled)
+	defer slow.Close()
+	slow.AddTransport(inproc.NewTransport())
+	if err = slow.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	for i := 0; i < total; i++ {
+		if err = p.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if _, err = fast.Recv(); err != nil {
+			t.Fatalf("Fast subscriber missed message %d: %v", i, err)
+		}
+	}
+
+	close(stalled.release)
+	n := 0
+	for {
+		select {
+		case m := <-stalled.msgs:
+			m.Free()
+			n++
+			continue
+		case <-time.After(time.Millisecond * 100):
+		}
+		return n
+	}
+}
+
+func TestWriteQLenPerPipe(t *testing.T) {
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	if v, err := p.GetOption(mangos.OptionWriteQLenPerPipe); err != nil || v != 128 {
+		t.Errorf("Default per-pipe length %v, %v", v, err)
+	}
+	p.SetOption(mangos.OptionWriteQLen, 7)
+	if v, _ := p.GetOption(mangos.OptionWriteQLenPerPipe); v != 7 {
+		t.Errorf("Per-pipe length should follow write queue, got %v", v)
+	}
+	if err = p.SetOption(mangos.OptionWriteQLenPerPipe, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	p.Close()
+
+	// The fast subscriber gets everything regardless; the stalled one
+	// gets its queue's worth plus whatever was already in flight.
+	small := pipeQLenRun(t, 2)
+	large := pipeQLenRun(t, 10)
+	t.Logf("Stalled subscriber got %d with depth 2, %d with depth 10",
+		small, large)
+	if small < 2 || small > 4 {
+		t.Errorf("Depth 2 delivered %d to stalled subscriber", small)
+	}
+	if large-small != 8 {
+		t.Errorf("Depth 10 delivered %d, depth 2 delivered %d", large, small)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/protocol/sub"
+)
+
+func TestProtocolDefaults(t *testing.T) {
+	// Package-wide defaults do not hide the protocol's own.
+	if err := mangos.SetDefaultOption(mangos.OptionRetryTime, 5*time.Second); err != nil {
+		t.Fatalf("SetDefaultOption: %v", err)
+	}
+	defer mangos.SetDefaultOption(mangos.OptionRetryTime, nil)
+
+	opts := mangos.ProtocolDefaults(req.NewProtocol())
+	if v, ok := opts[mangos.OptionRetryTime]; !ok || v.(time.Duration) != time.Minute {
+		t.Errorf("Retry time is %v, expected a minute", v)
+	}
+	if v := opts[mangos.OptionReadQLen]; v != 128 {
+		t.Errorf("Read queue length is %v, expected 128", v)
+	}
+	if v := opts[mangos.OptionRaw]; v != false {
+		t.Errorf("Raw is %v, expected false", v)
+	}
+
+	// Write-only options have nothing to report.
+	opts = mangos.ProtocolDefaults(sub.NewProtocol())
+	if _, ok := opts[mangos.OptionSubscribe]; ok {
+		t.Errorf("Write-only option reported")
+	}
+	if _, ok := opts[mangos.OptionRetryTime]; ok {
+		t.Errorf("REQ option reported for SUB")
+	}
+}
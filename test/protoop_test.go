@@ -0,0 +1,106 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestProtoOpWrongDirection(t *testing.T) {
+	type maker func() (mangos.Socket, error)
+	cases := []struct {
+		name   string
+		fn     maker
+		peer   maker
+		noSend bool // else it cannot receive
+		raw    bool
+	}{
+		{"sub", sub.NewSocket, pub.NewSocket, true, false},
+		{"raw sub", sub.NewSocket, pub.NewSocket, true, true},
+		{"pull", pull.NewSocket, push.NewSocket, true, false},
+		{"raw pull", pull.NewSocket, push.NewSocket, true, true},
+		{"pub", pub.NewSocket, sub.NewSocket, false, false},
+		{"raw pub", pub.NewSocket, sub.NewSocket, false, true},
+		{"push", push.NewSocket, pull.NewSocket, false, false},
+		{"raw push", push.NewSocket, pull.NewSocket, false, true},
+	}
+	for _, c := range cases {
+		s, err := c.fn()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		if c.raw {
+			if err = s.SetOption(mangos.OptionRaw, true); err != nil {
+				t.Fatalf("%s SetOption: %v", c.name, err)
+			}
+		}
+		// No deadline, so anything other than an error at once hangs.
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionLinger, time.Duration(0))
+		check := func(when string) {
+			if c.noSend {
+				if err := s.Send([]byte("oops")); err != mangos.ErrProtoOp {
+					t.Errorf("%s Send %s: expected ErrProtoOp, got %v",
+						c.name, when, err)
+				}
+				m := mangos.NewMessage(0)
+				if _, err := s.SendMsgEndpoint(m); err != mangos.ErrProtoOp {
+					t.Errorf("%s SendMsgEndpoint %s: expected ErrProtoOp, got %v",
+						c.name, when, err)
+				}
+				m.Free()
+				return
+			}
+			if b, err := s.Recv(); err != mangos.ErrProtoOp {
+				t.Errorf("%s Recv %s: expected ErrProtoOp, got %q, %v",
+					c.name, when, b, err)
+			}
+		}
+		check("alone")
+
+		// A connected peer, and a best effort socket, change nothing.
+		p, err := c.peer()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer p.Close()
+		p.AddTransport(inproc.NewTransport())
+		p.SetOption(mangos.OptionLinger, time.Duration(0))
+		addr := AddrTestInp()
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		if err = p.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		for i := 0; len(s.Endpoints()) == 0; i++ {
+			if i == 500 {
+				t.Fatalf("%s did not connect", c.name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		s.SetOption(mangos.OptionBestEffort, true)
+		check("connected")
+	}
+}
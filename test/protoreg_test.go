@@ -0,0 +1,263 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/transport/inproc"
+	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/ws"
+)
+
+// The "tiny" protocols are a minimal experimental pair.  tinyA accepts
+// both tinyB and other tinyA peers, while tinyB only accepts tinyA.
+const (
+	protoTinyA = 200 * 16
+	protoTinyB = 200*16 + 1
+)
+
+type tinyEp struct {
+	ep mangos.Endpoint
+	cq chan struct{}
+}
+
+// tiny sends each message to every peer, and receives from all of them.
+type tiny struct {
+	num   uint16
+	peer  uint16
+	sock  mangos.ProtocolSocket
+	peers map[uint32]*tinyEp
+	sync.Mutex
+}
+
+func (x *tiny) Init(sock mangos.ProtocolSocket) {
+	x.sock = sock
+	x.peers = make(map[uint32]*tinyEp)
+	go x.sender()
+}
+
+func (x *tiny) Shutdown(time.Time) {}
+
+func (x *tiny) sender() {
+	sq := x.sock.SendChannel()
+	cq := x.sock.CloseChannel()
+	for {
+		select {
+		case m := <-sq:
+			if m == nil {
+				sq = x.sock.SendChannel()
+				continue
+			}
+			x.Lock()
+			for _, pe := range x.peers {
+				if pe.ep.SendMsg(m.Dup()) != nil {
+					m.Free()
+				}
+			}
+			x.Unlock()
+			m.Free()
+		case <-cq:
+			return
+		}
+	}
+}
+
+func (x *tiny) AddEndpoint(ep mangos.Endpoint) {
+	pe := &tinyEp{ep: ep, cq: make(chan struct{})}
+	x.Lock()
+	x.peers[ep.GetID()] = pe
+	x.Unlock()
+	go func() {
+		rq := x.sock.RecvChannel()
+		cq := x.sock.CloseChannel()
+		for {
+			m := ep.RecvMsg()
+			if m == nil {
+				return
+			}
+			select {
+			case rq <- m:
+			case <-cq:
+				m.Free()
+				return
+			}
+		}
+	}()
+}
+
+func (x *tiny) RemoveEndpoint(ep mangos.Endpoint) {
+	x.Lock()
+	delete(x.peers, ep.GetID())
+	x.Unlock()
+}
+
+func (x *tiny) Number() uint16     { return x.num }
+func (x *tiny) PeerNumber() uint16 { return x.peer }
+func (x *tiny) Name() string       { return mangos.ProtocolName(x.num) }
+func (x *tiny) PeerName() string   { return mangos.ProtocolName(x.peer) }
+
+func (x *tiny) SetOption(string, interface{}) error {
+	return mangos.ErrBadOption
+}
+
+func (x *tiny) GetOption(string) (interface{}, error) {
+	return nil, mangos.ErrBadOption
+}
+
+var tinyOnce sync.Once
+var tinyErr error
+
+func registerTiny() error {
+	tinyOnce.Do(func() {
+		tinyErr = mangos.RegisterProtocol(mangos.ProtocolInfo{
+			Number: protoTinyA,
+			Name:   "tinya",
+			Peers:  []uint16{protoTinyA},
+			Cooked: func() mangos.Protocol {
+				return &tiny{num: protoTinyA, peer: protoTinyB}
+			},
+		})
+		if tinyErr != nil {
+			return
+		}
+		tinyErr = mangos.RegisterProtocol(mangos.ProtocolInfo{
+			Number: protoTinyB,
+			Name:   "tinyb",
+			Cooked: func() mangos.Protocol {
+				return &tiny{num: protoTinyB, peer: protoTinyA}
+			},
+		})
+	})
+	return tinyErr
+}
+
+func newTinySocket(t *testing.T, name string) mangos.Socket {
+	s, err := mangos.NewProtocolSocket(name, false)
+	if err != nil {
+		t.Fatalf("NewProtocolSocket %s: %v", name, err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	s.AddTransport(tcp.NewTransport())
+	s.AddTransport(ws.NewTransport())
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	s.SetOption(mangos.OptionSendDeadline, time.Second)
+	return s
+}
+
+func tinyExchange(t *testing.T, srv, cli, addr string) {
+	ss := newTinySocket(t, srv)
+	defer ss.Close()
+	cs := newTinySocket(t, cli)
+	defer cs.Close()
+	if err := ss.Listen(addr); err != nil {
+		t.Fatalf("Listen %s: %v", addr, err)
+	}
+	if err := cs.Dial(addr); err != nil {
+		t.Fatalf("Dial %s: %v", addr, err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err := cs.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := ss.Recv(); err != nil || string(b) != "hello" {
+		t.Fatalf("%s <- %s on %s: %q, %v", srv, cli, addr, b, err)
+	}
+	if err := ss.Send([]byte("world")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := cs.Recv(); err != nil || string(b) != "world" {
+		t.Fatalf("%s <- %s on %s: %q, %v", cli, srv, addr, b, err)
+	}
+}
+
+func TestCustomProtocol(t *testing.T) {
+	if err := registerTiny(); err != nil {
+		t.Fatalf("RegisterProtocol: %v", err)
+	}
+	if name := mangos.ProtocolName(protoTinyA); name != "tinya" {
+		t.Errorf("ProtocolName: %q", name)
+	}
+	a := &tiny{num: protoTinyA, peer: protoTinyB}
+	b := &tiny{num: protoTinyB, peer: protoTinyA}
+	if !mangos.ValidPeers(a, b) || !mangos.ValidPeers(a, a) {
+		t.Errorf("tinya should peer with tinya and tinyb")
+	}
+	if mangos.ValidPeers(b, b) {
+		t.Errorf("tinyb should not peer with itself")
+	}
+
+	tinyExchange(t, "tinya", "tinyb", AddrTestTCP())
+	tinyExchange(t, "tinyb", "tinya", AddrTestTCP())
+	tinyExchange(t, "tinya", "tinya", AddrTestTCP())
+	tinyExchange(t, "tinya", "tinyb", AddrTestInp())
+	tinyExchange(t, "tinya", "tinya", AddrTestWS())
+}
+
+func TestCustomProtocolRejected(t *testing.T) {
+	if err := registerTiny(); err != nil {
+		t.Fatalf("RegisterProtocol: %v", err)
+	}
+	addr := AddrTestTCP()
+	ss := newTinySocket(t, "tinyb")
+	defer ss.Close()
+	cs := newTinySocket(t, "tinyb")
+	defer cs.Close()
+	if err := ss.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := cs.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	cs.Send([]byte("hello"))
+	ss.SetOption(mangos.OptionRecvDeadline, time.Millisecond*200)
+	if b, err := ss.Recv(); err == nil {
+		t.Errorf("tinyb peers exchanged %q", b)
+	}
+}
+
+func TestRegisterProtocolErrors(t *testing.T) {
+	if err := registerTiny(); err != nil {
+		t.Fatalf("RegisterProtocol: %v", err)
+	}
+	cooked := func() mangos.Protocol { return &tiny{} }
+	cases := []struct {
+		info mangos.ProtocolInfo
+		err  error
+	}{
+		{mangos.ProtocolInfo{Number: 201 * 16, Cooked: cooked}, mangos.ErrBadProto},
+		{mangos.ProtocolInfo{Number: 201 * 16, Name: "x"}, mangos.ErrBadProto},
+		{mangos.ProtocolInfo{Number: mangos.ProtoReq, Name: "x", Cooked: cooked}, mangos.ErrProtoInUse},
+		{mangos.ProtocolInfo{Number: protoTinyA, Name: "x", Cooked: cooked}, mangos.ErrProtoInUse},
+		{mangos.ProtocolInfo{Number: 201 * 16, Name: "tinya", Cooked: cooked}, mangos.ErrProtoInUse},
+		{mangos.ProtocolInfo{Number: 201 * 16, Name: "pair", Cooked: cooked}, mangos.ErrProtoInUse},
+	}
+	for i, c := range cases {
+		if err := mangos.RegisterProtocol(c.info); err != c.err {
+			t.Errorf("Case %d: got %v, want %v", i, err, c.err)
+		}
+	}
+	if _, err := mangos.NewProtocolSocket("nosuch", false); err != mangos.ErrBadProto {
+		t.Errorf("Expected ErrBadProto, got %v", err)
+	}
+	if _, err := mangos.NewProtocolSocket("tinya", true); err != mangos.ErrProtoOp {
+		t.Errorf("Expected ErrProtoOp for raw, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestPubRetain(t *testing.T) {
+	addr := AddrTestInp()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	if err = p.SetOption(mangos.OptionRetain, map[string]int{"a/": -1}); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	depths := map[string]int{"a/": 2, "b/": 1}
+	if err = p.SetOption(mangos.OptionRetain, depths); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := p.GetOption(mangos.OptionRetain); err != nil || len(v.(map[string]int)) != 2 {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	// Published with nobody listening.
+	for _, body := range []string{"a/1", "b/1", "a/2", "c/1", "b/2", "a/3"} {
+		if err = p.Send([]byte(body)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	join := func() mangos.Socket {
+		s, err := sub.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		s.SetOption(mangos.OptionSubscribe, "")
+		if err = s.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		return s
+	}
+	expect := func(s mangos.Socket, want ...string) {
+		for _, w := range want {
+			b, err := s.Recv()
+			if err != nil {
+				t.Fatalf("Recv, expecting %q: %v", w, err)
+			}
+			if string(b) != w {
+				t.Errorf("Got %q, expected %q", b, w)
+			}
+		}
+	}
+
+	s1 := join()
+	defer s1.Close()
+	expect(s1, "a/2", "b/2", "a/3")
+
+	// Later messages follow the history, and update it.
+	time.Sleep(20 * time.Millisecond)
+	p.Send([]byte("a/4"))
+	expect(s1, "a/4")
+
+	s2 := join()
+	defer s2.Close()
+	expect(s2, "b/2", "a/3", "a/4")
+
+	// Clearing it forgets everything.
+	if err = p.SetOption(mangos.OptionRetain, nil); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	s3 := join()
+	defer s3.Close()
+	s3.SetOption(mangos.OptionRecvDeadline, 50*time.Millisecond)
+	if b, err := s3.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected timeout, got %q, %v", b, err)
+	}
+}
+
+func TestPubRetainSendDeadline(t *testing.T) {
+	addr := AddrTestInp()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	p.SetOption(mangos.OptionSendDeadline, 50*time.Millisecond)
+	if err = p.SetOption(mangos.OptionRetain, map[string]int{"": 1}); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err = p.Send([]byte("old")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Well after the send deadline for the original, history still
+	// goes to a new subscriber.
+	time.Sleep(150 * time.Millisecond)
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	s.SetOption(mangos.OptionSubscribe, "")
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if b, err := s.Recv(); err != nil || string(b) != "old" {
+		t.Errorf("Got %q, %v, expected old", b, err)
+	}
+}
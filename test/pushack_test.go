@@ -0,0 +1,109 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newAckPull(t *testing.T, addr string) mangos.Socket {
+	s, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PULL: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err = s.SetOption(mangos.OptionAckMode, true); err != nil {
+		t.Fatalf("Failed set ack mode: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionReadQLen, 0); err != nil {
+		t.Fatalf("Failed set read queue: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	return s
+}
+
+func TestPushAckNoLoss(t *testing.T) {
+	addr := AddrTestInp()
+	const total = 10
+
+	sp, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer sp.Close()
+	sp.AddTransport(inproc.NewTransport())
+	if err = sp.SetOption(mangos.OptionAckMode, true); err != nil {
+		t.Fatalf("Failed set ack mode: %v", err)
+	}
+	if err = sp.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	p1 := newAckPull(t, addr)
+	time.Sleep(time.Millisecond * 50)
+
+	for i := 0; i < total; i++ {
+		if err = sp.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Failed send %d: %v", i, err)
+		}
+	}
+
+	// Consume a few on the first receiver, then drop it mid-stream.
+	next := 0
+	for ; next < 3; next++ {
+		v, err := p1.Recv()
+		if err != nil {
+			t.Fatalf("Failed recv %d: %v", next, err)
+		}
+		if string(v) != fmt.Sprintf("%d", next) {
+			t.Fatalf("Out of order: got %s, want %d", v, next)
+		}
+	}
+	p1.Close()
+
+	p2 := newAckPull(t, addr)
+	defer p2.Close()
+
+	// Everything not yet received must arrive, in order.  Messages
+	// already seen may be duplicated at the head of the stream.
+	for next < total {
+		v, err := p2.Recv()
+		if err != nil {
+			t.Fatalf("Lost message %d: %v", next, err)
+		}
+		var n int
+		fmt.Sscanf(string(v), "%d", &n)
+		switch {
+		case n == next:
+			next++
+		case n < next:
+			t.Logf("Duplicate %d", n)
+		default:
+			t.Fatalf("Out of order: got %d, want %d", n, next)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// newBatchPair returns a connected PUSH and PULL, both using the given
+// batch size (zero disables batching).
+func newBatchPair(t testing.TB, addr string, batch int) (mangos.Socket, mangos.Socket) {
+	spull, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PULL: %v", err)
+	}
+	spull.AddTransport(tcp.NewTransport())
+	if err = spull.SetOption(mangos.OptionBatchSize, batch); err != nil {
+		t.Fatalf("Failed set batch size: %v", err)
+	}
+	if err = spull.SetOption(mangos.OptionRecvDeadline, time.Second*5); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = spull.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	spush, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	spush.AddTransport(tcp.NewTransport())
+	if err = spush.SetOption(mangos.OptionBatchSize, batch); err != nil {
+		t.Fatalf("Failed set batch size: %v", err)
+	}
+	if err = spush.SetOption(mangos.OptionWriteQLen, 1024); err != nil {
+		t.Fatalf("Failed set write queue: %v", err)
+	}
+	if err = spush.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	return spush, spull
+}
+
+func TestPushBatchOrder(t *testing.T) {
+	const total = 2000
+	spush, spull := newBatchPair(t, AddrTestTCP(), 512)
+	defer spush.Close()
+	defer spull.Close()
+
+	go func() {
+		for i := 0; i < total; i++ {
+			// Vary the sizes, including empty and over-sized
+			// messages.
+			b := bytes.Repeat([]byte{byte(i)}, (i*7)%700)
+			if err := spush.Send(b); err != nil {
+				t.Errorf("Send %d failed: %v", i, err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < total; i++ {
+		b, err := spull.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if len(b) != (i*7)%700 || (len(b) > 0 && b[0] != byte(i)) {
+			t.Fatalf("Message %d mismatch (len %d)", i, len(b))
+		}
+	}
+}
+
+func TestPushBatchMessages(t *testing.T) {
+	const total = 100
+	spush, spull := newBatchPair(t, AddrTestTCP(), 4096)
+	defer spush.Close()
+	defer spull.Close()
+
+	sent := make(chan error, total)
+	for i := 0; i < total; i++ {
+		m := mangos.NewMessage(8)
+		m.Header = append(m.Header, 'h', byte(i))
+		m.Body = append(m.Body, 'b', byte(i))
+		m.OnSent(func(err error) { sent <- err })
+		if err := spush.SendMsg(m); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	var port mangos.Port
+	for i := 0; i < total; i++ {
+		m, err := spull.RecvMsg()
+		if err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		// The header travels with the body, as it does unbatched.
+		if !bytes.Equal(m.Body, []byte{'h', byte(i), 'b', byte(i)}) {
+			t.Errorf("Message %d is %q", i, m.Body)
+		}
+		if m.Port == nil || (port != nil && m.Port != port) {
+			t.Errorf("Message %d has Port %v", i, m.Port)
+		}
+		port = m.Port
+		m.Free()
+	}
+	for i := 0; i < total; i++ {
+		select {
+		case err := <-sent:
+			if err != nil {
+				t.Errorf("OnSent got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d OnSent calls", i)
+		}
+	}
+}
+
+func TestPushBatchOption(t *testing.T) {
+	s, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionBatchSize, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionBatchSize, 4096); err != nil {
+		t.Fatalf("Failed set batch size: %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionBatchSize); err != nil || v.(int) != 4096 {
+		t.Errorf("Bad batch size %v: %v", v, err)
+	}
+}
+
+func benchmarkPushBatch(b *testing.B, batch int) {
+	spush, spull := newBatchPair(b, AddrTestTCP(), batch)
+	defer spush.Close()
+	defer spull.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			m, err := spull.RecvMsg()
+			if err != nil {
+				b.Errorf("Recv %d failed: %v", i, err)
+				return
+			}
+			m.Free()
+		}
+	}()
+
+	b.SetBytes(32)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := mangos.NewMessage(32)
+		m.Body = m.Body[:32]
+		if err := spush.SendMsg(m); err != nil {
+			b.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	<-done
+	b.StopTimer()
+}
+
+func BenchmarkPushNoBatch32TCP(b *testing.B) {
+	benchmarkPushBatch(b, 0)
+}
+
+func BenchmarkPushBatch32TCP(b *testing.B) {
+	benchmarkPushBatch(b, 16384)
+}
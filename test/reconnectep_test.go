@@ -0,0 +1,84 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+)
+
+// newBusReasonSocket is a reasonSocket that takes any number of peers, so
+// that a redialed connection is not refused while the old one lingers.
+func newBusReasonSocket(t *testing.T) *reasonSocket {
+	s, err := bus.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	return wrapReasonSocket(s, true)
+}
+
+func TestReconnectEndpoint(t *testing.T) {
+	addr := AddrTestTCP()
+	srv := newBusReasonSocket(t)
+	defer srv.Close()
+	cli := newBusReasonSocket(t)
+	defer cli.Close()
+
+	if err := cli.ReconnectEndpoint(addr); err != mangos.ErrBadAddr {
+		t.Errorf("Expected ErrBadAddr before Dial, got %v", err)
+	}
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	old := cli.port(t)
+	srv.port(t)
+
+	// The reconnect time is an hour, so only a forced redial will do.
+	if err := cli.ReconnectEndpoint(addr); err != nil {
+		t.Fatalf("ReconnectEndpoint: %v", err)
+	}
+	cli.expect(t, "client", mangos.CloseReasonReconnect)
+	if p := cli.port(t); p == old {
+		t.Errorf("Got the old port back")
+	}
+	srv.expect(t, "server", mangos.CloseReasonPeer)
+	srv.port(t)
+	if n := len(cli.Endpoints()); n != 1 {
+		t.Errorf("Expected 1 endpoint, got %d", n)
+	}
+
+	// A dialer waiting out its backoff tries again at once.
+	srv.Close()
+	cli.expect(t, "client", mangos.CloseReasonPeer)
+	srv2 := newBusReasonSocket(t)
+	defer srv2.Close()
+	if err := srv2.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err := cli.ReconnectEndpoint(addr); err != nil {
+		t.Fatalf("ReconnectEndpoint: %v", err)
+	}
+	cli.port(t)
+	srv2.port(t)
+
+	if err := cli.ReconnectEndpoint("tcp://127.0.0.1:1"); err != mangos.ErrBadAddr {
+		t.Errorf("Expected ErrBadAddr, got %v", err)
+	}
+}
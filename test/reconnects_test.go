@@ -0,0 +1,129 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestPortReconnects(t *testing.T) {
+	addr := AddrTestInp()
+
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	if err = sreq.SetOption(mangos.OptionReconnectTime, time.Millisecond*10); err != nil {
+		t.Fatalf("Failed set reconnect time: %v", err)
+	}
+
+	ports := make(chan mangos.Port, 10)
+	sreq.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		if a == mangos.PortActionAdd {
+			ports <- p
+		}
+		return true
+	})
+	start := time.Now()
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	// Each time we drop the connection, the dialer reconnects, and the
+	// count goes up.
+	var last time.Time
+	for i := 0; i < 4; i++ {
+		var p mangos.Port
+		select {
+		case p = <-ports:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for connection %d", i)
+		}
+		v, err := p.GetProp(mangos.PropReconnects)
+		if err != nil {
+			t.Fatalf("Failed get reconnects: %v", err)
+		}
+		if n := v.(int); n != i {
+			t.Errorf("Got %d reconnects, expected %d", n, i)
+		}
+		v, err = p.GetProp(mangos.PropEstablished)
+		if err != nil {
+			t.Fatalf("Failed get established: %v", err)
+		}
+		when := v.(time.Time)
+		if when.Before(start) || when.Before(last) {
+			t.Errorf("Bad established time %v", when)
+		}
+		last = when
+		p.Close()
+	}
+}
+
+func TestPortReconnectsServer(t *testing.T) {
+	addr := AddrTestInp()
+
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	ports := make(chan mangos.Port, 1)
+	srep.SetPortHook(func(a mangos.PortAction, p mangos.Port) bool {
+		if a == mangos.PortActionAdd {
+			ports <- p
+		}
+		return true
+	})
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	p := <-ports
+	if _, err = p.GetProp(mangos.PropReconnects); err != mangos.ErrBadProperty {
+		t.Errorf("Expected ErrBadProperty, got %v", err)
+	}
+	if _, err = p.GetProp(mangos.PropEstablished); err != nil {
+		t.Errorf("Failed get established: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// recvDrainSetup returns a PULL socket with count messages waiting in its
+// read queue.
+func recvDrainSetup(t *testing.T, drain time.Duration, count int) (mangos.Socket, mangos.Socket) {
+	addr := AddrTestInp()
+	rx, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	rx.AddTransport(inproc.NewTransport())
+	if err = rx.SetOption(mangos.OptionRecvDrain, drain); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		if err = tx.Send([]byte(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	// Give the messages time to reach the read queue.
+	time.Sleep(time.Millisecond * 100)
+	return tx, rx
+}
+
+func TestRecvDrain(t *testing.T) {
+	tx, rx := recvDrainSetup(t, time.Second, 5)
+	defer tx.Close()
+
+	if err := rx.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d after close: %v", i, err)
+		}
+		if want := fmt.Sprintf("msg%d", i); string(b) != want {
+			t.Errorf("Got %q, want %q", b, want)
+		}
+	}
+	if _, err := rx.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed once drained, got %v", err)
+	}
+}
+
+func TestRecvDrainExpires(t *testing.T) {
+	tx, rx := recvDrainSetup(t, time.Millisecond*50, 3)
+	defer tx.Close()
+
+	rx.Close()
+	if _, err := rx.Recv(); err != nil {
+		t.Errorf("Recv within window: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err := rx.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed after window, got %v", err)
+	}
+}
+
+func TestRecvDrainDisabled(t *testing.T) {
+	tx, rx := recvDrainSetup(t, 0, 3)
+	defer tx.Close()
+
+	rx.Close()
+	if _, err := rx.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if err := rx.SetOption(mangos.OptionRecvDrain, -time.Second); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// recvFullPair sends count messages from one PAIR to another whose read
+// queue holds only two, and returns the bodies received, along with the
+// number of drops reported.
+func recvFullPair(t *testing.T, policy string, count int) ([]string, uint64) {
+	addr := AddrTestInp()
+	rx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(inproc.NewTransport())
+	if err = rx.SetOption(mangos.OptionReadQLen, 2); err != nil {
+		t.Fatalf("Failed set read queue: %v", err)
+	}
+	if err = rx.SetOption(mangos.OptionRecvFull, policy); err != nil {
+		t.Fatalf("Failed set policy: %v", err)
+	}
+	if err = rx.SetOption(mangos.OptionRecvDeadline, time.Millisecond*200); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	if err = rx.SetOption(mangos.OptionRecvFull, policy); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption once active, got %v", err)
+	}
+
+	tx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	for i := 0; i < count; i++ {
+		if err = tx.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Failed send %d: %v", i, err)
+		}
+	}
+
+	// Let everything arrive (or back up) before we start reading.
+	var drops uint64
+	expire := time.Now().Add(time.Second)
+	for time.Now().Before(expire) {
+		v, err := rx.GetOption(mangos.OptionRecvDrops)
+		if err != nil {
+			t.Fatalf("Failed get drops: %v", err)
+		}
+		if drops = v.(uint64); drops >= uint64(count-2) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	var got []string
+	for {
+		b, err := rx.Recv()
+		if err != nil {
+			break
+		}
+		got = append(got, string(b))
+	}
+	return got, drops
+}
+
+func TestRecvFullDropOld(t *testing.T) {
+	got, drops := recvFullPair(t, mangos.RecvFullDropOld, 6)
+	if drops != 4 {
+		t.Errorf("Expected 4 drops, got %d", drops)
+	}
+	if fmt.Sprint(got) != "[4 5]" {
+		t.Errorf("Expected newest messages, got %v", got)
+	}
+}
+
+func TestRecvFullDropNew(t *testing.T) {
+	got, drops := recvFullPair(t, mangos.RecvFullDropNew, 6)
+	if drops != 4 {
+		t.Errorf("Expected 4 drops, got %d", drops)
+	}
+	if fmt.Sprint(got) != "[0 1]" {
+		t.Errorf("Expected oldest messages, got %v", got)
+	}
+}
+
+func TestRecvFullBlock(t *testing.T) {
+	got, drops := recvFullPair(t, mangos.RecvFullBlock, 6)
+	if drops != 0 {
+		t.Errorf("Expected no drops, got %d", drops)
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4 5]" {
+		t.Errorf("Expected all messages, got %v", got)
+	}
+}
+
+func TestRecvFullBadValue(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionRecvFull, "sometimes"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvFull, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionRecvFull); err != nil || v != mangos.RecvFullBlock {
+		t.Errorf("Bad default policy %v: %v", v, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestRecvWire(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srv.SetOption(mangos.OptionRecvWire, "yes"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = srv.SetOption(mangos.OptionRecvWire, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := srv.GetOption(mangos.OptionRecvWire); err != nil || v != true {
+		t.Errorf("GetOption: %v %v", v, err)
+	}
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(tcp.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	if err = cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m, err := srv.RecvMsg()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	// The request ID (with the high bit set) is on the front.
+	if len(m.Body) != 8 || m.Body[0]&0x80 == 0 ||
+		!bytes.Equal(m.Body[4:], []byte("ping")) {
+		t.Fatalf("Unexpected frame %v", m.Body)
+	}
+	id := append([]byte{}, m.Body[:4]...)
+	m.Free()
+
+	// The server still knows who to reply to; the client, without the
+	// option, sees only the payload.
+	if err = srv.Send([]byte("pong")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m, err = cli.RecvMsg()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if string(m.Body) != "pong" {
+		t.Errorf("Unexpected reply %q", m.Body)
+	}
+	m.Free()
+
+	// And with it, the reply carries the request ID back; a new one, as
+	// this is a new request.
+	cli.SetOption(mangos.OptionRecvWire, true)
+	srv.SetOption(mangos.OptionRecvWire, false)
+	if err = cli.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	b, err := srv.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if string(b) != "ping" {
+		t.Errorf("Unexpected request %q", b)
+	}
+	if err = srv.Send([]byte("pong")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	b, err = cli.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if len(b) != 8 || bytes.Equal(b[:4], id) || b[0]&0x80 == 0 ||
+		string(b[4:]) != "pong" {
+		t.Errorf("Unexpected frame %v", b)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestRepAnyOrder(t *testing.T) {
+	const clients = 4
+	addr := AddrTestTCP()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := srv.SetOption(mangos.OptionReplyAnyOrder, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := srv.SetOption(mangos.OptionReplyAnyOrder, true); err != nil {
+		t.Fatalf("Failed to set option: %v", err)
+	}
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	var cli [clients]mangos.Socket
+	for i := range cli {
+		c, err := req.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		defer c.Close()
+		c.AddTransport(tcp.NewTransport())
+		c.SetOption(mangos.OptionRecvDeadline, time.Second)
+		if err := c.Dial(addr); err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		cli[i] = c
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	// Every client has a request outstanding at once.
+	for i, c := range cli {
+		if err := c.Send([]byte(fmt.Sprintf("req%d", i))); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	var reqs []*mangos.Message
+	for range cli {
+		m, err := srv.RecvMsg()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		reqs = append(reqs, m)
+	}
+
+	// Reply newest first, so none is answered in arrival order.
+	for i := len(reqs) - 1; i >= 0; i-- {
+		m := reqs[i]
+		m.Body = append([]byte("rep-"), m.Body...)
+		if err := srv.SendMsg(m); err != nil {
+			t.Fatalf("Reply failed: %v", err)
+		}
+	}
+	for i, c := range cli {
+		b, err := c.Recv()
+		if err != nil {
+			t.Fatalf("Client %d recv failed: %v", i, err)
+		}
+		if expect := fmt.Sprintf("rep-req%d", i); string(b) != expect {
+			t.Errorf("Client %d got %q, expected %q", i, b, expect)
+		}
+	}
+}
+
+func TestRepAnyOrderPlainSend(t *testing.T) {
+	addr := AddrTestTCP()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(tcp.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	srv.SetOption(mangos.OptionReplyAnyOrder, true)
+	if err := srv.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	c, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer c.Close()
+	c.AddTransport(tcp.NewTransport())
+	c.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := c.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	// Plain Send and Recv still work as usual.
+	for i := 0; i < 3; i++ {
+		if err := c.Send([]byte("ping")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if _, err := srv.Recv(); err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if err := srv.Send([]byte("pong")); err != nil {
+			t.Fatalf("Reply failed: %v", err)
+		}
+		if b, err := c.Recv(); err != nil || string(b) != "pong" {
+			t.Fatalf("Client got %q %v", b, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func replyLatency(t *testing.T, s mangos.Socket) mangos.LatencyStats {
+	v, err := s.GetOption(mangos.OptionReplyLatency)
+	if err != nil {
+		t.Fatalf("GetOption: %v", err)
+	}
+	return v.(mangos.LatencyStats)
+}
+
+func TestReqReplyLatency(t *testing.T) {
+	addr := AddrTestInp()
+	clock := NewFakeClock()
+
+	srep, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sreq.SetOption(mangos.OptionClock, clock); err != nil {
+		t.Fatalf("Failed set clock: %v", err)
+	}
+	if err = sreq.SetOption(mangos.OptionRetryTime, time.Hour); err != nil {
+		t.Fatalf("Failed set retry: %v", err)
+	}
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	if s := replyLatency(t, sreq); s.Count != 0 || s.Mean() != 0 {
+		t.Errorf("Initial stats not empty: %+v", s)
+	}
+
+	roundTrip := func(delay time.Duration) {
+		if err := sreq.Send([]byte("ping")); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		if _, err := srep.Recv(); err != nil {
+			t.Fatalf("Failed server recv: %v", err)
+		}
+		clock.Advance(delay)
+		if err := srep.Send([]byte("pong")); err != nil {
+			t.Fatalf("Failed server send: %v", err)
+		}
+		if _, err := sreq.Recv(); err != nil {
+			t.Fatalf("Failed client recv: %v", err)
+		}
+	}
+
+	roundTrip(time.Millisecond * 250)
+	s := replyLatency(t, sreq)
+	if s.Count != 1 || s.Last != time.Millisecond*250 {
+		t.Errorf("After one reply: %+v", s)
+	}
+	roundTrip(time.Millisecond * 50)
+	s = replyLatency(t, sreq)
+	if s.Count != 2 || s.Min != time.Millisecond*50 ||
+		s.Max != time.Millisecond*250 || s.Mean() != time.Millisecond*150 {
+		t.Errorf("After two replies: %+v", s)
+	}
+
+	// A resent request is still measured from its first send.
+	if err = sreq.SetOption(mangos.OptionRetryTime, time.Millisecond*100); err != nil {
+		t.Fatalf("Failed set retry: %v", err)
+	}
+	if err = sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if _, err = srep.Recv(); err != nil {
+		t.Fatalf("Failed server recv: %v", err)
+	}
+	clock.Advance(time.Millisecond * 100)
+	if _, err = srep.Recv(); err != nil {
+		t.Fatalf("Failed to receive resend: %v", err)
+	}
+	clock.Advance(time.Millisecond * 30)
+	if err = srep.Send([]byte("pong")); err != nil {
+		t.Fatalf("Failed server send: %v", err)
+	}
+	if _, err = sreq.Recv(); err != nil {
+		t.Fatalf("Failed client recv: %v", err)
+	}
+	s = replyLatency(t, sreq)
+	if s.Count != 3 || s.Last != time.Millisecond*130 {
+		t.Errorf("After resend: %+v", s)
+	}
+}
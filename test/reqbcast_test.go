@@ -0,0 +1,165 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func repEcho(s mangos.Socket, name string) {
+	for {
+		m, err := s.RecvMsg()
+		if err != nil {
+			return
+		}
+		m.Body = append(m.Body, []byte(name)...)
+		if s.SendMsg(m) != nil {
+			return
+		}
+	}
+}
+
+func TestReqBroadcast(t *testing.T) {
+	const nrep = 3
+	inp := inproc.NewTransport()
+	a := AddrTestInp()
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inp)
+	if err = sreq.SetOption(mangos.OptionBroadcast, true); err != nil {
+		t.Fatalf("Failed set broadcast: %v", err)
+	}
+	if err = sreq.SetOption(mangos.OptionRecvDeadline, time.Millisecond*200); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+
+	for i := 0; i < nrep; i++ {
+		srep, err := rep.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to make REP: %v", err)
+		}
+		defer srep.Close()
+		srep.AddTransport(inp)
+		addr := a + fmt.Sprintf("REP%d", i)
+		if err = srep.Listen(addr); err != nil {
+			t.Fatalf("Failed listen: %v", err)
+		}
+		if err = sreq.Dial(addr); err != nil {
+			t.Fatalf("Failed dial: %v", err)
+		}
+		go repEcho(srep, fmt.Sprintf("%d", i))
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = sreq.Send([]byte("hello")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+
+	replies := make(map[mangos.Port][]byte)
+	for i := 0; i < nrep; i++ {
+		m, err := sreq.RecvMsg()
+		if err != nil {
+			t.Fatalf("Failed recv %d: %v", i, err)
+		}
+		if !bytes.HasPrefix(m.Body, []byte("hello")) {
+			t.Errorf("Bad reply: %q", m.Body)
+		}
+		replies[m.Port] = append([]byte{}, m.Body...)
+		m.Free()
+	}
+	if len(replies) != nrep {
+		t.Errorf("Got replies from %d peers, wanted %d", len(replies), nrep)
+	}
+	if _, err = sreq.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected timeout after all replies, got %v", err)
+	}
+}
+
+func TestReqBroadcastSlowPeer(t *testing.T) {
+	inp := inproc.NewTransport()
+	a := AddrTestInp()
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inp)
+	sreq.SetOption(mangos.OptionBroadcast, true)
+	sreq.SetOption(mangos.OptionSendDeadline, 50*time.Millisecond)
+	sreq.SetOption(mangos.OptionLinger, time.Duration(0))
+	drops := make(chan mangos.DropReason, 10)
+	sreq.SetDropHook(func(m *mangos.Message, r mangos.DropReason) {
+		drops <- r
+	})
+
+	var reps []mangos.Socket
+	for i := 0; i < 2; i++ {
+		srep, err := rep.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to make REP: %v", err)
+		}
+		defer srep.Close()
+		srep.AddTransport(inp)
+		srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+		srep.SetOption(mangos.OptionReadQLen, 0)
+		addr := a + fmt.Sprintf("REP%d", i)
+		if err = srep.Listen(addr); err != nil {
+			t.Fatalf("Failed listen: %v", err)
+		}
+		if err = sreq.Dial(addr); err != nil {
+			t.Fatalf("Failed dial: %v", err)
+		}
+		reps = append(reps, srep)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// The slow one never reads, so once what can be buffered on the
+	// way to it is full its copies are dropped, and reported, after the
+	// send deadline.  The other still gets every request, so long as we
+	// wait that out between them; anything queued meanwhile would expire.
+	fast, slow := reps[0], reps[1]
+	slow.PauseRecv()
+	const count = 6
+	for i := 0; i < count; i++ {
+		if err = sreq.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		if b, err := fast.Recv(); err != nil || string(b) != fmt.Sprintf("%d", i) {
+			t.Fatalf("Fast peer got %q, %v", b, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	select {
+	case r := <-drops:
+		if r != mangos.DropReasonQueueFull {
+			t.Errorf("Dropped for %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("No drop reported for the slow peer")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestReqFailFast(t *testing.T) {
+	addr := AddrTestInp()
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+
+	if err = s.SetOption(mangos.OptionReqFailFast, true); err != nil {
+		t.Fatalf("Failed set fail fast: %v", err)
+	}
+	if err = s.Send([]byte("ping")); err != mangos.ErrNoPeers {
+		t.Fatalf("Expected ErrNoPeers, got %v", err)
+	}
+
+	r, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	r.AddTransport(inproc.NewTransport())
+	if err = r.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if err = s.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send with a peer failed: %v", err)
+	}
+
+	// Once the peer goes away, we fail fast again.
+	r.Close()
+	time.Sleep(time.Millisecond * 100)
+	if err = s.Send([]byte("ping")); err != mangos.ErrNoPeers {
+		t.Errorf("Expected ErrNoPeers after disconnect, got %v", err)
+	}
+
+	// And turning the option off queues again.
+	if err = s.SetOption(mangos.OptionReqFailFast, false); err != nil {
+		t.Fatalf("Failed clear fail fast: %v", err)
+	}
+	if err = s.Send([]byte("ping")); err != nil {
+		t.Errorf("Send without fail fast failed: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newFailoverReq(t *testing.T, attempts int) mangos.Socket {
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	s.SetOption(mangos.OptionRetryTime, 100*time.Millisecond)
+	s.SetOption(mangos.OptionRecvDeadline, 2*time.Second)
+	if err = s.SetOption(mangos.OptionRetryOtherPeer, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionMaxAttempts, attempts); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	return s
+}
+
+// newFailoverRep returns a REP server that answers requests with reply,
+// or never answers if reply is nil.
+func newFailoverRep(t *testing.T, addr string, reply []byte) mangos.Socket {
+	s, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if reply != nil {
+		go func() {
+			for {
+				if _, err := s.Recv(); err != nil {
+					return
+				}
+				s.Send(reply)
+			}
+		}()
+	}
+	return s
+}
+
+func TestReqRetryOtherPeer(t *testing.T) {
+	addr1, addr2 := AddrTestInp(), AddrTestInp()
+	bad := newFailoverRep(t, addr1, nil)
+	defer bad.Close()
+	bad.SetOption(mangos.OptionRecvDeadline, 500*time.Millisecond)
+	good := newFailoverRep(t, addr2, []byte("good"))
+	defer good.Close()
+
+	c := newFailoverReq(t, 3)
+	defer c.Close()
+
+	// Make sure the first attempt goes to the peer that never answers.
+	if err := c.Dial(addr1); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if b, err := bad.Recv(); err != nil || string(b) != "ping" {
+		t.Fatalf("Bad server got %q, %v", b, err)
+	}
+	if err := c.Dial(addr2); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	b, err := c.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if string(b) != "good" {
+		t.Errorf("Got reply %q", b)
+	}
+	// The retry went to the other server, not back to this one.
+	if b, err := bad.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Bad server got request again %q, %v", b, err)
+	}
+}
+
+func TestReqMaxAttempts(t *testing.T) {
+	addr1, addr2 := AddrTestInp(), AddrTestInp()
+	bad1 := newFailoverRep(t, addr1, nil)
+	defer bad1.Close()
+	bad2 := newFailoverRep(t, addr2, nil)
+	defer bad2.Close()
+
+	c := newFailoverReq(t, 2)
+	defer c.Close()
+	if v, err := c.GetOption(mangos.OptionMaxAttempts); err != nil || v.(int) != 2 {
+		t.Errorf("MaxAttempts got %v, %v", v, err)
+	}
+	if err := c.SetOption(mangos.OptionMaxAttempts, -1); err != mangos.ErrBadValue {
+		t.Errorf("Negative attempts got %v", err)
+	}
+	c.Dial(addr1)
+	c.Dial(addr2)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := c.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := c.Recv(); err != mangos.ErrNoReply {
+		t.Errorf("Recv got %v, expected ErrNoReply", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond || d > time.Second {
+		t.Errorf("Gave up after %v", d)
+	}
+
+	// Each server saw the request once.
+	for _, s := range []mangos.Socket{bad1, bad2} {
+		s.SetOption(mangos.OptionRecvDeadline, 100*time.Millisecond)
+		if _, err := s.Recv(); err != nil {
+			t.Errorf("Server did not get request: %v", err)
+		}
+		if _, err := s.Recv(); err != mangos.ErrRecvTimeout {
+			t.Errorf("Server got request twice: %v", err)
+		}
+	}
+
+	// A new request starts over.
+	if err := c.Send([]byte("again")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := c.Recv(); err != mangos.ErrNoReply {
+		t.Errorf("Second Recv got %v", err)
+	}
+}
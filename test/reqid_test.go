@@ -0,0 +1,117 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// echoRep is a minimal custom REP, which returns each request unchanged
+// on the pipe it came from.  Unlike the standard REP, it does not care
+// about the backtrace convention.
+type echoRep struct {
+	sock mangos.ProtocolSocket
+}
+
+func (x *echoRep) Init(sock mangos.ProtocolSocket) { x.sock = sock }
+func (x *echoRep) Shutdown(time.Time)              {}
+func (x *echoRep) RemoveEndpoint(mangos.Endpoint)  {}
+func (x *echoRep) Number() uint16                  { return mangos.ProtoRep }
+func (x *echoRep) PeerNumber() uint16              { return mangos.ProtoReq }
+func (x *echoRep) Name() string                    { return "echorep" }
+func (x *echoRep) PeerName() string                { return "req" }
+
+func (x *echoRep) AddEndpoint(ep mangos.Endpoint) {
+	go func() {
+		for {
+			m := ep.RecvMsg()
+			if m == nil {
+				return
+			}
+			if ep.SendMsg(m) != nil {
+				m.Free()
+				return
+			}
+		}
+	}()
+}
+
+func (x *echoRep) SetOption(string, interface{}) error {
+	return mangos.ErrBadOption
+}
+
+func (x *echoRep) GetOption(string) (interface{}, error) {
+	return nil, mangos.ErrBadOption
+}
+
+func TestReqCustomRequestID(t *testing.T) {
+	addr := AddrTestInp()
+
+	srep := mangos.MakeSocket(&echoRep{})
+	defer srep.Close()
+	srep.AddTransport(inproc.NewTransport())
+	if err := srep.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	if err = sreq.SetOption(mangos.OptionRaw, true); err != nil {
+		t.Fatalf("Failed set raw: %v", err)
+	}
+	if err = sreq.SetOption(mangos.OptionRequestID, 5); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	var next uint32
+	idfn := func() uint32 { return atomic.AddUint32(&next, 1) }
+	if err = sreq.SetOption(mangos.OptionRequestID, idfn); err != nil {
+		t.Fatalf("Failed set request ID: %v", err)
+	}
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	for i := uint32(1); i <= 3; i++ {
+		if err = sreq.Send([]byte("hello")); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		m, err := sreq.RecvMsg()
+		if err != nil {
+			t.Fatalf("Failed recv: %v", err)
+		}
+		if len(m.Header) != 4 {
+			t.Fatalf("Bad header length %d", len(m.Header))
+		}
+		if id := binary.BigEndian.Uint32(m.Header); id != i {
+			t.Errorf("Got request ID %#x, expected %#x", id, i)
+		}
+		if string(m.Body) != "hello" {
+			t.Errorf("Bad body %q", string(m.Body))
+		}
+		m.Free()
+	}
+}
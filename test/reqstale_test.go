@@ -0,0 +1,202 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// holdRep is a custom REP that hands requests to the test, which decides
+// what, if anything, to send back on its endpoint.
+type holdRep struct {
+	reqs chan *mangos.Message
+	ep   mangos.Endpoint
+	sync.Mutex
+}
+
+func (x *holdRep) Init(mangos.ProtocolSocket)     {}
+func (x *holdRep) Shutdown(time.Time)             {}
+func (x *holdRep) RemoveEndpoint(mangos.Endpoint) {}
+func (x *holdRep) Number() uint16                 { return mangos.ProtoRep }
+func (x *holdRep) PeerNumber() uint16             { return mangos.ProtoReq }
+func (x *holdRep) Name() string                   { return "holdrep" }
+func (x *holdRep) PeerName() string               { return "req" }
+
+func (x *holdRep) AddEndpoint(ep mangos.Endpoint) {
+	x.Lock()
+	x.ep = ep
+	x.Unlock()
+	go func() {
+		for {
+			m := ep.RecvMsg()
+			if m == nil {
+				return
+			}
+			x.reqs <- m
+		}
+	}()
+}
+
+func (x *holdRep) SetOption(string, interface{}) error {
+	return mangos.ErrBadOption
+}
+
+func (x *holdRep) GetOption(string) (interface{}, error) {
+	return nil, mangos.ErrBadOption
+}
+
+// reply sends a reply carrying the given request ID (the first four bytes
+// of a request body).
+func (x *holdRep) reply(id []byte, body string) error {
+	m := mangos.NewMessage(len(body) + 4)
+	m.Body = append(m.Body, id...)
+	m.Body = append(m.Body, body...)
+	x.Lock()
+	ep := x.ep
+	x.Unlock()
+	return ep.SendMsg(m)
+}
+
+func TestReqStaleReply(t *testing.T) {
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	var reps [2]*holdRep
+	for i := range reps {
+		addr := AddrTestInp()
+		reps[i] = &holdRep{reqs: make(chan *mangos.Message, 1)}
+		s := mangos.MakeSocket(reps[i])
+		defer s.Close()
+		s.AddTransport(inproc.NewTransport())
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Failed listen: %v", err)
+		}
+		if err = sreq.Dial(addr); err != nil {
+			t.Fatalf("Failed dial: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = sreq.Send([]byte("query")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	var got, other *holdRep
+	var m *mangos.Message
+	select {
+	case m = <-reps[0].reqs:
+		got, other = reps[0], reps[1]
+	case m = <-reps[1].reqs:
+		got, other = reps[1], reps[0]
+	case <-time.After(time.Second):
+		t.Fatalf("Request not received")
+	}
+	id := append([]byte{}, m.Body[:4]...)
+	m.Free()
+
+	// The other peer never saw this request, but (as a server restarted
+	// while holding an earlier request with the same ID might) replies
+	// with a matching ID.  That reply must not be taken as the answer.
+	if err = other.reply(id, "stale"); err != nil {
+		t.Fatalf("Failed stale reply: %v", err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	if err = got.reply(id, "fresh"); err != nil {
+		t.Fatalf("Failed reply: %v", err)
+	}
+	b, err := sreq.Recv()
+	if err != nil {
+		t.Fatalf("Failed recv: %v", err)
+	}
+	if string(b) != "fresh" {
+		t.Errorf("Got reply %q, expected fresh", string(b))
+	}
+}
+
+func TestReqStaleByAge(t *testing.T) {
+	sreq, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer sreq.Close()
+	sreq.AddTransport(inproc.NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	sreq.SetOption(mangos.OptionRetryTime, 100*time.Millisecond)
+	sreq.SetOption(mangos.OptionSendTimestamp, true)
+	sreq.SetOption(mangos.OptionMaxMessageAge, time.Minute)
+
+	// The server's clock is an hour behind, so its replies look stale.
+	clock := NewFakeClock()
+	clock.Advance(-time.Hour)
+	rep := &holdRep{reqs: make(chan *mangos.Message, 2)}
+	s := mangos.MakeSocket(rep)
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	s.SetOption(mangos.OptionSendTimestamp, true)
+	s.SetOption(mangos.OptionClock, clock)
+	addr := AddrTestInp()
+	if err = s.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	if err = sreq.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = sreq.Send([]byte("query")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	var m *mangos.Message
+	select {
+	case m = <-rep.reqs:
+	case <-time.After(time.Second):
+		t.Fatalf("Request not received")
+	}
+	id := append([]byte{}, m.Body[:4]...)
+	m.Free()
+	if err = rep.reply(id, "stale"); err != nil {
+		t.Fatalf("Failed stale reply: %v", err)
+	}
+
+	// The stale reply is dropped without satisfying the request, so it
+	// is sent again, and the answer to that is taken.
+	select {
+	case m = <-rep.reqs:
+	case <-time.After(time.Second):
+		t.Fatalf("Request not resent")
+	}
+	m.Free()
+	s.SetOption(mangos.OptionClock, mangos.RealClock())
+	if err = rep.reply(id, "fresh"); err != nil {
+		t.Fatalf("Failed reply: %v", err)
+	}
+	b, err := sreq.Recv()
+	if err != nil {
+		t.Fatalf("Failed recv: %v", err)
+	}
+	if string(b) != "fresh" {
+		t.Errorf("Got reply %q, expected fresh", string(b))
+	}
+}
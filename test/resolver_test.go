@@ -0,0 +1,184 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// fakeResolver hands out a different address on each call, sticking with
+// the last one once the list is exhausted.
+type fakeResolver struct {
+	addrs []string
+	calls int
+	sync.Mutex
+}
+
+func (r *fakeResolver) Resolve(addr string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !strings.HasPrefix(addr, "disco://") {
+		return nil, mangos.ErrBadAddr
+	}
+	i := r.calls
+	if i >= len(r.addrs) {
+		i = len(r.addrs) - 1
+	}
+	r.calls++
+	return []string{r.addrs[i]}, nil
+}
+
+// nameServer starts a REP socket that replies to everything with its name.
+func nameServer(t *testing.T, addr, name string) mangos.Socket {
+	s, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	go func() {
+		for {
+			m, err := s.RecvMsg()
+			if err != nil {
+				return
+			}
+			m.Body = append(m.Body[:0], name...)
+			if s.SendMsg(m) != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func TestResolverReresolve(t *testing.T) {
+	addrA := AddrTestInp()
+	addrB := AddrTestInp() + "b"
+	srvA := nameServer(t, addrA, "A")
+	defer srvA.Close()
+	srvB := nameServer(t, addrB, "B")
+	defer srvB.Close()
+
+	r := &fakeResolver{addrs: []string{addrA, addrB}}
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	s.AddResolver("disco", r)
+	if err = s.SetOption(mangos.OptionReconnectTime, time.Millisecond*10); err != nil {
+		t.Fatalf("Failed set reconnect time: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRetryTime, time.Millisecond*100); err != nil {
+		t.Fatalf("Failed set retry time: %v", err)
+	}
+	if err = s.Dial("disco://names"); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	ask := func(expect string) {
+		if err := s.Send([]byte("who")); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Failed recv: %v", err)
+		}
+		if string(b) != expect {
+			t.Fatalf("Expected reply from %s, got %s", expect, b)
+		}
+	}
+
+	ask("A")
+
+	// Dropping the first server forces a reconnect, which must go
+	// through the resolver again, and so find the second server.
+	srvA.Close()
+	ask("B")
+
+	r.Lock()
+	calls := r.calls
+	r.Unlock()
+	if calls < 2 {
+		t.Errorf("Expected at least 2 resolutions, got %d", calls)
+	}
+}
+
+func TestResolverUnknown(t *testing.T) {
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Dial("disco://names"); err != mangos.ErrBadTran {
+		t.Errorf("Expected ErrBadTran without resolver, got %v", err)
+	}
+	s.AddResolver("disco", mangos.ResolverFunc(func(string) ([]string, error) {
+		return nil, nil
+	}))
+	d, err := s.NewDialer("disco://names", nil)
+	if err != nil {
+		t.Fatalf("Failed to make dialer: %v", err)
+	}
+	if d.Address() != "disco://names" {
+		t.Errorf("Bad dialer address %s", d.Address())
+	}
+}
+
+func TestResolverForeignOption(t *testing.T) {
+	addr := AddrTestInp()
+	srv := nameServer(t, addr, "A")
+	defer srv.Close()
+
+	s, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REQ: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	s.AddResolver("disco", &fakeResolver{addrs: []string{addr}})
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	// The option means nothing to inproc, which is what the name
+	// resolves to, so it is skipped rather than failing the dial.
+	opts := map[string]interface{}{
+		mangos.OptionTLSConfig: &tls.Config{ServerName: "example.com"},
+	}
+	if err = s.DialOptions("disco://names", opts); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	if err = s.Send([]byte("who")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if b, err := s.Recv(); err != nil || string(b) != "A" {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestRoundRobin(t *testing.T) {
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	if err = tx.SetOption(mangos.OptionRoundRobin, "yes"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = tx.SetOption(mangos.OptionRoundRobin, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		addr := AddrTestInp()
+		rx, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer rx.Close()
+		rx.AddTransport(inproc.NewTransport())
+		if err = rx.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		// Drain, so that no peer is ever slow to take its turn.
+		go func() {
+			for {
+				if _, err := rx.Recv(); err != nil {
+					return
+				}
+			}
+		}()
+		if err = tx.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	eps := tx.Endpoints()
+	if len(eps) != 3 {
+		t.Fatalf("Expected 3 endpoints, got %d", len(eps))
+	}
+
+	for i := 0; i < 12; i++ {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, byte(i))
+		ep, err := tx.SendMsgEndpoint(m)
+		if err != nil {
+			t.Fatalf("SendMsgEndpoint: %v", err)
+		}
+		if want := eps[i%3].GetID(); ep.GetID() != want {
+			t.Errorf("Message %d went to %x, expected %x", i, ep.GetID(), want)
+		}
+	}
+
+	// A disabled peer drops out of the rotation.
+	eps[1].SetSendEnabled(false)
+	for i := 0; i < 4; i++ {
+		ep, err := tx.SendMsgEndpoint(mangos.NewMessage(0))
+		if err != nil {
+			t.Fatalf("SendMsgEndpoint: %v", err)
+		}
+		if want := eps[(i%2)*2].GetID(); ep.GetID() != want {
+			t.Errorf("Message %d went to %x, expected %x", i, ep.GetID(), want)
+		}
+	}
+}
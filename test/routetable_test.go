@@ -0,0 +1,129 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestRouteTableSize(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(inproc.NewTransport())
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srv.SetOption(mangos.OptionRouteTableSize, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = srv.SetOption(mangos.OptionRouteTableSize, 4); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	evictions := func() uint64 {
+		v, err := srv.GetOption(mangos.OptionRouteEvictions)
+		if err != nil {
+			t.Fatalf("GetOption: %v", err)
+		}
+		return v.(uint64)
+	}
+
+	// Clients that stay connected, but are not heard from again.  They
+	// must not redial once evicted, or they would evict one another.
+	var clients []mangos.Socket
+	connect := func() {
+		c, err := req.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		clients = append(clients, c)
+		c.AddTransport(inproc.NewTransport())
+		c.SetOption(mangos.OptionReconnectTime, time.Hour)
+		c.SetOption(mangos.OptionRecvDeadline, time.Second)
+		if err = c.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		// Wait for the server to account for it, and for any peer it
+		// evicted to be gone.
+		for i := 0; ; i++ {
+			n := len(srv.Endpoints())
+			if n <= 4 && uint64(n)+evictions() == uint64(len(clients)) {
+				break
+			}
+			if i == 500 {
+				t.Fatalf("Client %d not seen", len(clients))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+
+	ask := func(c mangos.Socket) {
+		if err := c.Send([]byte("ping")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		m, err := srv.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if err = srv.Send(m); err != nil {
+			t.Fatalf("Send reply: %v", err)
+		}
+		if _, err = c.Recv(); err != nil {
+			t.Fatalf("Recv reply: %v", err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		connect()
+	}
+	first := clients[0]
+	if n := evictions(); n != 0 {
+		t.Errorf("Evicted %d before the table was full", n)
+	}
+
+	// The first client is in use, so it is not the one to go.
+	ask(first)
+	connect()
+	if n := evictions(); n != 1 {
+		t.Errorf("Expected 1 eviction, got %d", n)
+	}
+	ask(first)
+
+	for i := 0; i < 20; i++ {
+		connect()
+	}
+	if n := evictions(); n != 21 {
+		t.Errorf("Expected 21 evictions, got %d", n)
+	}
+	if n := len(srv.Endpoints()); n != 4 {
+		t.Errorf("Expected 4 peers, got %d", n)
+	}
+}
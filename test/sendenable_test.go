@@ -0,0 +1,98 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestSendEnabled(t *testing.T) {
+	addrs := []string{AddrTestTCP(), AddrTestTCP()}
+	pulls := make([]mangos.Socket, len(addrs))
+	for i, addr := range addrs {
+		s, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(tcp.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, 200*time.Millisecond)
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		pulls[i] = s
+	}
+
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	ports := make(map[string]mangos.Port)
+	wg.Add(len(addrs))
+	p.SetPortHook(func(a mangos.PortAction, port mangos.Port) bool {
+		if a == mangos.PortActionAdd {
+			if !port.SendEnabled() {
+				t.Errorf("New port is not enabled")
+			}
+			lk.Lock()
+			ports[port.Address()] = port
+			lk.Unlock()
+			wg.Done()
+		}
+		return true
+	})
+	for _, addr := range addrs {
+		if err = p.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	}
+	wg.Wait()
+
+	// With each peer disabled in turn, everything goes to the other.
+	for off := range addrs {
+		on := 1 - off
+		ports[addrs[off]].SetSendEnabled(false)
+		ports[addrs[on]].SetSendEnabled(true)
+		if ports[addrs[off]].SendEnabled() {
+			t.Errorf("Port still enabled")
+		}
+
+		for i := 0; i < 20; i++ {
+			if err = p.Send([]byte{byte(i)}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		}
+		for i := 0; i < 20; i++ {
+			if _, err = pulls[on].Recv(); err != nil {
+				t.Fatalf("Recv %d on enabled peer: %v", i, err)
+			}
+		}
+		if m, err := pulls[off].Recv(); err != mangos.ErrRecvTimeout {
+			t.Errorf("Disabled peer got %v, %v", m, err)
+		}
+	}
+}
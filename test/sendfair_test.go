@@ -0,0 +1,113 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSendFairness(t *testing.T) {
+	const senders = 50
+	const total = senders * 40
+	addr := AddrTestInp()
+
+	rx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(inproc.NewTransport())
+	if err = rx.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	tx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	// With no queue, every sender has to wait its turn.
+	if err = tx.SetOption(mangos.OptionWriteQLen, 0); err != nil {
+		t.Fatalf("Failed set write queue: %v", err)
+	}
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if tx.Send([]byte{id}) != nil {
+					return
+				}
+			}
+		}(byte(i))
+	}
+
+	counts := make([]int, senders)
+	for i := 0; i < total; i++ {
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed after %d: %v", i, err)
+		}
+		counts[b[0]]++
+	}
+	close(stop)
+	// Drain, so that senders still waiting can see the stop.
+	go func() {
+		for {
+			if _, err := rx.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	min, max := total, 0
+	for _, c := range counts {
+		if c < min {
+			min = c
+		}
+		if c > max {
+			max = c
+		}
+	}
+	t.Logf("Per sender messages: min %d max %d (mean %d)", min, max, total/senders)
+	// FIFO service gives each sender its turn in order, so the spread
+	// should be tiny; allow generous slack for startup.
+	if min < total/senders/2 || max > total/senders*2 {
+		t.Errorf("Unfair distribution: %v", counts)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestSendMsgEndpoint(t *testing.T) {
+	pulls := make(map[string]mangos.Socket)
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	p.SetOption(mangos.OptionSendDeadline, time.Second)
+
+	for i := 0; i < 2; i++ {
+		addr := AddrTestTCP()
+		s, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(tcp.NewTransport())
+		s.SetOption(mangos.OptionRecvDeadline, time.Second)
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		if err = p.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		pulls[addr] = s
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		m := mangos.NewMessage(1)
+		m.Body = append(m.Body, byte(i))
+		ep, err := p.SendMsgEndpoint(m)
+		if err != nil {
+			t.Fatalf("SendMsgEndpoint: %v", err)
+		}
+		if ep == nil {
+			t.Fatalf("No endpoint returned")
+		}
+		s := pulls[ep.(mangos.Port).Address()]
+		if s == nil {
+			t.Fatalf("Unknown endpoint %v", ep.(mangos.Port).Address())
+		}
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d: %v", i, err)
+		}
+		if len(b) != 1 || b[0] != byte(i) {
+			t.Errorf("Got %v, expected %d", b, i)
+		}
+	}
+}
+
+func TestSendMsgEndpointFanOut(t *testing.T) {
+	s, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+
+	ep, err := s.SendMsgEndpoint(mangos.NewMessage(0))
+	if err != nil || ep != nil {
+		t.Errorf("Got %v, %v", ep, err)
+	}
+}
+
+func TestSendMsgEndpointTimeout(t *testing.T) {
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer p.Close()
+	tran := stallTran{releaseq: make(chan struct{}), sent: new(int32)}
+	p.AddTransport(tran)
+	p.SetOption(mangos.OptionSendDeadline, 100*time.Millisecond)
+	if err = p.Dial("stall://nowhere"); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The first write has begun, and stalls, so its endpoint is given
+	// with the timeout.  The second never starts, and is discarded.
+	for i := 0; i < 2; i++ {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, "ping"...)
+		ep, err := p.SendMsgEndpoint(m)
+		if err != mangos.ErrSendTimeout {
+			t.Errorf("Send %d: expected ErrSendTimeout, got %v", i, err)
+		}
+		if (ep != nil) != (i == 0) {
+			t.Errorf("Send %d: got endpoint %v", i, ep)
+		}
+	}
+	close(tran.releaseq)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(tran.sent); n != 1 {
+		t.Errorf("Expected 1 write, got %d", n)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	"nanomsg.org/go-mangos"
+)
+
+func TestSendNoCopy(t *testing.T) {
+	for _, own := range []bool{false, true} {
+		// The transport shares bodies, so only Send's copy protects
+		// the caller's slice.
+		tx, rx := newInprocPair(t, AddrTestInp(), true)
+		if err := tx.SetOption(mangos.OptionSendNoCopy, 1); err != mangos.ErrBadValue {
+			t.Errorf("Expected ErrBadValue, got %v", err)
+		}
+		if err := tx.SetOption(mangos.OptionSendNoCopy, own); err != nil {
+			t.Fatalf("SetOption: %v", err)
+		}
+
+		body := []byte("original")
+		if err := tx.Send(body); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if !own {
+			// Allowed, as Send made its own copy.
+			copy(body, "mutated!")
+		}
+		m, err := rx.RecvMsg()
+		if err != nil {
+			t.Fatalf("RecvMsg: %v", err)
+		}
+		if string(m.Body) != "original" {
+			t.Errorf("own %v: got %q", own, m.Body)
+		}
+		if shared := &m.Body[0] == &body[0]; shared != own {
+			t.Errorf("own %v: body shared %v", own, shared)
+		}
+		m.Free()
+		tx.Close()
+		rx.Close()
+	}
+}
+
+func benchmarkSendNoCopy(b *testing.B, own bool) {
+	const size = 64 * 1024
+	tx, rx := newInprocPair(b, AddrTestInp(), true)
+	defer tx.Close()
+	defer rx.Close()
+	tx.SetOption(mangos.OptionSendNoCopy, own)
+
+	// Each message is given a freshly allocated slice, as
+	// OptionSendNoCopy requires, so both cases pay for that.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			m, err := rx.RecvMsg()
+			if err != nil {
+				b.Errorf("RecvMsg %d: %v", i, err)
+				return
+			}
+			m.Free()
+		}
+	}()
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tx.Send(make([]byte, size)); err != nil {
+			b.Fatalf("Send: %v", err)
+		}
+	}
+	<-done
+	b.StopTimer()
+}
+
+func BenchmarkSendCopy64K(b *testing.B) {
+	benchmarkSendNoCopy(b, false)
+}
+
+func BenchmarkSendNoCopy64K(b *testing.B) {
+	benchmarkSendNoCopy(b, true)
+}
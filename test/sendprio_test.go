@@ -0,0 +1,180 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/bus"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// testSendPriority queues a flood of low priority messages with no peer
+// present, then an urgent one, then attaches a peer and returns the
+// messages it receives, in order.
+func testSendPriority(t *testing.T, prio bool) []string {
+	const flood = 50
+	addr := AddrTestInp()
+
+	spush, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer spush.Close()
+	spush.AddTransport(inproc.NewTransport())
+	if err = spush.SetOption(mangos.OptionSendPriority, prio); err != nil {
+		t.Fatalf("Failed set send priority: %v", err)
+	}
+	if err = spush.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	if err = spush.SetOption(mangos.OptionSendPriority, prio); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption once active, got %v", err)
+	}
+
+	for i := 0; i < flood; i++ {
+		if err = spush.Send([]byte(fmt.Sprintf("bulk %d", i))); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+	}
+	m := mangos.NewMessage(0)
+	m.Body = append(m.Body, "urgent"...)
+	m.SetPriority(100)
+	if m.Priority() != mangos.MaxPriority {
+		t.Errorf("Priority not clamped: %d", m.Priority())
+	}
+	if err = spush.SendMsg(m); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+
+	spull, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PULL: %v", err)
+	}
+	defer spull.Close()
+	spull.AddTransport(inproc.NewTransport())
+	spull.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = spull.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+
+	var got []string
+	for i := 0; i <= flood; i++ {
+		b, err := spull.Recv()
+		if err != nil {
+			t.Fatalf("Failed recv %d: %v", i, err)
+		}
+		got = append(got, string(b))
+	}
+	return got
+}
+
+func TestSendPriority(t *testing.T) {
+	got := testSendPriority(t, true)
+	if got[0] != "urgent" {
+		t.Errorf("Got %q first, expected urgent", got[0])
+	}
+	// The bulk messages keep their order.
+	for i, s := range got[1:] {
+		if s != fmt.Sprintf("bulk %d", i) {
+			t.Fatalf("Got %q at %d, expected bulk %d", s, i+1, i)
+		}
+	}
+}
+
+func TestSendPriorityDefaultFIFO(t *testing.T) {
+	got := testSendPriority(t, false)
+	if got[0] != "bulk 0" || got[len(got)-1] != "urgent" {
+		t.Errorf("Order not FIFO: first %q, last %q", got[0], got[len(got)-1])
+	}
+}
+
+// testSendPriorityFanOut does the same for a protocol that copies each
+// message to a queue per peer, whose one peer stalls on its first message
+// while the rest are queued for it.
+func testSendPriorityFanOut(t *testing.T, s mangos.Socket) {
+	const flood = 20
+	defer s.Close()
+	tran := stallTran{
+		releaseq: make(chan struct{}),
+		sent:     new(int32),
+		bodies:   make(chan string, flood+2),
+	}
+	s.AddTransport(tran)
+	s.SetOption(mangos.OptionSendPriority, true)
+	s.SetOption(mangos.OptionWriteQLenPerPipe, flood+2)
+	if err := s.Dial("stall://nowhere"); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.Send([]byte("first")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < flood; i++ {
+		if err := s.Send([]byte(fmt.Sprintf("bulk %d", i))); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+	}
+	m := mangos.NewMessage(0)
+	m.Body = append(m.Body, "urgent"...)
+	m.SetPriority(mangos.MaxPriority)
+	if err := s.SendMsg(m); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(tran.releaseq)
+
+	var got []string
+	for i := 0; i < flood+2; i++ {
+		select {
+		case b := <-tran.bodies:
+			got = append(got, b)
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d messages written", i)
+		}
+	}
+	if got[0] != "first" || got[1] != "urgent" {
+		t.Errorf("Got %q then %q, expected first then urgent", got[0], got[1])
+	}
+	for i, s := range got[2:] {
+		if s != fmt.Sprintf("bulk %d", i) {
+			t.Fatalf("Got %q at %d, expected bulk %d", s, i+2, i)
+		}
+	}
+}
+
+func TestSendPriorityPub(t *testing.T) {
+	s, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	testSendPriorityFanOut(t, s)
+}
+
+func TestSendPriorityBus(t *testing.T) {
+	s, err := bus.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make BUS: %v", err)
+	}
+	testSendPriorityFanOut(t, s)
+}
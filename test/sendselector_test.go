@@ -0,0 +1,208 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// rendezvous picks the endpoint with the highest hash of the key and its
+// ID, so that a key only moves when its endpoint goes away.
+func rendezvous(eps []mangos.Endpoint, key []byte) mangos.Endpoint {
+	var best mangos.Endpoint
+	var top uint32
+	for _, ep := range eps {
+		h := fnv.New32a()
+		h.Write(key)
+		var id [4]byte
+		binary.BigEndian.PutUint32(id[:], ep.GetID())
+		h.Write(id[:])
+		if v := h.Sum32(); best == nil || v > top {
+			best, top = ep, v
+		}
+	}
+	return best
+}
+
+func TestSendSelectorHash(t *testing.T) {
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	tx.SetOption(mangos.OptionReconnectTime, time.Hour)
+	if err = tx.SetOption(mangos.OptionSendSelector, "key"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	err = tx.SetOption(mangos.OptionSendSelector,
+		func(eps []mangos.Endpoint, m *mangos.Message) mangos.Endpoint {
+			return rendezvous(eps, m.Body)
+		})
+	if err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := tx.GetOption(mangos.OptionSendSelector); err != nil || v.(mangos.SendSelector) == nil {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		addr := AddrTestInp()
+		rx, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer rx.Close()
+		rx.AddTransport(inproc.NewTransport())
+		if err = rx.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		go func() {
+			for {
+				if _, err := rx.Recv(); err != nil {
+					return
+				}
+			}
+		}()
+		if err = tx.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	}
+	waitEndpoints := func(n int) {
+		for i := 0; len(tx.Endpoints()) != n; i++ {
+			if i == 100 {
+				t.Fatalf("Expected %d endpoints, got %d", n, len(tx.Endpoints()))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitEndpoints(3)
+
+	// Each key goes where the hash says, every time.
+	pinned := make(map[string]uint32)
+	send := func() {
+		eps := tx.Endpoints()
+		for round := 0; round < 2; round++ {
+			for k := 0; k < 30; k++ {
+				key := fmt.Sprintf("key%d", k)
+				m := mangos.NewMessage(0)
+				m.Body = append(m.Body, key...)
+				ep, err := tx.SendMsgEndpoint(m)
+				if err != nil {
+					t.Fatalf("SendMsgEndpoint: %v", err)
+				}
+				want := rendezvous(eps, []byte(key)).GetID()
+				if ep.GetID() != want {
+					t.Errorf("%s went to %x, expected %x", key, ep.GetID(), want)
+				}
+				pinned[key] = ep.GetID()
+			}
+		}
+	}
+	send()
+	before := make(map[string]uint32)
+	for k, id := range pinned {
+		before[k] = id
+	}
+
+	// Losing a peer only moves the keys it had.
+	ep := tx.Endpoints()[0]
+	gone := ep.GetID()
+	ep.(mangos.Port).Close()
+	waitEndpoints(2)
+	send()
+	for k, id := range before {
+		if id != gone && pinned[k] != id {
+			t.Errorf("%s moved from %x to %x", k, id, pinned[k])
+		}
+		if pinned[k] == gone {
+			t.Errorf("%s still sent to the closed peer", k)
+		}
+	}
+}
+
+func TestSendSelectorReq(t *testing.T) {
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	cli.AddTransport(inproc.NewTransport())
+	cli.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	var addrs []string
+	for _, name := range []string{"a", "b"} {
+		addr := AddrTestInp()
+		addrs = append(addrs, addr)
+		srv, err := rep.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer srv.Close()
+		srv.AddTransport(inproc.NewTransport())
+		if err = srv.Listen(addr); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		go func(name string) {
+			for {
+				m, err := srv.RecvMsg()
+				if err != nil {
+					return
+				}
+				m.Body = append(m.Body[:0], name...)
+				srv.SendMsg(m)
+			}
+		}(name)
+		if err = cli.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Route by address: everything to the second server.
+	cli.SetOption(mangos.OptionSendSelector,
+		mangos.SendSelector(func(eps []mangos.Endpoint, m *mangos.Message) mangos.Endpoint {
+			for _, ep := range eps {
+				if ep.(mangos.Port).Address() == addrs[1] {
+					return ep
+				}
+			}
+			return nil
+		}))
+	for i := 0; i < 10; i++ {
+		if err = cli.Send([]byte("who")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		b, err := cli.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(b) != "b" {
+			t.Errorf("Request %d answered by %q", i, b)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSendSizeOption(t *testing.T) {
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+
+	for _, name := range []string{mangos.OptionMaxSendSize, mangos.OptionMinSendSize} {
+		if v, err := s.GetOption(name); err != nil || v.(int) != 0 {
+			t.Errorf("%s default: %v %v", name, v, err)
+		}
+		if err := s.SetOption(name, -1); err != mangos.ErrBadValue {
+			t.Errorf("%s negative: expected ErrBadValue, got %v", name, err)
+		}
+		if err := s.SetOption(name, "big"); err != mangos.ErrBadValue {
+			t.Errorf("%s string: expected ErrBadValue, got %v", name, err)
+		}
+		if err := s.SetOption(name, 16); err != nil {
+			t.Errorf("%s set: %v", name, err)
+		}
+		if v, err := s.GetOption(name); err != nil || v.(int) != 16 {
+			t.Errorf("%s readback: %v %v", name, v, err)
+		}
+	}
+}
+
+func TestSendSizeBounds(t *testing.T) {
+	addr := AddrTestInp()
+	rx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(inproc.NewTransport())
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := rx.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	tx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	if err := tx.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	tx.SetOption(mangos.OptionMinSendSize, 2)
+	tx.SetOption(mangos.OptionMaxSendSize, 4)
+
+	cases := []struct {
+		size int
+		err  error
+	}{
+		{0, mangos.ErrTooShort},
+		{1, mangos.ErrTooShort},
+		{2, nil},
+		{4, nil},
+		{5, mangos.ErrTooLong},
+		{1024, mangos.ErrTooLong},
+	}
+	for _, c := range cases {
+		if err := tx.Send(make([]byte, c.size)); err != c.err {
+			t.Errorf("Send of %d bytes: expected %v, got %v", c.size, c.err, err)
+			continue
+		}
+		if c.err != nil {
+			continue
+		}
+		m, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv of %d bytes failed: %v", c.size, err)
+		}
+		if len(m) != c.size {
+			t.Errorf("Got %d bytes, expected %d", len(m), c.size)
+		}
+	}
+
+	// Rejected messages must never reach the peer.
+	rx.SetOption(mangos.OptionRecvDeadline, time.Millisecond*20)
+	if m, err := rx.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Unexpected message %v (%v)", m, err)
+	}
+
+	// Zero removes the bounds again.
+	tx.SetOption(mangos.OptionMinSendSize, 0)
+	tx.SetOption(mangos.OptionMaxSendSize, 0)
+	if err := tx.Send([]byte{}); err != nil {
+		t.Errorf("Empty send: %v", err)
+	}
+	if err := tx.Send(make([]byte, 1024)); err != nil {
+		t.Errorf("Large send: %v", err)
+	}
+}
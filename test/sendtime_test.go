@@ -0,0 +1,208 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSendTimestamp(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+	s.SetOption(mangos.OptionSubscribe, "topic")
+
+	if err := p.SetOption(mangos.OptionSendTimestamp, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	for _, x := range []mangos.Socket{p, s} {
+		if err := x.SetOption(mangos.OptionSendTimestamp, true); err != nil {
+			t.Fatalf("SetOption: %v", err)
+		}
+		if v, err := x.GetOption(mangos.OptionSendTimestamp); err != nil || !v.(bool) {
+			t.Errorf("GetOption got %v, %v", v, err)
+		}
+	}
+
+	before := time.Now()
+	if err := p.Send([]byte("topic body")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("RecvMsg: %v", err)
+	}
+	if string(m.Body) != "topic body" {
+		t.Errorf("Got body %q", m.Body)
+	}
+	if st := m.SendTime(); st.Before(before.Add(-time.Millisecond)) || st.After(time.Now()) {
+		t.Errorf("Send time %v not close to %v", st, before)
+	}
+	m.Free()
+
+	// Without the option on the sender, there is no stamp.
+	p.SetOption(mangos.OptionSendTimestamp, false)
+	p.Send([]byte("topic plain"))
+	if m, err = s.RecvMsg(); err != nil {
+		t.Fatalf("RecvMsg: %v", err)
+	}
+	if string(m.Body) != "topic plain" || !m.SendTime().IsZero() {
+		t.Errorf("Got %q, sent at %v", m.Body, m.SendTime())
+	}
+	m.Free()
+}
+
+func TestSendTimestampClock(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+	s.SetOption(mangos.OptionSubscribeAll, true)
+	s.SetOption(mangos.OptionSendTimestamp, true)
+	p.SetOption(mangos.OptionSendTimestamp, true)
+
+	// The stamp comes from the sender's Clock.
+	clk := NewFakeClock()
+	clk.Advance(time.Hour)
+	if err := p.SetOption(mangos.OptionClock, clk); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	p.Send([]byte("x"))
+	m, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("RecvMsg: %v", err)
+	}
+	if !m.SendTime().Equal(clk.Now()) {
+		t.Errorf("Send time %v, expected %v", m.SendTime(), clk.Now())
+	}
+	m.Free()
+}
+
+func TestMaxMessageAge(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+	s.SetOption(mangos.OptionSubscribeAll, true)
+	s.SetOption(mangos.OptionSendTimestamp, true)
+	p.SetOption(mangos.OptionSendTimestamp, true)
+	s.SetOption(mangos.OptionRecvDeadline, 200*time.Millisecond)
+
+	if err := s.SetOption(mangos.OptionMaxMessageAge, -time.Second); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := s.SetOption(mangos.OptionMaxMessageAge, time.Minute); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	// The sender's clock is an hour behind the receiver's, so what it
+	// sends looks old.
+	clk := NewFakeClock()
+	clk.Advance(time.Hour)
+	s.SetOption(mangos.OptionClock, clk)
+
+	p.Send([]byte("stale"))
+	if b, err := s.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Got %q, %v", b, err)
+	}
+	if v, err := s.GetOption(mangos.OptionStaleDrops); err != nil || v.(uint64) != 1 {
+		t.Errorf("Stale drops %v, %v", v, err)
+	}
+
+	// Once the clocks agree, messages get through.
+	p.SetOption(mangos.OptionClock, clk)
+	p.Send([]byte("fresh"))
+	if b, err := s.Recv(); err != nil || string(b) != "fresh" {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
+
+func TestSendTimestampBody(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+	s.SetOption(mangos.OptionSubscribeAll, true)
+	s.SetOption(mangos.OptionSendTimestamp, true)
+	p.SetOption(mangos.OptionSendTimestamp, true)
+
+	// The stamp is kept apart from the message itself, so the body is
+	// left as it was, even if it looks like a stamp.
+	body := append([]byte("data"), "SPTS\x00\x00\x00\x00\x00\x00\x00\x01"...)
+	m := mangos.NewMessage(len(body))
+	m.Body = append(m.Body, body...)
+	m.Dup()
+	if err := p.SendMsg(m); err != nil {
+		t.Fatalf("SendMsg: %v", err)
+	}
+	got, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("RecvMsg: %v", err)
+	}
+	if string(got.Body) != string(body) || got.SendTime().IsZero() {
+		t.Errorf("Got %q, sent at %v", got.Body, got.SendTime())
+	}
+	got.Free()
+	if string(m.Body) != string(body) {
+		t.Errorf("Message sent changed to %q", m.Body)
+	}
+	m.Free()
+}
+
+func TestSendTimestampReqResend(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer cli.Close()
+	for _, x := range []mangos.Socket{srv, cli} {
+		x.AddTransport(inproc.NewTransport())
+		x.SetOption(mangos.OptionSendTimestamp, true)
+		x.SetOption(mangos.OptionRecvDeadline, time.Second)
+	}
+	cli.SetOption(mangos.OptionRetryTime, 50*time.Millisecond)
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if err = cli.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err = cli.Send([]byte("ask")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// Receiving the first copy, but not answering, gets us a resend,
+	// which carries the stamp too.
+	for i := 0; i < 2; i++ {
+		m, err := srv.RecvMsg()
+		if err != nil {
+			t.Fatalf("RecvMsg %d: %v", i, err)
+		}
+		if string(m.Body) != "ask" || m.SendTime().IsZero() {
+			t.Errorf("Copy %d: got %q, sent at %v", i, m.Body, m.SendTime())
+		}
+		m.Free()
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSendToEndpoint(t *testing.T) {
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(inproc.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	if err = tx.SetOption(mangos.OptionRaw, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	var mx sync.Mutex
+	ports := make(map[string]mangos.Port)
+	tx.SetPortHook(func(act mangos.PortAction, p mangos.Port) bool {
+		if act == mangos.PortActionAdd {
+			mx.Lock()
+			ports[p.Address()] = p
+			mx.Unlock()
+		}
+		return true
+	})
+
+	var addrs [2]string
+	var rxs [2]mangos.Socket
+	for i := range rxs {
+		addrs[i] = AddrTestInp()
+		if rxs[i], err = pull.NewSocket(); err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer rxs[i].Close()
+		rxs[i].AddTransport(inproc.NewTransport())
+		rxs[i].SetOption(mangos.OptionRecvDeadline, time.Millisecond*200)
+		if err = rxs[i].Listen(addrs[i]); err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		if err = tx.Dial(addrs[i]); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	mx.Lock()
+	target := ports[addrs[1]]
+	mx.Unlock()
+	if target == nil {
+		t.Fatalf("Port for %s not found", addrs[1])
+	}
+	ep := target.(mangos.Endpoint)
+
+	for i := 0; i < 5; i++ {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, []byte("ping")...)
+		if err = tx.SendToEndpoint(ep, m); err != nil {
+			t.Fatalf("SendToEndpoint: %v", err)
+		}
+		b, err := rxs[1].Recv()
+		if err != nil {
+			t.Fatalf("Target did not receive %d: %v", i, err)
+		}
+		if string(b) != "ping" {
+			t.Errorf("Got %q", b)
+		}
+	}
+	if b, err := rxs[0].Recv(); err == nil {
+		t.Errorf("Other peer received %q", b)
+	}
+
+	// Cooked sockets may not choose the peer.
+	other, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer other.Close()
+	m := mangos.NewMessage(0)
+	if err = other.SendToEndpoint(ep, m); err != mangos.ErrProtoOp {
+		t.Errorf("Expected ErrProtoOp on cooked socket, got %v", err)
+	}
+
+	// An endpoint of some other socket is rejected.
+	other.SetOption(mangos.OptionRaw, true)
+	m = mangos.NewMessage(0)
+	if err = other.SendToEndpoint(ep, m); err != mangos.ErrBadEndpoint {
+		t.Errorf("Expected ErrBadEndpoint, got %v", err)
+	}
+
+	// As is one that has since disconnected.
+	target.Close()
+	m = mangos.NewMessage(0)
+	if err = tx.SendToEndpoint(ep, m); err != mangos.ErrBadEndpoint {
+		t.Errorf("Expected ErrBadEndpoint after close, got %v", err)
+	}
+}
+
+// stallTran is a transport whose pipes hold each write until released,
+// counting the messages that reach them, and passing on their bodies if
+// bodies is not nil.
+type stallTran struct {
+	releaseq chan struct{}
+	sent     *int32
+	bodies   chan string
+}
+
+type stallPipe struct {
+	failPipe
+	t stallTran
+}
+
+type stallDialer struct {
+	failDialer
+	t stallTran
+}
+
+func (stallTran) Scheme() string { return "stall" }
+
+func (t stallTran) NewDialer(addr string, sock mangos.Socket) (mangos.PipeDialer, error) {
+	return &stallDialer{failDialer{proto: sock.GetProtocol()}, t}, nil
+}
+
+func (stallTran) NewListener(string, mangos.Socket) (mangos.PipeListener, error) {
+	return nil, mangos.ErrBadTran
+}
+
+func (d *stallDialer) Dial() (mangos.Pipe, error) {
+	return &stallPipe{failPipe{proto: d.proto, closeq: make(chan struct{})}, d.t}, nil
+}
+
+func (p *stallPipe) Send(m *mangos.Message) error {
+	atomic.AddInt32(p.t.sent, 1)
+	if p.t.bodies != nil {
+		p.t.bodies <- string(m.Body)
+	}
+	select {
+	case <-p.t.releaseq:
+	case <-p.closeq:
+		return mangos.ErrClosed
+	}
+	m.Free()
+	return nil
+}
+
+func TestSendToEndpointDeadline(t *testing.T) {
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tran := stallTran{releaseq: make(chan struct{}), sent: new(int32)}
+	tx.AddTransport(tran)
+	tx.SetOption(mangos.OptionSendDeadline, 100*time.Millisecond)
+	tx.SetOption(mangos.OptionRaw, true)
+	portq := make(chan mangos.Port, 1)
+	tx.SetPortHook(func(act mangos.PortAction, p mangos.Port) bool {
+		if act == mangos.PortActionAdd {
+			portq <- p
+		}
+		return true
+	})
+	if err = tx.Dial("stall://nowhere"); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	var ep mangos.Endpoint
+	select {
+	case p := <-portq:
+		ep = p.(mangos.Endpoint)
+	case <-time.After(time.Second):
+		t.Fatalf("No port")
+	}
+
+	// The first write stalls in the transport, and the second waits
+	// behind it; both give up at the deadline.
+	for i := 0; i < 2; i++ {
+		m := mangos.NewMessage(0)
+		m.Body = append(m.Body, "ping"...)
+		start := time.Now()
+		if err = tx.SendToEndpoint(ep, m); err != mangos.ErrSendTimeout {
+			t.Errorf("Send %d: expected ErrSendTimeout, got %v", i, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("Send %d took %v", i, d)
+		}
+	}
+
+	// Once the first is released, the second is discarded rather than
+	// written after its caller was told it timed out.
+	close(tran.releaseq)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(tran.sent); n != 1 {
+		t.Errorf("Expected 1 write, got %d", n)
+	}
+}
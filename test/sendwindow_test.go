@@ -0,0 +1,105 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// sentMessages converts the PUSH byte counter to a message count, for
+// messages of the given body size carrying the 4 byte ack mode sequence.
+func sentMessages(t *testing.T, s mangos.Socket, size int) int {
+	n := byteCount(t, s, mangos.OptionBytesSent)
+	return int(n) / (size + 4)
+}
+
+func TestPushSendWindow(t *testing.T) {
+	const total = 10
+	const window = 3
+	const size = 5 // "msg00"
+	addr := AddrTestTCP()
+
+	rx, err := pull.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(tcp.NewTransport())
+	rx.SetOption(mangos.OptionAckMode, true)
+	rx.SetOption(mangos.OptionReadQLen, 0)
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = rx.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	tx, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(tcp.NewTransport())
+	tx.SetOption(mangos.OptionAckMode, true)
+	if err = tx.SetOption(mangos.OptionSendWindow, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = tx.SetOption(mangos.OptionSendWindow, window); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := tx.GetOption(mangos.OptionSendWindow); err != nil || v != window {
+		t.Errorf("GetOption: %v %v", v, err)
+	}
+	if err = tx.Dial(addr); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < total; i++ {
+		if err = tx.Send([]byte(fmt.Sprintf("msg%02d", i))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	// Nothing has been acknowledged, so only the window's worth can
+	// have gone out, although TCP would happily buffer the rest.
+	time.Sleep(time.Millisecond * 100)
+	if n := sentMessages(t, tx, size); n != window {
+		t.Fatalf("Sent %d messages with a full window, want %d", n, window)
+	}
+
+	// Each message taken acknowledges one, and lets another through.
+	for i := 0; i < total; i++ {
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("msg%02d", i); string(b) != want {
+			t.Errorf("Got %q, want %q", b, want)
+		}
+		if i < 2 {
+			time.Sleep(time.Millisecond * 100)
+			want := window + i + 1
+			if n := sentMessages(t, tx, size); n != want {
+				t.Errorf("After %d acks sent %d, want %d", i+1, n, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+)
+
+// shortConn is a net.Conn that writes at most one byte per call, without
+// reporting an error, as some wrapped or flaky connections do.
+type shortConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *shortConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.writes++
+	return c.Conn.Write(b[:1])
+}
+
+func TestShortWrites(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	type result struct {
+		p   mangos.Pipe
+		err error
+	}
+	srvq := make(chan result, 1)
+	short := &shortConn{}
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			srvq <- result{nil, err}
+			return
+		}
+		short.Conn = c
+		sock, _ := pair.NewSocket()
+		p, err := mangos.NewConnPipe(short, sock)
+		srvq <- result{p, err}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	sock, _ := pair.NewSocket()
+	defer sock.Close()
+	client, err := mangos.NewConnPipe(c, sock)
+	if err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+	defer client.Close()
+	res := <-srvq
+	if res.err != nil {
+		t.Fatalf("Server handshake failed: %v", res.err)
+	}
+	server := res.p
+	defer server.Close()
+
+	// Send a few frames with both header and body, to make sure
+	// none of the pieces is truncated.
+	for i := 0; i < 3; i++ {
+		m := mangos.NewMessage(64)
+		m.Header = append(m.Header, 0x80, 0, 0, byte(i))
+		m.Body = append(m.Body, bytes.Repeat([]byte{byte('a' + i)}, 50)...)
+		if err = server.Send(m); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		m, err = client.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		// The header is not separated by the pipe.
+		expect := append([]byte{0x80, 0, 0, byte(i)},
+			bytes.Repeat([]byte{byte('a' + i)}, 50)...)
+		if !bytes.Equal(m.Body, expect) {
+			t.Errorf("Frame %d corrupted: %q", i, m.Body)
+		}
+		m.Free()
+	}
+	if short.writes < 3*(8+4+50) {
+		t.Errorf("Writes were not split: %d", short.writes)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestStreamCopy(t *testing.T) {
+	addr := AddrTestTCP()
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+
+	a, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	a.AddTransport(tcp.NewTransport())
+	if err = a.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	b, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PAIR: %v", err)
+	}
+	b.AddTransport(tcp.NewTransport())
+	if err = b.SetOption(mangos.OptionRecvDeadline, time.Second*5); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = b.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	w := mangos.NewStream(a)
+	r := mangos.NewStream(b)
+	defer r.Close()
+
+	errq := make(chan error, 1)
+	go func() {
+		// Use an odd sized buffer, so that writes don't line up
+		// with anything in particular.  (The LimitReader hides
+		// WriteTo, which would otherwise bypass the buffer.)
+		buf := make([]byte, 1000)
+		src := io.LimitReader(bytes.NewReader(data), int64(len(data)))
+		_, err := io.CopyBuffer(w, src, buf)
+		if err == nil {
+			err = w.Close()
+		}
+		errq <- err
+	}()
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, r)
+	if err != nil {
+		t.Fatalf("Copy failed after %d bytes: %v", n, err)
+	}
+	if err = <-errq; err != nil {
+		t.Fatalf("Writer failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Data mismatch: got %d bytes, expected %d", out.Len(), len(data))
+	}
+}
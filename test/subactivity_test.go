@@ -0,0 +1,140 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+)
+
+type subActivity struct {
+	topic  string
+	active bool
+}
+
+func TestSubActivityHook(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	events := make(chan subActivity, 10)
+	hook := func(topic string, active bool) {
+		events <- subActivity{topic, active}
+	}
+	if err := s.SetOption(mangos.OptionSubActivityHook, hook); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubIdleTime, -time.Second); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubIdleTime, 100*time.Millisecond); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	s.SetOption(mangos.OptionSubscribe, "a")
+	s.SetOption(mangos.OptionSubscribe, "b")
+
+	expect := func(want subActivity, within time.Duration) {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Errorf("Got %+v, expected %+v", ev, want)
+			}
+		case <-time.After(within):
+			t.Errorf("No event, expected %+v", want)
+		}
+	}
+	recv := func(want string) {
+		if b, err := s.Recv(); err != nil || string(b) != want {
+			t.Fatalf("Got %q, %v", b, err)
+		}
+	}
+
+	// The first matching message starts the subscription; more do not
+	// report it again.
+	p.Send([]byte("a1"))
+	recv("a1")
+	expect(subActivity{"a", true}, time.Second)
+	p.Send([]byte("a2"))
+	recv("a2")
+	p.Send([]byte("b1"))
+	recv("b1")
+	expect(subActivity{"b", true}, time.Second)
+
+	// Both go quiet, in either order, and a new match brings one back.
+	quiet := make(map[subActivity]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-events:
+			quiet[ev] = true
+		case <-time.After(time.Second):
+		}
+	}
+	if !quiet[subActivity{"a", false}] || !quiet[subActivity{"b", false}] {
+		t.Errorf("Got %v, expected both quiet", quiet)
+	}
+	p.Send([]byte("a3"))
+	recv("a3")
+	expect(subActivity{"a", true}, time.Second)
+
+	// Once unsubscribed, it is not reported quiet.
+	s.SetOption(mangos.OptionUnsubscribe, "a")
+	select {
+	case ev := <-events:
+		t.Errorf("Unexpected %+v", ev)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSubActivityClock(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	clock := NewFakeClock()
+	s.SetOption(mangos.OptionClock, clock)
+	events := make(chan subActivity, 10)
+	s.SetOption(mangos.OptionSubActivityHook, func(topic string, active bool) {
+		events <- subActivity{topic, active}
+	})
+	s.SetOption(mangos.OptionSubIdleTime, time.Minute)
+	s.SetOption(mangos.OptionSubscribe, "a")
+
+	p.Send([]byte("a1"))
+	if b, err := s.Recv(); err != nil || string(b) != "a1" {
+		t.Fatalf("Got %q, %v", b, err)
+	}
+	if ev := <-events; ev != (subActivity{"a", true}) {
+		t.Fatalf("Got %+v", ev)
+	}
+
+	// Timers fire from within Advance, so there is nothing to wait for.
+	clock.Advance(59 * time.Second)
+	select {
+	case ev := <-events:
+		t.Fatalf("Unexpected %+v before the idle time", ev)
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case ev := <-events:
+		if ev != (subActivity{"a", false}) {
+			t.Errorf("Got %+v, expected a quiet", ev)
+		}
+	default:
+		t.Errorf("Not reported quiet after the idle time")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newSubAllPair(t testing.TB) (mangos.Socket, mangos.Socket) {
+	addr := AddrTestInp()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	p.AddTransport(inproc.NewTransport())
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	return p, s
+}
+
+func TestSubSubscribeAll(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	if v, err := s.GetOption(mangos.OptionSubscribeAll); err != nil || v.(bool) {
+		t.Errorf("Bad default %v: %v", v, err)
+	}
+	if err := s.SetOption(mangos.OptionSubscribeAll, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	// A specific subscription stays in place, but is not needed.
+	if err := s.SetOption(mangos.OptionSubscribe, "a"); err != nil {
+		t.Fatalf("Failed subscribe: %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubscribeAll, true); err != nil {
+		t.Fatalf("Failed set subscribe all: %v", err)
+	}
+	for _, body := range []string{"a", "b", "", "c123"} {
+		if err := p.Send([]byte(body)); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Failed recv %q: %v", body, err)
+		}
+		if string(b) != body {
+			t.Errorf("Got %q, expected %q", string(b), body)
+		}
+	}
+
+	// Once cleared, only the specific subscription applies.
+	if err := s.SetOption(mangos.OptionSubscribeAll, false); err != nil {
+		t.Fatalf("Failed clear subscribe all: %v", err)
+	}
+	p.Send([]byte("b"))
+	p.Send([]byte("a"))
+	if b, err := s.Recv(); err != nil || string(b) != "a" {
+		t.Errorf("Got %q, %v; expected \"a\"", string(b), err)
+	}
+}
+
+func benchmarkSubMatch(b *testing.B, all bool) {
+	p, s := newSubAllPair(b)
+	defer p.Close()
+	defer s.Close()
+
+	// Many specific subscriptions that do not match, followed by the
+	// catch all.
+	for i := 0; i < 100; i++ {
+		s.SetOption(mangos.OptionSubscribe, fmt.Sprintf("topic%03d/", i))
+	}
+	if all {
+		s.SetOption(mangos.OptionSubscribeAll, true)
+	} else {
+		s.SetOption(mangos.OptionSubscribe, "")
+	}
+
+	body := []byte("some message body that matches no specific topic")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Send(body); err != nil {
+			b.Fatalf("Send failed: %v", err)
+		}
+		m, err := s.RecvMsg()
+		if err != nil {
+			b.Fatalf("Recv failed: %v", err)
+		}
+		m.Free()
+	}
+}
+
+func BenchmarkSubMatchPrefix(b *testing.B) {
+	benchmarkSubMatch(b, false)
+}
+
+func BenchmarkSubMatchAll(b *testing.B) {
+	benchmarkSubMatch(b, true)
+}
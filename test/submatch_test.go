@@ -0,0 +1,228 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSubMatchFunc(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	if err := s.SetOption(mangos.OptionSubMatch, "x"); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	re := regexp.MustCompile(`^sensor/[0-9]+/temp$`)
+	if err := s.SetOption(mangos.OptionSubMatch, re.Match); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionSubMatch); err != nil || v.(func([]byte) bool) == nil {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+	// The function only sees subscribed messages.
+	if err := s.SetOption(mangos.OptionSubscribe, "sensor/"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	send := []string{"sensor/12/temp", "sensor/x/temp", "sensor/7/humidity",
+		"other/1/temp", "sensor/3/temp"}
+	for _, body := range send {
+		if err := p.Send([]byte(body)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	for _, want := range []string{"sensor/12/temp", "sensor/3/temp"} {
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("Got %q, expected %q", b, want)
+		}
+	}
+
+	// Clearing it restores plain subscriptions.
+	if err := s.SetOption(mangos.OptionSubMatch, nil); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	p.Send([]byte("sensor/x/temp"))
+	if b, err := s.Recv(); err != nil || string(b) != "sensor/x/temp" {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
+
+func benchmarkSubMatchFunc(b *testing.B, fn func([]byte) bool) {
+	p, s := newSubAllPair(b)
+	defer p.Close()
+	defer s.Close()
+
+	s.SetOption(mangos.OptionSubscribe, "sensor/")
+	if fn != nil {
+		s.SetOption(mangos.OptionSubMatch, fn)
+	}
+	body := []byte("sensor/1234/temp")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Send(body); err != nil {
+			b.Fatalf("Send failed: %v", err)
+		}
+		m, err := s.RecvMsg()
+		if err != nil {
+			b.Fatalf("Recv failed: %v", err)
+		}
+		m.Free()
+	}
+}
+
+func BenchmarkSubMatchFuncNone(b *testing.B) {
+	benchmarkSubMatchFunc(b, nil)
+}
+
+func BenchmarkSubMatchFuncRegexp(b *testing.B) {
+	benchmarkSubMatchFunc(b, regexp.MustCompile(`^sensor/[0-9]+/temp$`).Match)
+}
+
+func TestSubMatchWorkers(t *testing.T) {
+	p, s := newSubAllPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	if err := s.SetOption(mangos.OptionSubMatchWorkers, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubMatchWorkers, 4); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionSubMatchWorkers); err != nil || v.(int) != 4 {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+	// Earlier messages take longer to match, yet must still come first.
+	s.SetOption(mangos.OptionSubMatch, func(b []byte) bool {
+		var n int
+		fmt.Sscanf(string(b), "msg%d", &n)
+		time.Sleep(time.Duration(10-n%10) * time.Millisecond)
+		return n%3 != 0
+	})
+	s.SetOption(mangos.OptionSubscribe, "msg")
+
+	for i := 0; i < 30; i++ {
+		if err := p.Send([]byte(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	for i := 0; i < 30; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if want := fmt.Sprintf("msg%d", i); string(b) != want {
+			t.Errorf("Got %q, expected %q", b, want)
+		}
+	}
+
+	// Going back to inline matching keeps the order too.
+	if err := s.SetOption(mangos.OptionSubMatchWorkers, 0); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	p.Send([]byte("msg1"))
+	p.Send([]byte("msg2"))
+	for _, want := range []string{"msg1", "msg2"} {
+		if b, err := s.Recv(); err != nil || string(b) != want {
+			t.Errorf("Got %q, %v; expected %q", b, err, want)
+		}
+	}
+}
+
+// benchmarkSubMatchSlow feeds several publishers into one subscriber whose
+// match function takes a while, as one that waits on a lookup would.  Each
+// publisher sends in batches small enough that nothing is dropped.
+func benchmarkSubMatchSlow(b *testing.B, workers int) {
+	const npub = 4
+	const batch = 16
+
+	addr := AddrTestInp()
+	s, err := sub.NewSocket()
+	if err != nil {
+		b.Fatalf("NewSocket: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(inproc.NewTransport())
+	s.SetOption(mangos.OptionRecvDeadline, 5*time.Second)
+	s.SetOption(mangos.OptionSubscribe, "sensor/")
+	s.SetOption(mangos.OptionSubMatch, func([]byte) bool {
+		time.Sleep(100 * time.Microsecond)
+		return true
+	})
+	if err = s.SetOption(mangos.OptionSubMatchWorkers, workers); err != nil {
+		b.Fatalf("SetOption: %v", err)
+	}
+	if err = s.Listen(addr); err != nil {
+		b.Fatalf("Listen: %v", err)
+	}
+	var pubs []mangos.Socket
+	for i := 0; i < npub; i++ {
+		p, err := pub.NewSocket()
+		if err != nil {
+			b.Fatalf("NewSocket: %v", err)
+		}
+		defer p.Close()
+		p.AddTransport(inproc.NewTransport())
+		if err = p.Dial(addr); err != nil {
+			b.Fatalf("Dial: %v", err)
+		}
+		pubs = append(pubs, p)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	body := []byte("sensor/1234/temp")
+	b.ResetTimer()
+	for n := 0; n < b.N; n += npub * batch {
+		for i := 0; i < batch; i++ {
+			for _, p := range pubs {
+				if err := p.Send(body); err != nil {
+					b.Fatalf("Send failed: %v", err)
+				}
+			}
+		}
+		for i := 0; i < npub*batch; i++ {
+			m, err := s.RecvMsg()
+			if err != nil {
+				b.Fatalf("Recv failed: %v", err)
+			}
+			m.Free()
+		}
+	}
+}
+
+func BenchmarkSubMatchSlowInline(b *testing.B) {
+	benchmarkSubMatchSlow(b, 0)
+}
+
+func BenchmarkSubMatchSlowWorkers(b *testing.B) {
+	benchmarkSubMatchSlow(b, 16)
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+type subEvent struct {
+	topic  string
+	active bool
+}
+
+func fwdSub(t *testing.T, addr string, fwd bool, topics ...string) mangos.Socket {
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open SUB: %v", err)
+	}
+	s.AddTransport(tcp.NewTransport())
+	if err := s.SetOption(mangos.OptionSubForward, fwd); err != nil {
+		t.Fatalf("Failed to set forward: %v", err)
+	}
+	for _, topic := range topics {
+		if err := s.SetOption(mangos.OptionSubscribe, topic); err != nil {
+			t.Fatalf("Failed subscribe: %v", err)
+		}
+	}
+	if err := s.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return s
+}
+
+// waitSubscribers polls until the PUB reports the expected counts.
+func waitSubscribers(t *testing.T, p mangos.Socket, expect map[string]int) {
+	var v interface{}
+	for i := 0; i < 500; i++ {
+		var err error
+		if v, err = p.GetOption(mangos.OptionSubscribers); err != nil {
+			t.Fatalf("GetOption failed: %v", err)
+		}
+		if reflect.DeepEqual(v, expect) {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("Subscribers %v, expected %v", v, expect)
+}
+
+// expectSubEvents checks for exactly the expected hook calls, which may
+// arrive in any order.
+func expectSubEvents(t *testing.T, q chan subEvent, expect ...subEvent) {
+	want := make(map[subEvent]bool)
+	for _, e := range expect {
+		want[e] = true
+	}
+	for range expect {
+		select {
+		case got := <-q:
+			if !want[got] {
+				t.Errorf("Unexpected event %v", got)
+			}
+			delete(want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("Missing events %v", want)
+		}
+	}
+	select {
+	case got := <-q:
+		t.Errorf("Unexpected event %v", got)
+	case <-time.After(time.Millisecond * 20):
+	}
+}
+
+func TestPubSubscribers(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	evq := make(chan subEvent, 16)
+	err = p.SetOption(mangos.OptionSubscriberHook, func(topic string, active bool) {
+		evq <- subEvent{topic, active}
+	})
+	if err != nil {
+		t.Fatalf("Failed to set hook: %v", err)
+	}
+	if err := p.SetOption(mangos.OptionSubscriberHook, 3); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := p.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	waitSubscribers(t, p, map[string]int{})
+
+	s1 := fwdSub(t, addr, true, "a")
+	defer s1.Close()
+	waitSubscribers(t, p, map[string]int{"a": 1})
+	expectSubEvents(t, evq, subEvent{"a", true})
+
+	s2 := fwdSub(t, addr, true, "a", "b")
+	defer s2.Close()
+	waitSubscribers(t, p, map[string]int{"a": 2, "b": 1})
+	expectSubEvents(t, evq, subEvent{"b", true})
+
+	// Subscribers that do not forward are not counted.
+	s3 := fwdSub(t, addr, false, "c")
+	defer s3.Close()
+	time.Sleep(time.Millisecond * 50)
+	waitSubscribers(t, p, map[string]int{"a": 2, "b": 1})
+
+	// Changes on a connected subscriber are reported.
+	if err := s1.SetOption(mangos.OptionUnsubscribe, "a"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	if err := s1.SetOption(mangos.OptionSubscribeAll, true); err != nil {
+		t.Fatalf("SubscribeAll failed: %v", err)
+	}
+	waitSubscribers(t, p, map[string]int{"": 1, "a": 1, "b": 1})
+	expectSubEvents(t, evq, subEvent{"", true})
+
+	// Going away drops the subscriber's topics.
+	s2.Close()
+	waitSubscribers(t, p, map[string]int{"": 1})
+	expectSubEvents(t, evq, subEvent{"a", false}, subEvent{"b", false})
+
+	// So does turning forwarding off.
+	if err := s1.SetOption(mangos.OptionSubForward, false); err != nil {
+		t.Fatalf("Failed to clear forward: %v", err)
+	}
+	waitSubscribers(t, p, map[string]int{})
+	expectSubEvents(t, evq, subEvent{"", false})
+}
+
+func TestSubForwardDelivery(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	if err := p.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := fwdSub(t, addr, true, "")
+	defer s.Close()
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	waitSubscribers(t, p, map[string]int{"": 1})
+
+	if err := p.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if m, err := s.Recv(); err != nil || string(m) != "hello" {
+		t.Errorf("Recv got %q %v", m, err)
+	}
+	if v, err := s.GetOption(mangos.OptionSubForward); err != nil || v != true {
+		t.Errorf("OptionSubForward readback %v %v", v, err)
+	}
+}
+
+func TestSubReportLookalike(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	if err := p.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := fwdSub(t, addr, false, "")
+	defer s.Close()
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	// Without forwarding, a payload that happens to look like a report
+	// is delivered like any other.
+	body := string(mangos.NewSubscriptionReport(nil, false).Body)
+	if err := p.Send([]byte(body)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if m, err := s.Recv(); err != nil || string(m) != body {
+		t.Errorf("Recv got %q %v", m, err)
+	}
+}
+
+func TestPubDropUnsubscribed(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	if v, err := p.GetOption(mangos.OptionDropUnsubscribed); err != nil || v != false {
+		t.Errorf("Default %v %v", v, err)
+	}
+	if err := p.SetOption(mangos.OptionDropUnsubscribed, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := p.SetOption(mangos.OptionDropUnsubscribed, true); err != nil {
+		t.Fatalf("Failed to set option: %v", err)
+	}
+	if err := p.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	drops := func() uint64 {
+		v, err := p.GetOption(mangos.OptionUnsubscribedDrops)
+		if err != nil {
+			t.Fatalf("GetOption failed: %v", err)
+		}
+		return v.(uint64)
+	}
+	waitDrops := func(n uint64) {
+		for i := 0; i < 100 && drops() != n; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		if d := drops(); d != n {
+			t.Fatalf("Got %d drops, expected %d", d, n)
+		}
+	}
+
+	// With no subscribers at all, everything is dropped.
+	p.Send([]byte("a1"))
+	waitDrops(1)
+
+	s := fwdSub(t, addr, true, "a")
+	defer s.Close()
+	s.SetOption(mangos.OptionRecvDeadline, time.Millisecond*100)
+	waitSubscribers(t, p, map[string]int{"a": 1})
+
+	// Nobody subscribes to "b", so it never reaches the subscriber.
+	for i := 0; i < 5; i++ {
+		if err := p.Send([]byte("b")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	waitDrops(6)
+	if err := p.Send([]byte("a2")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if m, err := s.Recv(); err != nil || string(m) != "a2" {
+		t.Errorf("Recv got %q %v", m, err)
+	}
+	if d := drops(); d != 6 {
+		t.Errorf("Got %d drops, expected 6", d)
+	}
+
+	// A subscriber that does not forward may want anything.
+	s2 := fwdSub(t, addr, false, "b")
+	defer s2.Close()
+	s2.SetOption(mangos.OptionRecvDeadline, time.Second)
+	time.Sleep(time.Millisecond * 50)
+	if err := p.Send([]byte("b")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if m, err := s2.Recv(); err != nil || string(m) != "b" {
+		t.Errorf("Recv got %q %v", m, err)
+	}
+	if d := drops(); d != 6 {
+		t.Errorf("Got %d drops, expected 6", d)
+	}
+}
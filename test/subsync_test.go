@@ -0,0 +1,183 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestSubSyncNoMiss(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	if err = s.SetOption(mangos.OptionSubscribe, ""); err != nil {
+		t.Fatalf("Failed subscribe: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionSync, time.Second); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// No settling delay; everything sent from here must arrive.
+	const count = 10
+	for i := 0; i < count; i++ {
+		if err = p.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		b, err := s.Recv()
+		if err != nil {
+			t.Fatalf("Missed message %d: %v", i, err)
+		}
+		if string(b) != fmt.Sprintf("%d", i) {
+			t.Fatalf("Got %q, expected %d", b, i)
+		}
+	}
+}
+
+func TestSubSyncTimeout(t *testing.T) {
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionSync, -time.Second); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionSync, time.Millisecond*10); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected ErrRecvTimeout, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionSync, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
+
+func TestSubSyncClock(t *testing.T) {
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	defer s.Close()
+	clock := NewFakeClock()
+	if err = s.SetOption(mangos.OptionClock, clock); err != nil {
+		t.Fatalf("Failed to set clock: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.SetOption(mangos.OptionSync, time.Hour) }()
+
+	// Only the fake hour passing ends the wait.
+	for i := 0; ; i++ {
+		select {
+		case err = <-done:
+			if err != mangos.ErrRecvTimeout {
+				t.Fatalf("Expected ErrRecvTimeout, got %v", err)
+			}
+			if i == 0 {
+				t.Fatalf("Sync ended before the clock moved")
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+		if i == 1000 {
+			t.Fatalf("Sync did not time out")
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+// spFrame writes one message to a raw SP over TCP connection.
+func spFrame(c net.Conn, body []byte) error {
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint64(b, uint64(len(body)))
+	_, err := c.Write(append(b, body...))
+	return err
+}
+
+func TestPubEchoesOnlyProbes(t *testing.T) {
+	addr := AddrTestTCP()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(tcp.NewTransport())
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	// A SUB peer of our own making, which may send anything at all.
+	c, err := net.Dial("tcp", strings.TrimPrefix(addr, "tcp://"))
+	if err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+	greet := []byte{0, 'S', 'P', 0, 0, mangos.ProtoSub, 0, 0}
+	if _, err = c.Write(greet); err != nil {
+		t.Fatalf("Failed greeting: %v", err)
+	}
+	if _, err = io.ReadFull(c, greet); err != nil {
+		t.Fatalf("Failed reading greeting: %v", err)
+	}
+
+	probe := mangos.NewSyncProbe([]byte("12345678"))
+	for _, b := range [][]byte{[]byte("reflect me"), probe.Body} {
+		if err = spFrame(c, b); err != nil {
+			t.Fatalf("Failed write: %v", err)
+		}
+	}
+	hdr := make([]byte, 8)
+	if _, err = io.ReadFull(c, hdr); err != nil {
+		t.Fatalf("Failed read: %v", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint64(hdr))
+	if _, err = io.ReadFull(c, body); err != nil {
+		t.Fatalf("Failed read: %v", err)
+	}
+	if string(body) != string(probe.Body) {
+		t.Errorf("Got %q back, expected only the probe", body)
+	}
+}
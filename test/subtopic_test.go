@@ -0,0 +1,123 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func newTopicPair(t *testing.T) (mangos.Socket, mangos.Socket) {
+	addr := AddrTestInp()
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUB: %v", err)
+	}
+	p.AddTransport(inproc.NewTransport())
+	if err = p.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	s.AddTransport(inproc.NewTransport())
+	if err = s.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	return p, s
+}
+
+func TestSubTopicDelimiter(t *testing.T) {
+	p, s := newTopicPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	if err := s.SetOption(mangos.OptionTopicDelimiter, "/"); err != nil {
+		t.Fatalf("Failed set delimiter: %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubscribe, "weather/"); err != nil {
+		t.Fatalf("Failed subscribe: %v", err)
+	}
+	if err := p.Send([]byte("weather/sunny/warm")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	m, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("Failed recv: %v", err)
+	}
+	if !bytes.Equal(m.Topic(), []byte("weather")) {
+		t.Errorf("Bad topic: %q", m.Topic())
+	}
+	if !bytes.Equal(m.Body, []byte("sunny/warm")) {
+		t.Errorf("Bad payload: %q", m.Body)
+	}
+	m.Free()
+}
+
+func TestSubTopicLength(t *testing.T) {
+	p, s := newTopicPair(t)
+	defer p.Close()
+	defer s.Close()
+
+	if err := s.SetOption(mangos.OptionTopicLength, 4); err != nil {
+		t.Fatalf("Failed set topic length: %v", err)
+	}
+	if err := s.SetOption(mangos.OptionSubscribe, ""); err != nil {
+		t.Fatalf("Failed subscribe: %v", err)
+	}
+	if err := p.Send([]byte("abc")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if err := p.Send([]byte("abcdefg")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	// The short message is dropped, so we only see the second one.
+	m, err := s.RecvMsg()
+	if err != nil {
+		t.Fatalf("Failed recv: %v", err)
+	}
+	if !bytes.Equal(m.Topic(), []byte("abcd")) {
+		t.Errorf("Bad topic: %q", m.Topic())
+	}
+	if !bytes.Equal(m.Body, []byte("efg")) {
+		t.Errorf("Bad payload: %q", m.Body)
+	}
+	m.Free()
+}
+
+func TestSubTopicBadValue(t *testing.T) {
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SUB: %v", err)
+	}
+	defer s.Close()
+	if err = s.SetOption(mangos.OptionTopicLength, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = s.SetOption(mangos.OptionTopicDelimiter, 5); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+}
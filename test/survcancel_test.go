@@ -0,0 +1,110 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/respondent"
+	"nanomsg.org/go-mangos/protocol/surveyor"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSurveyCancel(t *testing.T) {
+	addr := AddrTestInp()
+
+	ssurv, err := surveyor.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SURVEYOR: %v", err)
+	}
+	defer ssurv.Close()
+	ssurv.AddTransport(inproc.NewTransport())
+	if err = ssurv.SetOption(mangos.OptionSurveyTime, time.Minute); err != nil {
+		t.Fatalf("Failed set survey time: %v", err)
+	}
+	if err = ssurv.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sresp, err := respondent.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make RESPONDENT: %v", err)
+	}
+	defer sresp.Close()
+	sresp.AddTransport(inproc.NewTransport())
+	if err = sresp.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if err = sresp.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = ssurv.SetOption(mangos.OptionSurveyCancel, false); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+
+	if err = ssurv.Send([]byte("first")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if _, err = sresp.Recv(); err != nil {
+		t.Fatalf("Failed recv survey: %v", err)
+	}
+
+	// A blocked Recv is released by the cancellation.
+	done := make(chan error, 1)
+	go func() {
+		_, err := ssurv.Recv()
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	if err = ssurv.SetOption(mangos.OptionSurveyCancel, true); err != nil {
+		t.Fatalf("Failed cancel: %v", err)
+	}
+	select {
+	case err = <-done:
+		if err != mangos.ErrCanceled {
+			t.Errorf("Expected ErrCanceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Recv was not released")
+	}
+
+	// The late response is discarded, even once the next survey starts.
+	if err = sresp.Send([]byte("late")); err != nil {
+		t.Fatalf("Failed send response: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if _, err = ssurv.Recv(); err != mangos.ErrCanceled {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+	if err = ssurv.Send([]byte("second")); err != nil {
+		t.Fatalf("Failed send: %v", err)
+	}
+	if b, err := sresp.Recv(); err != nil || string(b) != "second" {
+		t.Fatalf("Bad survey %q: %v", string(b), err)
+	}
+	if err = sresp.Send([]byte("answer")); err != nil {
+		t.Fatalf("Failed send response: %v", err)
+	}
+	if err = ssurv.SetOption(mangos.OptionRecvDeadline, time.Second); err != nil {
+		t.Fatalf("Failed set recv deadline: %v", err)
+	}
+	if b, err := ssurv.Recv(); err != nil || string(b) != "answer" {
+		t.Errorf("Got %q, %v; expected answer", string(b), err)
+	}
+}
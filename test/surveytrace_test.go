@@ -0,0 +1,115 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/respondent"
+	"nanomsg.org/go-mangos/protocol/surveyor"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestSurveyBacktrace(t *testing.T) {
+	addr := AddrTestInp()
+	srv, err := surveyor.NewSocket()
+	if err != nil {
+		t.Fatalf("NewSocket: %v", err)
+	}
+	defer srv.Close()
+	srv.AddTransport(inproc.NewTransport())
+	srv.SetOption(mangos.OptionRaw, true)
+	srv.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = srv.SetOption(mangos.OptionSurveyBacktrace, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = srv.SetOption(mangos.OptionSurveyBacktrace, true); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err = srv.Listen(addr); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	names := []string{"alpha", "beta", "gamma"}
+	for _, name := range names {
+		r, err := respondent.NewSocket()
+		if err != nil {
+			t.Fatalf("NewSocket: %v", err)
+		}
+		defer r.Close()
+		r.AddTransport(inproc.NewTransport())
+		if err = r.Dial(addr); err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		go func(r mangos.Socket, name string) {
+			for {
+				if _, err := r.Recv(); err != nil {
+					return
+				}
+				if r.Send([]byte(name)) != nil {
+					return
+				}
+			}
+		}(r, name)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	ids := make(map[uint32]bool)
+	for _, ep := range srv.Endpoints() {
+		ids[ep.GetID()] = true
+	}
+	seen := make(map[string]uint32)
+	for survey := uint32(1); survey <= 3; survey++ {
+		m := mangos.NewMessage(0)
+		m.SetBacktrace([]uint32{0x80000000 | survey})
+		m.Body = append(m.Body, "who?"...)
+		if err = srv.SendMsg(m); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+		for range names {
+			m, err := srv.RecvMsg()
+			if err != nil {
+				t.Fatalf("RecvMsg: %v", err)
+			}
+			bt := m.Backtrace()
+			name := string(m.Body)
+			switch {
+			case len(bt) != 2:
+				t.Errorf("Bad backtrace %v", bt)
+			case !ids[bt[0]]:
+				t.Errorf("%s: unknown pipe %x", name, bt[0])
+			case bt[1] != 0x80000000|survey:
+				t.Errorf("%s: survey %x, expected %d", name, bt[1], survey)
+			case survey == 1:
+				seen[name] = bt[0]
+			case seen[name] != bt[0]:
+				t.Errorf("%s: was %x, now %x", name, seen[name], bt[0])
+			}
+			m.Free()
+		}
+	}
+	if len(seen) != len(names) {
+		t.Errorf("Responses from %v", seen)
+	}
+	for name, id := range seen {
+		for other, oid := range seen {
+			if name != other && id == oid {
+				t.Errorf("%s and %s both from %x", name, other, id)
+			}
+		}
+	}
+}
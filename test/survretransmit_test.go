@@ -0,0 +1,201 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/respondent"
+	"nanomsg.org/go-mangos/protocol/surveyor"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+// lossyForward relays surveys from front to back, and responses from back
+// to front, discarding the first response.
+func lossyForward(front, back mangos.Socket) {
+	go func() {
+		for {
+			m, err := front.RecvMsg()
+			if err != nil {
+				return
+			}
+			if back.SendMsg(m) != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		dropped := false
+		for {
+			m, err := back.RecvMsg()
+			if err != nil {
+				return
+			}
+			if !dropped {
+				dropped = true
+				m.Free()
+				continue
+			}
+			if front.SendMsg(m) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestSurveyRetransmit(t *testing.T) {
+	faddr := AddrTestInp()
+	baddr := AddrTestInp()
+
+	ssurv, err := surveyor.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SURVEYOR: %v", err)
+	}
+	defer ssurv.Close()
+	ssurv.AddTransport(inproc.NewTransport())
+	ssurv.SetOption(mangos.OptionSurveyTime, time.Millisecond*500)
+	if err = ssurv.SetOption(mangos.OptionSurveyDedup, true); err != nil {
+		t.Fatalf("Failed set dedup: %v", err)
+	}
+	if err = ssurv.Listen(faddr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	// The lossy relay in the middle.
+	front, _ := respondent.NewSocket()
+	back, _ := surveyor.NewSocket()
+	defer front.Close()
+	defer back.Close()
+	for _, s := range []mangos.Socket{front, back} {
+		s.AddTransport(inproc.NewTransport())
+		if err = s.SetOption(mangos.OptionRaw, true); err != nil {
+			t.Fatalf("Failed set raw: %v", err)
+		}
+	}
+	if err = back.Listen(baddr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+	if err = front.Dial(faddr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	lossyForward(front, back)
+
+	sresp, err := respondent.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make RESPONDENT: %v", err)
+	}
+	defer sresp.Close()
+	sresp.AddTransport(inproc.NewTransport())
+	if err = sresp.SetOption(mangos.OptionRetransmitCount, -1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = sresp.SetOption(mangos.OptionRetransmitCount, 2); err != nil {
+		t.Fatalf("Failed set retransmit count: %v", err)
+	}
+	if err = sresp.SetOption(mangos.OptionRetransmitInterval, time.Millisecond*50); err != nil {
+		t.Fatalf("Failed set retransmit interval: %v", err)
+	}
+	sresp.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sresp.Dial(baddr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = ssurv.Send([]byte("anyone?")); err != nil {
+		t.Fatalf("Failed send survey: %v", err)
+	}
+	if _, err = sresp.Recv(); err != nil {
+		t.Fatalf("Failed recv survey: %v", err)
+	}
+	if err = sresp.Send([]byte("me")); err != nil {
+		t.Fatalf("Failed send response: %v", err)
+	}
+
+	// The original is lost, but a copy gets through, and only once.
+	b, err := ssurv.Recv()
+	if err != nil {
+		t.Fatalf("Failed recv response: %v", err)
+	}
+	if string(b) != "me" {
+		t.Errorf("Got %q, expected me", string(b))
+	}
+	if b, err = ssurv.Recv(); err != mangos.ErrProtoState {
+		t.Errorf("Expected end of survey, got %q, %v", string(b), err)
+	}
+}
+
+func TestSurveyRetransmitClock(t *testing.T) {
+	addr := AddrTestInp()
+	clock := NewFakeClock()
+
+	ssurv, err := surveyor.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make SURVEYOR: %v", err)
+	}
+	defer ssurv.Close()
+	ssurv.AddTransport(inproc.NewTransport())
+	ssurv.SetOption(mangos.OptionSurveyTime, time.Second*5)
+	ssurv.SetOption(mangos.OptionRecvDeadline, time.Millisecond*100)
+	if err = ssurv.Listen(addr); err != nil {
+		t.Fatalf("Failed listen: %v", err)
+	}
+
+	sresp, err := respondent.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make RESPONDENT: %v", err)
+	}
+	sresp.AddTransport(inproc.NewTransport())
+	sresp.SetOption(mangos.OptionRetransmitCount, 2)
+	sresp.SetOption(mangos.OptionRetransmitInterval, time.Millisecond*50)
+	sresp.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sresp.SetOption(mangos.OptionClock, clock); err != nil {
+		t.Fatalf("Failed set clock: %v", err)
+	}
+	if err = sresp.Dial(addr); err != nil {
+		t.Fatalf("Failed dial: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if err = ssurv.Send([]byte("anyone?")); err != nil {
+		t.Fatalf("Failed send survey: %v", err)
+	}
+	if _, err = sresp.Recv(); err != nil {
+		t.Fatalf("Failed recv survey: %v", err)
+	}
+	if err = sresp.Send([]byte("me")); err != nil {
+		t.Fatalf("Failed send response: %v", err)
+	}
+	if _, err = ssurv.Recv(); err != nil {
+		t.Fatalf("Failed recv response: %v", err)
+	}
+
+	// Copies follow only as the respondent's clock moves.
+	if b, err := ssurv.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected no copy yet, got %q, %v", string(b), err)
+	}
+	clock.Advance(time.Millisecond * 50)
+	if _, err = ssurv.Recv(); err != nil {
+		t.Fatalf("Failed recv first copy: %v", err)
+	}
+
+	// Closing stops the last copy.
+	sresp.Close()
+	clock.Advance(time.Millisecond * 50)
+	if b, err := ssurv.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Expected no copy after close, got %q, %v", string(b), err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/transport/all"
+)
+
+func TestTransportAllowList(t *testing.T) {
+	s, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make REP: %v", err)
+	}
+	defer s.Close()
+	all.AddTransports(s)
+	if err = s.SetOption(mangos.OptionTransports, []string{"tls+tcp"}); err != nil {
+		t.Fatalf("Failed set transports: %v", err)
+	}
+	if v, err := s.GetOption(mangos.OptionTransports); err != nil ||
+		len(v.([]string)) != 1 || v.([]string)[0] != "tls+tcp" {
+		t.Errorf("Bad transports %v: %v", v, err)
+	}
+
+	if err = s.Listen(AddrTestTCP()); err != mangos.ErrTranDenied {
+		t.Errorf("Expected ErrTranDenied for tcp listen, got %v", err)
+	}
+	if err = s.Dial(AddrTestIPC()); err != mangos.ErrTranDenied {
+		t.Errorf("Expected ErrTranDenied for ipc dial, got %v", err)
+	}
+
+	// The permitted transport still works.
+	opts := map[string]interface{}{mangos.OptionTLSConfig: srvCfg}
+	if err = s.ListenOptions(AddrTestTLS(), opts); err != nil {
+		t.Errorf("TLS listen failed: %v", err)
+	}
+	if err = s.SetOption(mangos.OptionTransports, []string{"tcp"}); err != mangos.ErrBadOption {
+		t.Errorf("Expected ErrBadOption once active, got %v", err)
+	}
+}
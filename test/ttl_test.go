@@ -21,6 +21,8 @@ import (
 	"time"
 
 	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
 	"nanomsg.org/go-mangos/transport/inproc"
 )
 
@@ -239,3 +241,78 @@ func TTLDropTest(t *testing.T, cli newSockFunc, srv newSockFunc) {
 		t.Errorf("Got unexpected error: %v", err)
 	}
 }
+
+// TestRepTTLOverlongBacktrace feeds REP a backtrace deeper than its TTL,
+// and verifies that it is counted and dropped, and that the pipe keeps
+// working afterwards.
+func TestRepTTLOverlongBacktrace(t *testing.T) {
+	inp := inproc.NewTransport()
+	a := AddrTestInp()
+
+	srv, err := rep.NewSocket()
+	if err != nil {
+		t.Errorf("Failed to make server: %v", err)
+		return
+	}
+	defer srv.Close()
+	srv.AddTransport(inp)
+	if err = srv.Listen(a); err != nil {
+		t.Errorf("Failed listen: %v", err)
+		return
+	}
+	if err = srv.SetOption(mangos.OptionRecvDeadline, time.Millisecond*100); err != nil {
+		t.Errorf("Failed set recv deadline: %v", err)
+		return
+	}
+
+	cli, err := req.NewSocket()
+	if err != nil {
+		t.Errorf("Failed to make client: %v", err)
+		return
+	}
+	defer cli.Close()
+	cli.AddTransport(inp)
+	if err = cli.SetOption(mangos.OptionRaw, true); err != nil {
+		t.Errorf("Failed set raw mode: %v", err)
+		return
+	}
+	if err = cli.Dial(a); err != nil {
+		t.Errorf("Failed dial: %v", err)
+		return
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	// 1000 hops, far beyond the default TTL of 8.
+	m := mangos.NewMessage(0)
+	for i := 0; i < 1000; i++ {
+		m.Header = append(m.Header, 0, 0, 0, 1)
+	}
+	m.Header = append(m.Header, 0x80, 0, 0, 1)
+	m.Body = append(m.Body, []byte("DROP")...)
+	if err = cli.SendMsg(m); err != nil {
+		t.Errorf("Failed send: %v", err)
+		return
+	}
+	if v, err := srv.Recv(); err != mangos.ErrRecvTimeout {
+		t.Errorf("Message not dropped: %v %v", v, err)
+		return
+	}
+	if v, err := srv.GetOption(mangos.OptionTTLDrops); err != nil {
+		t.Errorf("Failed get drops: %v", err)
+	} else if v.(uint64) != 1 {
+		t.Errorf("Drop count %v not 1", v)
+	}
+
+	m = mangos.NewMessage(0)
+	m.Header = append(m.Header, 0x80, 0, 0, 2)
+	m.Body = append(m.Body, []byte("GOOD")...)
+	if err = cli.SendMsg(m); err != nil {
+		t.Errorf("Failed send: %v", err)
+		return
+	}
+	if v, err := srv.Recv(); err != nil {
+		t.Errorf("Failed recv after drop: %v", err)
+	} else if !bytes.Equal(v, []byte("GOOD")) {
+		t.Errorf("Got wrong message: %v", v)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/transport/udp"
+)
+
+func TestPubSubUDP(t *testing.T) {
+	const count = 200
+	addr := AddrTestUDP()
+
+	p, err := pub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PUB: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(udp.NewTransport())
+	if err := p.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	s, err := sub.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open SUB: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(udp.NewTransport())
+	s.SetOption(mangos.OptionSubscribe, "topic")
+	s.SetOption(mangos.OptionRecvDeadline, time.Millisecond*200)
+	if err := s.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	// The subscriber's announcement has to reach the publisher first.
+	if err := s.SetOption(mangos.OptionSync, time.Second); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	go func() {
+		for i := 0; i < count; i++ {
+			p.Send([]byte(fmt.Sprintf("topic %d", i)))
+			time.Sleep(time.Microsecond * 100)
+		}
+	}()
+
+	// Some loss is acceptable, but most should arrive, and in a sane
+	// form.
+	got := 0
+	for {
+		m, err := s.Recv()
+		if err == mangos.ErrRecvTimeout {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		var n int
+		if _, err := fmt.Sscanf(string(m), "topic %d", &n); err != nil || n >= count {
+			t.Errorf("Bad message %q", m)
+		}
+		got++
+	}
+	t.Logf("Received %d of %d", got, count)
+	if got < count/2 {
+		t.Errorf("Only %d of %d messages arrived", got, count)
+	}
+	if got > count {
+		t.Errorf("Received %d messages, only %d sent", got, count)
+	}
+}
+
+func TestUDPTooLong(t *testing.T) {
+	addr := AddrTestUDP()
+	rx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PAIR: %v", err)
+	}
+	defer rx.Close()
+	rx.AddTransport(udp.NewTransport())
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := rx.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	tx, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open PAIR: %v", err)
+	}
+	defer tx.Close()
+	tx.AddTransport(udp.NewTransport())
+	tx.SetOption(mangos.OptionSendDeadline, time.Second)
+	drops := make(chan mangos.DropReason, 1)
+	tx.SetDropHook(func(m *mangos.Message, r mangos.DropReason) {
+		drops <- r
+	})
+	if err := tx.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	// Refused by the transport, and reported as such, but the
+	// connection is still good for the next message.
+	big := mangos.NewMessage(70000)
+	big.Body = big.Body[:70000]
+	if _, err := tx.SendMsgEndpoint(big); err != mangos.ErrTooLong {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+	select {
+	case r := <-drops:
+		if r != mangos.DropReasonTooLong || r.String() != "too long for transport" {
+			t.Errorf("Dropped for %v", r)
+		}
+	default:
+		t.Errorf("Drop not reported")
+	}
+	if err := tx.Send([]byte("small")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if b, err := rx.Recv(); err != nil || string(b) != "small" {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
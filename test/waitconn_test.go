@@ -0,0 +1,112 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+func TestWaitConnected(t *testing.T) {
+	addr := AddrTestTCP()
+	c, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer c.Close()
+	c.AddTransport(tcp.NewTransport())
+	c.SetOption(mangos.OptionReconnectTime, time.Millisecond*10)
+	c.SetOption(mangos.OptionSendDeadline, time.Second)
+
+	// Nobody is listening yet.
+	if err := c.Dial(addr); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	err = c.WaitConnected(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitConnected(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitConnected returned early: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	s, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer s.Close()
+	s.AddTransport(tcp.NewTransport())
+	s.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := s.Listen(addr); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitConnected failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitConnected did not return")
+	}
+
+	// The first send goes straight to the new peer.
+	if err := c.Send([]byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if m, err := s.Recv(); err != nil || string(m) != "hello" {
+		t.Errorf("Recv got %q %v", m, err)
+	}
+
+	// Once connected it returns immediately.
+	if err := c.WaitConnected(context.Background()); err != nil {
+		t.Errorf("WaitConnected: %v", err)
+	}
+}
+
+func TestWaitConnectedClosed(t *testing.T) {
+	c, err := pair.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitConnected(context.Background())
+	}()
+	time.Sleep(time.Millisecond * 20)
+	c.Close()
+	select {
+	case err := <-done:
+		if err != mangos.ErrClosed {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitConnected did not return after Close")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pull"
+	"nanomsg.org/go-mangos/protocol/push"
+	"nanomsg.org/go-mangos/transport/inproc"
+)
+
+func TestPushWeighted(t *testing.T) {
+	testPushWeighted(t, 0)
+}
+
+// Messages batched together still take a turn each.
+func TestPushWeightedBatch(t *testing.T) {
+	testPushWeighted(t, 4096)
+}
+
+func testPushWeighted(t *testing.T, batch int) {
+	const total = 400
+	addrs := []string{AddrTestInp() + "heavy", AddrTestInp() + "light"}
+	weights := []int{3, 1}
+	counts := make([]int, len(addrs))
+	var wg sync.WaitGroup
+	var lk sync.Mutex
+	got := 0
+	done := make(chan struct{})
+
+	for i, addr := range addrs {
+		s, err := pull.NewSocket()
+		if err != nil {
+			t.Fatalf("Failed to make PULL: %v", err)
+		}
+		defer s.Close()
+		s.AddTransport(inproc.NewTransport())
+		s.SetOption(mangos.OptionBatchSize, batch)
+		if err = s.Listen(addr); err != nil {
+			t.Fatalf("Failed listen: %v", err)
+		}
+		wg.Add(1)
+		go func(i int, s mangos.Socket) {
+			defer wg.Done()
+			for {
+				if _, err := s.Recv(); err != nil {
+					return
+				}
+				lk.Lock()
+				counts[i]++
+				if got++; got == total {
+					close(done)
+				}
+				lk.Unlock()
+			}
+		}(i, s)
+	}
+
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	p.SetOption(mangos.OptionBatchSize, batch)
+	p.SetOption(mangos.OptionWriteQLen, total)
+	for i, addr := range addrs {
+		opts := map[string]interface{}{mangos.OptionWeight: weights[i]}
+		if err = p.DialOptions(addr, opts); err != nil {
+			t.Fatalf("Failed dial: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < total; i++ {
+		if err = p.Send([]byte("work")); err != nil {
+			t.Fatalf("Failed send: %v", err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Timed out, received %d of %d", got, total)
+	}
+
+	lk.Lock()
+	defer lk.Unlock()
+	t.Logf("Distribution: %v", counts)
+	// Expect 3:1, allowing some slack.
+	if counts[0] < total*7/10 || counts[0] > total*8/10 {
+		t.Errorf("Distribution not weighted 3:1: %v", counts)
+	}
+}
+
+func TestDialerWeightOption(t *testing.T) {
+	p, err := push.NewSocket()
+	if err != nil {
+		t.Fatalf("Failed to make PUSH: %v", err)
+	}
+	defer p.Close()
+	p.AddTransport(inproc.NewTransport())
+	d, err := p.NewDialer(AddrTestInp(), nil)
+	if err != nil {
+		t.Fatalf("Failed to make dialer: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionWeight); err != nil || v.(int) != 1 {
+		t.Errorf("Bad default weight %v: %v", v, err)
+	}
+	if err = d.SetOption(mangos.OptionWeight, 0); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.SetOption(mangos.OptionWeight, 5); err != nil {
+		t.Errorf("Failed set weight: %v", err)
+	}
+	if v, err := d.GetOption(mangos.OptionWeight); err != nil || v.(int) != 5 {
+		t.Errorf("Bad weight %v: %v", v, err)
+	}
+}
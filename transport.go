@@ -16,7 +16,9 @@ package mangos
 
 import (
 	"net"
+	"os"
 	"strings"
+	"syscall"
 )
 
 // Pipe behaves like a full-duplex message-oriented connection between two
@@ -31,7 +33,8 @@ type Pipe interface {
 	// Send sends a complete message.  In the event of a partial send,
 	// the Pipe will be closed, and an error is returned.  For reasons
 	// of efficiency, we allow the message to be sent in a scatter/gather
-	// list.
+	// list.  A Pipe that cannot carry a message of that size at all
+	// returns ErrTooLong without sending anything, and stays open.
 	Send(*Message) error
 
 	// Recv receives a complete message.  In the event that either a
@@ -45,7 +48,8 @@ type Pipe interface {
 	// Close closes the underlying transport.  Further operations on
 	// the Pipe will result in errors.  Note that messages that are
 	// queued in transport buffers may still be received by the remote
-	// peer.
+	// peer.  Any goroutines the Pipe started should have exited by the
+	// time Close returns, so that OptionCloseWait covers them.
 	Close() error
 
 	// LocalProtocol returns the 16-bit SP protocol number used by the
@@ -68,6 +72,13 @@ type Pipe interface {
 	GetProp(string) (interface{}, error)
 }
 
+// PipeFlusher is implemented by Pipes that may hold outbound data back to
+// combine writes, such as those of tls+tcp with OptionTLSCoalesce.  Flush
+// writes any such data to the connection at once; Socket.Flush calls it.
+type PipeFlusher interface {
+	Flush() error
+}
+
 // PipeDialer represents the client side of a connection.  Clients initiate
 // the connection.
 //
@@ -109,6 +120,8 @@ type PipeListener interface {
 	// to resume listening is to create a new Server instance.  Presumably
 	// this function is only called when the last reference to the server
 	// is about to go away.  Established connections are unaffected.
+	// As for Pipe, any goroutines the listener started should have
+	// exited by the time Close returns.
 	Close() error
 
 	// SetOption sets a local option on the listener.
@@ -151,6 +164,31 @@ func StripScheme(t Transport, addr string) (string, error) {
 	return addr[len(t.Scheme()+"://"):], nil
 }
 
+// ListenError converts an error from binding a listening socket, returning
+// ErrAddrInUse if the address is already bound by someone else, and the
+// original error otherwise.  This lets callers of Listen tell a conflict,
+// which retrying will not fix, from other failures.  Go sets SO_REUSEADDR
+// on listening TCP sockets, so an address left in TIME_WAIT by an
+// earlier listener can be bound again at once; only a live listener
+// conflicts.  This is mostly a utility for benefit of transport providers.
+func ListenError(err error) error {
+	for cause := err; ; {
+		switch e := cause.(type) {
+		case *net.OpError:
+			cause = e.Err
+		case *os.SyscallError:
+			cause = e.Err
+		case syscall.Errno:
+			if errnoAddrInUse(e) {
+				return ErrAddrInUse
+			}
+			return err
+		default:
+			return err
+		}
+	}
+}
+
 // ResolveTCPAddr is like net.ResolveTCPAddr, but it handles the
 // wildcard used in nanomsg URLs, replacing it with an empty
 // string to indicate that all local interfaces be used.
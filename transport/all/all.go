@@ -23,6 +23,7 @@ import (
 	"nanomsg.org/go-mangos/transport/ipc"
 	"nanomsg.org/go-mangos/transport/tcp"
 	"nanomsg.org/go-mangos/transport/tlstcp"
+	"nanomsg.org/go-mangos/transport/udp"
 	"nanomsg.org/go-mangos/transport/ws"
 	"nanomsg.org/go-mangos/transport/wss"
 )
@@ -33,6 +34,7 @@ func AddTransports(sock mangos.Socket) {
 	sock.AddTransport(inproc.NewTransport())
 	sock.AddTransport(ipc.NewTransport())
 	sock.AddTransport(tlstcp.NewTransport())
+	sock.AddTransport(udp.NewTransport())
 	sock.AddTransport(ws.NewTransport())
 	sock.AddTransport(wss.NewTransport())
 }
@@ -31,6 +31,7 @@ type inproc struct {
 	proto  mangos.Protocol
 	addr   addr
 	peer   *inproc
+	nocopy bool
 	sync.Mutex
 }
 
@@ -52,6 +53,7 @@ type listener struct {
 	addr      string
 	proto     mangos.Protocol
 	accepters []*inproc
+	nocopy    bool
 }
 
 type inprocTran struct{}
@@ -103,10 +105,20 @@ func (p *inproc) Send(m *mangos.Message) error {
 
 	// Upper protocols expect to have to pick header and body part.
 	// Also we need to have a fresh copy of the message for receiver, to
-	// break ownership.
-	nmsg := mangos.NewMessage(len(m.Header) + len(m.Body))
-	nmsg.Body = append(nmsg.Body, m.Header...)
-	nmsg.Body = append(nmsg.Body, m.Body...)
+	// break ownership.  That copy is skipped when the sender has promised
+	// not to touch the body again (OptionInprocNoCopy); the receiver still
+	// gets its own Message, as the sender's may be shared via Dup.  The
+	// capacity is clipped so that appends by the receiver cannot scribble
+	// past the end of the shared body.
+	var nmsg *mangos.Message
+	if p.nocopy && len(m.Header) == 0 {
+		nmsg = mangos.NewMessage(0)
+		nmsg.Body = m.Body[:len(m.Body):len(m.Body)]
+	} else {
+		nmsg = mangos.NewMessage(len(m.Header) + len(m.Body))
+		nmsg.Body = append(nmsg.Body, m.Header...)
+		nmsg.Body = append(nmsg.Body, m.Body...)
+	}
 	select {
 	case p.wq <- nmsg:
 		return nil
@@ -157,14 +169,15 @@ func (p *inproc) GetProp(name string) (interface{}, error) {
 }
 
 type dialer struct {
-	addr  string
-	proto mangos.Protocol
+	addr   string
+	proto  mangos.Protocol
+	nocopy bool
 }
 
 func (d *dialer) Dial() (mangos.Pipe, error) {
 
 	var server *inproc
-	client := &inproc{proto: d.proto, addr: addr(d.addr)}
+	client := &inproc{proto: d.proto, addr: addr(d.addr), nocopy: d.nocopy}
 	client.readyq = make(chan struct{})
 	client.closeq = make(chan struct{})
 
@@ -180,6 +193,7 @@ func (d *dialer) Dial() (mangos.Pipe, error) {
 		}
 
 		if !mangos.ValidPeers(client.proto, l.proto) {
+			listeners.mx.Unlock()
 			return nil, mangos.ErrBadProto
 		}
 
@@ -207,11 +221,23 @@ func (d *dialer) Dial() (mangos.Pipe, error) {
 	return client, nil
 }
 
-func (*dialer) SetOption(string, interface{}) error {
+func (d *dialer) SetOption(name string, value interface{}) error {
+	switch name {
+	case mangos.OptionInprocNoCopy:
+		if v, ok := value.(bool); ok {
+			d.nocopy = v
+			return nil
+		}
+		return mangos.ErrBadValue
+	}
 	return mangos.ErrBadOption
 }
 
-func (*dialer) GetOption(string) (interface{}, error) {
+func (d *dialer) GetOption(name string) (interface{}, error) {
+	switch name {
+	case mangos.OptionInprocNoCopy:
+		return d.nocopy, nil
+	}
 	return nil, mangos.ErrBadOption
 }
 
@@ -232,7 +258,7 @@ func (l *listener) Address() string {
 }
 
 func (l *listener) Accept() (mangos.Pipe, error) {
-	server := &inproc{proto: l.proto, addr: addr(l.addr)}
+	server := &inproc{proto: l.proto, addr: addr(l.addr), nocopy: l.nocopy}
 	server.readyq = make(chan struct{})
 	server.closeq = make(chan struct{})
 
@@ -249,11 +275,23 @@ func (l *listener) Accept() (mangos.Pipe, error) {
 	}
 }
 
-func (*listener) SetOption(string, interface{}) error {
+func (l *listener) SetOption(name string, value interface{}) error {
+	switch name {
+	case mangos.OptionInprocNoCopy:
+		if v, ok := value.(bool); ok {
+			l.nocopy = v
+			return nil
+		}
+		return mangos.ErrBadValue
+	}
 	return mangos.ErrBadOption
 }
 
-func (*listener) GetOption(string) (interface{}, error) {
+func (l *listener) GetOption(name string) (interface{}, error) {
+	switch name {
+	case mangos.OptionInprocNoCopy:
+		return l.nocopy, nil
+	}
 	return nil, mangos.ErrBadOption
 }
 
@@ -0,0 +1,96 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+)
+
+func TestIpcPeerAuthorizer(t *testing.T) {
+	addr := "ipc://testauth1234"
+	tran := NewTransport()
+	srv, _ := pair.NewSocket()
+	cli, _ := pair.NewSocket()
+	defer srv.Close()
+	defer cli.Close()
+
+	l, err := tran.NewListener(addr, srv)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	if err = l.SetOption(OptionPeerAuthorizer, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+
+	// Only admit root, or when running as root, only some other user.
+	want := int32(0)
+	if os.Getuid() == 0 {
+		want = 1
+	}
+	seen := make(chan PeerCreds, 2)
+	auth := func(c PeerCreds) bool {
+		seen <- c
+		return c.UID == int(atomic.LoadInt32(&want))
+	}
+	if err = l.SetOption(OptionPeerAuthorizer, auth); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			p, err := l.Accept()
+			if err == nil {
+				p.Close()
+			}
+			accepted <- err
+		}
+	}()
+
+	d, err := tran.NewDialer(addr, cli)
+	if err != nil {
+		t.Fatalf("NewDialer failed: %v", err)
+	}
+	if p, err := d.Dial(); err == nil {
+		p.Close()
+		t.Errorf("Dial succeeded")
+	}
+	if err = <-accepted; err != mangos.ErrConnRefused {
+		t.Errorf("Accept returned %v", err)
+	}
+	c := <-seen
+	if c.UID != os.Getuid() || c.GID != os.Getgid() || c.PID != os.Getpid() {
+		t.Errorf("Got creds %+v", c)
+	}
+
+	// Admitting our own user lets the connection through.
+	atomic.StoreInt32(&want, int32(os.Getuid()))
+	p, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	p.Close()
+	if err = <-accepted; err != nil {
+		t.Errorf("Accept returned %v", err)
+	}
+}
@@ -23,6 +23,30 @@ import (
 	"nanomsg.org/go-mangos"
 )
 
+// OptionPeerAuthorizer supplies a PeerAuthorizer, which decides whether
+// to accept each connection made to a Listener, given the credentials of
+// the connecting process.  A connection it refuses is closed before the
+// SP handshake, so it never reaches the socket, and the dialer sees its
+// attempt fail.  This lets a local daemon restrict access, for example
+// to processes running as root.  The value is a PeerAuthorizer (or a
+// func(PeerCreds) bool), and can only be set on a Listener, before it
+// is started.  It is only supported on Linux, where the credentials are
+// those reported by the kernel (SO_PEERCRED) for the process that
+// connected; elsewhere setting it fails with ErrBadOption.
+const OptionPeerAuthorizer = "IPC-PEER-AUTHORIZER"
+
+// PeerCreds are the credentials of the process at the other end of a
+// connection.
+type PeerCreds struct {
+	PID int // process ID
+	UID int // effective user ID
+	GID int // effective group ID
+}
+
+// PeerAuthorizer returns true if a connection from a process with the
+// given credentials should be accepted.  See OptionPeerAuthorizer.
+type PeerAuthorizer func(PeerCreds) bool
+
 // options is used for shared GetOption/SetOption logic.
 type options map[string]interface{}
 
@@ -80,7 +104,7 @@ type listener struct {
 func (l *listener) Listen() error {
 	listener, err := net.ListenUnix("unix", l.addr)
 	if err != nil {
-		return err
+		return mangos.ListenError(err)
 	}
 	l.listener = listener
 	return nil
@@ -97,6 +121,13 @@ func (l *listener) Accept() (mangos.Pipe, error) {
 	if err != nil {
 		return nil, err
 	}
+	if auth, ok := l.opts[OptionPeerAuthorizer].(PeerAuthorizer); ok {
+		creds, err := peerCreds(conn)
+		if err != nil || !auth(creds) {
+			conn.Close()
+			return nil, mangos.ErrConnRefused
+		}
+	}
 	return mangos.NewConnPipeIPC(conn, l.sock)
 }
 
@@ -106,8 +137,25 @@ func (l *listener) Close() error {
 	return nil
 }
 
-// SetOption implements a stub PipeListener SetOption method.
+// SetOption implements the PipeListener SetOption method.
 func (l *listener) SetOption(n string, v interface{}) error {
+	if n == OptionPeerAuthorizer && credsSupported {
+		var auth PeerAuthorizer
+		switch fn := v.(type) {
+		case PeerAuthorizer:
+			auth = fn
+		case func(PeerCreds) bool:
+			auth = fn
+		default:
+			return mangos.ErrBadValue
+		}
+		if auth == nil {
+			delete(l.opts, n)
+			return nil
+		}
+		l.opts[n] = auth
+		return nil
+	}
 	return l.opts.set(n, v)
 }
 
@@ -141,7 +189,7 @@ func (t *ipcTran) NewDialer(addr string, sock mangos.Socket) (mangos.PipeDialer,
 // NewListener implements the Transport NewListener method.
 func (t *ipcTran) NewListener(addr string, sock mangos.Socket) (mangos.PipeListener, error) {
 	var err error
-	l := &listener{sock: sock}
+	l := &listener{sock: sock, opts: make(options)}
 
 	if addr, err = mangos.StripScheme(t, addr); err != nil {
 		return nil, err
@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"net"
+	"syscall"
+)
+
+// credsSupported is true where peerCreds works.
+const credsSupported = true
+
+// peerCreds returns the credentials of the process that connected.
+func peerCreds(conn *net.UnixConn) (PeerCreds, error) {
+	var creds PeerCreds
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return creds, err
+	}
+	var uerr error
+	err = rc.Control(func(fd uintptr) {
+		var uc *syscall.Ucred
+		uc, uerr = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		if uerr == nil {
+			creds = PeerCreds{
+				PID: int(uc.Pid),
+				UID: int(uc.Uid),
+				GID: int(uc.Gid),
+			}
+		}
+	})
+	if err != nil {
+		return creds, err
+	}
+	return creds, uerr
+}
@@ -0,0 +1,32 @@
+//go:build !linux && !windows && !nacl && !plan9
+// +build !linux,!windows,!nacl,!plan9
+
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipc
+
+import (
+	"net"
+
+	"nanomsg.org/go-mangos"
+)
+
+// credsSupported is true where peerCreds works.
+const credsSupported = false
+
+// peerCreds is not implemented on this platform.
+func peerCreds(*net.UnixConn) (PeerCreds, error) {
+	return PeerCreds{}, mangos.ErrBadOption
+}
@@ -16,7 +16,10 @@
 package tcp
 
 import (
+	"context"
 	"net"
+	"strings"
+	"syscall"
 	"time"
 
 	"nanomsg.org/go-mangos"
@@ -59,6 +62,24 @@ func (o options) set(name string, val interface{}) error {
 		default:
 			return mangos.ErrBadValue
 		}
+	case mangos.OptionTCPLinger:
+		switch v := val.(type) {
+		case time.Duration:
+			o[name] = v
+			return nil
+		default:
+			return mangos.ErrBadValue
+		}
+	case mangos.OptionTOS:
+		if !tosSupported {
+			return mangos.ErrBadOption
+		}
+		v, ok := val.(int)
+		if !ok || v < 0 || v > 255 {
+			return mangos.ErrBadValue
+		}
+		o[name] = v
+		return nil
 	}
 	return mangos.ErrBadOption
 }
@@ -70,6 +91,15 @@ func newOptions() options {
 	return options(o)
 }
 
+// applied returns a copy of the options, for PropTransportOptions.
+func (o options) applied() map[string]interface{} {
+	m := make(map[string]interface{}, len(o))
+	for k, v := range o {
+		m[k] = v
+	}
+	return m
+}
+
 func (o options) configTCP(conn *net.TCPConn) error {
 	if v, ok := o[mangos.OptionNoDelay]; ok {
 		if err := conn.SetNoDelay(v.(bool)); err != nil {
@@ -86,9 +116,45 @@ func (o options) configTCP(conn *net.TCPConn) error {
 			return err
 		}
 	}
+	if v, ok := o[mangos.OptionTCPLinger]; ok {
+		if err := conn.SetLinger(lingerSecs(v.(time.Duration))); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// control returns the function to set OptionTOS on new sockets, before
+// they connect or listen, or nil if it is not set.
+func (o options) control() func(string, string, syscall.RawConn) error {
+	tos, ok := o[mangos.OptionTOS].(int)
+	if !ok {
+		return nil
+	}
+	return func(network, _ string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = setTOS(fd, strings.HasSuffix(network, "6"), tos)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// lingerSecs converts OptionTCPLinger to the whole seconds SO_LINGER takes,
+// rounding up so that a short linger is not mistaken for an abortive one.
+func lingerSecs(d time.Duration) int {
+	switch {
+	case d < 0:
+		return -1
+	case d == 0:
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
 type dialer struct {
 	addr string
 	sock mangos.Socket
@@ -96,24 +162,29 @@ type dialer struct {
 }
 
 func (d *dialer) Dial() (_ mangos.Pipe, err error) {
-	var (
-		addr *net.TCPAddr
-	)
-
-	if addr, err = mangos.ResolveTCPAddr(d.addr); err != nil {
-		return nil, err
-	}
-
-	conn, err := net.DialTCP("tcp", nil, addr)
+	// We dial by name, rather than with a pre-resolved address, so that
+	// the address is looked up again on each attempt, and so that when
+	// the host has both IPv4 and IPv6 addresses the attempts are raced
+	// ("happy eyeballs", RFC 6555), using whichever completes first;
+	// the net package does this by default.  Scoped IPv6 addresses
+	// (e.g. [fe80::1%eth0]:5555) are handled by it too.
+	nd := &net.Dialer{Control: d.opts.control()}
+	c, err := nd.Dial("tcp", strings.TrimPrefix(d.addr, "*"))
 	if err != nil {
 		return nil, err
 	}
+	conn, ok := c.(*net.TCPConn)
+	if !ok {
+		c.Close()
+		return nil, mangos.ErrBadTran
+	}
 	if err = d.opts.configTCP(conn); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	return mangos.NewConnPipe(conn, d.sock)
+	return mangos.NewConnPipe(conn, d.sock,
+		mangos.PropTransportOptions, d.opts.applied())
 }
 
 func (d *dialer) SetOption(n string, v interface{}) error {
@@ -145,15 +216,20 @@ func (l *listener) Accept() (mangos.Pipe, error) {
 		conn.Close()
 		return nil, err
 	}
-	return mangos.NewConnPipe(conn, l.sock)
+	return mangos.NewConnPipe(conn, l.sock,
+		mangos.PropTransportOptions, l.opts.applied())
 }
 
-func (l *listener) Listen() (err error) {
-	l.listener, err = net.ListenTCP("tcp", l.addr)
-	if err == nil {
-		l.bound = l.listener.Addr()
+func (l *listener) Listen() error {
+	// Accepted connections inherit OptionTOS from the listening socket.
+	lc := net.ListenConfig{Control: l.opts.control()}
+	nl, err := lc.Listen(context.Background(), "tcp", l.addr.String())
+	if err != nil {
+		return mangos.ListenError(err)
 	}
-	return
+	l.listener = nl.(*net.TCPListener)
+	l.bound = l.listener.Addr()
+	return nil
 }
 
 func (l *listener) Address() string {
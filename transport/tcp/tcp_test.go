@@ -16,6 +16,11 @@ package tcp
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -354,3 +359,289 @@ func TestTCPOptions(t *testing.T) {
 		return
 	}
 }
+
+func TestTCPHandshakeHookReject(t *testing.T) {
+	addr := "tcp://127.0.0.1:0"
+	errDenied := errors.New("address denied")
+
+	l, err := tran.NewListener(addr, sockRep)
+	if err != nil {
+		t.Errorf("NewListener failed: %v", err)
+		return
+	}
+	defer l.Close()
+	if err = l.Listen(); err != nil {
+		t.Errorf("Listen failed: %v", err)
+		return
+	}
+
+	go func() {
+		if server, err := l.Accept(); err == nil {
+			server.Close()
+		}
+	}()
+
+	sock, _ := req.NewSocket()
+	defer sock.Close()
+	hook := func(remote net.Addr, proto uint16) error {
+		if proto != mangos.ProtoRep {
+			t.Errorf("Wrong proposed protocol %d", proto)
+		}
+		if remote.(*net.TCPAddr).IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			return errDenied
+		}
+		return nil
+	}
+	if err = sock.SetOption(mangos.OptionHandshakeHook, hook); err != nil {
+		t.Errorf("Failed set hook: %v", err)
+		return
+	}
+
+	d, err := tran.NewDialer(l.Address(), sock)
+	if err != nil {
+		t.Errorf("NewDialer failed: %v", err)
+		return
+	}
+	client, err := d.Dial()
+	if err != errDenied || client != nil {
+		t.Errorf("Dial not rejected: %v", err)
+		return
+	}
+	t.Logf("Got expected error: %v", err)
+}
+
+func TestTCPScopedAddress(t *testing.T) {
+	addr, err := mangos.ResolveTCPAddr("[fe80::1%lo]:5555")
+	if err != nil {
+		t.Errorf("Resolve failed: %v", err)
+		return
+	}
+	if addr.Zone != "lo" || addr.Port != 5555 ||
+		!addr.IP.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("Bad scoped address: %v", addr)
+		return
+	}
+	if d, err := tran.NewDialer("tcp://[fe80::1%lo]:5555", sockReq); err != nil || d == nil {
+		t.Errorf("NewDialer failed for scoped address: %v", err)
+	}
+	if l, err := tran.NewListener("tcp://[fe80::1%lo]:5555", sockRep); err != nil {
+		t.Errorf("NewListener failed for scoped address: %v", err)
+	} else if a := l.Address(); a != "tcp://[fe80::1%lo]:5555" {
+		t.Errorf("Scoped listener address wrong: %s", a)
+	}
+}
+
+func TestTCPDualStackDial(t *testing.T) {
+	// Listen on the IPv6 wildcard, which on most systems accepts both
+	// IPv4 and IPv6 connections.  Then dial by name, which resolves
+	// to both families for localhost.
+	l, err := tran.NewListener("tcp://[::]:0", sockRep)
+	if err != nil {
+		t.Errorf("NewListener failed: %v", err)
+		return
+	}
+	if err = l.Listen(); err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+		return
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(l.Address(), "tcp://"))
+	if err != nil {
+		t.Errorf("Bad listener address %s: %v", l.Address(), err)
+		return
+	}
+
+	go func() {
+		if server, err := l.Accept(); err == nil {
+			server.Close()
+		}
+	}()
+
+	d, err := tran.NewDialer("tcp://localhost:"+port, sockReq)
+	if err != nil {
+		t.Errorf("NewDialer failed: %v", err)
+		return
+	}
+	client, err := d.Dial()
+	if err != nil {
+		t.Errorf("Dual stack dial failed: %v", err)
+		return
+	}
+	defer client.Close()
+	if v, err := client.GetProp(mangos.PropRemoteAddr); err == nil {
+		t.Logf("Connected to %v", v)
+	}
+}
+
+func TestTCPHandshakeTimeout(t *testing.T) {
+	sock, _ := rep.NewSocket()
+	defer sock.Close()
+	if err := sock.SetOption(mangos.OptionHandshakeTimeout, time.Millisecond*100); err != nil {
+		t.Errorf("Failed set handshake timeout: %v", err)
+		return
+	}
+
+	l, err := tran.NewListener("tcp://127.0.0.1:0", sock)
+	if err != nil {
+		t.Errorf("NewListener failed: %v", err)
+		return
+	}
+	defer l.Close()
+	if err = l.Listen(); err != nil {
+		t.Errorf("Listen failed: %v", err)
+		return
+	}
+	errq := make(chan error, 1)
+	go func() {
+		server, err := l.Accept()
+		if err == nil {
+			server.Close()
+		}
+		errq <- err
+	}()
+
+	// A raw client that never sends the SP greeting.
+	c, err := net.Dial("tcp", strings.TrimPrefix(l.Address(), "tcp://"))
+	if err != nil {
+		t.Errorf("Dial failed: %v", err)
+		return
+	}
+	defer c.Close()
+	start := time.Now()
+	c.SetReadDeadline(start.Add(time.Second * 2))
+	buf := make([]byte, 64)
+	n := 0
+	for err == nil {
+		var cnt int
+		cnt, err = c.Read(buf[n:])
+		n += cnt
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("Connection not dropped by server")
+		return
+	}
+	if n != 8 {
+		t.Errorf("Expected only the 8 byte greeting, got %d bytes", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("Dropped too soon: %v", elapsed)
+	}
+	if err = <-errq; err == nil {
+		t.Errorf("Accept succeeded without a greeting")
+	}
+}
+
+func TestTCPGreetingLenient(t *testing.T) {
+	for _, lenient := range []bool{false, true} {
+		sock, _ := rep.NewSocket()
+		defer sock.Close()
+		if err := sock.SetOption(mangos.OptionGreetingLenient, lenient); err != nil {
+			t.Fatalf("Failed set lenient: %v", err)
+		}
+		l, err := tran.NewListener("tcp://127.0.0.1:0", sock)
+		if err != nil {
+			t.Fatalf("NewListener failed: %v", err)
+		}
+		defer l.Close()
+		if err = l.Listen(); err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		errq := make(chan error, 1)
+		go func() {
+			server, err := l.Accept()
+			if err == nil {
+				server.Close()
+			}
+			errq <- err
+		}()
+
+		c, err := net.Dial("tcp", strings.TrimPrefix(l.Address(), "tcp://"))
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer c.Close()
+		// A REQ greeting, with junk in the reserved bytes.
+		greeting := []byte{0, 'S', 'P', 0, 0, byte(mangos.ProtoReq), 0, 1}
+		if _, err = c.Write(greeting); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		err = <-errq
+		switch {
+		case lenient && err != nil:
+			t.Errorf("Lenient mode refused greeting: %v", err)
+		case !lenient && err != mangos.ErrBadHeader:
+			t.Errorf("Strict mode returned %v", err)
+		}
+	}
+}
+
+func TestTCPLingerOption(t *testing.T) {
+	d, err := tran.NewDialer("tcp://127.0.0.1:19", sockReq)
+	if err != nil {
+		t.Fatalf("NewDialer failed: %v", err)
+	}
+	if _, err = d.GetOption(mangos.OptionTCPLinger); err != mangos.ErrBadOption {
+		t.Errorf("Linger set by default: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTCPLinger, 0); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTCPLinger, time.Duration(0)); err != nil {
+		t.Errorf("Set option failed: %v", err)
+	}
+	for v, secs := range map[time.Duration]int{
+		-time.Second:           -1,
+		0:                      0,
+		time.Millisecond:       1,
+		time.Second:            1,
+		time.Second * 5 / 2:    3,
+		time.Duration(1 << 40): 1100,
+	} {
+		if got := lingerSecs(v); got != secs {
+			t.Errorf("lingerSecs(%v) = %d, expected %d", v, got, secs)
+		}
+	}
+}
+
+func TestTCPLingerReset(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Reset is reported differently on Windows")
+	}
+	for _, abort := range []bool{false, true} {
+		sock, _ := rep.NewSocket()
+		defer sock.Close()
+		sock.SetOption(mangos.OptionHandshakeTimeout, time.Millisecond*100)
+
+		l, err := tran.NewListener("tcp://127.0.0.1:0", sock)
+		if err != nil {
+			t.Fatalf("NewListener failed: %v", err)
+		}
+		defer l.Close()
+		if abort {
+			l.SetOption(mangos.OptionTCPLinger, time.Duration(0))
+		}
+		if err = l.Listen(); err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		go l.Accept()
+
+		// The server gives up on a client that never greets it.
+		c, err := net.Dial("tcp", strings.TrimPrefix(l.Address(), "tcp://"))
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(time.Second * 2))
+		buf := make([]byte, 64)
+		for err == nil {
+			_, err = c.Read(buf)
+		}
+		reset := strings.Contains(err.Error(), "reset")
+		if abort && !reset {
+			t.Errorf("Expected a reset, got %v", err)
+		}
+		if !abort && err != io.EOF {
+			t.Errorf("Expected EOF, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"nanomsg.org/go-mangos"
+)
+
+// getTOS reads back the type of service of a connection or listener.
+func getTOS(t *testing.T, c syscall.Conn) int {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	var tos int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		tos, serr = syscall.GetsockoptInt(int(fd),
+			syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if err != nil || serr != nil {
+		t.Fatalf("Getsockopt: %v, %v", err, serr)
+	}
+	return tos
+}
+
+func TestTCPTOS(t *testing.T) {
+	l, err := tran.NewListener("tcp://127.0.0.1:0", sockRep)
+	if err != nil {
+		t.Fatalf("NewListener: %v", err)
+	}
+	for _, v := range []interface{}{-1, 256, "ef"} {
+		if err = l.SetOption(mangos.OptionTOS, v); err != mangos.ErrBadValue {
+			t.Errorf("Expected ErrBadValue for %v, got %v", v, err)
+		}
+	}
+	if err = l.SetOption(mangos.OptionTOS, 46<<2); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if v, err := l.GetOption(mangos.OptionTOS); err != nil || v.(int) != 184 {
+		t.Errorf("GetOption got %v, %v", v, err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	if tos := getTOS(t, l.(*listener).listener); tos != 184 {
+		t.Errorf("Listener TOS is %d, expected 184", tos)
+	}
+
+	d, err := tran.NewDialer(l.Address(), sockReq)
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTOS, 0x20); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	// Dial the way the dialer does, but keep hold of the connection.
+	nd := &net.Dialer{Control: d.(*dialer).opts.control()}
+	c, err := nd.Dial("tcp", l.(*listener).bound.String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if tos := getTOS(t, c.(*net.TCPConn)); tos != 0x20 {
+		t.Errorf("Dialed TOS is %d, expected 32", tos)
+	}
+}
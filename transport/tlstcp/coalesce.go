@@ -0,0 +1,93 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlstcp
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+)
+
+// maxRecord is the largest TLS record payload, so there is no point in
+// buffering more than this before writing.
+const maxRecord = 16384
+
+// coalescer buffers writes to a TLS connection so that the several small
+// writes making up each message, and consecutive small messages, share TLS
+// records.  Buffered data is written once a full record has accumulated,
+// or when the delay has passed since the first write that found the
+// buffer empty.  See OptionTLSCoalesce.
+type coalescer struct {
+	*tls.Conn
+	delay   time.Duration
+	buf     []byte
+	pending bool // a flush timer is running
+	err     error
+	sync.Mutex
+}
+
+func newCoalescer(c *tls.Conn, delay time.Duration) *coalescer {
+	return &coalescer{Conn: c, delay: delay}
+}
+
+func (c *coalescer) Write(b []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf) == 0 && len(b) >= maxRecord {
+		// Nothing to coalesce with, so avoid the copy.
+		return c.Conn.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= maxRecord {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+	} else if !c.pending {
+		c.pending = true
+		time.AfterFunc(c.delay, c.timeout)
+	}
+	return len(b), nil
+}
+
+func (c *coalescer) flushLocked() error {
+	if len(c.buf) == 0 || c.err != nil {
+		return c.err
+	}
+	_, c.err = c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return c.err
+}
+
+func (c *coalescer) timeout() {
+	c.Lock()
+	c.pending = false
+	c.flushLocked()
+	c.Unlock()
+}
+
+// Flush writes any buffered data immediately.
+func (c *coalescer) Flush() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.flushLocked()
+}
+
+func (c *coalescer) Close() error {
+	c.Flush()
+	return c.Conn.Close()
+}
@@ -15,8 +15,18 @@
 package tlstcp
 
 import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pair"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/rep"
+	"nanomsg.org/go-mangos/protocol/req"
+	"nanomsg.org/go-mangos/protocol/sub"
 	"nanomsg.org/go-mangos/test"
 )
 
@@ -41,3 +51,461 @@ func TestTLSSendRecv(t *testing.T) {
 func TestTLSAll(t *testing.T) {
 	tt.TestAll(t)
 }
+
+func TestTLSSessionResume(t *testing.T) {
+	addr := test.AddrTestTLS()
+	srvCfg, err := test.GetTLSConfig(true)
+	if err != nil {
+		t.Fatalf("Failed to get server config: %v", err)
+	}
+	cliCfg, err := test.GetTLSConfig(false)
+	if err != nil {
+		t.Fatalf("Failed to get client config: %v", err)
+	}
+	tran := NewTransport()
+	srep, _ := rep.NewSocket()
+	sreq, _ := req.NewSocket()
+	defer srep.Close()
+	defer sreq.Close()
+
+	l, err := tran.NewListener(addr, srep)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	if err = l.SetOption(mangos.OptionTLSConfig, srvCfg); err != nil {
+		t.Fatalf("Failed setting TLS config: %v", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go func() {
+		for {
+			p, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer p.Close()
+		}
+	}()
+
+	d, err := tran.NewDialer(addr, sreq)
+	if err != nil {
+		t.Fatalf("NewDialer failed: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTLSConfig, cliCfg); err != nil {
+		t.Fatalf("Failed setting TLS config: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTLSSessionCache, 1); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err = d.SetOption(mangos.OptionTLSSessionCache, true); err != nil {
+		t.Fatalf("Failed setting session cache: %v", err)
+	}
+
+	for i, resume := range []bool{false, true, true} {
+		p, err := d.Dial()
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		v, err := p.GetProp(mangos.PropTLSConnState)
+		if err != nil {
+			t.Fatalf("Failed to get TLS state: %v", err)
+		}
+		if did := v.(tls.ConnectionState).DidResume; did != resume {
+			t.Errorf("Dial %d: resumed %v, expected %v", i, did, resume)
+		}
+		p.Close()
+	}
+}
+
+// countConn counts the writes made to a connection.  Once the handshake
+// is done, crypto/tls makes one write per record.
+type countConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// coalescePair returns a connected pair of SP pipes over TLS, with the
+// client side counting its records, and coalescing if delay is positive.
+func coalescePair(tb testing.TB, delay time.Duration) (mangos.Pipe, mangos.Pipe, *countConn) {
+	srvCfg, err := test.GetTLSConfig(true)
+	if err != nil {
+		tb.Fatalf("Failed to get server config: %v", err)
+	}
+	cliCfg, err := test.GetTLSConfig(false)
+	if err != nil {
+		tb.Fatalf("Failed to get client config: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	ssock, _ := pair.NewSocket()
+	csock, _ := pair.NewSocket()
+	srvq := make(chan mangos.Pipe, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			srvq <- nil
+			return
+		}
+		p, err := mangos.NewConnPipe(tls.Server(c, srvCfg), ssock)
+		if err != nil {
+			c.Close()
+		}
+		srvq <- p
+	}()
+
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatalf("Dial failed: %v", err)
+	}
+	cc := &countConn{Conn: raw}
+	tc := tls.Client(cc, cliCfg)
+	if err = tc.Handshake(); err != nil {
+		tb.Fatalf("Handshake failed: %v", err)
+	}
+	var nc net.Conn = tc
+	if delay > 0 {
+		nc = newCoalescer(tc, delay)
+	}
+	cp, err := mangos.NewConnPipe(nc, csock)
+	if err != nil {
+		tb.Fatalf("Client pipe failed: %v", err)
+	}
+	sp := <-srvq
+	if sp == nil {
+		tb.Fatalf("Server pipe failed")
+	}
+	atomic.StoreInt64(&cc.writes, 0)
+	return cp, sp, cc
+}
+
+// sendSmall sends n small messages from cp, which sp must receive.
+func sendSmall(tb testing.TB, cp, sp mangos.Pipe, n int) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			m, err := sp.Recv()
+			if err != nil {
+				tb.Errorf("Recv %d failed: %v", i, err)
+				return
+			}
+			m.Free()
+		}
+	}()
+	payload := make([]byte, 64)
+	for i := 0; i < n; i++ {
+		m := mangos.NewMessage(len(payload))
+		m.Body = append(m.Body, payload...)
+		if err := cp.Send(m); err != nil {
+			tb.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	<-done
+}
+
+func TestTLSCoalesce(t *testing.T) {
+	const n = 200
+	for _, delay := range []time.Duration{0, time.Millisecond * 5} {
+		cp, sp, cc := coalescePair(t, delay)
+		sendSmall(t, cp, sp, n)
+		records := atomic.LoadInt64(&cc.writes)
+		t.Logf("Delay %v: %d records for %d messages", delay, records, n)
+		switch {
+		case delay == 0 && records < n:
+			t.Errorf("Expected at least one record per message, got %d", records)
+		case delay > 0 && records > n/10:
+			t.Errorf("Expected coalescing, got %d records", records)
+		}
+		cp.Close()
+		sp.Close()
+	}
+}
+
+func TestTLSCoalesceOption(t *testing.T) {
+	addr := "tls+tcp://127.0.0.1:3337"
+	srvCfg, _ := test.GetTLSConfig(true)
+	cliCfg, _ := test.GetTLSConfig(false)
+	srep, _ := rep.NewSocket()
+	sreq, _ := req.NewSocket()
+	defer srep.Close()
+	defer sreq.Close()
+	srep.AddTransport(NewTransport())
+	sreq.AddTransport(NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	if err := srep.ListenOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:   srvCfg,
+		mangos.OptionTLSCoalesce: time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if err := sreq.DialOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:   cliCfg,
+		mangos.OptionTLSCoalesce: -time.Millisecond,
+	}); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := sreq.DialOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:   cliCfg,
+		mangos.OptionTLSCoalesce: time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := srep.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if err := srep.Send([]byte("pong")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if b, err := sreq.Recv(); err != nil || string(b) != "pong" {
+		t.Fatalf("Reply: %q %v", b, err)
+	}
+}
+
+func benchmarkCoalesce(b *testing.B, delay time.Duration) {
+	cp, sp, cc := coalescePair(b, delay)
+	defer cp.Close()
+	defer sp.Close()
+	b.ResetTimer()
+	sendSmall(b, cp, sp, b.N)
+	b.StopTimer()
+	b.Logf("%.2f TLS records per message",
+		float64(atomic.LoadInt64(&cc.writes))/float64(b.N))
+}
+
+func BenchmarkTLSPerMessage(b *testing.B) {
+	benchmarkCoalesce(b, 0)
+}
+
+func BenchmarkTLSCoalesce(b *testing.B) {
+	benchmarkCoalesce(b, time.Millisecond)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	addr := "tls+tcp://127.0.0.1:3338"
+	srvCfg, _ := test.GetTLSConfig(true)
+	cliCfg, _ := test.GetTLSConfig(false)
+	srep, _ := rep.NewSocket()
+	defer srep.Close()
+	srep.AddTransport(NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+
+	if err := srep.ListenOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:           srvCfg,
+		mangos.OptionTLSHandshakeTimeout: -time.Second,
+	}); err != mangos.ErrBadValue {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+	if err := srep.ListenOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:           srvCfg,
+		mangos.OptionTLSHandshakeTimeout: 100 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	// A client that connects, but never starts the handshake.
+	c, err := net.Dial("tcp", addr[len("tls+tcp://"):])
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	start := time.Now()
+	c.SetReadDeadline(start.Add(2 * time.Second))
+	buf := make([]byte, 64)
+	for err == nil {
+		_, err = c.Read(buf)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("Stalled connection not closed")
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("Closed after %v", d)
+	}
+
+	// The listener carries on serving other clients.
+	sreq, _ := req.NewSocket()
+	defer sreq.Close()
+	sreq.AddTransport(NewTransport())
+	sreq.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := sreq.DialOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig: cliCfg,
+	}); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := srep.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+}
+
+func TestTLSPortProps(t *testing.T) {
+	addr := "tls+tcp://127.0.0.1:3339"
+	srvCfg, _ := test.GetTLSConfig(true)
+	cliCfg, _ := test.GetTLSConfig(false)
+	cliCfg = cliCfg.Clone()
+	cliCfg.MaxVersion = tls.VersionTLS12
+	srep, _ := rep.NewSocket()
+	defer srep.Close()
+	srep.AddTransport(NewTransport())
+	srep.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err := srep.ListenOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig: srvCfg,
+	}); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	sreq, _ := req.NewSocket()
+	defer sreq.Close()
+	sreq.AddTransport(NewTransport())
+	if err := sreq.DialOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:           cliCfg,
+		mangos.OptionTLSHandshakeTimeout: 5 * time.Second,
+	}); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := sreq.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := srep.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	var ports []mangos.Port
+	for _, s := range []mangos.Socket{sreq, srep} {
+		eps := s.Endpoints()
+		if len(eps) != 1 {
+			t.Fatalf("Expected 1 endpoint, got %d", len(eps))
+		}
+		ports = append(ports, eps[0].(mangos.Port))
+	}
+	for i, p := range ports {
+		v, err := p.GetProp(mangos.PropTLSConnState)
+		if err != nil {
+			t.Fatalf("Failed to get TLS state: %v", err)
+		}
+		state := v.(tls.ConnectionState)
+		if state.Version != tls.VersionTLS12 {
+			t.Errorf("Port %d negotiated version %x", i, state.Version)
+		}
+		if v, err := p.GetProp(mangos.PropTLSVersion); err != nil || v.(uint16) != state.Version {
+			t.Errorf("Port %d version %v, %v", i, v, err)
+		}
+		if v, err := p.GetProp(mangos.PropTLSCipherSuite); err != nil || v.(uint16) != state.CipherSuite {
+			t.Errorf("Port %d cipher suite %v, %v", i, v, err)
+		}
+	}
+
+	v, err := ports[0].GetProp(mangos.PropTransportOptions)
+	if err != nil {
+		t.Fatalf("Failed to get transport options: %v", err)
+	}
+	opts := v.(map[string]interface{})
+	if d, ok := opts[mangos.OptionTLSHandshakeTimeout]; !ok || d.(time.Duration) != 5*time.Second {
+		t.Errorf("Handshake timeout reported as %v", d)
+	}
+	delete(opts, mangos.OptionTLSHandshakeTimeout)
+	v, _ = ports[0].GetProp(mangos.PropTransportOptions)
+	if _, ok := v.(map[string]interface{})[mangos.OptionTLSHandshakeTimeout]; !ok {
+		t.Errorf("Transport options not copied")
+	}
+}
+
+func TestTLSFlush(t *testing.T) {
+	rx, _ := pair.NewSocket()
+	tx, _ := pair.NewSocket()
+	flushTest(t, tx, rx)
+}
+
+func TestTLSFlushPub(t *testing.T) {
+	rx, _ := sub.NewSocket()
+	tx, _ := pub.NewSocket()
+	rx.SetOption(mangos.OptionSubscribe, []byte{})
+	flushTest(t, tx, rx)
+}
+
+// flushTest checks that Flush gets out messages sent with plain Send,
+// which may still be queued in the socket or protocol when it is called.
+func flushTest(t *testing.T, tx, rx mangos.Socket) {
+	addr := test.AddrTestTLS()
+	srvCfg, _ := test.GetTLSConfig(true)
+	cliCfg, _ := test.GetTLSConfig(false)
+	defer rx.Close()
+	defer tx.Close()
+	rx.AddTransport(NewTransport())
+	tx.AddTransport(NewTransport())
+
+	if err := rx.ListenOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig: srvCfg,
+	}); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	// Long enough that only Flush gets the messages out during the test.
+	if err := tx.DialOptions(addr, map[string]interface{}{
+		mangos.OptionTLSConfig:   cliCfg,
+		mangos.OptionTLSCoalesce: time.Minute,
+	}); err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if err := tx.Send([]byte{byte(i)}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if err := tx.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	for i := 0; i < 5; i++ {
+		b, err := rx.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if len(b) != 1 || b[0] != byte(i) {
+			t.Errorf("Got %v, expected %d", b, i)
+		}
+	}
+
+	// Without a Flush, the coalescing delay holds messages back.
+	if err := tx.Send([]byte{5}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	rx.SetOption(mangos.OptionRecvDeadline, 100*time.Millisecond)
+	if _, err := rx.Recv(); err != mangos.ErrRecvTimeout {
+		t.Fatalf("Expected ErrRecvTimeout before Flush, got %v", err)
+	}
+	if err := tx.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	rx.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if b, err := rx.Recv(); err != nil || len(b) != 1 || b[0] != 5 {
+		t.Fatalf("Got %v, %v after Flush", b, err)
+	}
+
+	// Nothing is buffered now, and flushing again is harmless.
+	if err := tx.Flush(); err != nil {
+		t.Errorf("Second Flush failed: %v", err)
+	}
+	tx.Close()
+	if err := tx.Flush(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
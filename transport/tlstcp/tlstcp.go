@@ -18,6 +18,7 @@ package tlstcp
 import (
 	"crypto/tls"
 	"net"
+	"sync"
 	"time"
 
 	"nanomsg.org/go-mangos"
@@ -41,12 +42,36 @@ func (o options) set(name string, val interface{}) error {
 		default:
 			return mangos.ErrBadValue
 		}
+	case mangos.OptionTLSSessionCache:
+		switch v := val.(type) {
+		case bool:
+			o[name] = v
+		case tls.ClientSessionCache:
+			o[name] = v
+		default:
+			return mangos.ErrBadValue
+		}
+	case mangos.OptionTLSCoalesce, mangos.OptionTLSHandshakeTimeout:
+		v, ok := val.(time.Duration)
+		if !ok || v < 0 {
+			return mangos.ErrBadValue
+		}
+		o[name] = v
 	default:
 		return mangos.ErrBadOption
 	}
 	return nil
 }
 
+// applied returns a copy of the options, for PropTransportOptions.
+func (o options) applied() map[string]interface{} {
+	m := make(map[string]interface{}, len(o))
+	for k, v := range o {
+		m[k] = v
+	}
+	return m
+}
+
 func (o options) configTCP(conn *net.TCPConn) error {
 	if v, ok := o[mangos.OptionNoDelay]; ok {
 		if err := conn.SetNoDelay(v.(bool)); err != nil {
@@ -67,6 +92,27 @@ func (o options) configTCP(conn *net.TCPConn) error {
 	return nil
 }
 
+// handshake performs the TLS handshake, giving up (and closing the
+// connection) if OptionTLSHandshakeTimeout is set and it takes too long.
+func (o options) handshake(conn *tls.Conn) error {
+	if v, ok := o[mangos.OptionTLSHandshakeTimeout].(time.Duration); ok && v > 0 {
+		conn.SetDeadline(time.Now().Add(v))
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// wrap applies OptionTLSCoalesce, if set, to an established connection.
+func (o options) wrap(conn *tls.Conn) net.Conn {
+	if v, ok := o[mangos.OptionTLSCoalesce].(time.Duration); ok && v > 0 {
+		return newCoalescer(conn, v)
+	}
+	return conn
+}
+
 func newOptions(t *tlsTran) options {
 	o := make(map[string]interface{})
 	o[mangos.OptionTLSConfig] = t.config
@@ -77,6 +123,22 @@ type dialer struct {
 	addr string
 	sock mangos.Socket
 	opts options
+	tran *tlsTran
+}
+
+// sessionCache returns the session cache selected by OptionTLSSessionCache,
+// and true, or false if the option has not been set.
+func (d *dialer) sessionCache() (tls.ClientSessionCache, bool) {
+	switch v := d.opts[mangos.OptionTLSSessionCache].(type) {
+	case bool:
+		if v {
+			return d.tran.sessionCache(), true
+		}
+		return nil, true
+	case tls.ClientSessionCache:
+		return v, true
+	}
+	return nil, false
 }
 
 func (d *dialer) Dial() (_ mangos.Pipe, err error) {
@@ -100,13 +162,21 @@ func (d *dialer) Dial() (_ mangos.Pipe, err error) {
 	if v, ok := d.opts[mangos.OptionTLSConfig]; ok {
 		config = v.(*tls.Config)
 	}
+	if cache, ok := d.sessionCache(); ok {
+		if config == nil {
+			config = &tls.Config{}
+		} else {
+			config = config.Clone()
+		}
+		config.ClientSessionCache = cache
+	}
 	conn := tls.Client(tconn, config)
-	if err = conn.Handshake(); err != nil {
-		conn.Close()
+	if err = d.opts.handshake(conn); err != nil {
 		return nil, err
 	}
-	return mangos.NewConnPipe(conn, d.sock,
-		mangos.PropTLSConnState, conn.ConnectionState())
+	return mangos.NewConnPipe(d.opts.wrap(conn), d.sock,
+		mangos.PropTLSConnState, conn.ConnectionState(),
+		mangos.PropTransportOptions, d.opts.applied())
 }
 
 func (d *dialer) SetOption(n string, v interface{}) error {
@@ -141,7 +211,7 @@ func (l *listener) Listen() error {
 	}
 
 	if l.listener, err = net.ListenTCP("tcp", l.addr); err != nil {
-		return err
+		return mangos.ListenError(err)
 	}
 
 	l.bound = l.listener.Addr()
@@ -169,12 +239,12 @@ func (l *listener) Accept() (mangos.Pipe, error) {
 	}
 
 	conn := tls.Server(tconn, l.config)
-	if err = conn.Handshake(); err != nil {
-		conn.Close()
+	if err = l.opts.handshake(conn); err != nil {
 		return nil, err
 	}
-	return mangos.NewConnPipe(conn, l.sock,
-		mangos.PropTLSConnState, conn.ConnectionState())
+	return mangos.NewConnPipe(l.opts.wrap(conn), l.sock,
+		mangos.PropTLSConnState, conn.ConnectionState(),
+		mangos.PropTransportOptions, l.opts.applied())
 }
 
 func (l *listener) Close() error {
@@ -193,6 +263,17 @@ func (l *listener) GetOption(n string) (interface{}, error) {
 type tlsTran struct {
 	config    *tls.Config
 	localAddr net.Addr
+	cache     tls.ClientSessionCache
+	once      sync.Once
+}
+
+// sessionCache returns the session cache shared by dialers that set
+// OptionTLSSessionCache to true.
+func (t *tlsTran) sessionCache() tls.ClientSessionCache {
+	t.once.Do(func() {
+		t.cache = tls.NewLRUClientSessionCache(0)
+	})
+	return t.cache
 }
 
 func (t *tlsTran) Scheme() string {
@@ -211,7 +292,7 @@ func (t *tlsTran) NewDialer(addr string, sock mangos.Socket) (mangos.PipeDialer,
 		return nil, err
 	}
 
-	d := &dialer{sock: sock, opts: newOptions(t), addr: addr}
+	d := &dialer{sock: sock, opts: newOptions(t), addr: addr, tran: t}
 
 	return d, nil
 }
@@ -0,0 +1,534 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package udp implements a datagram transport for mangos, using addresses
+// of the form udp://host:port.  Each SP message is carried in a single UDP
+// datagram, so delivery is unreliable and unordered: messages may be lost,
+// duplicated or reordered, and nothing is retransmitted.  It suits
+// protocols that tolerate loss, such as PUB/SUB and SURVEYOR/RESPONDENT,
+// where low latency matters more than completeness.
+//
+// UDP has no connections, so there is no SP greeting.  Instead every
+// datagram starts with a short header carrying the sender's protocol
+// number, which is checked against the local protocol.  A dialer
+// announces itself to the listener as soon as it dials, and both ends
+// send a small keepalive periodically, every OptionKeepAliveTime (one
+// second by default).  A peer that has been silent for five keepalive
+// intervals is considered gone, and its pipe is closed; a dialer will then
+// redial as usual.  Closing a pipe notifies the peer.
+//
+// Messages larger than a datagram can carry (a little under 64KB) are
+// refused by the pipe with ErrTooLong, which leaves it open.  The socket
+// then discards the message, telling any DropHook, OnSent function or
+// SendMsgEndpoint caller why.  Use OptionMaxSendSize to have Send itself
+// reject them.  PropFrameSizeHint reports the largest message that fits in an
+// unfragmented datagram on a typical Ethernet path.
+package udp
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nanomsg.org/go-mangos"
+)
+
+const (
+	hdrSize = 8 // 0, 'S', 'P', kind, protocol (16 bits), reserved (16)
+
+	// maxDatagram is the largest UDP payload over IPv4.
+	maxDatagram = 65507
+
+	// frameHint is an Ethernet MTU, less IPv4, UDP and our headers.
+	frameHint = 1500 - 20 - 8 - hdrSize
+
+	recvQLen = 128
+)
+
+// Datagram kinds, in the fourth byte of the header.
+const (
+	kindData  = 0 // an SP message
+	kindHello = 1 // announcement or keepalive, no payload
+	kindBye   = 2 // the sender closed its pipe
+)
+
+const (
+	// defaultHello is how often each end sends a keepalive, unless
+	// OptionKeepAliveTime is set.
+	defaultHello = time.Second
+
+	// deadHellos is the number of keepalive intervals a peer may be
+	// silent before it is considered gone.
+	deadHellos = 5
+)
+
+// options is used for shared GetOption/SetOption logic.
+type options map[string]interface{}
+
+func newOptions() options {
+	o := make(map[string]interface{})
+	o[mangos.OptionKeepAliveTime] = defaultHello
+	return options(o)
+}
+
+func (o options) get(name string) (interface{}, error) {
+	v, ok := o[name]
+	if !ok {
+		return nil, mangos.ErrBadOption
+	}
+	return v, nil
+}
+
+func (o options) set(name string, val interface{}) error {
+	switch name {
+	case mangos.OptionKeepAliveTime:
+		if v, ok := val.(time.Duration); ok && v > 0 {
+			o[name] = v
+			return nil
+		}
+		return mangos.ErrBadValue
+	}
+	return mangos.ErrBadOption
+}
+
+func (o options) hello() time.Duration {
+	return o[mangos.OptionKeepAliveTime].(time.Duration)
+}
+
+func putHeader(b []byte, kind byte, proto uint16) {
+	b[0], b[1], b[2], b[3] = 0, 'S', 'P', kind
+	binary.BigEndian.PutUint16(b[4:], proto)
+	b[6], b[7] = 0, 0
+}
+
+// parseHeader returns the kind and protocol from a datagram header, and
+// false if the datagram is not one of ours.
+func parseHeader(b []byte) (byte, uint16, bool) {
+	if len(b) < hdrSize || b[0] != 0 || b[1] != 'S' || b[2] != 'P' ||
+		b[3] > kindBye {
+		return 0, 0, false
+	}
+	return b[3], binary.BigEndian.Uint16(b[4:]), true
+}
+
+// pipe is one peer of a UDP socket.  Dialer pipes own a connected UDP
+// socket; listener pipes share the listener's socket, and are addressed
+// by the peer's address.
+type pipe struct {
+	conn   *net.UDPConn
+	raddr  *net.UDPAddr // nil if conn is connected
+	proto  mangos.Protocol
+	rproto uint32 // set from datagrams received, read atomically
+	seen   int64  // UnixNano of the last datagram received, atomic
+	recvq  chan *mangos.Message
+	closeq chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup // our goroutines, joined by Close
+	props  map[string]interface{}
+	hello  time.Duration // keepalive interval
+	done   func()        // called once on close
+}
+
+func newPipe(conn *net.UDPConn, raddr *net.UDPAddr, sock mangos.Socket, hello time.Duration) *pipe {
+	p := &pipe{
+		conn:   conn,
+		raddr:  raddr,
+		proto:  sock.GetProtocol(),
+		hello:  hello,
+		recvq:  make(chan *mangos.Message, recvQLen),
+		closeq: make(chan struct{}),
+		props:  make(map[string]interface{}),
+	}
+	p.rproto = uint32(p.proto.PeerNumber())
+	p.seen = time.Now().UnixNano()
+	p.props[mangos.PropLocalAddr] = conn.LocalAddr()
+	if raddr != nil {
+		p.props[mangos.PropRemoteAddr] = raddr
+	} else {
+		p.props[mangos.PropRemoteAddr] = conn.RemoteAddr()
+	}
+	p.props[mangos.PropFrameSizeHint] = frameHint
+	return p
+}
+
+func (p *pipe) write(b []byte) error {
+	var err error
+	if p.raddr != nil {
+		_, err = p.conn.WriteToUDP(b, p.raddr)
+	} else {
+		_, err = p.conn.Write(b)
+	}
+	return err
+}
+
+func (p *pipe) control(kind byte) error {
+	var b [hdrSize]byte
+	putHeader(b[:], kind, p.proto.Number())
+	return p.write(b[:])
+}
+
+// input handles a datagram from the peer.  Data is dropped if the receive
+// queue is full, as it would be by the network.
+func (p *pipe) input(kind byte, proto uint16, payload []byte) {
+	atomic.StoreInt64(&p.seen, time.Now().UnixNano())
+	atomic.StoreUint32(&p.rproto, uint32(proto))
+	switch kind {
+	case kindBye:
+		p.shut()
+	case kindData:
+		m := mangos.NewMessage(len(payload))
+		m.Body = append(m.Body, payload...)
+		select {
+		case p.recvq <- m:
+		default:
+			m.Free()
+		}
+	}
+}
+
+// keepalive sends hellos, and closes the pipe if the peer falls silent.
+func (p *pipe) keepalive() {
+	defer p.wg.Done()
+	tick := time.NewTicker(p.hello)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.closeq:
+			return
+		case <-tick.C:
+		}
+		seen := time.Unix(0, atomic.LoadInt64(&p.seen))
+		if time.Since(seen) > p.hello*deadHellos ||
+			p.control(kindHello) != nil {
+			p.shut()
+			return
+		}
+	}
+}
+
+func (p *pipe) Send(m *mangos.Message) error {
+	n := hdrSize + len(m.Header) + len(m.Body)
+	if n > maxDatagram {
+		return mangos.ErrTooLong
+	}
+	b := make([]byte, hdrSize, n)
+	putHeader(b, kindData, p.proto.Number())
+	b = append(b, m.Header...)
+	b = append(b, m.Body...)
+	if err := p.write(b); err != nil {
+		if !p.IsOpen() {
+			return mangos.ErrClosed
+		}
+		// Transient failures (e.g. no buffer space) just lose
+		// the message, as the network might.
+	}
+	m.Free()
+	return nil
+}
+
+func (p *pipe) Recv() (*mangos.Message, error) {
+	select {
+	case m := <-p.recvq:
+		return m, nil
+	case <-p.closeq:
+		return nil, mangos.ErrClosed
+	}
+}
+
+// Close shuts the pipe, and waits for its goroutines to exit.
+func (p *pipe) Close() error {
+	p.shut()
+	p.wg.Wait()
+	return nil
+}
+
+// shut closes the pipe without waiting, for use by its own goroutines.
+func (p *pipe) shut() {
+	p.once.Do(func() {
+		p.control(kindBye)
+		close(p.closeq)
+		if p.done != nil {
+			p.done()
+		}
+	})
+}
+
+func (p *pipe) LocalProtocol() uint16 {
+	return p.proto.Number()
+}
+
+func (p *pipe) RemoteProtocol() uint16 {
+	return uint16(atomic.LoadUint32(&p.rproto))
+}
+
+func (p *pipe) IsOpen() bool {
+	select {
+	case <-p.closeq:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *pipe) GetProp(name string) (interface{}, error) {
+	if v, ok := p.props[name]; ok {
+		return v, nil
+	}
+	return nil, mangos.ErrBadProperty
+}
+
+type dialer struct {
+	addr string
+	sock mangos.Socket
+	opts options
+}
+
+// Dial "connects" a UDP socket to the peer, and announces us.  There is
+// no reply to wait for, so this succeeds whether or not a listener is
+// present; if none is, the pipe is closed when the network reports the
+// port unreachable, or when no keepalive arrives.
+func (d *dialer) Dial() (mangos.Pipe, error) {
+	raddr, err := resolve(d.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	p := newPipe(conn, nil, d.sock, d.opts.hello())
+	p.done = func() { conn.Close() }
+	if err = p.control(kindHello); err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.wg.Add(2)
+	go p.reader()
+	go p.keepalive()
+	return p, nil
+}
+
+// reader receives datagrams for a dialer pipe.
+func (p *pipe) reader() {
+	defer p.wg.Done()
+	buf := make([]byte, maxDatagram+1)
+	for {
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			// Includes "connection refused", when the
+			// peer's port is unreachable.
+			p.shut()
+			return
+		}
+		kind, proto, ok := parseHeader(buf[:n])
+		if !ok || !mangos.ValidPeer(p.proto, proto) {
+			continue
+		}
+		p.input(kind, proto, buf[hdrSize:n])
+	}
+}
+
+func (d *dialer) SetOption(n string, v interface{}) error {
+	return d.opts.set(n, v)
+}
+
+func (d *dialer) GetOption(n string) (interface{}, error) {
+	return d.opts.get(n)
+}
+
+type listener struct {
+	addr    *net.UDPAddr
+	sock    mangos.Socket
+	conn    *net.UDPConn
+	peers   map[string]*pipe
+	acceptq chan *pipe
+	closeq  chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup // the reader, joined by Close
+	opts    options
+	sync.Mutex
+}
+
+func (l *listener) Listen() error {
+	conn, err := net.ListenUDP("udp", l.addr)
+	if err != nil {
+		return mangos.ListenError(err)
+	}
+	l.conn = conn
+	l.wg.Add(1)
+	go l.reader()
+	return nil
+}
+
+// reader receives every datagram for the listener, creating a pipe the
+// first time a peer is heard from.
+func (l *listener) reader() {
+	defer l.wg.Done()
+	proto := l.sock.GetProtocol()
+	hello := l.opts.hello()
+	buf := make([]byte, maxDatagram+1)
+	for {
+		n, raddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closeq:
+				return
+			default:
+				// Probably an ICMP error for one of our
+				// peers; keep serving the others.
+				continue
+			}
+		}
+		kind, rproto, ok := parseHeader(buf[:n])
+		if !ok || !mangos.ValidPeer(proto, rproto) {
+			continue
+		}
+		key := raddr.String()
+		l.Lock()
+		p := l.peers[key]
+		if p == nil && kind != kindBye && l.isOpen() {
+			p = newPipe(l.conn, raddr, l.sock, hello)
+			p.done = func() {
+				l.Lock()
+				if l.peers[key] == p {
+					delete(l.peers, key)
+				}
+				l.Unlock()
+			}
+			// Counted before the pipe is handed out, in case
+			// it is closed at once.
+			p.wg.Add(1)
+			select {
+			case l.acceptq <- p:
+				l.peers[key] = p
+				go p.keepalive()
+			default:
+				// Not keeping up with new peers; they
+				// will try again.
+				p.wg.Done()
+				p = nil
+			}
+		}
+		l.Unlock()
+		if p != nil {
+			p.input(kind, rproto, buf[hdrSize:n])
+		}
+	}
+}
+
+// isOpen is true until Close is called.  Checked with the lock held, this
+// ensures that Close sees every peer added.
+func (l *listener) isOpen() bool {
+	select {
+	case <-l.closeq:
+		return false
+	default:
+		return true
+	}
+}
+
+func (l *listener) Accept() (mangos.Pipe, error) {
+	select {
+	case p := <-l.acceptq:
+		return p, nil
+	case <-l.closeq:
+		return nil, mangos.ErrClosed
+	}
+}
+
+func (l *listener) Address() string {
+	if l.conn != nil {
+		return "udp://" + l.conn.LocalAddr().String()
+	}
+	return "udp://" + l.addr.String()
+}
+
+// Close stops listening, and closes the pipes of all peers, as they share
+// the listener's socket.  It returns once the goroutines of the listener
+// and those pipes have exited.
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		close(l.closeq)
+		l.Lock()
+		peers := make([]*pipe, 0, len(l.peers))
+		for _, p := range l.peers {
+			peers = append(peers, p)
+		}
+		l.Unlock()
+		for _, p := range peers {
+			p.Close()
+		}
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	})
+	l.wg.Wait()
+	return nil
+}
+
+func (l *listener) SetOption(n string, v interface{}) error {
+	return l.opts.set(n, v)
+}
+
+func (l *listener) GetOption(n string) (interface{}, error) {
+	return l.opts.get(n)
+}
+
+type udpTran struct{}
+
+func (t *udpTran) Scheme() string {
+	return "udp"
+}
+
+// resolve is like mangos.ResolveTCPAddr, for UDP.
+func resolve(addr string) (*net.UDPAddr, error) {
+	return net.ResolveUDPAddr("udp", strings.TrimPrefix(addr, "*"))
+}
+
+func (t *udpTran) NewDialer(addr string, sock mangos.Socket) (mangos.PipeDialer, error) {
+	var err error
+	if addr, err = mangos.StripScheme(t, addr); err != nil {
+		return nil, err
+	}
+	if _, err = resolve(addr); err != nil {
+		return nil, err
+	}
+	return &dialer{addr: addr, sock: sock, opts: newOptions()}, nil
+}
+
+func (t *udpTran) NewListener(addr string, sock mangos.Socket) (mangos.PipeListener, error) {
+	var err error
+	l := &listener{
+		sock:    sock,
+		peers:   make(map[string]*pipe),
+		acceptq: make(chan *pipe, 16),
+		closeq:  make(chan struct{}),
+		opts:    newOptions(),
+	}
+	if addr, err = mangos.StripScheme(t, addr); err != nil {
+		return nil, err
+	}
+	if l.addr, err = resolve(addr); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewTransport allocates a new UDP transport.
+func NewTransport() mangos.Transport {
+	return &udpTran{}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The Mangos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use file except in compliance with the License.
+// You may obtain a copy of the license at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udp
+
+import (
+	"testing"
+	"time"
+
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/protocol/sub"
+	"nanomsg.org/go-mangos/test"
+)
+
+var tt = test.NewTranTest(NewTransport(), "udp://127.0.0.1:3397")
+
+func TestUDPListenAndAccept(t *testing.T) {
+	tt.TestListenAndAccept(t)
+}
+
+func TestUDPDuplicateListen(t *testing.T) {
+	tt.TestDuplicateListen(t)
+}
+
+func TestUDPSendRecv(t *testing.T) {
+	tt.TestSendRecv(t)
+}
+
+func TestUDPScheme(t *testing.T) {
+	tt.TestScheme(t)
+}
+
+func TestUDPListenerSetOptionInvalid(t *testing.T) {
+	tt.TestListenerSetOptionInvalid(t)
+}
+
+func TestUDPListenerGetOptionInvalid(t *testing.T) {
+	tt.TestListenerGetOptionInvalid(t)
+}
+
+func TestUDPDialerSetOptionInvalid(t *testing.T) {
+	tt.TestDialerSetOptionInvalid(t)
+}
+
+func TestUDPDialerGetOptionInvalid(t *testing.T) {
+	tt.TestDialerGetOptionInvalid(t)
+}
+
+func TestUDPDialerBadScheme(t *testing.T) {
+	tt.TestDialerBadScheme(t)
+}
+
+func TestUDPListenerBadScheme(t *testing.T) {
+	tt.TestListenerBadScheme(t)
+}
+
+// udpPair returns a listener and dialer pipe connected over UDP.
+func udpPair(t *testing.T, addr string, hello time.Duration) (mangos.PipeListener, mangos.Pipe, mangos.Pipe) {
+	ps, _ := pub.NewSocket()
+	ss, _ := sub.NewSocket()
+	tran := NewTransport()
+	l, err := tran.NewListener(addr, ps)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	if err = l.SetOption(mangos.OptionKeepAliveTime, hello); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if err = l.Listen(); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	d, err := tran.NewDialer(addr, ss)
+	if err != nil {
+		t.Fatalf("NewDialer failed: %v", err)
+	}
+	if err = d.SetOption(mangos.OptionKeepAliveTime, hello); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	cli, err := d.Dial()
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	srv, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	return l, srv, cli
+}
+
+func TestUDPTooLong(t *testing.T) {
+	l, srv, cli := udpPair(t, "udp://127.0.0.1:3398", time.Second)
+	defer l.Close()
+	defer cli.Close()
+
+	// The oversized message is refused, without failing the pipe.
+	big := mangos.NewMessage(maxDatagram)
+	big.Body = big.Body[:maxDatagram]
+	if err := srv.Send(big); err != mangos.ErrTooLong {
+		t.Fatalf("Expected ErrTooLong, got %v", err)
+	}
+	big.Free()
+	m := mangos.NewMessage(5)
+	m.Body = append(m.Body, "small"...)
+	if err := srv.Send(m); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	m, err := cli.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if string(m.Body) != "small" {
+		t.Errorf("Got %d bytes, expected the small message", len(m.Body))
+	}
+	if v, err := cli.GetProp(mangos.PropFrameSizeHint); err != nil || v.(int) != frameHint {
+		t.Errorf("Frame size hint %v %v", v, err)
+	}
+}
+
+func TestUDPPeerGone(t *testing.T) {
+	const hello = time.Millisecond * 10
+	l, srv, cli := udpPair(t, "udp://127.0.0.1:3399", hello)
+	defer l.Close()
+
+	// Keepalives hold an idle pair open.
+	time.Sleep(hello * deadHellos * 3)
+	if !srv.IsOpen() || !cli.IsOpen() {
+		t.Fatalf("Idle pipes closed")
+	}
+
+	// A closed peer is noticed at once.
+	cli.Close()
+	for i := 0; i < 50 && srv.IsOpen(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if srv.IsOpen() {
+		t.Errorf("Server pipe still open after peer closed")
+	}
+	if _, err := srv.Recv(); err != mangos.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
@@ -213,6 +213,16 @@ func (d *dialer) Dial() (mangos.Pipe, error) {
 	wd := &websocket.Dialer{}
 
 	wd.Subprotocols = []string{d.proto.PeerName() + ".sp.nanomsg.org"}
+	if info, ok := mangos.LookupProtocol(d.proto.Number()); ok {
+		// Offer the other peers a registered protocol accepts too.
+		for _, n := range info.Peers {
+			name := mangos.ProtocolName(n)
+			if n != d.proto.PeerNumber() && name != "" {
+				wd.Subprotocols = append(wd.Subprotocols,
+					name+".sp.nanomsg.org")
+			}
+		}
+	}
 	if v, ok := d.opts[mangos.OptionTLSConfig]; ok {
 		wd.TLSClientConfig = v.(*tls.Config)
 	}
@@ -228,6 +238,7 @@ func (d *dialer) Dial() (mangos.Pipe, error) {
 	w.ws.SetReadLimit(int64(d.maxrx))
 	w.props[mangos.PropLocalAddr] = w.ws.LocalAddr()
 	w.props[mangos.PropRemoteAddr] = w.ws.RemoteAddr()
+	w.props[mangos.PropNetConn] = w.ws.UnderlyingConn()
 	if tlsConn, ok := w.ws.UnderlyingConn().(*tls.Conn); ok {
 		w.props[mangos.PropTLSConnState] = tlsConn.ConnectionState()
 	}
@@ -328,7 +339,7 @@ func (l *listener) Listen() error {
 	}
 
 	if tlist, err := net.ListenTCP("tcp", taddr); err != nil {
-		return err
+		return mangos.ListenError(err)
 	} else if l.iswss {
 		l.listener = tls.NewListener(tlist, tcfg)
 	} else {
@@ -389,6 +400,7 @@ func (l *listener) handler(ws *websocket.Conn, req *http.Request) {
 	w.props = make(map[string]interface{})
 	w.props[mangos.PropLocalAddr] = ws.LocalAddr()
 	w.props[mangos.PropRemoteAddr] = ws.RemoteAddr()
+	w.props[mangos.PropNetConn] = ws.UnderlyingConn()
 
 	if req.TLS != nil {
 		w.props[mangos.PropTLSConnState] = *req.TLS
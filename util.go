@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +35,21 @@ func mkTimer(deadline time.Duration) <-chan time.Time {
 	return time.After(deadline)
 }
 
+// setFlag stores a bool option in a flag that is read atomically, for
+// options checked on every message.
+func setFlag(flag *int32, on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(flag, v)
+}
+
+// getFlag returns the value of a flag stored by setFlag.
+func getFlag(flag *int32) bool {
+	return atomic.LoadInt32(flag) != 0
+}
+
 var debug = true
 
 func debugf(format string, args ...interface{}) {
@@ -39,13 +39,14 @@ func (cv *CondTimed) WaitRelTimeout(when time.Duration) bool {
 }
 
 // WaitAbsTimeout is like WaitRelTimeout, but expires on an absolute time
-// instead of a relative one.
+// instead of a relative one.  If that time has already passed, it returns
+// false at once, without waiting.
 func (cv *CondTimed) WaitAbsTimeout(when time.Time) bool {
 	now := time.Now()
 	if when.After(now) {
 		return cv.WaitRelTimeout(when.Sub(now))
 	}
-	return cv.WaitRelTimeout(time.Duration(0))
+	return false
 }
 
 // Waiter is a way to wait for completion, but it includes a timeout.  It